@@ -0,0 +1,58 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// logAccess logs a DONE line when the request is served, with the status code,
+// the number of bytes written and the duration - with the same request logger
+// as the ACCEPT line, so the two can be paired by the reqid.
+func logAccess(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		defer func() {
+			if aw.code == 0 {
+				aw.code = http.StatusOK
+			}
+			requestLogger(r.Context(), r).Log("msg", "DONE", "uri", r.RequestURI,
+				"status", aw.code, "bytes", aw.n, "duration", time.Since(start))
+		}()
+		h(aw, r)
+	}
+}
+
+// accessWriter records the status code and the number of the written bytes.
+type accessWriter struct {
+	http.ResponseWriter
+	code int
+	n    int64
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying ResponseWriter, if it can.
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tgulacsi/agostle/converter"
+)
+
+func TestAccessLogReqID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-accesslog-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	oldWorkdir, oldLogger := converter.Workdir, logger
+	defer func() { converter.Workdir, logger = oldWorkdir, oldLogger }()
+	converter.Workdir = dir
+	var buf bytes.Buffer
+	logger = log.NewContext(log.NewLogfmtLogger(&buf))
+
+	h := withWorkdir(logAccess(func(w http.ResponseWriter, r *http.Request) {
+		prepareContext(r.Context(), r)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/pdf/merge", nil))
+
+	rx := regexp.MustCompile(`reqid=(\S+) .*msg=(ACCEPT|DONE)`)
+	ids := make(map[string]string, 2)
+	for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		if m := rx.FindSubmatch(line); m != nil {
+			ids[string(m[2])] = string(m[1])
+		}
+	}
+	if ids["ACCEPT"] == "" || ids["ACCEPT"] != ids["DONE"] {
+		t.Errorf("ACCEPT and DONE have different reqids: %q\n%s", ids, buf.Bytes())
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+// authConfig is a snapshot of the configured credentials.
+type authConfig struct {
+	token, user, password string
+}
+
+// currentAuth returns the configured credentials, read under RLockConfig:
+// requireAuth runs outside of withConfig, so it must not race with a reload.
+func currentAuth() authConfig {
+	defer converter.RLockConfig()()
+	return authConfig{
+		token:    *converter.ConfAuthToken,
+		user:     *converter.ConfAuthUser,
+		password: *converter.ConfAuthPassword,
+	}
+}
+
+// configured reports whether any credentials are configured.
+func (a authConfig) configured() bool {
+	return a.token != "" || a.user != ""
+}
+
+// authorized reports whether the request carries the configured bearer token,
+// or the configured basic auth credentials.
+func (a authConfig) authorized(r *http.Request) bool {
+	if a.token != "" {
+		if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(h[7:])), []byte(a.token)) == 1 {
+			return true
+		}
+	}
+	if a.user != "" {
+		if u, p, ok := r.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(u), []byte(a.user)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(p), []byte(a.password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAuth gates the handler with the configured credentials.
+// /healthz is always open; /_admin/stop always needs authorization,
+// so it is refused if no credentials are configured (and the status page hides its link).
+func requireAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := currentAuth()
+		switch r.URL.Path {
+		case "/healthz":
+			h.ServeHTTP(w, r)
+			return
+		case "/_admin/stop":
+		default:
+			if !a.configured() {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		if !a.authorized(r) {
+			a.unauthorized(w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a authConfig) unauthorized(w http.ResponseWriter) {
+	if a.user != "" {
+		w.Header().Add("WWW-Authenticate", `Basic realm="agostle"`)
+	}
+	if a.token != "" || a.user == "" {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="agostle"`)
+	}
+	http.Error(w, "authorization required", http.StatusUnauthorized)
+}
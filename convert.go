@@ -0,0 +1,155 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+// convertServer converts one uploaded file of any (known) type to PDF,
+// at /convert.
+var convertServer = kithttp.NewServer(
+	context.Background(),
+	convertEP,
+	convertDecode,
+	convertEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+type convertResponse struct {
+	fileName    string
+	contentType string
+	// name is the file name offered to the client
+	name string
+}
+
+type convertRequest struct {
+	Input reqFile
+	// Density is the DPI of the image conversions, 0 if not given.
+	Density int
+	// EmbedFonts asks for embedding all the fonts into the PDF, even without embedFonts in the config.
+	EmbedFonts bool
+	// Version is the PDF version the result is written with, "" for leaving it as is.
+	Version converter.PdfVersion
+}
+
+func convertDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	f, err := getOneRequestFile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req := convertRequest{Input: f, EmbedFonts: r.FormValue("embedFonts") == "1"}
+	if req.Density, err = getDensity(r); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if req.Version, err = getPdfVersion(r); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return req, nil
+}
+
+// convertEP converts the file with converter.Convert. The result is a PDF,
+// or a zip for the types converted into several files (such as the mails).
+func convertEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	req := request.(convertRequest)
+	f := req.Input
+	defer func() { _ = f.Close() }()
+	if req.Density > 0 {
+		ctx = converter.WithImageDensity(ctx, req.Density)
+	}
+	if req.EmbedFonts {
+		ctx = converter.WithEmbedFonts(ctx, true)
+	}
+	if req.Version != "" {
+		ctx = converter.WithPdfVersion(ctx, req.Version)
+	}
+	fh, err := ioutil.TempFile(converter.GetWorkdir(ctx), "convert-"+reqPrefix(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp file")
+	}
+	outfn := fh.Name()
+	_ = fh.Close()
+	contentType := f.Header.Get("Content-Type")
+	if err = converter.Convert(ctx, outfn, f, contentType, f.Filename); err != nil {
+		_ = os.Remove(outfn)
+		getLogger(ctx).Log("msg", "Convert", "file", f.Filename, "ct", contentType, "error", err)
+		return nil, err
+	}
+	resp := convertResponse{fileName: outfn, contentType: "application/pdf",
+		name: convertedName(f.Filename) + ".pdf"}
+	if isZip(outfn) {
+		resp.contentType = "application/zip"
+		resp.name = strings.TrimSuffix(resp.name, ".pdf") + ".zip"
+	} else if converter.GetEmbedFonts(ctx) {
+		if err = converter.PdfEmbedFonts(ctx, outfn, outfn, req.Version); err != nil {
+			_ = os.Remove(outfn)
+			return nil, err
+		}
+	} else if req.Version != "" {
+		if err = converter.PdfSetVersion(ctx, outfn, outfn, req.Version); err != nil {
+			_ = os.Remove(outfn)
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func convertEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(convertResponse)
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(resp.fileName) }()
+	}
+	fh, err := os.Open(resp.fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fh.Close() }()
+	w.Header().Set("Content-Type", resp.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": resp.name}))
+	_, err = io.Copy(w, fh)
+	return err
+}
+
+// isZip reports whether the file starts with the zip local file header signature.
+func isZip(fn string) bool {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = fh.Close() }()
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(fh, head); err != nil {
+		return false
+	}
+	return bytes.Equal(head, []byte("PK\x03\x04"))
+}
+
+// convertedName returns the name of the converted file, without extension.
+func convertedName(fileName string) string {
+	name := baseName(fileName)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if name == "" {
+		return "converted"
+	}
+	return name
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+func TestConvertEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-convert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	defer func(old string) { converter.Workdir = old }(converter.Workdir)
+	converter.Workdir = dir
+
+	writer := func(content string) converter.Converter {
+		return func(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+			return ioutil.WriteFile(destfn, []byte(content), 0644)
+		}
+	}
+	converter.RegisterConverter("application/x-agostle-test-pdf", writer("%PDF-1.4 converted"))
+	defer converter.RegisterConverter("application/x-agostle-test-pdf", nil)
+	converter.RegisterConverter("application/x-agostle-test-zip", writer("PK\x03\x04 converted"))
+	defer converter.RegisterConverter("application/x-agostle-test-zip", nil)
+
+	post := func(fileName, contentType string, fields map[string]string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file"; filename="`+fileName+`"`)
+		h.Set("Content-Type", contentType)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("input"))
+		for k, v := range fields {
+			if err = mw.WriteField(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/convert", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		convertServer.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for i, tc := range []struct {
+		fileName, contentType string
+		wantCT, wantName      string
+		wantBody              string
+	}{
+		{"report.final.x1", "application/x-agostle-test-pdf", "application/pdf", "report.final.pdf", "%PDF-1.4 converted"},
+		{`C:\mails\mail.x2`, "application/x-agostle-test-zip", "application/zip", "mail.zip", "PK\x03\x04 converted"},
+		{".x1", "application/x-agostle-test-pdf", "application/pdf", "converted.pdf", "%PDF-1.4 converted"},
+	} {
+		rec := post(tc.fileName, tc.contentType, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%d. got %d: %s", i, rec.Code, rec.Body.Bytes())
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.wantCT {
+			t.Errorf("%d. got Content-Type %q, wanted %q", i, ct, tc.wantCT)
+		}
+		_, params, _ := mime.ParseMediaType(rec.Header().Get("Content-Disposition"))
+		if params["filename"] != tc.wantName {
+			t.Errorf("%d. got filename %q, wanted %q", i, params["filename"], tc.wantName)
+		}
+		if got := rec.Body.String(); got != tc.wantBody {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.wantBody)
+		}
+	}
+
+	if rec := post("a.x1", "application/x-agostle-test-pdf", map[string]string{"density": "-1"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad density: got %d, wanted %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := post("a.x1", "application/x-agostle-test-pdf", map[string]string{"pdfVersion": "9.9"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad pdfVersion: got %d, wanted %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := post("a.unknown", "application/x-agostle-unknown", nil); rec.Code == http.StatusOK {
+		t.Errorf("no converter: got %d", rec.Code)
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 {
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("temp files left: %q", names)
+	}
+}
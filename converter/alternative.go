@@ -0,0 +1,123 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// altPart is one part of a multipart/alternative.
+type altPart struct {
+	ContentType string
+	Params      map[string]string
+	Body        []byte
+}
+
+// alternativeRank returns how capable the content-type is:
+// html (or multipart/related, the html with its inline images) > markdown > plain
+// > anything else with a converter.
+// The unconvertable ones get -1.
+func alternativeRank(contentType string, params map[string]string) int {
+	switch contentType {
+	case "text/html", "multipart/related":
+		return 3
+	case "text/markdown", "text/x-markdown":
+		return 2
+	case "text/plain":
+		return 1
+	}
+	if convertible(contentType, params) {
+		return 0
+	}
+	return -1
+}
+
+// readAlternatives reads the parts of the multipart/alternative body,
+// decoding the base64 transfer encoding (quoted-printable is decoded by multipart).
+func readAlternatives(r io.Reader, boundary string) ([]altPart, error) {
+	mr := multipart.NewReader(r, boundary)
+	var parts []altPart
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, errors.Wrap(err, "next part")
+		}
+		ct, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil {
+			ct, params = "text/plain", map[string]string{"charset": "us-ascii"}
+		}
+		var body io.Reader = p
+		if strings.EqualFold(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding")), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return parts, errors.Wrapf(err, "read %s part", ct)
+		}
+		parts = append(parts, altPart{ContentType: ct, Params: params, Body: b})
+	}
+}
+
+// chooseAlternative returns the index of the most capable part (the last of the
+// equally capable ones, as they are in increasing order of preference), and of
+// the text/plain part (as a fallback), -1 if there is none.
+func chooseAlternative(parts []altPart) (best, plain int) {
+	best, plain = -1, -1
+	bestRank := -1
+	for i, p := range parts {
+		rank := alternativeRank(p.ContentType, p.Params)
+		if rank >= 0 && rank >= bestRank {
+			best, bestRank = i, rank
+		}
+		if p.ContentType == "text/plain" {
+			plain = i
+		}
+	}
+	return best, plain
+}
+
+// MPAlternativeToPdf converts multipart/alternative to PDF, by converting only
+// the richest part (html or multipart/related > markdown > plain); if that fails,
+// the plain text part.
+func MPAlternativeToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	Log := getLogger(ctx).Log
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.Wrapf(err, "parse Content-Type %s", contentType)
+	}
+	parts, err := readAlternatives(r, params["boundary"])
+	if err != nil {
+		return err
+	}
+	best, plain := chooseAlternative(parts)
+	if best < 0 {
+		return errors.Wrapf(ErrNoConverter, "no convertable part in %s", contentType)
+	}
+	convert := func(p altPart) error {
+		ct := p.ContentType
+		if len(p.Params) != 0 {
+			ct = mime.FormatMediaType(ct, p.Params)
+		}
+		return GetConverter(p.ContentType, p.Params)(ctx, destfn, bytes.NewReader(p.Body), ct)
+	}
+	Log("msg", "multipart/alternative", "parts", len(parts), "chosen", parts[best].ContentType)
+	if err = convert(parts[best]); err == nil || plain < 0 || plain == best {
+		return err
+	}
+	Log("msg", "converting alternative", "ct", parts[best].ContentType, "error", err)
+	return convert(parts[plain])
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChooseAlternative(t *testing.T) {
+	body := strings.Replace(`--b
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+=C3=A1rv=C3=ADz
+--b
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: base64
+
+PHA+w6FydsOtejwvcD4=
+--b
+Content-Type: application/x-unknown-thing
+
+???
+--b--
+`, "\n", "\r\n", -1)
+	parts, err := readAlternatives(strings.NewReader(body), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts, wanted 3", len(parts))
+	}
+	if got := string(parts[0].Body); got != "árvíz" {
+		t.Errorf("plain: got %q", got)
+	}
+	if got := string(parts[1].Body); got != "<p>árvíz</p>" {
+		t.Errorf("html: got %q", got)
+	}
+	if best, plain := chooseAlternative(parts); best != 1 || plain != 0 {
+		t.Errorf("got best=%d plain=%d, wanted 1 and 0", best, plain)
+	}
+
+	if best, plain := chooseAlternative([]altPart{
+		{ContentType: "text/plain"}, {ContentType: "text/markdown"}, {ContentType: "text/plain"},
+	}); best != 1 || plain != 2 {
+		t.Errorf("markdown: got best=%d plain=%d", best, plain)
+	}
+	if best, plain := chooseAlternative([]altPart{
+		{ContentType: "text/plain"},
+		{ContentType: "multipart/related", Params: map[string]string{"boundary": "r"}},
+	}); best != 1 || plain != 0 {
+		t.Errorf("related: got best=%d plain=%d", best, plain)
+	}
+	if best, plain := chooseAlternative([]altPart{
+		{ContentType: "text/plain"}, {ContentType: "text/markdown"}, {ContentType: "multipart/related"},
+	}); best != 2 || plain != 0 {
+		t.Errorf("related over markdown: got best=%d plain=%d", best, plain)
+	}
+	if best, _ := chooseAlternative([]altPart{{ContentType: "application/x-unknown-thing"}}); best != -1 {
+		t.Errorf("unknown: got best=%d", best)
+	}
+}
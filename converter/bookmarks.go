@@ -0,0 +1,71 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Bookmark is a PDF outline entry.
+type Bookmark struct {
+	Title string
+	Level int // 1 is the top level
+	Page  int // 1-based
+}
+
+// bookmarkData returns the pdftk update_info data for the bookmarks.
+func bookmarkData(marks []Bookmark) []byte {
+	var buf bytes.Buffer
+	for _, m := range marks {
+		level := m.Level
+		if level < 1 {
+			level = 1
+		}
+		buf.WriteString("BookmarkBegin\nBookmarkTitle: " + infoEscaper.Replace(m.Title) +
+			"\nBookmarkLevel: " + strconv.Itoa(level) +
+			"\nBookmarkPageNumber: " + strconv.Itoa(m.Page) + "\n")
+	}
+	return buf.Bytes()
+}
+
+// PdfAddBookmarks writes srcfn to destfn, with the given bookmarks added.
+func PdfAddBookmarks(ctx context.Context, destfn, srcfn string, marks []Bookmark) error {
+	if len(marks) == 0 {
+		return copyFile(srcfn, destfn)
+	}
+	return pdftkUpdateInfo(ctx, destfn, srcfn, bookmarkData(marks))
+}
+
+// MergeBookmarks returns a bookmark for the first page of each file,
+// as they will be in the merged result. The title is the corresponding
+// element of titles, or the file's name without the extension, if missing.
+func MergeBookmarks(ctx context.Context, filenames, titles []string) ([]Bookmark, error) {
+	marks := make([]Bookmark, 0, len(filenames))
+	page := 1
+	for i, fn := range filenames {
+		n, _, err := pdfPageNum(ctx, fn)
+		if err != nil {
+			return marks, errors.Wrapf(err, "count pages of %s", fn)
+		}
+		var title string
+		if i < len(titles) {
+			title = titles[i]
+		}
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn))
+		}
+		if n > 0 {
+			marks = append(marks, Bookmark{Title: title, Level: 1, Page: page})
+			page += n
+		}
+	}
+	return marks, nil
+}
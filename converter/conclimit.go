@@ -4,6 +4,8 @@
 
 package converter
 
+import "sync"
+
 // Concurrency is the default concurrent goroutines number
 var Concurrency = int(8)
 
@@ -13,6 +15,15 @@ type RateLimiter interface {
 	Acquire() Token
 	//Release releases the token
 	Release(Token)
+	//InUse returns the number of acquired tokens
+	InUse() int
+	//Waiting returns the number of goroutines waiting for a token
+	Waiting() int
+	//Limit returns the number of tokens
+	Limit() int
+	//Resize sets the number of tokens. The tokens in use above the new limit
+	//remain valid, but no more can be acquired till enough of them are released.
+	Resize(n int)
 }
 
 // Token is a token
@@ -20,27 +31,78 @@ type Token struct{}
 
 // NewRateLimiter returns a RateLimiter
 func NewRateLimiter(n int) RateLimiter {
-	rl := &rateLimiter{tokens: make(chan Token, n)}
-	var t Token
-	for i := 0; i < n; i++ {
-		rl.tokens <- t
-	}
+	rl := &rateLimiter{limit: n}
+	rl.cond = sync.NewCond(&rl.mu)
 	return rl
 }
 
 type rateLimiter struct {
-	tokens chan Token
+	mu           sync.Mutex
+	cond         *sync.Cond
+	limit, inUse int
+	waiting      int
 }
 
 // Acquire pulls a token
 func (rl *rateLimiter) Acquire() Token {
-	return <-rl.tokens
+	rl.mu.Lock()
+	for rl.inUse >= rl.limit {
+		rl.waiting++
+		rl.cond.Wait()
+		rl.waiting--
+	}
+	rl.inUse++
+	rl.mu.Unlock()
+	return Token{}
 }
 
 // Release pushes back the token
 func (rl *rateLimiter) Release(t Token) {
-	select {
-	case rl.tokens <- t:
-	default:
+	rl.mu.Lock()
+	if rl.inUse > 0 {
+		rl.inUse--
+	}
+	rl.mu.Unlock()
+	rl.cond.Signal()
+}
+
+// InUse returns the number of acquired tokens
+func (rl *rateLimiter) InUse() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.inUse
+}
+
+// Waiting returns the number of goroutines waiting for a token
+func (rl *rateLimiter) Waiting() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.waiting
+}
+
+// Limit returns the number of tokens
+func (rl *rateLimiter) Limit() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.limit
+}
+
+// Resize sets the number of tokens
+func (rl *rateLimiter) Resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	rl.mu.Lock()
+	rl.limit = n
+	rl.mu.Unlock()
+	rl.cond.Broadcast()
+}
+
+// SetConcurrency sets Concurrency, and resizes ConcLimit accordingly.
+func SetConcurrency(n int) {
+	if n < 1 {
+		return
 	}
+	Concurrency = n
+	ConcLimit.Resize(n)
 }
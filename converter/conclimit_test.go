@@ -0,0 +1,59 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterResize(t *testing.T) {
+	rl := NewRateLimiter(2)
+	t1, t2 := rl.Acquire(), rl.Acquire()
+	if n := rl.InUse(); n != 2 {
+		t.Fatalf("in use: got %d, wanted 2", n)
+	}
+
+	acquired := make(chan Token)
+	go func() { acquired <- rl.Acquire() }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired above the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := rl.Waiting(); n != 1 {
+		t.Errorf("waiting: got %d, wanted 1", n)
+	}
+	rl.Resize(3)
+	var t3 Token
+	select {
+	case t3 = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after growing")
+	}
+	if n := rl.Waiting(); n != 0 {
+		t.Errorf("waiting after growing: got %d, wanted 0", n)
+	}
+
+	// shrink while all are in use
+	rl.Resize(1)
+	if rl.Limit() != 1 || rl.InUse() != 3 {
+		t.Fatalf("after shrink: limit=%d inUse=%d", rl.Limit(), rl.InUse())
+	}
+	go func() { acquired <- rl.Acquire() }()
+	rl.Release(t1)
+	rl.Release(t2)
+	select {
+	case <-acquired:
+		t.Fatal("acquired above the shrunk limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	rl.Release(t3)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after releasing")
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 
 	"github.com/go-kit/kit/log"
@@ -41,24 +44,89 @@ var (
 	// ConfGm is the path for GraphicsMagick
 	ConfGm = config.String("gm", lookPath("gm"))
 
+	// ConfImageDensity is the density (DPI) the images are converted to PDF with.
+	// The same pixels make a smaller, sharper printing page at a higher density;
+	// for the vector images (SVG, EPS) it is the rasterization resolution, so the
+	// size and memory need grow with its square. It is capped at MaxImageDensity.
+	// The default 0 does not set it, so the resolution embedded in the image is kept;
+	// a set density overrides it (150 is a sensible choice for printing).
+	ConfImageDensity = config.Int("imageDensity", 0)
+
+	// ConfMagick is the path for ImageMagick ("magick", or "convert" for IM 6)
+	ConfMagick = config.String("magick", lookMagick())
+
+	// ConfImageBackend is the image conversion backend: "gm" (GraphicsMagick) or "im" (ImageMagick).
+	// If empty, gm is used if available, im otherwise.
+	ConfImageBackend = config.String("imageBackend", "")
+
+	// ConfHeifConvert is the path for heif-convert (libheif), for HEIC/HEIF images
+	ConfHeifConvert = config.String("heifConvert", lookPath("heif-convert"))
+
+	// ConfRsvgConvert is the path for rsvg-convert (librsvg), for SVG images
+	ConfRsvgConvert = config.String("rsvgConvert", lookPath("rsvg-convert"))
+
 	// ConfGs is the path for GhostScript
 	ConfGs = config.String("gs", lookPath("gs"))
 
+	// ConfGsProfile is the default GhostScript pdfwrite profile (screen, ebook, printer, prepress)
+	ConfGsProfile = config.String("gsProfile", string(GsPrinter))
+
+	// ConfGsMemLimit is the address space (in bytes) GhostScript may use when converting,
+	// enforced with prlimit on Linux; 0 means no limit.
+	// ConfGsMaxBitmap and ConfGsBufferSpace are its -dMaxBitmap and -dBufferSpace (0: the default).
+	// Running out of them is reported as ErrResourceLimit, without retrying.
+	// Only the PDF and PostScript conversions with GhostScript (xToX) are limited,
+	// not the other GhostScript calls (such as rendering the pages).
+	ConfGsMemLimit    = config.Int64("gsMemLimit", 0)
+	ConfGsMaxBitmap   = config.Int64("gsMaxBitmap", 0)
+	ConfGsBufferSpace = config.Int64("gsBufferSpace", 0)
+
+	// ConfPdfVersion is the default version of the PDFs written by GhostScript
+	// (1.3 - 1.7), overridable by the pdfVersion form field.
+	ConfPdfVersion = config.String("pdfVersion", "1.4")
+
 	// ConfPdfClean is the path for pdfclean
 	ConfPdfClean = config.String("pdfclean", lookPath("pdfclean"))
 
 	// ConfMutool is the path for mutool
 	ConfMutool = config.String("mutool", lookPath("mutool"))
 
+	// ConfCleanTools is the comma-separated list of the PDF cleaners (mutool, pdfclean, gs),
+	// tried in order until one succeeds.
+	ConfCleanTools = config.String("cleanTools", "mutool,pdfclean,gs")
+
+	// ConfPdftotext is the path for pdftotext (member of poppler-utils)
+	ConfPdftotext = config.String("pdftotext", lookPath("pdftotext"))
+
+	// ConfPdftoppm is the path for pdftoppm (member of poppler-utils)
+	ConfPdftoppm = config.String("pdftoppm", lookPath("pdftoppm"))
+
+	// ConfTesseract is the path for tesseract, for OCR
+	ConfTesseract = config.String("tesseract", lookPath("tesseract"))
+
+	// ConfVeraPDF is the path for veraPDF, for validating PDF/A conformance
+	ConfVeraPDF = config.String("veraPDF", lookPath("verapdf"))
+
+	// ConfOCRLang is the default language for OCR (tesseract -l)
+	ConfOCRLang = config.String("ocrLang", "hun+eng")
+
+	// ConfOCRAuto makes PdfToPdf run OCR on the PDFs without text
+	ConfOCRAuto = config.Bool("ocrAuto", false)
+
 	// ConvWkhtmltopdf is the parth for wkhtmltopdf
 	ConfWkhtmltopdf = config.String("wkhtmltopdf", lookPath("wkhtmltopdf"))
 
 	// ConfSortBeforeMerge should be true if generally we should sort files by filename before merge
+	// (when no explicit order is given). If false (the default), the files are merged in the order of their form fields.
 	ConfSortBeforeMerge = config.Bool("sortBeforeMerge", false)
 
 	// ConfChildTimeout is the time before the child gets killed
 	ConfChildTimeout = config.Duration("childTimeout", 1*time.Hour)
 
+	// ConfConcurrency is the number of the concurrently running child processes,
+	// overrides Concurrency (the --concurrency flag) if > 0.
+	ConfConcurrency = config.Int("concurrency", 0)
+
 	// ConcLimit limits the concurrently running child processes
 	ConcLimit = NewRateLimiter(Concurrency)
 
@@ -68,18 +136,253 @@ var (
 	// ConfListenAddr is a listen address for HTTP requests
 	ConfListenAddr = config.String("listen", ":9500")
 
+	// ConfTLSCert and ConfTLSKey are the certificate and key files for serving HTTPS;
+	// plain HTTP is served if any of them is empty.
+	ConfTLSCert = config.String("tlsCert", "")
+	ConfTLSKey  = config.String("tlsKey", "")
+
+	// ConfReadTimeout and ConfWriteTimeout are the time limits for reading a whole request
+	// (with the uploads) and for writing the response; ConfIdleTimeout is the time
+	// a keep-alive connection may wait for the next request (0 means ConfReadTimeout).
+	ConfReadTimeout  = config.Duration("readTimeout", 300*time.Second)
+	ConfWriteTimeout = config.Duration("writeTimeout", 1800*time.Second)
+	ConfIdleTimeout  = config.Duration("idleTimeout", 0)
+
+	// ConfMaxHeaderBytes is the maximum size of the request headers (0 means 1MiB).
+	ConfMaxHeaderBytes = config.Int("maxHeaderBytes", 0)
+
+	// ConfHTTP2 enables HTTP/2 over TLS; ConfH2C enables HTTP/2 over plain HTTP
+	// (h2c with prior knowledge or Upgrade), for serving behind a proxy.
+	ConfHTTP2 = config.Bool("http2", true)
+	ConfH2C   = config.Bool("h2c", false)
+
+	// ConfAuthToken is the bearer token, ConfAuthUser and ConfAuthPassword are the
+	// basic auth credentials required by the HTTP handlers (except /healthz), if set.
+	ConfAuthToken    = config.String("authToken", "")
+	ConfAuthUser     = config.String("authUser", "")
+	ConfAuthPassword = config.String("authPassword", "")
+
+	// ConfHTTPRedirect is the listen address for redirecting HTTP to HTTPS (empty disables it).
+	ConfHTTPRedirect = config.String("httpRedirect", "")
+
 	// ConfDefaultIsService decides whether start as service without args
 	ConfDefaultIsService = config.Bool("defaultIsService", false)
 
 	// ConfUseLofficePortLock defines whether to limit Loffice usage by a port lock
 	ConfLofficeUsePortLock = config.Bool("lofficeUsePortLock", !osgroup.IsInsideDocker())
 
+	// ConfLofficeWorkers is the number of LibreOffice instances allowed to run in parallel,
+	// each with its own user profile.
+	ConfLofficeWorkers = config.Int("lofficeWorkers", 1)
+
+	// ConfLofficeSafeMode runs LibreOffice with its own profiles, where the macros
+	// and the scripting are disabled (MacroSecurityLevel=3), and with --norestore.
+	ConfLofficeSafeMode = config.Bool("lofficeSafeMode", true)
+
+	// ConfMaxRequestSize is the maximum size of a request body (all uploaded files), in bytes.
+	// 0 means no limit.
+	ConfMaxRequestSize = config.Int64("maxRequestSize", 512<<20)
+
+	// ConfUploadMemory is the size of an uploaded file over which it is spooled
+	// into the work directory, instead of being kept in memory.
+	ConfUploadMemory = config.Int64("uploadMemory", 64<<10)
+
+	// ConfStreamMail makes the mail conversions walk the MIME parts as they're read,
+	// spooling only the parts, not the whole message, into the work directory.
+	ConfStreamMail = config.Bool("streamMail", true)
+
+	// ConfURLAllowHosts is the comma-separated list of the hosts which remote URL inputs
+	// may be fetched from ("*.example.com" matches the subdomains, too); empty allows every
+	// host with a public address. The addresses of the private networks and the loopback
+	// are accepted only for the explicitly listed hosts.
+	ConfURLAllowHosts = config.String("urlAllowHosts", "")
+
+	// ConfURLDenyHosts is the comma-separated list of the hosts remote URL inputs must not
+	// be fetched from - it wins over ConfURLAllowHosts.
+	ConfURLDenyHosts = config.String("urlDenyHosts", "")
+
+	// ConfURLTimeout is the time limit for downloading a remote URL input.
+	ConfURLTimeout = config.Duration("urlTimeout", 1*time.Minute)
+
+	// ConfAllowedContentTypes is the comma-separated list of the content-types to be converted
+	// ("video/*" matches every video type); empty allows everything not denied.
+	ConfAllowedContentTypes = config.String("allowedContentTypes", "")
+
+	// ConfDeniedContentTypes is the comma-separated list of the content-types to be refused,
+	// it wins over ConfAllowedContentTypes.
+	ConfDeniedContentTypes = config.String("deniedContentTypes", "")
+
+	// ConfUnknownStrategy decides what happens with the content-types without a converter:
+	// "skip" them (UnknownSkip), try LibreOffice on them ("office"), or refuse them ("error").
+	ConfUnknownStrategy = config.String("unknownStrategy", UnknownSkip)
+
+	// ConfZipCompression is the deflate level of the resulting zip files (1-9, -1 is the default);
+	// 0 stores everything uncompressed. The already compressed entries (PDF, JPEG, PNG...)
+	// are always stored as is.
+	ConfZipCompression = config.Int("zipCompression", -1)
+
+	// ConfDrainTimeout is the time to wait for the running conversions to finish
+	// when stopping (/_admin/stop); the remaining child processes are killed after it.
+	ConfDrainTimeout = config.Duration("drainTimeout", 1*time.Minute)
+
+	// ConfWorkdirTTL is the age after which the files in the workdir are removed.
+	// 0 disables the removal; only a configured workdir is reaped, never os.TempDir().
+	ConfWorkdirTTL = config.Duration("workdirTTL", 0)
+
+	// ConfAllowDebug allows the requests to turn on the debug mode with the debug=1
+	// form field (see SetDebug). The workdirs of such requests are kept
+	// (with DebugWorkdirSuffix), till ConfDebugTTL.
+	ConfAllowDebug = config.Bool("allowDebug", false)
+
+	// ConfDebugTTL is the age after which the kept workdirs of the debug requests
+	// are removed - even if ConfWorkdirTTL is 0; 0 disables the removal.
+	ConfDebugTTL = config.Duration("debugTTL", 24*time.Hour)
+
+	// ConfFdfCacheSize is the number of the parsed PDF forms kept in memory for filling;
+	// 0 disables the in-memory cache.
+	ConfFdfCacheSize = config.Int("fdfCacheSize", 32)
+
+	// ConfFdfCacheFiles is the maximum number of the PDF forms whose FDF is kept in the workdir;
+	// 0 means no limit.
+	ConfFdfCacheFiles = config.Int("fdfCacheFiles", 1000)
+
+	// ConfFdfCacheTTL is the age after which an unused FDF is removed from the workdir;
+	// 0 means no limit.
+	ConfFdfCacheTTL = config.Duration("fdfCacheTTL", 30*24*time.Hour)
+
+	// ConfIMAPAddr is the host:port of the IMAP server to fetch the mails from
+	// (see IngestIMAP); empty disables the ingestion.
+	ConfIMAPAddr = config.String("imapAddr", "")
+
+	// ConfIMAPTLS makes the IMAP connection use TLS (imaps, usually on port 993).
+	ConfIMAPTLS = config.Bool("imapTLS", true)
+
+	// ConfIMAPUser and ConfIMAPPassword are the credentials of the IMAP mailbox.
+	ConfIMAPUser     = config.String("imapUser", "")
+	ConfIMAPPassword = config.String("imapPassword", "")
+
+	// ConfIMAPFolder is the IMAP folder whose unseen messages are converted.
+	ConfIMAPFolder = config.String("imapFolder", "INBOX")
+
+	// ConfIMAPMoveTo is the IMAP folder the converted messages are moved into;
+	// if empty, they're just marked as seen.
+	ConfIMAPMoveTo = config.String("imapMoveTo", "")
+
+	// ConfIMAPOutDir is the directory the zips of the converted messages are written into.
+	ConfIMAPOutDir = config.String("imapOutDir", "")
+
+	// ConfIMAPInterval is the time between the polls of the IMAP folder.
+	ConfIMAPInterval = config.Duration("imapInterval", 1*time.Minute)
+
+	// ConfDocxBackend is the command converting the word processing documents (.docx) to PDF
+	// instead of LibreOffice, such as "docx2pdf {input} {output}" (the placeholders
+	// are appended if missing). LibreOffice is used if it is empty or fails.
+	ConfDocxBackend = config.String("docxBackend", "")
+
+	// ConfDeterministic makes the merged and rewritten PDFs reproducible: their dates
+	// are set to SOURCE_DATE_EPOCH (or the Unix epoch), and their ID is derived from the content.
+	ConfDeterministic = config.Bool("deterministic", false)
+
+	// ConfExecRetries is the number of retries of the external commands failing
+	// with transient (resource shortage) errors.
+	ConfExecRetries = config.Int("execRetries", 2)
+
+	// ConfMaxPages is the maximum number of pages of a document to be split or rendered;
+	// 0 means no limit.
+	ConfMaxPages = config.Int("maxPages", 0)
+
+	// ConfMergeBatchSize is the number of files merged at once with pdftk; the bigger
+	// merges are done in batches, which are not merged again when the merge is retried.
+	// 0 disables the batching.
+	ConfMergeBatchSize = config.Int("mergeBatchSize", 50)
+
+	// ConfPreserveSignatures makes the digitally signed PDFs be kept as is:
+	// they're not rewritten nor split, and merging them is refused (ErrSignedPdf).
+	// The PDFs are probed for signatures only when it is set.
+	ConfPreserveSignatures = config.Bool("preserveSignatures", false)
+
+	// ConfTextWrapWidth is the width (in characters) the plain text lines are wrapped at;
+	// 0 means no wrapping.
+	ConfTextWrapWidth = config.Int("textWrapWidth", 80)
+
+	// ConfTextFonts is the CSS font-family list of the plain text conversions;
+	// the fonts of the RTL and CJK scripts should be listed, too.
+	ConfTextFonts = config.String("textFonts",
+		`"DejaVu Sans Mono", "Noto Sans Mono", "Noto Sans Mono CJK SC", "Noto Naskh Arabic", "Noto Sans Hebrew", monospace`)
+
+	// ConfTextCSS is an additional style sheet of the plain text conversions.
+	ConfTextCSS = config.String("textCSS", "")
+
+	// ConfCSVMaxColumns is the number of CSV columns in one table; the rest is wrapped into the next.
+	ConfCSVMaxColumns = config.Int("csvMaxColumns", 12)
+
+	// ConfEmbedFonts makes the converted PDFs have all their fonts embedded
+	// (see PdfEmbedFonts), at the cost of an extra GhostScript pass and a bigger size.
+	ConfEmbedFonts = config.Bool("embedFonts", false)
+
+	// ConfIncludeFailedOriginals makes the mail conversions include the original
+	// of the parts which could not be converted, so nothing is lost from the zip.
+	ConfIncludeFailedOriginals = config.Bool("includeFailedOriginals", false)
+
+	// ConfDedupAttachments makes the mail conversion convert identical parts only once.
+	ConfDedupAttachments = config.Bool("dedupAttachments", false)
+
+	// ConfDedupMinSize is the size under which the duplicate parts are dropped;
+	// the bigger ones are referenced again in the output.
+	ConfDedupMinSize = config.Int64("dedupMinSize", 64<<10)
+
+	// ConfStatusTemplate is the html/template file for the status page (/);
+	// the built-in one is used if empty.
+	ConfStatusTemplate = config.String("statusTemplate", "")
+
+	// ConfLeaveTempFiles makes the conversions leave their temp files (as --leave-tempfiles),
+	// for debugging. Use the debug=1 form field to do it for one request only
+	// (see ConfAllowDebug).
+	ConfLeaveTempFiles = config.Bool("leaveTempFiles", false)
+
+	// ConfSaveOriginalHTML makes the HTML parts be saved as is, besides their PDF conversion,
+	// and the HTML fed to the converters be included in the zips (with SrcHTMLSuffix).
+	ConfSaveOriginalHTML = config.Bool("saveOriginalHTML", false)
+
 	// ConfLogFile specifies the file to log - instead of command line.
 	ConfLogFile = config.String("logfile", "")
 )
 
-// LoadConfig loads TOML config file
+// configMu guards the config values: LoadConfig holds it for writing,
+// the conversions for reading (see RLockConfig), so a reload waits for the
+// running conversions, and never changes the config under them.
+var configMu sync.RWMutex
+
+// RLockConfig locks the config for reading, and returns the unlock function.
+// Hold it while using the config, as LoadConfig may change it concurrently.
+func RLockConfig() (unlock func()) {
+	configMu.RLock()
+	return configMu.RUnlock
+}
+
+// LoadConfig loads TOML config file.
+//
+// It can be called again (on SIGHUP) to reload the config: it waits for the running
+// conversions (holding RLockConfig), the new values are used by the next ones.
 func LoadConfig(fn string) error {
+	return ReloadConfig(fn, nil)
+}
+
+// ReloadConfig loads the config file as LoadConfig, and calls apply (if not nil)
+// with the config still locked, to derive the caller's settings from the new values.
+func ReloadConfig(fn string, apply func()) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if err := loadConfig(fn); err != nil {
+		return err
+	}
+	if apply != nil {
+		apply()
+	}
+	return nil
+}
+
+func loadConfig(fn string) error {
 	if err := config.Parse(fn); err != nil {
 		Log("msg", "WARN Cannot open config file", "file", fn, "error", err)
 	}
@@ -92,6 +395,16 @@ func LoadConfig(fn string) error {
 			}
 		}
 	}
+	// assigned on every (re)load, so they can be switched off, too;
+	// the --leave-tempfiles flag is applied after this by the caller.
+	LeaveTempFiles = *ConfLeaveTempFiles
+	SaveOriginalHTML = *ConfSaveOriginalHTML
+	if err := PdfVersion(*ConfPdfVersion).Validate(); err != nil {
+		return errors.Wrap(err, "pdfVersion")
+	}
+	if err := checkUnknownStrategy(*ConfUnknownStrategy); err != nil {
+		return errors.Wrap(err, "unknownStrategy")
+	}
 	if *ConfWorkdir != "" {
 		_ = os.Setenv("TMPDIR", *ConfWorkdir)
 		Workdir = *ConfWorkdir
@@ -99,49 +412,162 @@ func LoadConfig(fn string) error {
 
 	bn := filepath.Base(*ConfPdfseparate)
 	prefix := (*ConfPdfseparate)[:len(*ConfPdfseparate)-len(bn)]
-	for k := range popplerOk {
+	ok := make(map[string]string, len(popplerOk))
+	for k := range getPopplerOk() {
+		ok[k] = ""
 		if err := exec.Command(prefix+k, "-h").Run(); err == nil {
-			popplerOk[k] = prefix + k
+			ok[k] = prefix + k
 		}
 	}
-	Log("popplerOk", popplerOk)
+	mb := probeMutool(ok)
+	popplerMu.Lock()
+	popplerOk = ok
+	mutoolOk = mb
+	popplerMu.Unlock()
+	Log("popplerOk", ok)
+	if mb.Pdf || mb.Render {
+		Log("msg", "using mutool", "pdf", mb.Pdf, "render", mb.Render)
+	}
+
+	lofficeMu.Lock()
+	lofficePool = newLofficePool(*ConfLofficeWorkers, *ConfLofficeUsePortLock, *ConfLofficeSafeMode)
+	lofficeMu.Unlock()
 
-	if !*ConfLofficeUsePortLock {
-		lofficeMu.Lock()
-		lofficePortLock = nil
-		lofficeMu.Unlock()
+	if *ConfConcurrency > 0 {
+		SetConcurrency(*ConfConcurrency)
 	}
 
 	return nil
 }
 
+// MissingTools returns the names of the external tools needed by the
+// converters which are not available. It only looks up the binaries,
+// so it is cheap enough to be called frequently.
+func MissingTools() []string {
+	var missing []string
+	for _, tool := range []struct {
+		Name string
+		Path string
+	}{
+		{"loffice", *ConfLoffice},
+		{"gs", *ConfGs},
+	} {
+		if tool.Path == "" || lookPath(tool.Path) == "" {
+			missing = append(missing, tool.Name)
+		}
+	}
+	// pdftk is not needed for splitting and merging if poppler or mutool is available.
+	if (*ConfPdftk == "" || lookPath(*ConfPdftk) == "") && !getMutoolOk().Pdf {
+		poppler := getPopplerOk()
+		for _, k := range []string{"pdfinfo", "pdfseparate", "pdfunite"} {
+			if poppler[k] == "" {
+				missing = append(missing, "pdftk")
+				break
+			}
+		}
+	}
+	return missing
+}
+
+// Tools returns the configured paths of the external tools, by name
+// (the empty string means the tool is not available).
+func Tools() map[string]string {
+	tools := map[string]string{
+		"pdftk":       *ConfPdftk,
+		"loffice":     *ConfLoffice,
+		"gm":          *ConfGm,
+		"magick":      *ConfMagick,
+		"heifConvert": *ConfHeifConvert,
+		"rsvgConvert": *ConfRsvgConvert,
+		"gs":          *ConfGs,
+		"pdfclean":    *ConfPdfClean,
+		"mutool":      *ConfMutool,
+		"pdftotext":   *ConfPdftotext,
+		"pdftoppm":    *ConfPdftoppm,
+		"tesseract":   *ConfTesseract,
+		"verapdf":     *ConfVeraPDF,
+		"wkhtmltopdf": *ConfWkhtmltopdf,
+	}
+	for k, v := range getPopplerOk() {
+		tools[k] = v
+	}
+	return tools
+}
+
 // Workdir is the main working directory
 var Workdir = os.TempDir()
 
 // LeaveTempFiles should be true only for debugging purposes (leaves temp files)
 var LeaveTempFiles = false
 
+// prepareContext returns the context with the work directory ("workdir") set,
+// and the work directory itself, which is created if needed.
+// The base is the work directory already in the context, or Workdir;
+// subdir, if not empty, is appended to it.
 func prepareContext(ctx context.Context, subdir string) (context.Context, string) {
 	const wdKey = "workdir"
 	odir, _ := ctx.Value(wdKey).(string)
-	if odir != "" {
-		if subdir != "" {
-			ctx = context.WithValue(ctx, wdKey, filepath.Join(Workdir, subdir))
-		}
-	} else {
-		if subdir != "" {
-			ctx = context.WithValue(ctx, wdKey, Workdir)
-		} else {
-			ctx = context.WithValue(ctx, wdKey, filepath.Join(Workdir, subdir))
-		}
+	ndir := odir
+	if ndir == "" {
+		ndir = Workdir
 	}
-	ndir, ok := ctx.Value(wdKey).(string)
-	if ok && odir != ndir {
-		if err := os.MkdirAll(ndir, 0750); err != nil {
-			panic("cannot create workdir " + ndir + ": " + err.Error())
-		}
+	if subdir != "" {
+		ndir = filepath.Join(ndir, subdir)
+	}
+	if ndir == odir {
+		return ctx, ndir
+	}
+	if err := os.MkdirAll(ndir, 0750); err != nil {
+		panic("cannot create workdir " + ndir + ": " + err.Error())
 	}
-	return ctx, ndir
+	return context.WithValue(ctx, wdKey, ndir), ndir
+}
+
+// NewRequestWorkdir creates the work directory of a request under Workdir,
+// named by the request id. All the intermediate files of the request should go
+// there (see GetWorkdir), so they can be removed at once by RemoveRequestWorkdir.
+func NewRequestWorkdir(reqid string) (string, error) {
+	if reqid == "" || strings.ContainsAny(reqid, `/\.`) {
+		return "", errors.Errorf("bad request id %q", reqid)
+	}
+	dn := filepath.Join(Workdir, reqid)
+	if err := os.MkdirAll(dn, 0750); err != nil {
+		return "", errors.Wrap(err, "create request workdir")
+	}
+	return dn, nil
+}
+
+// DebugWorkdirSuffix is appended to the name of the kept workdirs of the debug requests.
+const DebugWorkdirSuffix = ".debug"
+
+// KeepRequestWorkdir keeps the request's work directory, renaming it with
+// DebugWorkdirSuffix, so ReapDebugWorkdirs removes it later. It returns the new name.
+func KeepRequestWorkdir(dir string) (string, error) {
+	if dir == "" || filepath.Clean(dir) == filepath.Clean(Workdir) {
+		return dir, nil
+	}
+	kept := dir + DebugWorkdirSuffix
+	if err := os.Rename(dir, kept); err != nil {
+		return dir, errors.Wrap(err, "keep request workdir")
+	}
+	return kept, nil
+}
+
+// RemoveRequestWorkdir removes the request's work directory with everything in it,
+// unless LeaveTempFiles is set.
+func RemoveRequestWorkdir(dir string) error {
+	if LeaveTempFiles || dir == "" || filepath.Clean(dir) == filepath.Clean(Workdir) {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
+// GetWorkdir returns the work directory from the context ("workdir"), or Workdir.
+func GetWorkdir(ctx context.Context) string {
+	if dir, ok := ctx.Value("workdir").(string); ok && dir != "" {
+		return dir
+	}
+	return Workdir
 }
 
 // port for LibreOffice locking (only one instance should be running)
@@ -153,6 +579,9 @@ var SaveOriginalHTML = false
 // name of errors list in resulting archive
 const ErrTextFn = "ZZZ-errors.txt"
 
+// name of the manifest (describing the converted parts) in resulting archive
+const ManifestFn = "manifest.json"
+
 func getLogger(ctx context.Context) *log.Context {
 	if ctx == nil {
 		return Logger
@@ -0,0 +1,118 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRequestWorkdir(t *testing.T) {
+	oWorkdir := Workdir
+	defer func() { Workdir = oWorkdir }()
+	var err error
+	if Workdir, err = ioutil.TempDir("", "agostle-workdir-"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(Workdir)
+
+	for _, bad := range []string{"", "..", "a/b"} {
+		if dn, err := NewRequestWorkdir(bad); err == nil {
+			t.Errorf("%q: wanted error, got %q", bad, dn)
+		}
+	}
+	dn, err := NewRequestWorkdir("01BX5ZZKBKACTAV9WEVGEMMVRZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dn) != Workdir {
+		t.Errorf("%q is not under %q", dn, Workdir)
+	}
+	ctx := context.WithValue(context.Background(), "workdir", dn)
+	if got := GetWorkdir(ctx); got != dn {
+		t.Errorf("GetWorkdir: got %q, wanted %q", got, dn)
+	}
+	if got := GetWorkdir(context.Background()); got != Workdir {
+		t.Errorf("GetWorkdir without workdir: got %q, wanted %q", got, Workdir)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dn, "part.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = RemoveRequestWorkdir(dn); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(dn) {
+		t.Errorf("%q still exists", dn)
+	}
+	if err = RemoveRequestWorkdir(Workdir); err != nil || !fileExists(Workdir) {
+		t.Errorf("Workdir itself must not be removed (%v)", err)
+	}
+}
+
+func TestPrepareContext(t *testing.T) {
+	oWorkdir := Workdir
+	defer func() { Workdir = oWorkdir }()
+	var err error
+	if Workdir, err = ioutil.TempDir("", "agostle-workdir-"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(Workdir)
+	req := filepath.Join(Workdir, "req")
+
+	for i, tc := range []struct {
+		odir, subdir, want string
+	}{
+		{"", "", Workdir},
+		{"", "sub", filepath.Join(Workdir, "sub")},
+		{req, "", req},
+		{req, "sub", filepath.Join(req, "sub")},
+	} {
+		ctx := context.Background()
+		if tc.odir != "" {
+			ctx = context.WithValue(ctx, "workdir", tc.odir)
+		}
+		ctx, got := prepareContext(ctx, tc.subdir)
+		if got != tc.want {
+			t.Errorf("%d. (%q, %q): got %q, wanted %q", i, tc.odir, tc.subdir, got, tc.want)
+		}
+		if v, _ := ctx.Value("workdir").(string); v != tc.want {
+			t.Errorf("%d. (%q, %q): context has %q, wanted %q", i, tc.odir, tc.subdir, v, tc.want)
+		}
+		if tc.subdir != "" && !fileExists(got) {
+			t.Errorf("%d. (%q, %q): %q is not created", i, tc.odir, tc.subdir, got)
+		}
+	}
+}
+
+func TestReloadConfigFlags(t *testing.T) {
+	defer func(conf, html, leave, save bool) {
+		*ConfLeaveTempFiles, *ConfSaveOriginalHTML, LeaveTempFiles, SaveOriginalHTML = conf, html, leave, save
+	}(*ConfLeaveTempFiles, *ConfSaveOriginalHTML, LeaveTempFiles, SaveOriginalHTML)
+
+	for i, tc := range []struct {
+		conf, flag bool
+		want       bool
+	}{
+		{true, false, true},
+		{false, false, false}, // switched off by the reload
+		{false, true, true},   // the command line flag is kept
+	} {
+		*ConfLeaveTempFiles, *ConfSaveOriginalHTML = tc.conf, tc.conf
+		if err := ReloadConfig("", func() {
+			if tc.flag {
+				LeaveTempFiles = true
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if LeaveTempFiles != tc.want || SaveOriginalHTML != tc.conf {
+			t.Errorf("%d. got leave=%t save=%t, wanted %t, %t", i, LeaveTempFiles, SaveOriginalHTML, tc.want, tc.conf)
+		}
+	}
+}
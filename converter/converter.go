@@ -5,6 +5,7 @@
 package converter
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"mime"
@@ -13,12 +14,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 
 	"bitbucket.org/taruti/mimemagic"
 	"github.com/pkg/errors"
-	"github.com/tgulacsi/go/iohlp"
 	"golang.org/x/net/context"
 )
 
@@ -27,16 +28,62 @@ var ErrSkip = errors.New("skip this part")
 // Converter converts to Pdf (destination filename, source reader and source content-type)
 type Converter func(context.Context, string, io.Reader, string) error
 
+// ErrNoConverter is returned by Convert when there is no converter for the content-type.
+// The content-types which are not allowed get ErrContentTypeNotAllowed.
+var ErrNoConverter = errors.New("no converter")
+
+// sniffSize is the length of the prefix used by Convert to detect the content-type.
+const sniffSize = 4096
+
+// Convert converts the content read from r to destfn: it fixes the content-type
+// (by looking at the first bytes and the fileName), selects the converter with
+// GetConverter, and calls it.
+func Convert(ctx context.Context, destfn string, r io.Reader, contentType, fileName string) error {
+	var mediaType map[string]string
+	if contentType != "" {
+		ct, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			getLogger(ctx).Log("msg", "parse media type", "ct", contentType, "error", err)
+		} else {
+			contentType, mediaType = ct, params
+		}
+	}
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize)
+	contentType = FixContentType(head, contentType, fileName)
+	converter := GetConverter(contentType, mediaType)
+	if converter == nil {
+		ConversionsTotal.WithLabelValues(contentType, "error").Inc()
+		return errors.Wrapf(ErrNoConverter, "%s (%q)", contentType, fileName)
+	}
+	err := converter(ctx, destfn, br, contentType)
+	ConversionsTotal.WithLabelValues(contentType, outcome(err)).Inc()
+	return err
+}
+
 // TextToPdf converts text (text/plain) to PDF
 func TextToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
-	getLogger(ctx).Log("msg", "Converting into", "ct", contentType, "dest", destfn)
-	return HTMLToPdf(ctx, destfn, textToHTML(r), "text/html")
+	charset, r := sniffCharset(r)
+	getLogger(ctx).Log("msg", "Converting into", "ct", contentType, "charset", charset, "dest", destfn)
+	return HTMLToPdf(ctx, destfn, textToHTML(r, getTextWrapWidth(ctx)), "text/html")
 }
 
-func textToHTML(r io.Reader) io.Reader {
+// textToHTML wraps the text read from r in a <pre> HTML document,
+// dropping any leading byte order mark, and breaking the lines longer
+// than width runes (0 means no wrapping).
+//
+// The direction of each line is decided by its content (dir="auto"),
+// and the fonts are ConfTextFonts, so the RTL and CJK scripts are rendered properly.
+func textToHTML(r io.Reader, width int) io.Reader {
+	r = stripBOM(r)
 	pr, pw := io.Pipe()
 	go func() {
-		if _, err := io.Copy(&htmlEscaper{pw}, iohlp.WrappingReader(r, 80)); err != nil {
+		lw := newLineWrapper(&htmlEscaper{pw}, width)
+		_, err := io.Copy(lw, r)
+		if closeErr := lw.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
 			Log("msg", "escape", "error", err)
 			pw.CloseWithError(err)
 			return
@@ -46,13 +93,26 @@ func textToHTML(r io.Reader) io.Reader {
 	return io.MultiReader(
 		strings.NewReader(`<!DOCTYPE html>
 <html>
-<head><meta charset="utf-8"></head>
-<body><pre>`),
+<head><meta charset="utf-8">`+textStyle()+`</head>
+<body><pre dir="auto">`),
 		pr,
 		strings.NewReader("</pre></body></html>"),
 	)
 }
 
+// textStyle returns the <style> element of textToHTML, with ConfTextFonts and ConfTextCSS.
+func textStyle() string {
+	css := "pre { unicode-bidi: plaintext; "
+	if fonts := strings.TrimSpace(*ConfTextFonts); fonts != "" {
+		css += "font-family: " + fonts + "; "
+	}
+	css += "}"
+	if extra := strings.TrimSpace(*ConfTextCSS); extra != "" {
+		css += "\n" + extra
+	}
+	return "<style>" + strings.Replace(css, "</", `<\/`, -1) + "</style>"
+}
+
 // ImageToPdf convert image (image/...) to PDF
 func ImageToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
 	Log := getLogger(ctx).Log
@@ -79,7 +139,7 @@ func ImageToPdf(ctx context.Context, destfn string, r io.Reader, contentType str
 			return errors.Wrapf(err, "open inp "+inpfn)
 		}
 		defer func() { _ = ifh.Close() }()
-		if !LeaveTempFiles {
+		if !KeepTempFiles(ctx) {
 			defer func() { _ = unlink(inpfn, "ImageToPdf") }()
 		}
 	}
@@ -91,12 +151,40 @@ func ImageToPdf(ctx context.Context, destfn string, r io.Reader, contentType str
 		Log("msg", "Input file not exist!", "file", ifh.Name())
 		return errors.New("input file " + ifh.Name() + " not exists")
 	}
+	if contentType == "image/heic" || contentType == "image/heif" {
+		jpgfn := destfn + ".jpg"
+		if err := heifToJpeg(ctx, jpgfn, ifh.Name()); err != nil {
+			return err
+		}
+		if !KeepTempFiles(ctx) {
+			defer func() { _ = unlink(jpgfn, "ImageToPdf") }()
+		}
+		jfh, err := os.Open(jpgfn)
+		if err != nil {
+			return errors.Wrap(err, "open "+jpgfn)
+		}
+		defer func() { _ = jfh.Close() }()
+		ifh, contentType, imgtyp = jfh, "image/jpeg", "jpeg"
+	}
+	if opts := getImageFitOpts(ctx); !opts.IsZero() {
+		Log("msg", "fit image", "file", ifh.Name(), "fit", opts)
+		return ImageToPdfFit(ctx, destfn, ifh.Name(), imgtyp, opts)
+	}
+	if contentType == "image/tiff" {
+		n, err := ImageFrames(ctx, ifh.Name())
+		if err != nil {
+			Log("msg", "ImageFrames", "file", ifh.Name(), "error", err)
+		} else if n > 1 {
+			Log("msg", "multi-page image", "file", ifh.Name(), "frames", n)
+			return MultiImageToPdfGm(ctx, destfn, ifh.Name(), imgtyp)
+		}
+	}
 	w, err := os.Create(destfn)
 	if err != nil {
 		return err
 	}
-	if err = ImageToPdfGm(w, ifh, contentType); err != nil {
-		Log("msg", "ImageToPdfGm", "error", err)
+	if err = imageToPdf(ctx, w, ifh, contentType); err != nil {
+		Log("msg", "imageToPdf", "backend", imageBackend(), "error", err)
 	}
 	closeErr := w.Close()
 	if err != nil {
@@ -105,22 +193,46 @@ func ImageToPdf(ctx context.Context, destfn string, r io.Reader, contentType str
 	return closeErr
 }
 
-// OfficeToPdf converts other to PDF with LibreOffice
+// OfficeToPdf converts other to PDF with LibreOffice.
+// The word processing documents (.docx) are converted with ConfDocxBackend,
+// if it is set, falling back to LibreOffice if it fails.
 func OfficeToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
-	getLogger(ctx).Log("msg", "Converting into", "ct", contentType, "dest", destfn)
+	Log := getLogger(ctx).Log
+	Log("msg", "Converting into", "ct", contentType, "dest", destfn)
 	if strings.HasSuffix(destfn, ".pdf") {
 		destfn = destfn[:len(destfn)-4]
 	}
+	docx := *ConfDocxBackend != "" && isWordprocessingML(contentType)
 	inpfn := destfn + ".raw"
+	if docx {
+		inpfn = destfn + docxExt(contentType)
+	}
 	fh, err := os.Create(inpfn)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = unlink(inpfn, "OtherToPdf") }()
-	if _, err = io.Copy(fh, r); err != nil {
+	_, err = io.Copy(fh, r)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if docx {
+		// the backends' output differs from LibreOffice's, so log which one made it
+		if err = docxBackendConvert(ctx, destfn+".pdf", inpfn); err == nil {
+			Log("msg", "converted", "backend", strings.Fields(*ConfDocxBackend)[0], "dest", destfn+".pdf")
+			return nil
+		}
+		Log("msg", "docxBackend failed, falling back to LibreOffice", "error", err)
+		_ = os.Remove(destfn + ".pdf")
+	}
+	if err = lofficeConvert(ctx, filepath.Dir(destfn), inpfn, lofficeFilter(contentType)); err != nil {
 		return err
 	}
-	return lofficeConvert(ctx, filepath.Dir(destfn), inpfn)
+	Log("msg", "converted", "backend", "libreoffice", "dest", destfn+".pdf")
+	return nil
 }
 
 // OtherToPdf is the default converter
@@ -138,7 +250,24 @@ func PdfToPdf(ctx context.Context, destfn string, r io.Reader, _ string) error {
 	if err != nil {
 		return err
 	}
-	return closeErr
+	if closeErr != nil || !*ConfOCRAuto || *ConfTesseract == "" {
+		return closeErr
+	}
+	// OCR is best effort: on failure, the original is kept
+	Log := getLogger(ctx).Log
+	if need, err := PdfNeedsOCR(ctx, destfn); err != nil || !need {
+		if err != nil {
+			Log("msg", "PdfNeedsOCR", "file", destfn, "error", err)
+		}
+		return nil
+	}
+	ocrfn := destfn + "-ocr.pdf"
+	if err = PdfOCR(ctx, ocrfn, destfn, ""); err != nil {
+		Log("msg", "PdfOCR", "file", destfn, "error", err)
+		_ = os.Remove(ocrfn)
+		return nil
+	}
+	return os.Rename(ocrfn, destfn)
 }
 
 // MPRelatedToPdf converts multipart/related to PDF
@@ -177,20 +306,25 @@ func HTMLToPdf(ctx context.Context, destfn string, r io.Reader, contentType stri
 		if err != nil {
 			return err
 		}
-		if !LeaveTempFiles {
+		if !KeepTempFiles(ctx) {
 			defer func() { _ = unlink(inpfn, "HtmlToPdf") }()
 		}
 		if _, err = io.Copy(fh, r); err != nil {
 			return err
 		}
 	}
+	if keepOriginalHTML(ctx) {
+		if err := copyFile(inpfn, nakeFilename(destfn)+SrcHTMLSuffix); err != nil {
+			getLogger(ctx).Log("msg", "save the source HTML", "file", inpfn, "error", err)
+		}
+	}
 	if *ConfWkhtmltopdf != "" {
 		return wkhtmltopdf(ctx, destfn, inpfn)
 	}
 
 	dn := filepath.Dir(destfn)
 	outfn := filepath.Join(dn, filepath.Base(nakeFilename(inpfn))+".pdf")
-	if err := lofficeConvert(ctx, dn, inpfn); err != nil {
+	if err := lofficeConvert(ctx, dn, inpfn, lofficeFilter("text/html")); err != nil {
 		return err
 	}
 	if outfn != destfn {
@@ -204,26 +338,114 @@ func Skip(ctx context.Context, destfn string, r io.Reader, contentType string) e
 	return ErrSkip
 }
 
+// lofficeWorker is one slot of the LibreOffice pool: each has its own
+// user profile and lock port, so they can run in parallel.
+type lofficeWorker struct {
+	profile  string    // UserInstallation directory, empty for the default
+	portLock *PortLock // nil if no port locking is used
+	safe     bool      // run with macros and scripting disabled (ConfLofficeSafeMode)
+}
+
+// LofficeFilters maps the content-types to LibreOffice import filters (--infilter),
+// for the types LibreOffice's auto-detection may get wrong.
+// The unknown content-types are auto-detected.
+var LofficeFilters = map[string]string{
+	"text/csv":                  "Text - txt - csv (StarCalc):44,34,76",
+	"text/tab-separated-values": "Text - txt - csv (StarCalc):9,34,76",
+	"text/html":                 "HTML (StarWriter)",
+	"application/rtf":           "Rich Text Format",
+	"application/msword":        "MS Word 97",
+	"application/vnd.ms-excel":  "MS Excel 97",
+
+	"application/vnd.ms-powerpoint":                                             "MS PowerPoint 97",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "MS Word 2007 XML",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "Calc MS Excel 2007 XML",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "Impress MS PowerPoint 2007 XML",
+}
+
+// lofficeFilter returns the LibreOffice import filter for the content-type,
+// or the empty string for auto-detection.
+func lofficeFilter(contentType string) string {
+	if ct, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = ct
+	}
+	return LofficeFilters[contentType]
+}
+
 var (
-	lofficeMu       = sync.Mutex{}
-	lofficePortLock = NewPortLock(LofficeLockPort)
+	lofficeMu   = sync.Mutex{} // protects lofficePool
+	lofficePool = newLofficePool(1, true, true)
 )
 
-// calls loffice converter with only one instance at a time,
-// in the input file's directory
-func lofficeConvert(ctx context.Context, outDir, inpfn string) error {
+// newLofficePool returns a pool of n LibreOffice workers.
+// The first worker uses the default user profile and LofficeLockPort,
+// so with n == 1 only one instance runs at a time, just as before.
+//
+// In safe mode every worker has its own profile, with the macros disabled
+// (see writeLofficeSafeProfile), as the default profile is not ours to change.
+func newLofficePool(n int, usePortLock, safe bool) chan *lofficeWorker {
+	if n < 1 {
+		n = 1
+	}
+	pool := make(chan *lofficeWorker, n)
+	for i := 0; i < n; i++ {
+		w := &lofficeWorker{safe: safe}
+		if i > 0 || safe {
+			w.profile = filepath.Join(Workdir, "loffice-profile-"+strconv.Itoa(i))
+		}
+		if usePortLock {
+			w.portLock = NewPortLock(LofficeLockPort + i)
+		}
+		pool <- w
+	}
+	return pool
+}
+
+// args returns the LibreOffice arguments needed for this worker.
+func (w *lofficeWorker) args() []string {
+	var args []string
+	if w.profile != "" {
+		p := filepath.ToSlash(w.profile)
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		args = append(args, "-env:UserInstallation=file://"+p)
+	}
+	if w.safe {
+		args = append(args, "--norestore", "--nolockcheck", "--nodefault")
+	}
+	return args
+}
+
+// calls loffice converter with at most len(lofficePool) instances at a time,
+// in the input file's directory, with the given import filter (auto-detected if empty)
+func lofficeConvert(ctx context.Context, outDir, inpfn, filter string) error {
 	if outDir == "" {
 		return errors.New("outDir is required!")
 	}
 	Log := getLogger(ctx).Log
-	args := []string{"--headless", "--convert-to", "pdf", "--outdir",
-		outDir, inpfn}
 	lofficeMu.Lock()
-	defer lofficeMu.Unlock()
-	if lofficePortLock != nil {
-		lofficePortLock.Lock()
-		defer lofficePortLock.Unlock()
+	pool := lofficePool
+	lofficeMu.Unlock()
+	w := <-pool
+	defer func() { pool <- w }()
+	if cap(pool) > 1 {
+		defer ConcLimit.Release(ConcLimit.Acquire())
+	}
+	if w.portLock != nil {
+		w.portLock.Lock()
+		defer w.portLock.Unlock()
+	}
+	if w.safe {
+		if err := writeLofficeSafeProfile(w.profile); err != nil {
+			return err
+		}
 	}
+	args := append(w.args(), "--headless")
+	if filter != "" {
+		args = append(args, "--infilter="+filter)
+	}
+	args = append(args, "--convert-to", "pdf", "--outdir", outDir, inpfn)
 	cmd := exec.Command(*ConfLoffice, args...)
 	cmd.Dir = filepath.Dir(inpfn)
 	cmd.Stderr = os.Stderr
@@ -248,7 +470,7 @@ func lofficeConvert(ctx context.Context, outDir, inpfn string) error {
 		}
 	}
 
-	err := runWithTimeout(cmd)
+	err := runWithContext(ctx, cmd)
 	if err != nil {
 		return err
 	}
@@ -259,23 +481,29 @@ func lofficeConvert(ctx context.Context, outDir, inpfn string) error {
 	return nil
 }
 
-// calls wkhtmltopdf
+// calls wkhtmltopdf, with the WkhtmltopdfOptions from the context
 func wkhtmltopdf(ctx context.Context, outfn, inpfn string) error {
 	Log := getLogger(ctx).Log
-	args := []string{
-		"--quiet",
+	opts := getWkhtmltopdfOptions(ctx)
+	args := append([]string{"--quiet"}, opts.args()...)
+	hfArgs, cleanup, err := opts.headerFooterArgs(filepath.Dir(inpfn))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	args = append(args, hfArgs...)
+	args = append(args,
 		inpfn,
 		"--encoding", "utf-8",
 		"--load-error-handling", "ignore",
 		"--load-media-error-handling", "ignore",
-		outfn}
+		outfn)
 	var buf bytes.Buffer
 	cmd := exec.Command(*ConfWkhtmltopdf, args...)
 	cmd.Dir = filepath.Dir(inpfn)
 	cmd.Stderr = &buf
 	cmd.Stdout = os.Stdout
-	err := runWithTimeout(cmd)
-	if err != nil {
+	if err = runWithContext(ctx, cmd); err != nil {
 		if bytes.HasSuffix(buf.Bytes(), []byte("ContentNotFoundError\n")) ||
 			bytes.HasSuffix(buf.Bytes(), []byte("ProtocolUnknownError\n")) ||
 			bytes.HasSuffix(buf.Bytes(), []byte("HostNotFoundError\n")) { // K-MT11422:99503
@@ -321,12 +549,21 @@ var ExtContentType = map[string]string{
 	"odi": "application/vnd.oasis.image",
 
 	"txt": "text/plain",
+	"csv": "text/csv",
+	"tsv": "text/tab-separated-values",
+	"ics": "text/calendar",
 	"msg": "application/x-ole-storage",
+	"eml": "message/rfc822",
 
 	"jpg":  "image/jpeg",
 	"jpeg": "image/jpeg",
 	"gif":  "image/gif",
 	"png":  "image/png",
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"heic": "image/heic",
+	"heif": "image/heif",
+	"svg":  "image/svg+xml",
 }
 
 func fixCT(contentType, fileName string) (ct string) {
@@ -352,6 +589,12 @@ func fixCT(contentType, fileName string) (ct string) {
 		return "application/rar"
 	case "image/pdf":
 		return "application/pdf"
+	case "image/tif", "image/x-tiff":
+		return "image/tiff"
+	case "image/heic-sequence", "image/x-heic":
+		return "image/heic"
+	case "image/heif-sequence", "image/x-heif":
+		return "image/heif"
 	}
 	return contentType
 }
@@ -366,6 +609,20 @@ func FixContentType(body []byte, contentType, fileName string) (ct string) {
 	}()
 
 	contentType = fixCT(contentType, fileName)
+	if (contentType == "" || contentType == "application/octet-stream") && isHEIF(body) {
+		return "image/heic"
+	}
+	if (contentType == "" || contentType == "application/octet-stream") && isRFC822(body) {
+		return "message/rfc822"
+	}
+	if needsOOXMLSniff(contentType) {
+		if nct := sniffOOXML(body); nct != "" {
+			return nct
+		}
+	}
+	if nct := sniffOffice(body, contentType, fileName); nct != "" {
+		return nct
+	}
 	var useMagic bool
 	ext := filepath.Ext(fileName)
 	useMagic = ext == ".pdf" && contentType != "application/pdf"
@@ -383,16 +640,16 @@ func FixContentType(body []byte, contentType, fileName string) (ct string) {
 			return fixCT(nct, fileName)
 		}
 	}
-	c := GetConverter(contentType, nil)
-	if c == nil { // no converter for this
+	ok := convertible(contentType, nil)
+	if !ok { // no converter for this
 		if nct := mimemagic.Match(contentType, body); nct != "" {
 			return fixCT(nct, fileName)
 		}
 	}
 	if fileName != "" &&
-		(contentType == "" || contentType == "application/octet-stream" || c == nil) {
+		(contentType == "" || contentType == "application/octet-stream" || !ok) {
 		if ext := filepath.Ext(fileName); len(ext) > 3 {
-			if nct, ok := ExtContentType[ext[1:]]; ok {
+			if nct, ok := extContentType(ext[1:]); ok {
 				return fixCT(nct, fileName)
 			}
 			if nct := mime.TypeByExtension(ext); nct != "" {
@@ -404,9 +661,20 @@ func FixContentType(body []byte, contentType, fileName string) (ct string) {
 	return contentType
 }
 
-// GetConverter gets converter for the content-type
+// GetConverter gets converter for the content-type.
+// The converters registered with RegisterConverter are used for the content-types
+// without a built-in converter, or if they were registered with Override.
+// The content-types not allowed (see CheckContentType) get a converter which
+// returns ErrContentTypeNotAllowed.
+// The unknown content-types get what ConfUnknownStrategy says (nil by default).
 func GetConverter(contentType string, mediaType map[string]string) (converter Converter) {
-	converter = nil
+	if CheckContentType(contentType) != nil {
+		return refuseContentType
+	}
+	registered, override := registeredConverter(contentType)
+	if registered != nil && override {
+		return registered
+	}
 	switch contentType {
 	case "application/pdf":
 		converter = PdfToPdf
@@ -421,12 +689,27 @@ func GetConverter(contentType string, mediaType map[string]string) (converter Co
 		if converter == nil {
 			converter = TextToPdf
 		}
+	case "text/csv", "text/tab-separated-values":
+		delim := mediaType["delimiter"]
+		if delim == "" && contentType == "text/tab-separated-values" {
+			delim = "\t"
+		}
+		converter = NewCSVConverter(delim, mediaType["charset"])
 	case "text/html":
 		converter = HTMLToPdf
+	case "text/calendar":
+		converter = ICSToPdf
+	case "image/svg+xml":
+		converter = SVGToPdf
 	case "message/rfc822":
 		converter = MailToPdfZip
+	case "application/x-ole-storage", "application/vnd.ms-outlook":
+		// sniffOffice tells apart the other OLE2 Office documents
+		converter = OutlookToPdfZip
 	case "multipart/related":
 		converter = MPRelatedToPdf
+	case "multipart/alternative":
+		converter = MPAlternativeToPdf
 	case "application/x-pkcs7-signature":
 		converter = Skip
 	default:
@@ -438,7 +721,6 @@ func GetConverter(contentType string, mediaType map[string]string) (converter Co
 			strings.HasPrefix(contentType, "application/vnd.ms-word") ||
 			strings.HasPrefix(contentType, "application/vnd.ms-excel") ||
 			strings.HasPrefix(contentType, "application/vnd.ms-powerpoint") ||
-			contentType == "application/x-ole-storage" ||
 			//StarOffice
 			strings.HasPrefix(contentType, "application/vnd.sun.xml.") ||
 			strings.HasPrefix(contentType, "application/vnd.stardivision.") ||
@@ -448,6 +730,10 @@ func GetConverter(contentType string, mediaType map[string]string) (converter Co
 			converter = OfficeToPdf
 			break
 		}
+		if registered != nil {
+			converter = registered
+			break
+		}
 		i := strings.Index(contentType, "/")
 		if i > 0 {
 			switch contentType[:i] {
@@ -456,9 +742,12 @@ func GetConverter(contentType string, mediaType map[string]string) (converter Co
 			case "text":
 				converter = TextToPdf
 			case "audio", "video":
-				converter = nil
+				converter = refuseContentType
 			}
 		}
+		if converter == nil {
+			converter = unknownConverter()
+		}
 	}
 	return
 }
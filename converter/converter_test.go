@@ -7,20 +7,161 @@ package converter
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/charmap"
 )
 
 func TestTextToHTML(t *testing.T) {
 	var buf bytes.Buffer
-	r := textToHTML(strings.NewReader("árvíztűrő <em>tükörfúrógép</em>"))
+	r := textToHTML(strings.NewReader("árvíztűrő <em>tükörfúrógép</em>"), 80)
 	if _, err := io.Copy(&buf, r); err != nil {
 		t.Errorf("read: %v", err)
 	}
 	if !bytes.Equal(buf.Bytes(), []byte(`<!DOCTYPE html>
 <html>
-<head><meta charset="utf-8"></head>
-<body><pre>árvíztűrő &lt;em&gt;tükörfúrógép&lt;/em&gt;</pre></body></html>`)) {
+<head><meta charset="utf-8">`+textStyle()+`</head>
+<body><pre dir="auto">árvíztűrő &lt;em&gt;tükörfúrógép&lt;/em&gt;</pre></body></html>`)) {
 		t.Errorf("mismatch")
 	}
 }
+
+func TestTextStyle(t *testing.T) {
+	oldFonts, oldCSS := *ConfTextFonts, *ConfTextCSS
+	defer func() { *ConfTextFonts, *ConfTextCSS = oldFonts, oldCSS }()
+	*ConfTextFonts, *ConfTextCSS = `"Noto Naskh Arabic", monospace`, "pre { font-size: 9pt; }</style><script>"
+	want := `<style>pre { unicode-bidi: plaintext; font-family: "Noto Naskh Arabic", monospace; }
+pre { font-size: 9pt; }<\/style><script></style>`
+	if got := textStyle(); got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	*ConfTextFonts, *ConfTextCSS = "", ""
+	if got, want := textStyle(), "<style>pre { unicode-bidi: plaintext; }</style>"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestSniffCharset(t *testing.T) {
+	const hun = "árvíztűrő tükörfúrógép"
+	latin2, _ := charmap.ISO8859_2.NewEncoder().String(hun)
+	cp1250, _ := charmap.Windows1250.NewEncoder().String("„" + hun + "” – Šíp")
+	for i, tc := range []struct {
+		in, charset, out string
+	}{
+		{hun, "utf-8", hun},
+		{"\xef\xbb\xbf" + hun, "utf-8", hun},
+		{"\xff\xfea\x00b\x00", "utf-16le", "ab"},
+		{latin2, "iso-8859-2", hun},
+		{cp1250, "windows-1250", "„" + hun + "” – Šíp"},
+	} {
+		cs, r := sniffCharset(strings.NewReader(tc.in))
+		if cs != tc.charset {
+			t.Errorf("%d. got charset %q, wanted %q", i, cs, tc.charset)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%d. read: %v", i, err)
+			continue
+		}
+		if string(b) != tc.out {
+			t.Errorf("%d. got %q, wanted %q", i, b, tc.out)
+		}
+	}
+}
+
+func TestValidUTF8Prefix(t *testing.T) {
+	b := []byte("tűz")
+	for i, tc := range []struct {
+		in   []byte
+		want bool
+	}{
+		{b, true},
+		{b[:2], true}, // cut in the middle of "ű"
+		{[]byte{'a', 0xfb, 'z'}, false},
+	} {
+		if got := validUTF8Prefix(tc.in); got != tc.want {
+			t.Errorf("%d. %q: got %t, wanted %t", i, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	for i, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{ErrSkip, "skip"},
+		{errors.Wrap(ErrSkip, "part"), "skip"},
+		{ErrNoConverter, "error"},
+	} {
+		if got := outcome(tc.err); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+	if n := NewRateLimiter(2); n.InUse() != 0 {
+		t.Errorf("fresh limiter in use: %d", n.InUse())
+	} else if tok := n.Acquire(); n.InUse() != 1 {
+		t.Errorf("after Acquire, got %d in use", n.InUse())
+	} else if n.Release(tok); n.InUse() != 0 {
+		t.Errorf("after Release, got %d in use", n.InUse())
+	}
+}
+
+func TestLofficeFilter(t *testing.T) {
+	for ct, want := range map[string]string{
+		"text/csv":                                "Text - txt - csv (StarCalc):44,34,76",
+		"text/csv; charset=utf-8":                 "Text - txt - csv (StarCalc):44,34,76",
+		"application/msword":                      "MS Word 97",
+		"application/x-unknown":                   "",
+		"application/vnd.oasis.opendocument.text": "",
+	} {
+		if got := lofficeFilter(ct); got != want {
+			t.Errorf("%q: got %q, wanted %q", ct, got, want)
+		}
+	}
+}
+
+func TestLofficeSafeMode(t *testing.T) {
+	has := func(args []string, arg string) bool {
+		for _, a := range args {
+			if a == arg || strings.HasPrefix(a, arg) {
+				return true
+			}
+		}
+		return false
+	}
+	safe := <-newLofficePool(1, false, true)
+	args := safe.args()
+	for _, want := range []string{"--norestore", "-env:UserInstallation=file://"} {
+		if !has(args, want) {
+			t.Errorf("safe args %q miss %q", args, want)
+		}
+	}
+	if args := (<-newLofficePool(1, false, false)).args(); has(args, "--norestore") {
+		t.Errorf("unsafe args %q have --norestore", args)
+	}
+
+	dir, err := ioutil.TempDir("", "loffice-profile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 2; i++ {
+		if err = writeLofficeSafeProfile(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "user", "registrymodifications.xcu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"MacroSecurityLevel" oor:op="fuse"><value>3</value>`) {
+		t.Errorf("MacroSecurityLevel=3 is missing from %s", b)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"html"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// CSVToPdf converts CSV (text/csv) or TSV (text/tab-separated-values) to a PDF table.
+// The delimiter and the charset can be given with the "delimiter" and "charset"
+// parameters of the content-type.
+func CSVToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	ct, params, _ := mime.ParseMediaType(contentType)
+	delim := params["delimiter"]
+	if delim == "" && ct == "text/tab-separated-values" {
+		delim = "\t"
+	}
+	return NewCSVConverter(delim, params["charset"])(ctx, destfn, r, ct)
+}
+
+// NewCSVConverter returns a Converter for CSV with the given delimiter
+// ("," if empty, "tab" means "\t"), and charset (sniffed if empty).
+func NewCSVConverter(delimiter, charset string) Converter {
+	return func(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+		comma, err := csvDelimiter(delimiter)
+		if err != nil {
+			return err
+		}
+		charset, r := csvDecoder(r, charset)
+		getLogger(ctx).Log("msg", "Converting into", "ct", contentType, "charset", charset,
+			"delimiter", string(comma), "dest", destfn)
+		var buf bytes.Buffer
+		if err = csvToHTML(&buf, r, comma, *ConfCSVMaxColumns); err != nil {
+			return err
+		}
+		return HTMLToPdf(ctx, destfn, &buf, "text/html")
+	}
+}
+
+// csvDecoder returns the reader of r converted from the charset to UTF-8, and the charset.
+// For UTF-8, and the empty or unknown charsets, it is sniffed (see sniffCharset).
+func csvDecoder(r io.Reader, charset string) (string, io.Reader) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+	default:
+		if enc, err := htmlindex.Get(charset); err == nil {
+			return charset, transform.NewReader(r, enc.NewDecoder())
+		}
+	}
+	return sniffCharset(r)
+}
+
+func csvDelimiter(delimiter string) (rune, error) {
+	switch delimiter {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	c, size := utf8.DecodeRuneInString(delimiter)
+	if c == utf8.RuneError || size != len(delimiter) || c == '"' || c == '\r' || c == '\n' {
+		return 0, errors.Errorf("bad CSV delimiter %q", delimiter)
+	}
+	return c, nil
+}
+
+// csvToHTML writes the CSV read from r as HTML table(s), the first row as header.
+// Rows wider than maxColumns are wrapped: the columns after the first
+// maxColumns go into another table, with the same header.
+func csvToHTML(w io.Writer, r io.Reader, comma rune, maxColumns int) error {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return errors.Wrap(err, "parse CSV")
+	}
+	var width int
+	for _, rec := range records {
+		if len(rec) > width {
+			width = len(rec)
+		}
+	}
+	if maxColumns <= 0 || maxColumns > width {
+		maxColumns = width
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8">
+<style>
+table { border-collapse: collapse; margin-bottom: 1em; font-size: 10pt; }
+th, td { border: 1px solid #888; padding: 2px 4px; vertical-align: top; }
+th { background: #ddd; }
+</style></head>
+<body>
+`)
+	for from := 0; from < width || from == 0; from += maxColumns {
+		to := from + maxColumns
+		if to > width {
+			to = width
+		}
+		buf.WriteString("<table>\n")
+		for i, rec := range records {
+			cell := "td"
+			if i == 0 {
+				cell = "th"
+			}
+			buf.WriteString("<tr>")
+			for j := from; j < to; j++ {
+				var field string
+				if j < len(rec) {
+					field = rec[j]
+				}
+				buf.WriteString("<" + cell + ">" +
+					strings.Replace(html.EscapeString(field), "\n", "<br/>", -1) +
+					"</" + cell + ">")
+			}
+			buf.WriteString("</tr>\n")
+		}
+		buf.WriteString("</table>\n")
+		if width == 0 {
+			break
+		}
+	}
+	buf.WriteString("</body></html>")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCSVToHTML(t *testing.T) {
+	var buf bytes.Buffer
+	in := "név;kor;megjegyzés\n\"Kovács; Éva\";42;\"első sor\nmásodik <sor>\"\nPéter;7\n"
+	if err := csvToHTML(&buf, strings.NewReader(in), ';', 2); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"<tr><th>név</th><th>kor</th></tr>",
+		"<tr><td>Kovács; Éva</td><td>42</td></tr>",
+		"<tr><td>Péter</td><td>7</td></tr>",
+		// wrapped into the second table, with header
+		"<tr><th>megjegyzés</th></tr>",
+		"<tr><td>első sor<br/>második &lt;sor&gt;</td></tr>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "<table>"); n != 2 {
+		t.Errorf("got %d tables, wanted 2", n)
+	}
+}
+
+func TestCSVDelimiter(t *testing.T) {
+	for in, want := range map[string]rune{"": ',', ";": ';', "tab": '\t', "\t": '\t', "|": '|'} {
+		if got, err := csvDelimiter(in); err != nil || got != want {
+			t.Errorf("%q: got %q (%v), wanted %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{`"`, ";;", "\n"} {
+		if _, err := csvDelimiter(in); err == nil {
+			t.Errorf("%q: wanted error", in)
+		}
+	}
+}
+
+func TestCSVDecoder(t *testing.T) {
+	// 0xB9 is "ą" in windows-1250, but "š" in iso-8859-2 (which would be sniffed)
+	in := "n\xb9v;\xe1\n"
+	for i, tc := range []struct {
+		charset, want string
+	}{
+		{"windows-1250", "nąv;á\n"},
+		{"", "nšv;á\n"},
+		{"no-such-charset", "nšv;á\n"},
+		{"utf-8", "nšv;á\n"},
+	} {
+		_, r := csvDecoder(strings.NewReader(in), tc.charset)
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("%d. %q: got %q, wanted %q", i, tc.charset, b, tc.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrContentTypeNotAllowed is returned for the content-types refused by
+// ConfAllowedContentTypes and ConfDeniedContentTypes, and for audio and video.
+var ErrContentTypeNotAllowed = errors.New("content-type not allowed")
+
+// CheckContentType returns ErrContentTypeNotAllowed (wrapped) if the content-type
+// is denied by ConfDeniedContentTypes, or not allowed by ConfAllowedContentTypes.
+func CheckContentType(contentType string) error {
+	contentType = strings.ToLower(contentType)
+	if matchContentTypes(*ConfDeniedContentTypes, contentType) {
+		return errors.Wrapf(ErrContentTypeNotAllowed, "%s is denied", contentType)
+	}
+	if allowed := strings.TrimSpace(*ConfAllowedContentTypes); allowed != "" &&
+		!matchContentTypes(allowed, contentType) {
+		return errors.Wrapf(ErrContentTypeNotAllowed, "%s is not allowed", contentType)
+	}
+	return nil
+}
+
+// matchContentTypes reports whether the content-type matches any of the
+// comma-separated patterns: "image/png" matches only itself,
+// "video/*" matches every video type.
+func matchContentTypes(patterns, contentType string) bool {
+	for _, p := range strings.Split(patterns, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if p == "*" || p == "*/*" || p == contentType ||
+			strings.HasSuffix(p, "/*") && strings.HasPrefix(contentType, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// The strategies for the content-types without a converter (ConfUnknownStrategy).
+const (
+	UnknownSkip   = "skip"   // no converter: skipped
+	UnknownOffice = "office" // try OtherToPdf (LibreOffice)
+	UnknownError  = "error"  // refused with ErrNoConverter
+)
+
+// checkUnknownStrategy returns an error if the strategy is not known.
+func checkUnknownStrategy(strategy string) error {
+	switch strategy {
+	case UnknownSkip, UnknownOffice, UnknownError:
+		return nil
+	}
+	return errors.Errorf("unknown strategy %q (want %s, %s or %s)",
+		strategy, UnknownSkip, UnknownOffice, UnknownError)
+}
+
+// unknownConverter returns the converter of the content-types GetConverter
+// does not know, according to ConfUnknownStrategy.
+func unknownConverter() Converter {
+	switch *ConfUnknownStrategy {
+	case UnknownOffice:
+		return OtherToPdf
+	case UnknownError:
+		return refuseUnknown
+	}
+	return nil
+}
+
+// refuseUnknown is the converter of the unknown content-types with UnknownError.
+func refuseUnknown(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	return errors.Wrapf(ErrNoConverter, "%s is unknown", contentType)
+}
+
+// refuseContentType is the converter of the content-types which are not allowed.
+func refuseContentType(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	if err := CheckContentType(contentType); err != nil {
+		return err
+	}
+	return errors.Wrap(ErrContentTypeNotAllowed, contentType)
+}
+
+// convertible reports whether the content-type has a converter, and is allowed.
+func convertible(contentType string, mediaType map[string]string) bool {
+	c := GetConverter(contentType, mediaType)
+	if c == nil {
+		return false
+	}
+	p := reflect.ValueOf(c).Pointer()
+	return p != reflect.ValueOf(refuseContentType).Pointer() &&
+		p != reflect.ValueOf(refuseUnknown).Pointer()
+}
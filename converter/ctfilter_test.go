@@ -0,0 +1,96 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+func TestCheckContentType(t *testing.T) {
+	defer func(a, d string) {
+		*ConfAllowedContentTypes, *ConfDeniedContentTypes = a, d
+	}(*ConfAllowedContentTypes, *ConfDeniedContentTypes)
+
+	for i, tc := range []struct {
+		allowed, denied, ct string
+		ok                  bool
+	}{
+		{"", "", "application/pdf", true},
+		{"", "video/*", "video/mp4", false},
+		{"", "video/*", "videos/x", true},
+		{"", "application/x-msdownload, video/*", "application/x-msdownload", false},
+		{"application/pdf,image/*", "", "image/png", true},
+		{"application/pdf,image/*", "", "text/plain", false},
+		{"image/*", "image/tiff", "image/tiff", false},
+		{"*", "", "text/plain", true},
+	} {
+		*ConfAllowedContentTypes, *ConfDeniedContentTypes = tc.allowed, tc.denied
+		err := CheckContentType(tc.ct)
+		if tc.ok && err != nil || !tc.ok && errors.Cause(err) != ErrContentTypeNotAllowed {
+			t.Errorf("%d. %q (allowed=%q denied=%q): got %v", i, tc.ct, tc.allowed, tc.denied, err)
+		}
+	}
+}
+
+func TestConvertNotAllowed(t *testing.T) {
+	defer func(d string) { *ConfDeniedContentTypes = d }(*ConfDeniedContentTypes)
+	ctx := context.Background()
+
+	*ConfDeniedContentTypes = "text/*"
+	err := Convert(ctx, "/nonexistent.pdf", bytes.NewReader([]byte("text")), "text/plain", "a.txt")
+	if errors.Cause(err) != ErrContentTypeNotAllowed {
+		t.Errorf("denied text/plain: got %v", err)
+	}
+
+	*ConfDeniedContentTypes = ""
+	for _, ct := range []string{"video/mp4", "audio/mpeg"} {
+		err = Convert(ctx, "/nonexistent.pdf", bytes.NewReader([]byte{0, 0, 0}), ct, "")
+		if errors.Cause(err) != ErrContentTypeNotAllowed {
+			t.Errorf("%s: got %v", ct, err)
+		}
+		if convertible(ct, nil) {
+			t.Errorf("%s should not be convertible", ct)
+		}
+	}
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	defer func(s string) { *ConfUnknownStrategy = s }(*ConfUnknownStrategy)
+	same := func(a, b Converter) bool {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	const ct = "application/x-unknown-to-agostle"
+
+	*ConfUnknownStrategy = UnknownSkip
+	if c := GetConverter(ct, nil); c != nil {
+		t.Errorf("skip: got a converter for %s", ct)
+	}
+	*ConfUnknownStrategy = UnknownOffice
+	if c := GetConverter(ct, nil); !same(c, OtherToPdf) {
+		t.Errorf("office: got %v for %s", c, ct)
+	}
+	if !convertible(ct, nil) {
+		t.Errorf("office: %s should be convertible", ct)
+	}
+	*ConfUnknownStrategy = UnknownError
+	err := Convert(context.Background(), "/nonexistent.pdf", bytes.NewReader([]byte{0, 1, 2}), ct, "")
+	if errors.Cause(err) != ErrNoConverter {
+		t.Errorf("error: got %v", err)
+	}
+	if convertible(ct, nil) {
+		t.Errorf("error: %s should not be convertible", ct)
+	}
+	if c := GetConverter("video/mp4", nil); !same(c, refuseContentType) {
+		t.Errorf("error: video/mp4 should be refused, got %v", c)
+	}
+	if err := checkUnknownStrategy("maybe"); err == nil {
+		t.Error("wanted error for strategy maybe")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	debugKey        = "debug"
+	originalHTMLKey = "originalHTML"
+)
+
+// debugFlag is the debug switch of one request, which can be turned on
+// after the context is created (when the form of the request is parsed).
+type debugFlag struct {
+	on int32
+}
+
+// WithDebug returns a context with a debug switch (turned off), see SetDebug.
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey, &debugFlag{})
+}
+
+// SetDebug turns on the debug switch of the context (set by WithDebug),
+// making the conversions of that context leave their temp files (as LeaveTempFiles)
+// and save the original HTML (as SaveOriginalHTML).
+// It reports whether the context has a debug switch.
+func SetDebug(ctx context.Context) bool {
+	f, ok := ctx.Value(debugKey).(*debugFlag)
+	if ok {
+		atomic.StoreInt32(&f.on, 1)
+	}
+	return ok
+}
+
+// IsDebug reports whether the debug switch of the context is turned on.
+func IsDebug(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	f, ok := ctx.Value(debugKey).(*debugFlag)
+	return ok && atomic.LoadInt32(&f.on) == 1
+}
+
+// KeepTempFiles reports whether the temp files should be left in place:
+// LeaveTempFiles is set, or the context is in debug mode.
+func KeepTempFiles(ctx context.Context) bool {
+	return LeaveTempFiles || IsDebug(ctx)
+}
+
+// WithOriginalHTML returns a context which asks for keeping the HTML fed to the
+// HTML converters, in the zips, as with SaveOriginalHTML.
+func WithOriginalHTML(ctx context.Context, keep bool) context.Context {
+	return context.WithValue(ctx, originalHTMLKey, keep)
+}
+
+// keepOriginalHTML reports whether the original HTML should be saved:
+// SaveOriginalHTML is set, the context asks for it (WithOriginalHTML),
+// or the context is in debug mode.
+func keepOriginalHTML(ctx context.Context) bool {
+	if ctx != nil {
+		if keep, ok := ctx.Value(originalHTMLKey).(bool); ok && keep {
+			return true
+		}
+	}
+	return SaveOriginalHTML || IsDebug(ctx)
+}
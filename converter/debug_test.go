@@ -0,0 +1,77 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestDebug(t *testing.T) {
+	ctx := context.Background()
+	if SetDebug(ctx) {
+		t.Error("SetDebug succeeded without a debug switch")
+	}
+	if KeepTempFiles(ctx) || keepOriginalHTML(ctx) {
+		t.Error("debug mode without a debug switch")
+	}
+
+	ctx = WithDebug(ctx)
+	// the switch is shared by the derived contexts
+	child := context.WithValue(ctx, "reqid", "x")
+	if KeepTempFiles(child) {
+		t.Error("debug mode before SetDebug")
+	}
+	if !SetDebug(ctx) {
+		t.Error("SetDebug failed")
+	}
+	if !KeepTempFiles(child) || !keepOriginalHTML(child) {
+		t.Error("no debug mode after SetDebug")
+	}
+	if KeepTempFiles(WithDebug(context.Background())) {
+		t.Error("debug mode leaked into an other request")
+	}
+}
+
+func TestOriginalHTML(t *testing.T) {
+	defer func(w string) { *ConfWkhtmltopdf = w }(*ConfWkhtmltopdf)
+	*ConfWkhtmltopdf = "/nonexistent/wkhtmltopdf"
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	if keepOriginalHTML(ctx) {
+		t.Fatal("original HTML is kept by default")
+	}
+	ctx = WithOriginalHTML(ctx, true)
+	if !keepOriginalHTML(ctx) || KeepTempFiles(ctx) {
+		t.Fatal("WithOriginalHTML should keep only the original HTML")
+	}
+
+	dir, err := ioutil.TempDir("", "srchtml-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const html = "<html><body>árvíztűrő</body></html>"
+	destfn := filepath.Join(dir, "01#002.text--html..pdf")
+	// the conversion fails, but the source is kept nevertheless
+	_ = HTMLToPdf(ctx, destfn, strings.NewReader(html), "text/html")
+	b, err := ioutil.ReadFile(filepath.Join(dir, "01#002.text--html."+SrcHTMLSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != html {
+		t.Errorf("got %q, wanted %q", b, html)
+	}
+	if fileExists(filepath.Join(dir, "01#002.text--html..html")) {
+		t.Error("the temp HTML is left behind")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+const dedupKey = "dedup"
+
+// dedupParts tracks the already converted mail parts by content hash,
+// for ConfDedupAttachments.
+type dedupParts struct {
+	mu   sync.Mutex
+	seen map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	done chan struct{}
+	fn   string // the converted PDF
+	err  error
+}
+
+// withDedup returns a context with a new dedupParts, if ConfDedupAttachments is set,
+// and there is no dedupParts in the context yet (embedded mails share the parent's).
+func withDedup(ctx context.Context) context.Context {
+	if !*ConfDedupAttachments || getDedup(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, dedupKey, &dedupParts{seen: make(map[string]*dedupEntry, 16)})
+}
+
+func getDedup(ctx context.Context) *dedupParts {
+	dp, _ := ctx.Value(dedupKey).(*dedupParts)
+	return dp
+}
+
+// convert spools the part's body to fn, and converts it to fn+".pdf" with conv -
+// or, if an identical part has already been converted, links that result.
+// Small duplicates are dropped (ErrSkip), as are duplicates of failed parts,
+// as that failure is already reported.
+//
+// mp.Body is replaced with the spooled file, for the error report.
+func (dp *dedupParts) convert(ctx context.Context, fn string, mp *i18nmail.MailPart, conv Converter) error {
+	Log := getLogger(ctx).Log
+	fh, err := os.Create(fn)
+	if err != nil {
+		return errors.Wrap(err, fn)
+	}
+	size, err := io.Copy(fh, mp.Body)
+	if err == nil {
+		_, err = fh.Seek(0, 0)
+	}
+	if err != nil {
+		_ = fh.Close()
+		return errors.Wrap(err, fn)
+	}
+	mp.Body = fh
+	hsh := getHash(fn)
+	if hsh == "" {
+		if err = conv(ctx, fn+".pdf", fh, mp.ContentType); err != nil {
+			if _, seekErr := fh.Seek(0, 0); seekErr != nil {
+				Log("msg", "seek", "file", fn, "error", seekErr)
+			}
+			return err
+		}
+		_ = fh.Close()
+		_ = os.Remove(fn)
+		return nil
+	}
+
+	dp.mu.Lock()
+	e, dup := dp.seen[hsh]
+	if !dup {
+		e = &dedupEntry{done: make(chan struct{}), fn: fn + ".pdf"}
+		dp.seen[hsh] = e
+	}
+	dp.mu.Unlock()
+
+	if !dup {
+		e.err = conv(ctx, e.fn, fh, mp.ContentType)
+		close(e.done)
+		if e.err != nil {
+			if _, seekErr := fh.Seek(0, 0); seekErr != nil {
+				Log("msg", "seek", "file", fn, "error", seekErr)
+			}
+			return e.err
+		}
+		_ = fh.Close()
+		_ = os.Remove(fn)
+		return nil
+	}
+
+	<-e.done
+	_ = fh.Close()
+	_ = os.Remove(fn)
+	if e.err != nil {
+		Log("msg", "duplicate of a failed part", "seq", mp.Seq, "hash", hsh)
+		return ErrSkip
+	}
+	if size < *ConfDedupMinSize {
+		Log("msg", "dropping duplicate", "seq", mp.Seq, "hash", hsh, "size", size)
+		return ErrSkip
+	}
+	Log("msg", "referencing duplicate", "seq", mp.Seq, "hash", hsh, "of", e.fn)
+	if err = os.Link(e.fn, fn+".pdf"); err != nil {
+		if err = copyFile(e.fn, fn+".pdf"); err != nil {
+			return errors.Wrapf(err, "copy %q to %q", e.fn, fn+".pdf")
+		}
+	}
+	return nil
+}
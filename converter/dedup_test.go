@@ -0,0 +1,81 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+func TestDedupParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-dedup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldMin := *ConfDedupMinSize
+	*ConfDedupMinSize = 8
+	defer func() { *ConfDedupMinSize = oldMin }()
+
+	var calls int
+	conv := func(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+		calls++
+		fh, err := os.Create(destfn)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fh, r)
+		if closeErr := fh.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	dp := &dedupParts{seen: make(map[string]*dedupEntry)}
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	for i, tc := range []struct {
+		body   string
+		exists bool
+		err    error
+	}{
+		{"a big attachment", true, nil},
+		{"logo", true, nil},
+		{"a big attachment", true, nil},
+		{"logo", false, ErrSkip},
+	} {
+		fn := filepath.Join(dir, strings.Repeat("x", i+1))
+		mp := i18nmail.MailPart{ContentType: "text/plain", Body: bytes.NewReader([]byte(tc.body))}
+		if err := dp.convert(ctx, fn, &mp, conv); err != tc.err {
+			t.Errorf("%d. got error %v, wanted %v", i, err, tc.err)
+			continue
+		}
+		b, err := ioutil.ReadFile(fn + ".pdf")
+		if !tc.exists {
+			if err == nil {
+				t.Errorf("%d. %q should not exist", i, fn+".pdf")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. %v", i, err)
+		} else if string(b) != tc.body {
+			t.Errorf("%d. got %q, wanted %q", i, b, tc.body)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("converter called %d times, wanted 2", calls)
+	}
+}
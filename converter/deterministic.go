@@ -0,0 +1,133 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// deterministicTime returns the date of the deterministic PDFs (see ConfDeterministic):
+// SOURCE_DATE_EPOCH if it is set, the Unix epoch otherwise.
+func deterministicTime() time.Time {
+	if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+		if sec, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+		Log("msg", "bad SOURCE_DATE_EPOCH", "value", s)
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// deterministicEnv returns the environment of the commands writing PDFs:
+// Ghostscript uses SOURCE_DATE_EPOCH for the dates and the document ID.
+func deterministicEnv() []string {
+	return append(os.Environ(),
+		"SOURCE_DATE_EPOCH="+strconv.FormatInt(deterministicTime().Unix(), 10))
+}
+
+var (
+	pdfDateRx = regexp.MustCompile(`/(?:CreationDate|ModDate)\s*\((D:[^)]*)\)`)
+	pdfIDRx   = regexp.MustCompile(`/ID\s*\[\s*<([0-9A-Fa-f]*)>\s*<([0-9A-Fa-f]*)>\s*\]`)
+)
+
+// pdfMakeDeterministic rewrites the creation and modification dates of the PDF
+// to deterministicTime, and its document ID to the hash of the content,
+// if ConfDeterministic is set; so the same inputs produce the same bytes.
+//
+// The file is patched in place without changing any offset, so only the
+// uncompressed dictionaries are changed; encrypted files are left as is,
+// as their ID is part of the key.
+func pdfMakeDeterministic(fn string) error {
+	if !*ConfDeterministic {
+		return nil
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return errors.Wrap(err, "read "+fn)
+	}
+	if !makeDeterministic(b) {
+		return nil
+	}
+	tmpfn := fn + "-deterministic.pdf"
+	if err = ioutil.WriteFile(tmpfn, b, 0644); err != nil {
+		_ = os.Remove(tmpfn)
+		return errors.Wrap(err, "write "+tmpfn)
+	}
+	return errors.Wrap(moveFile(tmpfn, fn), fn)
+}
+
+// makeDeterministic patches the dates and the ID of the PDF in b,
+// reporting whether it has changed anything.
+func makeDeterministic(b []byte) bool {
+	if bytes.Contains(b, []byte("/Encrypt")) {
+		Log("msg", "encrypted PDF is not made deterministic")
+		return false
+	}
+	orig := md5.Sum(b)
+	t := deterministicTime()
+	for _, loc := range pdfDateRx.FindAllSubmatchIndex(b, -1) {
+		// the date and the closing parenthesis, padded with spaces
+		start, end := loc[2], loc[3]
+		d := pdfDate(t, end-start)
+		if d == "" {
+			continue
+		}
+		n := copy(b[start:], d)
+		b[start+n] = ')'
+		for i := start + n + 1; i <= end; i++ {
+			b[i] = ' '
+		}
+	}
+	ids := pdfIDRx.FindAllSubmatchIndex(b, -1)
+	for _, loc := range ids {
+		for i := loc[2]; i < loc[3]; i++ {
+			b[i] = '0'
+		}
+		for i := loc[4]; i < loc[5]; i++ {
+			b[i] = '0'
+		}
+	}
+	if len(ids) > 0 {
+		sum := md5.Sum(b)
+		id := hex.EncodeToString(sum[:])
+		for _, loc := range ids {
+			fillRepeat(b[loc[2]:loc[3]], id)
+			fillRepeat(b[loc[4]:loc[5]], id)
+		}
+	}
+	return md5.Sum(b) != orig
+}
+
+// fillRepeat fills p with s, repeated as needed.
+func fillRepeat(p []byte, s string) {
+	for i := 0; i < len(p); i += len(s) {
+		copy(p[i:], s)
+	}
+}
+
+// pdfDate returns the longest form of the PDF date of t, which is at most n bytes long,
+// or the empty string if even the shortest form (D:YYYY) is longer.
+func pdfDate(t time.Time, n int) string {
+	full := "D:" + t.UTC().Format("20060102150405")
+	for _, d := range []string{
+		full + "+00'00'", full + "+00'00", full + "Z", full,
+		full[:14], full[:12], full[:10], full[:8], full[:6],
+	} {
+		if len(d) <= n {
+			return d
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakeDeterministic(t *testing.T) {
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	os.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+
+	pdf := func(created, modified, id1, id2 string) []byte {
+		return []byte(`%PDF-1.4
+1 0 obj
+<< /Producer (pdftk) /CreationDate (` + created + `) /ModDate (` + modified + `) >>
+endobj
+trailer
+<< /Info 1 0 R /ID [<` + id1 + `> <` + id2 + `>] >>
+%%EOF
+`)
+	}
+	a := pdf("D:20170102030405+01'00'", "D:20170102030405Z", "0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210")
+	b := pdf("D:20180809101112-05'00'", "D:20180809101112Z", "abcdefabcdefabcdefabcdefabcdef01", "00112233445566778899aabbccddeeff")
+	la, lb := len(a), len(b)
+	if !makeDeterministic(a) || !makeDeterministic(b) {
+		t.Fatal("nothing changed")
+	}
+	if len(a) != la || len(b) != lb {
+		t.Errorf("length changed")
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("differs:\n%s\n%s", a, b)
+	}
+	want := "/CreationDate (D:20170714024000+00'00')"
+	if !bytes.Contains(a, []byte(want)) {
+		t.Errorf("no %q in\n%s", want, a)
+	}
+	if !bytes.Contains(a, []byte("/ModDate (D:20170714024000Z)")) {
+		t.Errorf("bad ModDate in\n%s", a)
+	}
+	if bytes.Contains(a, []byte("<00000000")) {
+		t.Errorf("zero ID in\n%s", a)
+	}
+	if makeDeterministic(a) {
+		t.Error("changed again")
+	}
+
+	enc := append(pdf("D:2017", "D:2017", "01", "02"), "/Encrypt 5 0 R"...)
+	if makeDeterministic(enc) {
+		t.Error("encrypted PDF changed")
+	}
+}
+
+func TestPdfDate(t *testing.T) {
+	tm := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	for n, want := range map[int]string{
+		30: "D:20170714024000+00'00'",
+		17: "D:20170714024000Z",
+		15: "D:201707140240",
+		6:  "D:2017",
+		5:  "",
+	} {
+		if got := pdfDate(tm, n); got != want {
+			t.Errorf("%d: got %q, wanted %q", n, got, want)
+		}
+	}
+	if !strings.HasPrefix(pdfDate(tm, 100), "D:") {
+		t.Error("no D: prefix")
+	}
+}
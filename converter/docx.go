@@ -0,0 +1,72 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// isWordprocessingML reports whether the content type is an OOXML word processing
+// document (.docx) or template (.dotx).
+func isWordprocessingML(contentType string) bool {
+	return strings.Contains(contentType, "officedocument.wordprocessingml.")
+}
+
+// docxExt returns the file extension for the wordprocessingml content type,
+// as the converters usually decide on the extension.
+func docxExt(contentType string) string {
+	if strings.Contains(contentType, "wordprocessingml.template") {
+		return ".dotx"
+	}
+	return ".docx"
+}
+
+// docxBackendArgs returns the command line of the backend (such as ConfDocxBackend),
+// with the {input} and {output} placeholders replaced by inpfn and outfn.
+// The missing placeholders are appended, input first.
+func docxBackendArgs(backend, inpfn, outfn string) []string {
+	args := strings.Fields(backend)
+	var hasInp, hasOut bool
+	for i, a := range args {
+		if strings.Contains(a, "{input}") {
+			args[i], hasInp = strings.Replace(a, "{input}", inpfn, -1), true
+		}
+		if strings.Contains(args[i], "{output}") {
+			args[i], hasOut = strings.Replace(args[i], "{output}", outfn, -1), true
+		}
+	}
+	if !hasInp {
+		args = append(args, inpfn)
+	}
+	if !hasOut {
+		args = append(args, outfn)
+	}
+	return args
+}
+
+// docxBackendConvert converts the word processing document inpfn to the PDF outfn
+// with ConfDocxBackend.
+func docxBackendConvert(ctx context.Context, outfn, inpfn string) error {
+	args := docxBackendArgs(*ConfDocxBackend, inpfn, outfn)
+	if len(args) < 3 {
+		return errors.New("empty docxBackend")
+	}
+	if err := execute(ctx, exec.Command(args[0], args[1:]...)); err != nil {
+		return errors.Wrapf(err, "%q", args)
+	}
+	fi, err := os.Stat(outfn)
+	if err != nil {
+		return errors.Wrapf(err, "%q", args)
+	}
+	if fi.Size() == 0 {
+		return errors.Errorf("%q: empty output", args)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestDocxBackendArgs(t *testing.T) {
+	for i, tc := range []struct {
+		backend string
+		want    []string
+	}{
+		{"docx2pdf", []string{"docx2pdf", "a.docx", "a.pdf"}},
+		{"pandoc {input} -o {output}", []string{"pandoc", "a.docx", "-o", "a.pdf"}},
+		{"conv --out={output}", []string{"conv", "--out=a.pdf", "a.docx"}},
+	} {
+		if got := docxBackendArgs(tc.backend, "a.docx", "a.pdf"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestOfficeToPdfDocxBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-docx-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := *ConfDocxBackend
+	defer func() { *ConfDocxBackend = old }()
+	// a "converter" which just copies
+	*ConfDocxBackend = "cp {input} {output}"
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	destfn := filepath.Join(dir, "doc.pdf")
+	if err = OfficeToPdf(ctx, destfn,
+		strings.NewReader("docx"),
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(destfn); err != nil || string(b) != "docx" {
+		t.Errorf("got %q (%v), wanted the copy", b, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "doc.docx")); !os.IsNotExist(err) {
+		t.Errorf("the input is left: %v", err)
+	}
+}
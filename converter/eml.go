@@ -0,0 +1,57 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+)
+
+// rfc822Fields are the header fields (lowercase) which mark an RFC822 mail.
+var rfc822Fields = []string{"from", "received", "mime-version", "return-path", "message-id"}
+
+// isRFC822 reports whether the head of the file looks like an RFC822 mail (.eml):
+// header fields (and their continuation lines) up to the first empty line (or the
+// end of head), with at least one of the rfc822Fields.
+func isRFC822(head []byte) bool {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	if i := bytes.LastIndexByte(head, '\n'); i >= 0 && bytes.IndexByte(head[:i], '\n') >= 0 {
+		// the last line may be truncated
+		head = head[:i]
+	}
+	var found bool
+	for n, line := range bytes.Split(head, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			return found && n > 0
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if n == 0 {
+				return false
+			}
+			continue
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return false
+		}
+		name := line[:i]
+		for _, c := range name {
+			// field names are printable US-ASCII, except colon and space
+			if c <= ' ' || c > '~' {
+				return false
+			}
+		}
+		if !found {
+			name = bytes.ToLower(name)
+			for _, f := range rfc822Fields {
+				if string(name) == f {
+					found = true
+					break
+				}
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+// MailToAttachmentsZip writes the raw (not converted) attachments of the mail
+// into a ZIP, with their original file names. The content-type of each
+// attachment is stored in the comment of its ZIP entry.
+func MailToAttachmentsZip(ctx context.Context, destfn string, body io.Reader, contentType string) error {
+	Log := getLogger(ctx).Log
+	destfh, err := openOut(destfn)
+	if err != nil {
+		return errors.Wrapf(err, "open out %s", destfn)
+	}
+	zw := newZipWriter(destfh)
+
+	partch := make(chan i18nmail.MailPart)
+	errch := make(chan error, 128)
+	go SlurpMail(ctx, partch, errch, body)
+
+	seen := make(map[string]int, 8)
+	var n int
+	for mp := range partch {
+		if err != nil {
+			continue // drain
+		}
+		name, ok := attachmentName(mp)
+		if !ok {
+			continue
+		}
+		name = uniqueName(seen, name)
+		hdr := &zip.FileHeader{Name: name, Method: zipMethod(name, mp.ContentType), Comment: mp.ContentType}
+		hdr.SetModTime(time.Now())
+		var w io.Writer
+		if w, err = zw.CreateHeader(hdr); err != nil {
+			err = errors.Wrap(err, name)
+			continue
+		}
+		if _, err = io.Copy(w, mp.Body); err != nil {
+			err = errors.Wrap(err, name)
+			continue
+		}
+		n++
+	}
+	if err == nil {
+		select {
+		case err = <-errch:
+		default:
+		}
+	}
+	if closeErr := zw.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if destfh != os.Stdout {
+		if closeErr := destfh.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "MailToAttachmentsZip")
+	}
+	Log("msg", "extracted", "attachments", n, "dest", destfn)
+	return nil
+}
+
+// attachmentName returns the file name of the part, and whether it is an attachment:
+// a non-multipart part with a file name, or with "attachment" disposition.
+func attachmentName(mp i18nmail.MailPart) (string, bool) {
+	if strings.HasPrefix(mp.ContentType, "multipart/") {
+		return "", false
+	}
+	fn := headerGetFileName(mp.Header)
+	if fn == "" {
+		disp, _, _ := mime.ParseMediaType(mp.Header.Get("Content-Disposition"))
+		if disp != "attachment" {
+			return "", false
+		}
+		fn = fmt.Sprintf("attachment-%03d", mp.Seq)
+		if exts, _ := mime.ExtensionsByType(mp.ContentType); len(exts) > 0 {
+			fn += exts[0]
+		}
+	}
+	// no directories from the sender
+	fn = path.Base(strings.Replace(fn, "\\", "/", -1))
+	if fn == "." || fn == "/" || fn == ".." {
+		fn = fmt.Sprintf("attachment-%03d", mp.Seq)
+	}
+	return fn, true
+}
+
+// uniqueName returns name, or "name (2).ext" etc. if it is already seen.
+func uniqueName(seen map[string]int, name string) string {
+	cnt := seen[name]
+	seen[name] = cnt + 1
+	if cnt == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for {
+		cnt++
+		nm := fmt.Sprintf("%s (%d)%s", base, cnt, ext)
+		if seen[nm] == 0 {
+			seen[nm] = 1
+			seen[name] = cnt
+			return nm
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"net/textproto"
+	"testing"
+
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+func TestAttachmentName(t *testing.T) {
+	for i, tc := range []struct {
+		ct, disp string
+		want     string
+		ok       bool
+	}{
+		{"multipart/mixed", "", "", false},
+		{"text/plain", "", "", false},
+		{"text/plain", "inline", "", false},
+		{"application/pdf", `attachment; filename="a.pdf"`, "a.pdf", true},
+		{"application/pdf", `attachment; filename="..\\..\\evil.pdf"`, "evil.pdf", true},
+		{"application/x-unknown-type", "attachment", "attachment-007", true},
+	} {
+		hdr := textproto.MIMEHeader{}
+		if tc.disp != "" {
+			hdr.Set("Content-Disposition", tc.disp)
+		}
+		got, ok := attachmentName(i18nmail.MailPart{ContentType: tc.ct, Header: hdr, Seq: 7})
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%d. got %q, %t, wanted %q, %t", i, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	seen := make(map[string]int)
+	var got []string
+	for _, nm := range []string{"a.pdf", "a.pdf", "a (2).pdf", "a.pdf", "b"} {
+		got = append(got, uniqueName(seen, nm))
+	}
+	want := []string{"a.pdf", "a (2).pdf", "a (2) (2).pdf", "a (3).pdf", "b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d. got %q, wanted %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+func TestIncludeFailedOriginals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-failed-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer RegisterConverter("application/x-failing", nil)
+	// reads a bit, then fails
+	RegisterConverter("application/x-failing", func(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+		var b [4]byte
+		_, _ = io.ReadFull(r, b[:])
+		return errors.New("cannot convert")
+	})
+	old := *ConfIncludeFailedOriginals
+	defer func() { *ConfIncludeFailedOriginals = old }()
+
+	const body = "the original content of the attachment"
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	ctx = context.WithValue(ctx, "workdir", dir)
+	for _, include := range []bool{false, true} {
+		*ConfIncludeFailedOriginals = include
+		mp := i18nmail.MailPart{Seq: 3, ContentType: "application/x-failing",
+			Header: textproto.MIMEHeader{"Content-Disposition": {`attachment; filename="a/b.xf"`}},
+			Body:   strings.NewReader(body)}
+		ch := make(chan ArchFileItem, 1)
+		if err = convertPart(ctx, mp, ch); err != nil {
+			t.Fatal(err)
+		}
+		item := <-ch
+		if item.Error == nil {
+			t.Fatalf("%t: no error", include)
+		}
+		if !include {
+			continue
+		}
+		if item.File != nil {
+			t.Fatalf("got File, wanted the original file")
+		}
+		b, err := ioutil.ReadFile(item.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, []byte(body)) {
+			t.Errorf("got %q, wanted %q", b, body)
+		}
+		if want := "application/00#003.application--x-failing-a-b.xf"; item.Archive != want {
+			t.Errorf("got archive name %q, wanted %q", item.Archive, want)
+		}
+	}
+}
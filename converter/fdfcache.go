@@ -0,0 +1,106 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fdfCache holds the recently used parsed FDFs, in front of the on-disk gob cache.
+var fdfCache = newFdfLRU()
+
+// fdfLRU is a size-limited, concurrency-safe LRU cache of fieldParts,
+// keyed by the content hash of the PDF.
+type fdfLRU struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type fdfEntry struct {
+	key string
+	fp  fieldParts
+}
+
+func newFdfLRU() *fdfLRU {
+	return &fdfLRU{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns a copy of the cached fieldParts, which can be Set freely.
+func (c *fdfLRU) get(key string) (fieldParts, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return fieldParts{}, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*fdfEntry).fp.clone(), true
+}
+
+// add stores a copy of fp, evicting the least recently used entries
+// above max entries. A max of zero (or less) disables the cache.
+func (c *fdfLRU) add(key string, fp fieldParts, max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*fdfEntry).fp = fp.clone()
+		c.ll.MoveToFront(e)
+	} else if max > 0 {
+		c.items[key] = c.ll.PushFront(&fdfEntry{key: key, fp: fp.clone()})
+	}
+	for c.ll.Len() > 0 && c.ll.Len() > max {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*fdfEntry).key)
+	}
+}
+
+// Len returns the number of the cached entries.
+func (c *fdfLRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// clone returns a copy of fp with its own Values, as only those are changed by Set.
+func (fp fieldParts) clone() fieldParts {
+	values := make(map[string]string, len(fp.Values))
+	for k, v := range fp.Values {
+		values[k] = v
+	}
+	fp.Values = values
+	return fp
+}
+
+// fdfCacheKey returns the key of the FDF cache file name, as written by getFdf:
+// KEY.fdf, KEY.fdf.gob or KEY.fdf.vN.gob, where KEY is the base64 encoded SHA1
+// content hash of the PDF. Other names (maybe of other programs' files,
+// in a shared temp dir) are not cache files.
+func fdfCacheKey(name string) (string, bool) {
+	keyLen := base64.URLEncoding.EncodedLen(sha1.Size)
+	if len(name) <= keyLen {
+		return "", false
+	}
+	key, rest := name[:keyLen], name[keyLen:]
+	if b, err := base64.URLEncoding.DecodeString(key); err != nil || len(b) != sha1.Size {
+		return "", false
+	}
+	switch {
+	case rest == ".fdf", rest == ".fdf.gob":
+	case strings.HasPrefix(rest, ".fdf.v") && strings.HasSuffix(rest, ".gob"):
+		if _, err := strconv.Atoi(rest[6 : len(rest)-4]); err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFdfLRU(t *testing.T) {
+	c := newFdfLRU()
+	newFp := func() fieldParts {
+		return fieldParts{Fields: []string{"a"}, Values: map[string]string{"a": ""}}
+	}
+	c.add("1", newFp(), 2)
+	c.add("2", newFp(), 2)
+	if _, ok := c.get("1"); !ok { // 1 is the most recently used
+		t.Fatal("1 is missing")
+	}
+	c.add("3", newFp(), 2)
+	if _, ok := c.get("2"); ok {
+		t.Error("2 should have been evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("got %d entries, wanted 2", c.Len())
+	}
+
+	fp, _ := c.get("1")
+	if err := fp.Set("a", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if fp2, _ := c.get("1"); fp2.Values["a"] != "" {
+		t.Errorf("cached value changed to %q", fp2.Values["a"])
+	}
+
+	c.add("4", newFp(), 0)
+	if c.Len() != 0 {
+		t.Errorf("got %d entries with a zero size, wanted 0", c.Len())
+	}
+}
+
+func TestPruneFdfCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-fdfcache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := func(s string) string {
+		h := sha1.Sum([]byte(s))
+		return base64.URLEncoding.EncodeToString(h[:])
+	}
+	newKey, midKey, oldKey := key("new"), key("mid"), key("old")
+	now := time.Now()
+	for fn, age := range map[string]time.Duration{
+		newKey + ".fdf": time.Minute, newKey + ".fdf.v2.gob": 0,
+		midKey + ".fdf": time.Hour, midKey + ".fdf.v2.gob": time.Hour,
+		oldKey + ".fdf": 48 * time.Hour, oldKey + ".fdf.gob": 48 * time.Hour,
+		"other.pdf": 72 * time.Hour,
+		// files of other programs in a shared temp dir
+		"report.fdf": 72 * time.Hour, "state.fdf.gob": 72 * time.Hour,
+	} {
+		fn = filepath.Join(dir, fn)
+		if err = ioutil.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mt := now.Add(-age - time.Second)
+		_ = os.Chtimes(fn, mt, mt)
+	}
+
+	if n := pruneFdfCache(dir, 0, 24*time.Hour, now); n != 2 {
+		t.Errorf("removed %d files by age, wanted 2", n)
+	}
+	if n := pruneFdfCache(dir, 1, 0, now); n != 2 {
+		t.Errorf("removed %d files by count, wanted 2", n)
+	}
+	for fn, want := range map[string]bool{
+		newKey + ".fdf": true, newKey + ".fdf.v2.gob": true, midKey + ".fdf": false, oldKey + ".fdf.gob": false,
+		"other.pdf": true, "report.fdf": true, "state.fdf.gob": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, fn)); (err == nil) != want {
+			t.Errorf("%s: exists? %t, wanted %t", fn, err == nil, want)
+		}
+	}
+}
+
+func TestFdfCacheKey(t *testing.T) {
+	h := sha1.Sum([]byte("a"))
+	key := base64.URLEncoding.EncodeToString(h[:])
+	for i, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{key + ".fdf", true},
+		{key + ".fdf.gob", true},
+		{key + ".fdf.v2.gob", true},
+		{key + ".fdf.vx.gob", false},
+		{key + ".pdf", false},
+		{key, false},
+		{"a.fdf", false},
+		{"abcdefghijklmnopqrstuvwxyz01.fdf", false},
+	} {
+		got, ok := fdfCacheKey(tc.name)
+		if ok != tc.ok || ok && got != key {
+			t.Errorf("%d. %q: got %q, %t", i, tc.name, got, ok)
+		}
+	}
+}
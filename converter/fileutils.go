@@ -12,7 +12,6 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
-	"mime"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -145,10 +144,11 @@ func (fl ReadCloserFileLike) Stat() (os.FileInfo, error) {
 
 // ArchFileItem groups an archive item
 type ArchFileItem struct {
-	File     FileLike //opened file handle
-	Filename string   //name of the file
-	Archive  string   //name in the archive
-	Error    error    //error
+	File     FileLike  //opened file handle
+	Filename string    //name of the file
+	Archive  string    //name in the archive
+	Error    error     //error
+	Part     *PartInfo //the mail part this item is made of, for the manifest
 }
 
 // ArchiveName returns the archive name - Archive, Filename if set, otherwise File's name
@@ -226,7 +226,7 @@ func zipFiles(dest io.Writer, skipOnError, unsafeArchFn bool, files <-chan ArchF
 			}
 		}()
 	}
-	zfh := zip.NewWriter(dest)
+	zfh := newZipWriter(dest)
 	defer func() {
 		if e := zfh.Close(); e != nil && err == nil {
 			err = e
@@ -295,6 +295,7 @@ func zipFiles(dest io.Writer, skipOnError, unsafeArchFn bool, files <-chan ArchF
 		} else if unsafeArchFn {
 			zi.Name = unsafeFn(zi.Name, true)
 		}
+		zi.Method = zipMethod(zi.Name, "")
 		if w, err = zfh.CreateHeader(zi); err != nil {
 			if openedHere {
 				_ = item.File.Close()
@@ -379,21 +380,28 @@ func fileContentHash(fn string) (hash.Hash, error) {
 	return hsh, err
 }
 
+// headerGetFileName returns the file name of the part, decoded (see mediaTypeParam):
+// from X-FileName, the filename of Content-Disposition, the name of Content-Type,
+// or Content-Description.
 func headerGetFileName(hdr map[string][]string) string {
 	for _, fn := range hdr["X-Filename"] {
 		if fn != "" {
-			return fn
+			return decodeFileName(fn)
 		}
 	}
 	for _, mt := range hdr["Content-Disposition"] {
-		_, params, err := mime.ParseMediaType(mt)
-		if err == nil && params["filename"] != "" {
-			return params["filename"]
+		if fn := mediaTypeParam(mt, "filename"); fn != "" {
+			return fn
+		}
+	}
+	for _, mt := range hdr["Content-Type"] {
+		if fn := mediaTypeParam(mt, "name"); fn != "" {
+			return fn
 		}
 	}
 	for _, desc := range hdr["Content-Description"] {
 		if desc != "" {
-			return desc
+			return decodeFileName(desc)
 		}
 	}
 	return ""
@@ -5,6 +5,7 @@
 package converter
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/base64"
@@ -12,6 +13,7 @@ import (
 	"io"
 	"io/ioutil"
 	"mime"
+	"net/mail"
 	"net/textproto"
 	"os"
 	"path/filepath"
@@ -39,23 +41,32 @@ type maybeArchItems struct {
 // MailToSplittedPdfZip converts mail to ZIP of PDFs and images
 func MailToSplittedPdfZip(ctx context.Context, destfn string, body io.Reader,
 	contentType string, split bool, imgmime, imgsize string,
+) error {
+	return mailToSplittedPdfZip(ctx, destfn, body, contentType, split, imgmime, imgsize, false)
+}
+
+// MailToImageZip converts mail to ZIP of images (imgmime, such as image/gif),
+// one per page - the intermediate page PDFs are not included.
+func MailToImageZip(ctx context.Context, destfn string, body io.Reader,
+	contentType string, imgmime, imgsize string,
+) error {
+	if imgmime == "" {
+		return errors.New("image mime type is required")
+	}
+	return mailToSplittedPdfZip(ctx, destfn, body, contentType, true, imgmime, imgsize, true)
+}
+
+func mailToSplittedPdfZip(ctx context.Context, destfn string, body io.Reader,
+	contentType string, split bool, imgmime, imgsize string, imagesOnly bool,
 ) error {
 	Log := getLogger(ctx).Log
 	ctx, _ = prepareContext(ctx, "")
 	var errs []string
-	files, err := MailToPdfFiles(ctx, body)
+	head := &headCapture{}
+	files, err := MailToPdfFiles(ctx, io.TeeReader(body, head))
 	if err != nil {
-		fcount := 0
-		errs = make([]string, 1, max(1, len(files)))
-		errs[0] = err.Error() + "\n"
-		for _, f := range files {
-			if f.Error == nil {
-				fcount++
-			} else {
-				errs = append(errs, f.Archive+": "+f.Error.Error()+"\n")
-			}
-		}
-		if fcount == 0 {
+		var fcount int
+		if errs, fcount = conversionErrors(err, files); fcount == 0 {
 			return err
 		}
 	}
@@ -66,6 +77,16 @@ func MailToSplittedPdfZip(ctx context.Context, destfn string, body io.Reader,
 		return err
 	}
 
+	if subject := mailSubject(head.Bytes()); subject != "" {
+		stampTitle(ctx, files, subject)
+	}
+	if profile, version := getGsProfile(ctx), getPdfVersion(ctx); profile != "" || version != "" {
+		rewriteFiles(ctx, files, profile, version)
+	}
+	if GetEmbedFonts(ctx) {
+		embedFontsFiles(ctx, files)
+	}
+
 	rch := make(chan maybeArchItems, len(files))
 	tbz := make([]ArchFileItem, 0, 2*len(files))
 	if !split && imgmime == "" {
@@ -75,19 +96,47 @@ func MailToSplittedPdfZip(ctx context.Context, destfn string, body io.Reader,
 	} else {
 		fts := make([]string, len(files))
 		for i, a := range files {
-			if a.Error == nil {
+			if a.Error == nil && !isSrcHTML(a) && signatureGuard(ctx, a, "splitting") {
 				fts[i] = a.Filename
 			} else {
 				tbz = append(tbz, a)
 			}
 		}
 
-		go splitPdfMulti(ctx, fts, imgmime, imgsize, rch)
+		// splitPdfMulti sends exactly one result for each file, in order
+		go splitPdfMulti(ctx, fts, imgmime, imgsize, imagesOnly, rch)
+		k := 0
+		var tooMany error
 		for ms := range rch {
+			var part *PartInfo
+			if k < len(files) && files[k].Error == nil {
+				part = files[k].Part
+			}
+			k++
 			if ms.Error != nil {
+				if errors.Cause(ms.Error) == ErrTooManyPages {
+					tooMany = ms.Error
+				}
 				errs = append(errs, ms.Error.Error())
+				if part != nil {
+					part.Error = ms.Error.Error()
+				}
+			}
+			for _, item := range ms.Items {
+				item.Part = part
+				tbz = append(tbz, item)
+			}
+		}
+		// nothing left to return: refuse the document as a whole
+		if len(tbz) == 0 && tooMany != nil {
+			return tooMany
+		}
+	}
+	for _, f := range files {
+		if f.Part != nil && !isSrcHTML(f) {
+			for _, w := range f.Part.Warnings {
+				errs = append(errs, w+"\n")
 			}
-			tbz = append(tbz, ms.Items...)
 		}
 	}
 
@@ -113,6 +162,20 @@ func MailToSplittedPdfZip(ctx context.Context, destfn string, body io.Reader,
 			Error: errors.New("")})
 	}
 
+	if opts := getEncryptOpts(ctx); !opts.IsZero() {
+		if err = encryptFiles(ctx, tbz, opts); err != nil {
+			cleanupFiles(ctx, files, tbz)
+			return err
+		}
+	}
+
+	mfn := destfn + "-" + ManifestFn
+	if e := writeManifest(ctx, mfn, tbz, split); e != nil {
+		Log("msg", "writeManifest", "dest", mfn, "error", e)
+	} else {
+		tbz = append(tbz, ArchFileItem{Filename: mfn, Archive: ManifestFn})
+	}
+
 	destfh, err := openOut(destfn)
 	if err != nil {
 		return errors.Wrapf(err, "open out %s", destfn)
@@ -127,6 +190,77 @@ func MailToSplittedPdfZip(ctx context.Context, destfn string, body io.Reader,
 	return ze
 }
 
+// headCapture keeps the first maxHeadLen bytes written into it.
+type headCapture struct {
+	bytes.Buffer
+}
+
+const maxHeadLen = 64 << 10
+
+func (hc *headCapture) Write(p []byte) (int, error) {
+	if n := maxHeadLen - hc.Len(); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		hc.Buffer.Write(p[:n])
+	}
+	return len(p), nil
+}
+
+// mailSubject returns the decoded Subject from the mail header in head.
+func mailSubject(head []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(head))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(i18nmail.HeadDecode(msg.Header.Get("Subject")))
+}
+
+// stampTitle sets the Title of the converted PDF files to title.
+// Errors are only logged.
+func stampTitle(ctx context.Context, files []ArchFileItem, title string) {
+	Log := getLogger(ctx).Log
+	info := map[string]string{"Title": title}
+	for _, f := range files {
+		if f.Error != nil || f.File != nil || !strings.HasSuffix(f.Filename, ".pdf") ||
+			!signatureGuard(ctx, f, "setting the title") {
+			continue
+		}
+		tmpfn := f.Filename + ".info.pdf"
+		if err := PdfSetInfo(ctx, tmpfn, f.Filename, info); err != nil {
+			Log("msg", "PdfSetInfo", "file", f.Filename, "error", err)
+			_ = os.Remove(tmpfn)
+			continue
+		}
+		if err := os.Rename(tmpfn, f.Filename); err != nil {
+			Log("msg", "rename", "from", tmpfn, "to", f.Filename, "error", err)
+			_ = os.Remove(tmpfn)
+		}
+	}
+}
+
+// rewriteFiles rewrites the PDF files with the given GhostScript profile and PDF version.
+// Without a profile, only the version is set, with PdfSetVersion.
+// This is best effort: on error, the file is left as is.
+func rewriteFiles(ctx context.Context, files []ArchFileItem, profile GsProfile, version PdfVersion) {
+	Log := getLogger(ctx).Log
+	for _, f := range files {
+		if f.Error != nil || !strings.HasSuffix(f.Filename, ".pdf") ||
+			!signatureGuard(ctx, f, "rewriting") {
+			continue
+		}
+		var err error
+		if profile == "" {
+			err = PdfSetVersion(ctx, f.Filename, f.Filename, version)
+		} else {
+			err = PdfRewrite(ctx, f.Filename, f.Filename, profile, version)
+		}
+		if err != nil {
+			Log("msg", "rewrite", "file", f.Filename, "profile", profile, "version", version, "error", err)
+		}
+	}
+}
+
 func cleanupFiles(ctx context.Context, files []ArchFileItem, tbz []ArchFileItem) {
 	Log := getLogger(ctx).Log
 	ctx, wd := prepareContext(ctx, "")
@@ -136,7 +270,7 @@ func cleanupFiles(ctx context.Context, files []ArchFileItem, tbz []ArchFileItem)
 		if item.File != nil {
 			_ = item.File.Close()
 		}
-		if !LeaveTempFiles {
+		if !KeepTempFiles(ctx) {
 			_ = unlink(item.Filename, "after zipped") // ignore error
 		}
 	}
@@ -145,7 +279,7 @@ func cleanupFiles(ctx context.Context, files []ArchFileItem, tbz []ArchFileItem)
 		if item.File != nil {
 			_ = item.File.Close()
 		}
-		if !LeaveTempFiles {
+		if !KeepTempFiles(ctx) {
 			_ = unlink(item.Filename, "after zipped2") // ignore error
 		}
 	}
@@ -180,7 +314,7 @@ func cleanupFiles(ctx context.Context, files []ArchFileItem, tbz []ArchFileItem)
 	}
 }
 
-func splitPdfMulti(ctx context.Context, files []string, imgmime, imgsize string, rch chan maybeArchItems) {
+func splitPdfMulti(ctx context.Context, files []string, imgmime, imgsize string, imagesOnly bool, rch chan maybeArchItems) {
 	Log := getLogger(ctx).Log
 	var sfiles, ifiles, tbd []string
 	var err error
@@ -200,13 +334,13 @@ func splitPdfMulti(ctx context.Context, files []string, imgmime, imgsize string,
 			rch <- maybeArchItems{Items: []ArchFileItem{ArchFileItem{Filename: fn}}}
 			continue
 		}
-		sfiles, err = PdfSplit(fn)
-		if err != nil || len(sfiles) == 0 {
+		sfiles, err = PdfSplit(ctx, fn)
+		if (err != nil || len(sfiles) == 0) && errors.Cause(err) != ErrTooManyPages {
 			Log("msg", "Splitting", "file", fn, "error", err)
-			if err = PdfRewrite(fn, fn); err != nil {
+			if err = PdfRewrite(ctx, fn, fn, getGsProfile(ctx), getPdfVersion(ctx)); err != nil {
 				Log("msg", "Cannot clean", "file", fn, "error", err)
 			} else {
-				if sfiles, err = PdfSplit(fn); err != nil || len(sfiles) == 0 {
+				if sfiles, err = PdfSplit(ctx, fn); err != nil || len(sfiles) == 0 {
 					Log("msg", "splitting CLEANED", "file", fn, "error", err)
 				}
 			}
@@ -218,8 +352,14 @@ func splitPdfMulti(ctx context.Context, files []string, imgmime, imgsize string,
 		}
 		n = mul * len(sfiles)
 		items := make([]ArchFileItem, 0, n)
-		for _, nm := range sfiles {
-			items = append(items, ArchFileItem{Filename: nm})
+		if imagesOnly && imgmime != "" {
+			if !KeepTempFiles(ctx) {
+				tbd = append(tbd, sfiles...)
+			}
+		} else {
+			for _, nm := range sfiles {
+				items = append(items, ArchFileItem{Filename: nm})
+			}
 		}
 		if imgmime == "" {
 			rch <- maybeArchItems{Items: items}
@@ -229,7 +369,7 @@ func splitPdfMulti(ctx context.Context, files []string, imgmime, imgsize string,
 			Log("msg", "converting to image", "error", err)
 		}
 		//log.Printf("sfiles=%s err=%s", sfiles, err)
-		if !LeaveTempFiles && len(sfiles) > 1 {
+		if !KeepTempFiles(ctx) && len(sfiles) > 1 {
 			tbd = append(tbd, fn)
 		}
 		for _, nm := range ifiles {
@@ -245,22 +385,30 @@ type pdfToImageArgs struct {
 	name, mime, size string
 }
 
+// ValidImageSize checks the image size ("WIDTHxHEIGHT", empty means the default).
+func ValidImageSize(imgsize string) error {
+	if imgsize == "" {
+		return nil
+	}
+	i := strings.Index(imgsize, "x")
+	if i < 1 || i >= len(imgsize)-1 {
+		return errors.Errorf("bad image size %q (WIDTHxHEIGHT)", imgsize)
+	}
+	for _, s := range []string{imgsize[:i], imgsize[i+1:]} {
+		if n, err := strconv.Atoi(s); err != nil || n <= 0 {
+			return errors.Errorf("bad image size %q (WIDTHxHEIGHT)", imgsize)
+		}
+	}
+	return nil
+}
+
 // PdfToImageMulti converts PDF pages to images, using parallel threads
 func PdfToImageMulti(ctx context.Context, sfiles []string, imgmime, imgsize string) (imgfilenames []string, err error) {
 	if imgmime == "" {
 		return
 	}
-	if imgsize != "" {
-		i := strings.Index(imgsize, "x")
-		if i < 1 || i >= len(imgsize)-1 {
-			return
-		}
-		if _, err = strconv.Atoi(imgsize[:i]); err != nil {
-			return
-		}
-		if _, err = strconv.Atoi(imgsize[i+1:]); err != nil {
-			return
-		}
+	if err = ValidImageSize(imgsize); err != nil {
+		return
 	}
 	i := strings.Index(imgmime, "/")
 	if i < 0 {
@@ -286,7 +434,7 @@ func PdfToImageMulti(ctx context.Context, sfiles []string, imgmime, imgsize stri
 		defer workWg.Done()
 		var err error
 		for args := range workch {
-			err = PdfToImage(args.w, args.r, args.mime, args.size)
+			err = PdfToImage(ctx, args.w, args.r, args.mime, args.size)
 			if e := args.w.Close(); e != nil && err == nil {
 				err = e
 			}
@@ -300,7 +448,7 @@ func PdfToImageMulti(ctx context.Context, sfiles []string, imgmime, imgsize stri
 			}
 		}
 	}
-	for i := 0; i < Concurrency; i++ {
+	for i, n := 0, ConcLimit.Limit(); i < n; i++ {
 		workWg.Add(1)
 		go work()
 	}
@@ -417,21 +565,35 @@ const maxErrLen = 1 << 20
 
 // MailToPdfFiles converts email to PDF files
 // all mail part goes through all filter in Filters, in reverse order (last first)
+//
+// With ConfStreamMail, the parts are walked as they're read from r, so only
+// the parts are spooled, not the whole mail.
 func MailToPdfFiles(ctx context.Context, r io.Reader) (files []ArchFileItem, err error) {
-	hsh := sha1.New()
-	br, e := temp.NewReadSeeker(io.TeeReader(r, hsh))
-	if e != nil {
-		err = errors.Wrapf(e, "MailToPdfFiles")
-		return
-	}
-	defer func() { _ = br.Close() }()
+	if *ConfStreamMail {
+		// the parts go into a new subdirectory, as the hash is known only at the end
+		dn, e := ioutil.TempDir(GetWorkdir(ctx), "mail-")
+		if e != nil {
+			return nil, errors.Wrapf(e, "MailToPdfFiles")
+		}
+		ctx, _ = prepareContext(ctx, filepath.Base(dn))
+	} else {
+		hsh := sha1.New()
+		br, e := temp.NewReadSeeker(io.TeeReader(r, hsh))
+		if e != nil {
+			err = errors.Wrapf(e, "MailToPdfFiles")
+			return
+		}
+		defer func() { _ = br.Close() }()
 
-	hshS := base64.URLEncoding.EncodeToString(hsh.Sum(nil))
-	ctx, _ = prepareContext(ctx, hshS)
-	if _, err := br.Seek(0, 0); err != nil {
-		return nil, err
+		hshS := base64.URLEncoding.EncodeToString(hsh.Sum(nil))
+		ctx, _ = prepareContext(ctx, hshS)
+		if _, err := br.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		r = br
 	}
-	r = br
+	ctx = withDedup(ctx)
+	ctx, _ = withProgressTracker(ctx)
 
 	files = make([]ArchFileItem, 0, 16)
 	errs := make([]string, 0, 16)
@@ -453,7 +615,7 @@ func MailToPdfFiles(ctx context.Context, r io.Reader) (files []ArchFileItem, err
 			}
 		}
 	}
-	for i := 0; i < Concurrency; i++ {
+	for i, n := 0, ConcLimit.Limit(); i < n; i++ {
 		workWg.Add(1)
 		go worker()
 	}
@@ -528,28 +690,119 @@ func convertPart(ctx context.Context, mp i18nmail.MailPart, resultch chan<- Arch
 		}
 		return nil
 	}
+	part := &PartInfo{Seq: mp.Seq, FileName: headerGetFileName(mp.Header), ContentType: mp.ContentType}
+	pt := getProgressTracker(ctx)
+	pt.start(mp)
+	var orig *os.File
+	if *ConfIncludeFailedOriginals {
+		// keep a copy of what the converter reads, to be included if it fails
+		if orig, err = os.Create(fn + ".orig"); err != nil {
+			Log("msg", "create copy of the original", "seq", mp.Seq, "error", err)
+			orig, err = nil, nil
+		} else {
+			mp.Body = io.TeeReader(mp.Body, orig)
+			defer func() { _ = orig.Close() }()
+		}
+	}
 	if converter == nil { // no converter for this!?
 		err = errors.New("no converter for " + mp.ContentType)
+	} else if dp := getDedup(ctx); dp != nil {
+		err = dp.convert(ctx, fn, &mp, converter)
 	} else {
 		err = converter(ctx, fn+".pdf", mp.Body, mp.ContentType)
 	}
+	if orig != nil && (err == nil || err == ErrSkip) {
+		_ = unlink(orig.Name(), "original of converted part")
+	}
+	if err == ErrSkip {
+		pt.finish(mp, nil)
+		return nil
+	}
+	pt.finish(mp, err)
 	if err != nil {
-		if err == ErrSkip {
-			return nil
-		}
 		_ = unlink(fn, "MailToPdfFiles dest part") // ignore error
 		Log("msg", "converting to pdf", "ct", mp.ContentType, "seq", mp.Seq, "error", err)
 		j := strings.Index(mp.ContentType, "/")
-		resultch <- ArchFileItem{
+		item := ArchFileItem{
 			File:    MakeFileLike(mp.Body),
 			Archive: mp.ContentType[:j+1] + filepath.Base(fn),
-			Error:   err}
+			Error:   err,
+			Part:    part}
+		if orig != nil {
+			// the rest of the body, which the converter has not read
+			_, e := io.Copy(ioutil.Discard, mp.Body)
+			if closeErr := orig.Close(); e == nil {
+				e = closeErr
+			}
+			if e != nil {
+				Log("msg", "copy the original", "seq", mp.Seq, "error", e)
+			} else {
+				item.File, item.Filename = nil, orig.Name()
+				item.Archive = failedOriginalName(mp.ContentType, fn, part.FileName)
+			}
+		}
+		resultch <- item
 	} else {
-		resultch <- ArchFileItem{Filename: fn + ".pdf"}
+		// the signatures matter only when they are preserved (see signatureGuard)
+		if mp.ContentType == "application/pdf" && *ConfPreserveSignatures {
+			if part.Signed, err = PdfHasSignature(ctx, fn+".pdf"); err != nil {
+				Log("msg", "PdfHasSignature", "seq", mp.Seq, "error", err)
+			}
+		}
+		resultch <- ArchFileItem{Filename: fn + ".pdf", Part: part}
+	}
+	// the HTML the part was converted from (see keepOriginalHTML)
+	if src := fn + SrcHTMLSuffix; fileExists(src) {
+		resultch <- ArchFileItem{Filename: src,
+			Archive: strings.TrimSuffix(filepath.Base(fn), ".") + SrcHTMLSuffix, Part: part}
 	}
 	return nil
 }
 
+// conversionErrors returns the lines of the errors file for the failed conversion
+// of the files (err and the errors of the items), and the number of the converted files.
+// The source HTMLs (see isSrcHTML) are not counted, as they're no conversions.
+func conversionErrors(err error, files []ArchFileItem) ([]string, int) {
+	var fcount int
+	errs := make([]string, 1, max(1, len(files)))
+	errs[0] = err.Error() + "\n"
+	for _, f := range files {
+		if isSrcHTML(f) {
+			continue
+		}
+		if f.Error == nil {
+			fcount++
+		} else {
+			errs = append(errs, f.Archive+": "+f.Error.Error()+"\n")
+		}
+	}
+	return errs, fcount
+}
+
+// SrcHTMLSuffix is the suffix of the HTML the parts were converted from,
+// kept besides their PDF in the zip if asked for (see WithOriginalHTML).
+const SrcHTMLSuffix = ".src.html"
+
+// isSrcHTML reports whether the item is the HTML source of a converted part.
+func isSrcHTML(item ArchFileItem) bool {
+	return strings.HasSuffix(item.Filename, SrcHTMLSuffix)
+}
+
+// failedOriginalName returns the name of the original of the part which
+// could not be converted, in the zip: the content type's main type as
+// directory, and the original file name, if known.
+func failedOriginalName(contentType, fn, fileName string) string {
+	dir := contentType
+	if j := strings.IndexByte(contentType, '/'); j >= 0 {
+		dir = contentType[:j]
+	}
+	name := filepath.Base(fn)
+	if fileName != "" {
+		name = strings.TrimSuffix(name, ".") + "-" + safeFn(fileName, true)
+	}
+	return dir + "/" + name
+}
+
 // MailToTree writes mail parts as files starting at outdir as root, trying to reimplement
 // the mime hierarchy in the directory hierarchy
 func MailToTree(ctx context.Context, outdir string, r io.Reader) error {
@@ -683,7 +936,16 @@ func ExtractingFilter(ctx context.Context,
 		)
 		body := part.Body
 		if part.ContentType == "application/x-ole-storage" {
-			r, err := NewOLEStorageReader(body)
+			// .msg files are often misnamed Office documents: those go to OfficeToPdf
+			br := bufio.NewReaderSize(body, sniffSize)
+			head, _ := br.Peek(sniffSize)
+			part.Body = br
+			if ct := sniffOffice(head, part.ContentType, headerGetFileName(part.Header)); ct != "" && ct != part.ContentType {
+				Log("msg", "not an Outlook message", "ct", ct)
+				part.ContentType = ct
+				goto Skip
+			}
+			r, err := NewOLEStorageReader(ctx, br)
 			if err != nil {
 				goto Error
 			}
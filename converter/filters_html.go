@@ -48,7 +48,7 @@ func HTMLPartFilter(ctx context.Context,
 		aConverter Converter
 		tbd        = make(map[string]struct{}, 4)
 	)
-	if !LeaveTempFiles {
+	if !KeepTempFiles(ctx) {
 		defer func() {
 			tbdA := make([]string, 0, len(tbd))
 			for k := range tbd {
@@ -276,7 +276,7 @@ func SaveOriHTMLFilter(ctx context.Context,
 	}()
 	ctx, wd := prepareContext(ctx, "")
 
-	if !SaveOriginalHTML {
+	if !keepOriginalHTML(ctx) {
 		for part := range inch {
 			outch <- part
 		}
@@ -5,12 +5,19 @@
 package converter
 
 import (
+	"errors"
 	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tgulacsi/go/i18nmail"
 )
 
 func TestFixXMLHeader(t *testing.T) {
@@ -52,3 +59,57 @@ func TestFixXMLCharset(t *testing.T) {
 		}
 	}
 }
+
+func TestConversionErrorsSrcHTML(t *testing.T) {
+	defer func(w string) { *ConfWkhtmltopdf = w }(*ConfWkhtmltopdf)
+	*ConfWkhtmltopdf = "/nonexistent/wkhtmltopdf"
+	dir, err := ioutil.TempDir("", "srchtml-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	ctx = context.WithValue(ctx, "workdir", dir)
+	ctx = WithOriginalHTML(ctx, true)
+
+	// the HTML part fails, with its source kept
+	resultch := make(chan ArchFileItem, 4)
+	mp := i18nmail.MailPart{ContentType: "text/html", Seq: 1,
+		Header: textproto.MIMEHeader{}, Body: strings.NewReader("<html><body>alma</body></html>")}
+	if err = convertPart(ctx, mp, resultch); err != nil {
+		t.Fatal(err)
+	}
+	close(resultch)
+	files := []ArchFileItem{{Filename: filepath.Join(dir, "00#000.text--plain..pdf")}}
+	var srcs int
+	for item := range resultch {
+		if isSrcHTML(item) {
+			srcs++
+		}
+		files = append(files, item)
+	}
+	if srcs != 1 {
+		t.Fatalf("got %d source HTMLs in %+v, wanted 1", srcs, files)
+	}
+
+	errs, n := conversionErrors(errors.New("one part failed"), files)
+	if n != 1 {
+		t.Errorf("got %d converted, wanted 1", n)
+	}
+	if len(errs) != 2 {
+		t.Errorf("got errors %q, wanted the error and the failed part", errs)
+	}
+}
+
+func TestValidImageSize(t *testing.T) {
+	for _, s := range []string{"", "640x640", "1x2"} {
+		if err := ValidImageSize(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"640", "x640", "640x", "ax640", "640x-1", "0x10", "big"} {
+		if err := ValidImageSize(s); err == nil {
+			t.Errorf("%q: wanted error", s)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const embedFontsKey = "embedFonts"
+
+// WithEmbedFonts returns a context which asks for embedding all the fonts
+// into the converted PDFs (see PdfEmbedFonts), overriding ConfEmbedFonts.
+func WithEmbedFonts(ctx context.Context, embed bool) context.Context {
+	return context.WithValue(ctx, embedFontsKey, embed)
+}
+
+// GetEmbedFonts reports whether the context asks for embedding all the fonts
+// (see WithEmbedFonts), defaulting to ConfEmbedFonts.
+func GetEmbedFonts(ctx context.Context) bool {
+	if ctx != nil {
+		if embed, ok := ctx.Value(embedFontsKey).(bool); ok {
+			return embed
+		}
+	}
+	return *ConfEmbedFonts
+}
+
+// PdfEmbedFonts rewrites srcfn into destfn with GhostScript, embedding (subsets of)
+// all the fonts, so the PDF renders the same everywhere; with the given PDF version
+// (empty means ConfPdfVersion). It is a no-op copy if pdffonts says all the fonts
+// are embedded already.
+func PdfEmbedFonts(ctx context.Context, destfn, srcfn string, version PdfVersion) error {
+	if ok, err := allFontsEmbedded(ctx, srcfn); err != nil {
+		getLogger(ctx).Log("msg", "pdffonts", "file", srcfn, "error", err)
+	} else if ok {
+		if destfn == srcfn {
+			return nil
+		}
+		return copyFile(srcfn, destfn)
+	}
+	tmpfn := destfn
+	if destfn == srcfn {
+		tmpfn = nakeFilename(srcfn) + "-emb.pdf"
+	}
+	if err := xToX(ctx, tmpfn, srcfn, false, "", version, "-dEmbedAllFonts=true", "-dSubsetFonts=true"); err != nil {
+		return errors.Wrapf(err, "embed fonts of %s", srcfn)
+	}
+	return moveFile(tmpfn, destfn)
+}
+
+// embedFontsFiles embeds the fonts into the converted PDFs, in place.
+func embedFontsFiles(ctx context.Context, files []ArchFileItem) {
+	Log := getLogger(ctx).Log
+	for _, f := range files {
+		if f.Error != nil || !strings.HasSuffix(f.Filename, ".pdf") ||
+			!signatureGuard(ctx, f, "embedding fonts") {
+			continue
+		}
+		if err := PdfEmbedFonts(ctx, f.Filename, f.Filename, getPdfVersion(ctx)); err != nil {
+			Log("msg", "PdfEmbedFonts", "file", f.Filename, "error", err)
+		}
+	}
+}
+
+// allFontsEmbedded reports whether all the fonts of the PDF are embedded,
+// using pdffonts. It returns an error if pdffonts is not available.
+func allFontsEmbedded(ctx context.Context, fn string) (bool, error) {
+	pdffonts := getPopplerOk()["pdffonts"]
+	if pdffonts == "" {
+		return false, errors.New("no pdffonts")
+	}
+	var errout bytes.Buffer
+	cmd := exec.Command(pdffonts, fn)
+	cmd.Stderr = &errout
+	out, err := outputWithContext(ctx, cmd)
+	if err != nil {
+		return false, errors.Wrapf(err, "pdffonts %s: %s", fn, errout.Bytes())
+	}
+	return parsePdffonts(out)
+}
+
+// parsePdffonts parses the output of pdffonts, and reports whether the "emb"
+// column is "yes" for all the fonts. The columns are located by the dashed
+// line under the header, as the font names may contain spaces.
+func parsePdffonts(out []byte) (bool, error) {
+	lines := bytes.Split(out, []byte("\n"))
+	if len(lines) < 2 {
+		return false, errors.Errorf("cannot parse pdffonts output %q", out)
+	}
+	col := -1
+	for i, name := range strings.Fields(string(lines[0])) {
+		if name == "emb" {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return false, errors.Errorf("no emb column in pdffonts output %q", lines[0])
+	}
+	// the column spans from the dashed line
+	var spans [][2]int
+	dashes := lines[1]
+	for i := 0; i < len(dashes); {
+		if dashes[i] != '-' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(dashes) && dashes[j] == '-' {
+			j++
+		}
+		spans = append(spans, [2]int{i, j})
+		i = j
+	}
+	if col >= len(spans) {
+		return false, errors.Errorf("cannot parse pdffonts output %q", out)
+	}
+	start, end := spans[col][0], spans[col][1]
+	for _, line := range lines[2:] {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if len(line) < end {
+			return false, errors.Errorf("short pdffonts line %q", line)
+		}
+		if string(bytes.TrimSpace(line[start:end])) != "yes" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParsePdffonts(t *testing.T) {
+	const header = `name                                 type              encoding         emb sub uni object ID
+------------------------------------ ----------------- ---------------- --- --- --- ---------
+`
+	row := func(name, typ, emb string) string {
+		return fmt.Sprintf("%-36s %-17s %-16s %-3s %-3s %-3s %6d  0\n", name, typ, "WinAnsi", emb, emb, "no", 10)
+	}
+	for i, tc := range []struct {
+		out     string
+		want    bool
+		wantErr bool
+	}{
+		{header, true, false},
+		{header + row("BAAAAA+Liberation Serif", "TrueType", "yes") +
+			row("CAAAAA+DejaVu Sans", "TrueType", "yes"), true, false},
+		{header + row("BAAAAA+Liberation Serif", "TrueType", "yes") +
+			row("Helvetica", "Type 1", "no"), false, false},
+		{"name type emb\n", false, true},
+		{"foo\n---\n", false, true},
+	} {
+		got, err := parsePdffonts([]byte(tc.out))
+		if err != nil {
+			if !tc.wantErr {
+				t.Errorf("%d. %v", i, err)
+			}
+			continue
+		}
+		if tc.wantErr {
+			t.Errorf("%d. wanted error, got %t", i, got)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%d. got %t, wanted %t", i, got, tc.want)
+		}
+	}
+}
+
+func TestGetEmbedFonts(t *testing.T) {
+	defer func(old bool) { *ConfEmbedFonts = old }(*ConfEmbedFonts)
+	*ConfEmbedFonts = true
+	if !GetEmbedFonts(context.Background()) {
+		t.Error("the config is not honored")
+	}
+	if GetEmbedFonts(WithEmbedFonts(context.Background(), false)) {
+		t.Error("the context does not override the config")
+	}
+	*ConfEmbedFonts = false
+	if !GetEmbedFonts(WithEmbedFonts(context.Background(), true)) {
+		t.Error("the context does not ask for embedding")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ErrResourceLimit is returned when GhostScript runs out of the memory it is allowed
+// to use (ConfGsMemLimit, ConfGsMaxBitmap, ConfGsBufferSpace).
+var ErrResourceLimit = errors.New("resource limit reached")
+
+// gsLimitSignatures are the GhostScript (and allocator) messages of running out of memory.
+var gsLimitSignatures = [][]byte{
+	[]byte("VMerror"),
+	[]byte("Cannot allocate memory"),
+	[]byte("Memory allocation failed"),
+	[]byte("out of memory"),
+	[]byte("std::bad_alloc"),
+}
+
+// gsLimited reports whether any GhostScript resource limit is configured.
+func gsLimited() bool {
+	return *ConfGsMemLimit > 0 || *ConfGsMaxBitmap > 0 || *ConfGsBufferSpace > 0
+}
+
+// gsLimitArgs returns the GhostScript options of ConfGsMaxBitmap and ConfGsBufferSpace.
+func gsLimitArgs() []string {
+	var args []string
+	if n := *ConfGsMaxBitmap; n > 0 {
+		args = append(args, "-dMaxBitmap="+strconv.FormatInt(n, 10))
+	}
+	if n := *ConfGsBufferSpace; n > 0 {
+		args = append(args, "-dBufferSpace="+strconv.FormatInt(n, 10))
+	}
+	return args
+}
+
+// gsCommand returns the command running GhostScript with args - under prlimit,
+// limiting its address space to ConfGsMemLimit, if set (on Linux).
+func gsCommand(args ...string) *exec.Cmd {
+	if n := *ConfGsMemLimit; n > 0 && runtime.GOOS == "linux" {
+		if prlimit := lookPath("prlimit"); prlimit != "" {
+			return exec.Command(prlimit,
+				append([]string{"--as=" + strconv.FormatInt(n, 10), "--", *ConfGs}, args...)...)
+		}
+		Log("msg", "WARN no prlimit, GhostScript memory is not limited", "gsMemLimit", n)
+	}
+	return exec.Command(*ConfGs, args...)
+}
+
+// gsLimitError returns err as ErrResourceLimit if it is GhostScript running out
+// of memory with a resource limit configured, err otherwise.
+func gsLimitError(err error) error {
+	if err == nil || !gsLimitReached([]byte(err.Error())) {
+		return err
+	}
+	return errors.Wrap(ErrResourceLimit, err.Error())
+}
+
+// gsLimitReached reports whether the GhostScript output is of running out of memory,
+// with a resource limit configured - that is not worth retrying.
+func gsLimitReached(errout []byte) bool {
+	if !gsLimited() {
+		return false
+	}
+	for _, sig := range gsLimitSignatures {
+		if bytes.Contains(errout, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// gsRetryable reports whether the GhostScript failure is transient, and not
+// of running out of the configured limits, which would fail again.
+func gsRetryable(errout []byte) bool {
+	return isTransient(errout) && !gsLimitReached(errout)
+}
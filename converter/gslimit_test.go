@@ -0,0 +1,91 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestGsLimit(t *testing.T) {
+	defer func(m, b, s int64) {
+		*ConfGsMemLimit, *ConfGsMaxBitmap, *ConfGsBufferSpace = m, b, s
+	}(*ConfGsMemLimit, *ConfGsMaxBitmap, *ConfGsBufferSpace)
+
+	*ConfGsMemLimit, *ConfGsMaxBitmap, *ConfGsBufferSpace = 0, 0, 0
+	if args := gsLimitArgs(); len(args) != 0 {
+		t.Errorf("no limits: got %q", args)
+	}
+	vmErr := errors.New("Error: /VMerror in --showpage--")
+	if err := gsLimitError(vmErr); err != vmErr {
+		t.Errorf("no limits: got %v", err)
+	}
+	if cmd := gsCommand("-q"); cmd.Args[0] != *ConfGs {
+		t.Errorf("no limits: got %q", cmd.Args)
+	}
+
+	*ConfGsMaxBitmap, *ConfGsBufferSpace = 50000000, 4000000
+	want := []string{"-dMaxBitmap=50000000", "-dBufferSpace=4000000"}
+	if args := gsLimitArgs(); !reflect.DeepEqual(args, want) {
+		t.Errorf("got %q, wanted %q", args, want)
+	}
+	if err := gsLimitError(vmErr); errors.Cause(err) != ErrResourceLimit {
+		t.Errorf("VMerror: got %v", err)
+	}
+	if other := errors.New("Unrecoverable error"); gsLimitError(other) != other {
+		t.Errorf("other error: got %v", gsLimitError(other))
+	}
+
+	*ConfGsMemLimit = 1 << 30
+	cmd := gsCommand("-q")
+	if runtime.GOOS != "linux" || lookPath("prlimit") == "" {
+		t.Skip("no prlimit")
+	}
+	if want := []string{"--as=1073741824", "--", *ConfGs, "-q"}; !reflect.DeepEqual(cmd.Args[1:], want) {
+		t.Errorf("got %q, wanted %q", cmd.Args[1:], want)
+	}
+}
+
+func TestGsLimitNoRetry(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	defer func(m int64, n int) {
+		*ConfGsMemLimit, *ConfExecRetries = m, n
+	}(*ConfGsMemLimit, *ConfExecRetries)
+	*ConfGsMemLimit, *ConfExecRetries = 1<<30, 2
+
+	dir, err := ioutil.TempDir("", "agostle-gslimit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	countFn := filepath.Join(dir, "count")
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	cmd := exec.Command("sh", "-c", `echo x >>"$0"; echo "Error: /VMerror in --showpage--"; exit 1`, countFn)
+	if err = executeRetry(ctx, cmd, gsRetryable); err == nil {
+		t.Fatal("wanted error")
+	}
+	if errors.Cause(gsLimitError(err)) != ErrResourceLimit {
+		t.Errorf("wanted ErrResourceLimit, got %v", err)
+	}
+	b, err := ioutil.ReadFile(countFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2 {
+		t.Errorf("run %d times, wanted once", len(b)/2)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// GsProfile is a GhostScript pdfwrite quality profile (-dPDFSETTINGS).
+type GsProfile string
+
+const (
+	GsScreen   = GsProfile("screen")   // 72 dpi images, smallest
+	GsEbook    = GsProfile("ebook")    // 150 dpi images
+	GsPrinter  = GsProfile("printer")  // 300 dpi images
+	GsPrepress = GsProfile("prepress") // 300 dpi images, color preserving
+)
+
+// Validate checks that the profile is known (or empty, which means the default).
+func (p GsProfile) Validate() error {
+	switch p {
+	case "", GsScreen, GsEbook, GsPrinter, GsPrepress:
+		return nil
+	}
+	return errors.Errorf("unknown GhostScript profile %q", string(p))
+}
+
+// orDefault returns ConfGsProfile for the empty profile.
+func (p GsProfile) orDefault() GsProfile {
+	if p != "" {
+		return p
+	}
+	if *ConfGsProfile != "" {
+		return GsProfile(*ConfGsProfile)
+	}
+	return GsPrinter
+}
+
+const gsProfileKey = "gsProfile"
+
+// WithGsProfile returns a context which carries the GhostScript profile
+// for the PDF rewrites during the conversion.
+func WithGsProfile(ctx context.Context, profile GsProfile) context.Context {
+	return context.WithValue(ctx, gsProfileKey, profile)
+}
+
+func getGsProfile(ctx context.Context) GsProfile {
+	if ctx == nil {
+		return ""
+	}
+	p, _ := ctx.Value(gsProfileKey).(GsProfile)
+	return p
+}
+
+var (
+	gsVersionMu    sync.Mutex
+	gsVersionCache = make(map[string]int)
+)
+
+// gsMajorVersion returns the major version of the GhostScript at path,
+// or 0 if it cannot be determined.
+func gsMajorVersion(path string) int {
+	gsVersionMu.Lock()
+	defer gsVersionMu.Unlock()
+	if v, ok := gsVersionCache[path]; ok {
+		return v
+	}
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		Log("msg", "gs --version", "gs", path, "error", err)
+	}
+	v := parseGsMajorVersion(out)
+	gsVersionCache[path] = v
+	return v
+}
+
+func parseGsMajorVersion(out []byte) int {
+	s := string(bytes.TrimSpace(out))
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	v, _ := strconv.Atoi(s)
+	return v
+}
+
+// pdfwriteOpts returns the profile dependent pdfwrite options.
+// -dUseCIEColor is deprecated since GhostScript 9, and distorts the colors.
+func pdfwriteOpts(gs string, profile GsProfile) []string {
+	opts := []string{"-dPDFSETTINGS=/" + string(profile.orDefault())}
+	if v := gsMajorVersion(gs); v > 0 && v < 9 {
+		opts = append(opts, "-dUseCIEColor=true")
+	}
+	return opts
+}
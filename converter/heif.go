@@ -0,0 +1,69 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// heifBrands are the ISO BMFF major brands of HEIC/HEIF images.
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1"}
+
+// isHEIF reports whether the head of the file looks like a HEIC/HEIF image
+// ("ftyp" box with a HEIF brand).
+func isHEIF(head []byte) bool {
+	if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return false
+	}
+	brand := string(head[8:12])
+	for _, b := range heifBrands {
+		if brand == b {
+			return true
+		}
+	}
+	return false
+}
+
+// heifToJpeg converts the (primary) image of the HEIC/HEIF srcfn to a JPEG,
+// with ConfHeifConvert if set, otherwise with GraphicsMagick
+// (which needs to be compiled with libheif).
+func heifToJpeg(ctx context.Context, destfn, srcfn string) error {
+	var errout bytes.Buffer
+	if *ConfHeifConvert != "" {
+		cmd := exec.Command(*ConfHeifConvert, "-q", "92", srcfn, destfn)
+		cmd.Stdout = &errout
+		cmd.Stderr = &errout
+		if err := runWithContext(ctx, cmd); err != nil {
+			return errors.Wrapf(err, "%s %s: %s", *ConfHeifConvert, srcfn, errout.Bytes())
+		}
+		if fileExists(destfn) {
+			return nil
+		}
+		// image collections are written as dest-1.jpg, dest-2.jpg...
+		first := strings.TrimSuffix(destfn, ".jpg") + "-1.jpg"
+		if !fileExists(first) {
+			return errors.Errorf("%s %s: no output: %s", *ConfHeifConvert, srcfn, errout.Bytes())
+		}
+		return os.Rename(first, destfn)
+	}
+	if *ConfGm == "" {
+		return errors.New("no HEIC/HEIF decoder: set heifConvert (libheif's heif-convert)")
+	}
+	cmd := exec.Command(*ConfGm, "convert", srcfn+"[0]", "jpeg:"+destfn)
+	cmd.Stdout = &errout
+	cmd.Stderr = &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return errors.Wrapf(err,
+			"no HEIC/HEIF decoder (gm cannot read it, set heifConvert to libheif's heif-convert): %s",
+			errout.Bytes())
+	}
+	return nil
+}
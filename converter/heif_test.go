@@ -0,0 +1,24 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import "testing"
+
+func TestIsHEIF(t *testing.T) {
+	for i, tc := range []struct {
+		head string
+		want bool
+	}{
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", true},
+		{"\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00", true},
+		{"\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00", false},
+		{"\xff\xd8\xff\xe0\x00\x10JFIF\x00", false},
+		{"ftyp", false},
+	} {
+		if got := isHEIF([]byte(tc.head)); got != tc.want {
+			t.Errorf("%d. got %t, wanted %t", i, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// icsProp is a property (content line) of an iCalendar component.
+type icsProp struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icsEvent is a VEVENT, with its properties.
+type icsEvent []icsProp
+
+func (ev icsEvent) get(name string) (icsProp, bool) {
+	for _, p := range ev {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return icsProp{}, false
+}
+
+func (ev icsEvent) all(name string) []icsProp {
+	var props []icsProp
+	for _, p := range ev {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// ICSToPdf converts an iCalendar file (text/calendar) to PDF, as a readable
+// summary of its events. Files without events are converted as plain text.
+func ICSToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	charset, r := sniffCharset(r)
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read calendar")
+	}
+	method, events := parseICS(bytes.NewReader(b))
+	getLogger(ctx).Log("msg", "Converting into", "ct", contentType, "charset", charset,
+		"events", len(events), "dest", destfn)
+	if len(events) == 0 {
+		return TextToPdf(ctx, destfn, bytes.NewReader(b), "text/plain")
+	}
+	var buf bytes.Buffer
+	icsToHTML(&buf, method, events)
+	return HTMLToPdf(ctx, destfn, &buf, "text/html")
+}
+
+// parseICS returns the METHOD of the calendar and its VEVENTs.
+// The properties of the nested components (such as VALARM) are skipped.
+func parseICS(r io.Reader) (method string, events []icsEvent) {
+	var stack []string
+	var ev icsEvent
+	for _, line := range unfoldICS(r) {
+		p, ok := parseICSLine(line)
+		if !ok {
+			continue
+		}
+		switch p.Name {
+		case "BEGIN":
+			stack = append(stack, strings.ToUpper(p.Value))
+			if len(stack) == 2 && stack[1] == "VEVENT" {
+				ev = icsEvent{}
+			}
+			continue
+		case "END":
+			if len(stack) == 2 && stack[1] == "VEVENT" {
+				events = append(events, ev)
+				ev = nil
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		switch {
+		case len(stack) == 1 && p.Name == "METHOD":
+			method = p.Value
+		case len(stack) == 2 && stack[1] == "VEVENT":
+			ev = append(ev, p)
+		}
+	}
+	return method, events
+}
+
+// unfoldICS returns the content lines, joining the folded (continuation) lines.
+func unfoldICS(r io.Reader) []string {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseICSLine parses a NAME;PARAM=VALUE;...:value content line.
+func parseICSLine(line string) (icsProp, bool) {
+	var p icsProp
+	var parts []string
+	// the name and the parameters end at the first colon outside of quotes
+	inQuote, start, colon := false, 0, -1
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch c := line[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == ';':
+			parts = append(parts, line[start:i])
+			start = i + 1
+		case c == ':':
+			parts = append(parts, line[start:i])
+			colon = i
+		}
+	}
+	if colon < 0 {
+		return p, false
+	}
+	p.Value = line[colon+1:]
+	if len(parts) == 0 || parts[0] == "" {
+		return p, false
+	}
+	p.Name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		i := strings.IndexByte(param, '=')
+		if i <= 0 {
+			continue
+		}
+		if p.Params == nil {
+			p.Params = make(map[string]string, len(parts)-1)
+		}
+		p.Params[strings.ToUpper(param[:i])] = strings.Trim(param[i+1:], `"`)
+	}
+	return p, true
+}
+
+// icsUnescape unescapes the TEXT value.
+var icsUnescape = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace
+
+// icsTime formats the DATE or DATE-TIME property, with its time zone.
+func icsTime(p icsProp) string {
+	v := p.Value
+	if p.Params["VALUE"] == "DATE" || len(v) == 8 {
+		if t, err := time.Parse("20060102", v); err == nil {
+			return t.Format("2006-01-02")
+		}
+		return v
+	}
+	utc := strings.HasSuffix(v, "Z")
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(v, "Z"))
+	if err != nil {
+		return v
+	}
+	s := t.Format("2006-01-02 15:04")
+	switch tzid := p.Params["TZID"]; {
+	case utc:
+		s += " UTC"
+	case tzid != "":
+		s += " (" + tzid + ")"
+	}
+	return s
+}
+
+// icsPerson formats the ORGANIZER or ATTENDEE property as "Name <address>".
+func icsPerson(p icsProp) string {
+	addr := p.Value
+	if strings.HasPrefix(strings.ToLower(addr), "mailto:") {
+		addr = addr[7:]
+	}
+	s := addr
+	if cn := p.Params["CN"]; cn != "" && cn != addr {
+		s = cn + " <" + addr + ">"
+	}
+	if st := p.Params["PARTSTAT"]; st != "" && st != "NEEDS-ACTION" {
+		s += " (" + strings.ToLower(st) + ")"
+	}
+	if p.Params["ROLE"] == "OPT-PARTICIPANT" {
+		s += " [optional]"
+	}
+	return s
+}
+
+// icsToHTML writes the events as HTML tables.
+func icsToHTML(w io.Writer, method string, events []icsEvent) {
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8">
+<style>
+body { font-family: sans-serif; font-size: 11pt; }
+table { border-collapse: collapse; margin-bottom: 2em; width: 100%; }
+th { text-align: left; vertical-align: top; padding: 2px 8px 2px 0; width: 8em; color: #555; }
+td { vertical-align: top; padding: 2px 0; }
+.desc { white-space: pre-wrap; }
+</style></head>
+<body>
+`)
+	row := func(label, value string, class string) {
+		if value == "" {
+			return
+		}
+		buf.WriteString("<tr><th>" + label + "</th><td")
+		if class != "" {
+			buf.WriteString(` class="` + class + `"`)
+		}
+		buf.WriteString(">" + html.EscapeString(value) + "</td></tr>\n")
+	}
+	for _, ev := range events {
+		summary := "(no title)"
+		if p, ok := ev.get("SUMMARY"); ok && p.Value != "" {
+			summary = icsUnescape(p.Value)
+		}
+		if method == "CANCEL" {
+			summary = "Cancelled: " + summary
+		}
+		buf.WriteString("<h2>" + html.EscapeString(summary) + "</h2>\n<table>\n")
+		if p, ok := ev.get("DTSTART"); ok {
+			row("Start", icsTime(p), "")
+		}
+		if p, ok := ev.get("DTEND"); ok {
+			row("End", icsTime(p), "")
+		} else if p, ok := ev.get("DURATION"); ok {
+			row("Duration", p.Value, "")
+		}
+		if p, ok := ev.get("RRULE"); ok {
+			row("Repeats", p.Value, "")
+		}
+		if p, ok := ev.get("LOCATION"); ok {
+			row("Location", icsUnescape(p.Value), "")
+		}
+		if p, ok := ev.get("ORGANIZER"); ok {
+			row("Organizer", icsPerson(p), "")
+		}
+		attendees := ev.all("ATTENDEE")
+		for i, p := range attendees {
+			label := ""
+			if i == 0 {
+				label = "Attendees"
+			}
+			buf.WriteString("<tr><th>" + label + "</th><td>" + html.EscapeString(icsPerson(p)) + "</td></tr>\n")
+		}
+		if p, ok := ev.get("STATUS"); ok {
+			row("Status", strings.ToLower(p.Value), "")
+		}
+		if p, ok := ev.get("DESCRIPTION"); ok {
+			row("Description", strings.TrimSpace(icsUnescape(p.Value)), "desc")
+		}
+		buf.WriteString("</table>\n")
+	}
+	buf.WriteString("</body></html>")
+	_, _ = w.Write(buf.Bytes())
+}
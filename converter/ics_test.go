@@ -0,0 +1,94 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Budapest\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19701025T030000\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Tervezés\\, 2. kör\r\n" +
+	"DTSTART;TZID=Europe/Budapest:20170912T100000\r\n" +
+	"DTEND;TZID=Europe/Budapest:20170912T113000\r\n" +
+	"LOCATION:Tárgyaló <1>\r\n" +
+	"ORGANIZER;CN=\"Doe, John\":mailto:john@example.com\r\n" +
+	"ATTENDEE;CN=Jane;PARTSTAT=ACCEPTED:mailto:jane@example.com\r\n" +
+	"ATTENDEE;ROLE=OPT-PARTICIPANT:mailto:bob@example.com\r\n" +
+	"DESCRIPTION:First line\\nsecond line\\, fol\r\n" +
+	" ded\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"DESCRIPTION:alarm\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"DTSTART;VALUE=DATE:20171224\r\n" +
+	"DTEND:20171226T000000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	method, events := parseICS(strings.NewReader(testICS))
+	if method != "REQUEST" {
+		t.Errorf("got method %q", method)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, wanted 2", len(events))
+	}
+	ev := events[0]
+	if p, _ := ev.get("DESCRIPTION"); icsUnescape(p.Value) != "First line\nsecond line, folded" {
+		t.Errorf("got description %q", p.Value)
+	}
+	if p, _ := ev.get("ORGANIZER"); icsPerson(p) != "Doe, John <john@example.com>" {
+		t.Errorf("got organizer %q", icsPerson(p))
+	}
+	for i, want := range []string{"Jane <jane@example.com> (accepted)", "bob@example.com [optional]"} {
+		if got := icsPerson(ev.all("ATTENDEE")[i]); got != want {
+			t.Errorf("%d. got attendee %q, wanted %q", i, got, want)
+		}
+	}
+	for i, tc := range []struct {
+		ev         icsEvent
+		name, want string
+	}{
+		{ev, "DTSTART", "2017-09-12 10:00 (Europe/Budapest)"},
+		{events[1], "DTSTART", "2017-12-24"},
+		{events[1], "DTEND", "2017-12-26 00:00 UTC"},
+	} {
+		p, _ := tc.ev.get(tc.name)
+		if got := icsTime(p); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	icsToHTML(&buf, method, events)
+	s := buf.String()
+	for _, want := range []string{
+		"<h2>Tervezés, 2. kör</h2>", "<h2>Holiday</h2>", "Tárgyaló &lt;1&gt;",
+		"First line\nsecond line, folded",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("%q is missing from %s", want, s)
+		}
+	}
+	if strings.Contains(s, "alarm") {
+		t.Errorf("VALARM leaked into %s", s)
+	}
+	if GetConverter("text/calendar", nil) == nil {
+		t.Error("no converter for text/calendar")
+	}
+}
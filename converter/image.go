@@ -10,27 +10,31 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
 	"github.com/tgulacsi/go/temp"
 )
 
 // ImageToPdfGm converts image to PDF using GraphicsMagick
-func ImageToPdfGm(w io.Writer, r io.Reader, contentType string) error {
+func ImageToPdfGm(ctx context.Context, w io.Writer, r io.Reader, contentType string) error {
 	//log.Printf("converting image %s to %s", contentType, destfn)
 	imgtyp := ""
 	if false && contentType != "" {
 		imgtyp = contentType[strings.Index(contentType, "/")+1:] + ":"
 	}
 
-	cmd := exec.Command(*ConfGm, "convert", imgtyp+"-", "pdf:-")
+	args := append([]string{"convert"}, densityArgs(ctx)...)
+	cmd := exec.Command(*ConfGm, append(args, imgtyp+"-", "pdf:-")...)
 	// cmd.Stdin = io.TeeReader(r, os.Stderr)
 	cmd.Stdin = r
 	cmd.Stdout = w
 	errout := bytes.NewBuffer(nil)
 	cmd.Stderr = errout
-	err := runWithTimeout(cmd)
+	err := runWithContext(ctx, cmd)
 	if err != nil {
 		return errors.Wrapf(err, "gm convert converting %s: %s", r, errout.Bytes())
 	}
@@ -40,24 +44,61 @@ func ImageToPdfGm(w io.Writer, r io.Reader, contentType string) error {
 	return nil
 }
 
+// ImageFrames returns the number of frames (pages) in the image file,
+// using the image backend (GraphicsMagick or ImageMagick).
+func ImageFrames(ctx context.Context, fn string) (int, error) {
+	var out, errout bytes.Buffer
+	cmd := magickCommand("identify", "-format", "%p\n", fn)
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return 0, errors.Wrapf(err, "identify %s: %s", fn, errout.Bytes())
+	}
+	var n int
+	for _, line := range bytes.Split(out.Bytes(), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MultiImageToPdfGm converts all the frames of the image file
+// (such as a multi-page TIFF) to the pages of destfn, using the image backend.
+func MultiImageToPdfGm(ctx context.Context, destfn, srcfn, imgtyp string) error {
+	var errout bytes.Buffer
+	args := append(densityArgs(ctx), "-adjoin", imgtyp+":"+srcfn, "pdf:"+destfn)
+	cmd := magickCommand("convert", args...)
+	cmd.Stdout = &errout
+	cmd.Stderr = &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return errors.Wrapf(err, "convert %s: %s", srcfn, errout.Bytes())
+	}
+	return nil
+}
+
 // PdfToImage converts PDF to image using PdfToImageGm if available and the result is OK, then PdfToImageCairo.
-func PdfToImage(w io.Writer, r io.Reader, contentType, size string) error {
+// Without those, mutool is used.
+func PdfToImage(ctx context.Context, w io.Writer, r io.Reader, contentType, size string) error {
+	if getMutoolOk().Render {
+		return PdfToImageMutool(ctx, w, r, contentType, size)
+	}
 	src := temp.NewMemorySlurper("PdfToImage-src-")
 	defer src.Close()
 	dst := temp.NewMemorySlurper("PdfToImage-dst-")
 	defer dst.Close()
 
 	var err error
-	if err = PdfToImageCairo(dst, io.TeeReader(r, src), contentType, size); err == nil {
+	if err = PdfToImageCairo(ctx, dst, io.TeeReader(r, src), contentType, size); err == nil {
 		_, err = io.Copy(w, dst)
 		return err
 	}
 	Log("msg", "ERROR PdfToImageCairo", "error", err)
-	return PdfToImageGm(w, io.MultiReader(src, r), contentType, size)
+	return PdfToImageGm(ctx, w, io.MultiReader(src, r), contentType, size)
 }
 
 // PdfToImageCairo converts PDF to image using pdftocairo from poppler-utils.
-func PdfToImageCairo(w io.Writer, r io.Reader, contentType, size string) error {
+func PdfToImageCairo(ctx context.Context, w io.Writer, r io.Reader, contentType, size string) error {
 	imgtyp, ext := "gif", "png"
 	if contentType != "" && strings.HasPrefix(contentType, "image/") {
 		imgtyp = contentType[6:]
@@ -65,20 +106,8 @@ func PdfToImageCairo(w io.Writer, r io.Reader, contentType, size string) error {
 	if imgtyp == "png" || imgtyp == "jpeg" {
 		ext = imgtyp
 	}
-	args := append(make([]string, 0, 8), "-singlefile", "-"+ext, "-cropbox")
-	if size != "" {
-		i := strings.IndexByte(size, 'x')
-		if i <= 0 || size[:i] == size[i+1:] {
-			if i > 0 {
-				size = size[:i]
-			}
-			args = append(args, "-scale-to", size)
-		} else {
-			args = append(args, "-scale-to-x", size[:i])
-			args = append(args, "-scale-to-y", size[i+1:])
-		}
-	}
-	tfh, err := ioutil.TempFile("", "PdfToImageGm-")
+	args := append([]string{"-singlefile", "-" + ext, "-cropbox"}, scaleToArgs(size)...)
+	tfh, err := ioutil.TempFile(GetWorkdir(ctx), "PdfToImageGm-")
 	if err != nil {
 		Log("msg", "ERROR cannot create temp file", "error", err)
 		return err
@@ -93,7 +122,7 @@ func PdfToImageCairo(w io.Writer, r io.Reader, contentType, size string) error {
 	cmd.Stdin = r
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err = runWithTimeout(cmd); err != nil {
+	if err = runWithContext(ctx, cmd); err != nil {
 		return err
 	}
 	if tfh, err = os.Open(fn); err != nil {
@@ -115,8 +144,31 @@ func PdfToImageCairo(w io.Writer, r io.Reader, contentType, size string) error {
 	return cmd.Run()
 }
 
+// PdfToImageMutool converts the first page of the PDF to image using mutool
+// (GIF by default, as PdfToImageCairo).
+func PdfToImageMutool(ctx context.Context, w io.Writer, r io.Reader, contentType, size string) error {
+	imgtyp := "gif"
+	if strings.HasPrefix(contentType, "image/") {
+		imgtyp = contentType[6:]
+	}
+	tfh, err := ioutil.TempFile(GetWorkdir(ctx), "PdfToImageMutool-")
+	if err != nil {
+		return err
+	}
+	fn := tfh.Name()
+	defer func() { _ = os.Remove(fn) }()
+	_, err = io.Copy(tfh, r)
+	if closeErr := tfh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return mutoolDraw(ctx, w, fn, size, 1, imgtyp)
+}
+
 // PdfToImageGm converts PDF to image using GraphicsMagick.
-func PdfToImageGm(w io.Writer, r io.Reader, contentType, size string) error {
+func PdfToImageGm(ctx context.Context, w io.Writer, r io.Reader, contentType, size string) error {
 	// gm may pollute its stdout with error & warning messages, so we must use files!
 	var imgtyp = "gif"
 	if contentType != "" && strings.HasPrefix(contentType, "image/") {
@@ -128,7 +180,7 @@ func PdfToImageGm(w io.Writer, r io.Reader, contentType, size string) error {
 		args[2] = "-resize"
 		args = append(args, size)
 	}
-	tfh, err := ioutil.TempFile("", "PdfToImageGm-")
+	tfh, err := ioutil.TempFile(GetWorkdir(ctx), "PdfToImageGm-")
 	if err != nil {
 		Log("msg", "ERROR cannot create temp file", "error", err)
 		return err
@@ -139,7 +191,7 @@ func PdfToImageGm(w io.Writer, r io.Reader, contentType, size string) error {
 	//cmd.Stdout = &filterFirstLines{Beginning: []string{"Can't find ", "Warning: "}, Writer: w}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err = runWithTimeout(cmd); err != nil {
+	if err = runWithContext(ctx, cmd); err != nil {
 		return err
 	}
 	fn := tfh.Name()
@@ -154,3 +206,41 @@ func PdfToImageGm(w io.Writer, r io.Reader, contentType, size string) error {
 	_ = os.Remove(fn)
 	return err
 }
+
+// MaxImageDensity is the cap of the image density, against running out of memory.
+const MaxImageDensity = 600
+
+const imageDensityKey = "imageDensity"
+
+// WithImageDensity returns a context which carries the density (DPI)
+// the images are converted with, overriding ConfImageDensity.
+func WithImageDensity(ctx context.Context, density int) context.Context {
+	return context.WithValue(ctx, imageDensityKey, density)
+}
+
+// getImageDensity returns the image density of the context, or ConfImageDensity,
+// capped at MaxImageDensity.
+func getImageDensity(ctx context.Context) int {
+	density := *ConfImageDensity
+	if ctx != nil {
+		if d, ok := ctx.Value(imageDensityKey).(int); ok {
+			density = d
+		}
+	}
+	if density > MaxImageDensity {
+		return MaxImageDensity
+	}
+	if density < 0 {
+		return 0
+	}
+	return density
+}
+
+// densityArgs returns the -density argument for the image backend, if set.
+func densityArgs(ctx context.Context) []string {
+	density := getImageDensity(ctx)
+	if density == 0 {
+		return nil
+	}
+	return []string{"-density", strconv.Itoa(density)}
+}
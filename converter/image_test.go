@@ -0,0 +1,39 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestImageDensity(t *testing.T) {
+	old := *ConfImageDensity
+	defer func() { *ConfImageDensity = old }()
+	*ConfImageDensity = 150
+	for i, tc := range []struct {
+		ctx  context.Context
+		want []string
+	}{
+		{context.Background(), []string{"-density", "150"}},
+		{WithImageDensity(context.Background(), 300), []string{"-density", "300"}},
+		{WithImageDensity(context.Background(), 10000), []string{"-density", "600"}},
+		{WithImageDensity(context.Background(), 0), nil},
+	} {
+		if got := densityArgs(tc.ctx); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+	*ConfImageDensity = 0
+	if got := densityArgs(context.Background()); got != nil {
+		t.Errorf("got %q, wanted no density by default", got)
+	}
+	*ConfImageDensity = 1200
+	if got := getImageDensity(context.Background()); got != MaxImageDensity {
+		t.Errorf("got %d, wanted the cap %d", got, MaxImageDensity)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ImageFitOpts are the options for fitting the images onto pages of the given size.
+// The zero value means no fitting: the page size is the image's native size.
+type ImageFitOpts struct {
+	PageSize string  // A4, Letter...
+	DPI      int     // resolution of the page; the default is 150
+	Margin   float64 // margin on each side, in mm
+}
+
+// page sizes in mm
+var imageFitPageSizes = map[string][2]float64{
+	"a3":     {297, 420},
+	"a4":     {210, 297},
+	"a5":     {148, 210},
+	"letter": {215.9, 279.4},
+	"legal":  {215.9, 355.6},
+}
+
+const defaultImageFitDPI = 150
+
+// ParseImageFitOpts parses the "PAGESIZE[,DPI[,MARGIN]]" string, such as "A4,150,10mm".
+// The margin's unit can be mm (the default), cm or in.
+func ParseImageFitOpts(s string) (ImageFitOpts, error) {
+	var opts ImageFitOpts
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return opts, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > 3 {
+		return opts, errors.Errorf("bad fit %q: wanted PAGESIZE[,DPI[,MARGIN]]", s)
+	}
+	opts.PageSize = strings.TrimSpace(parts[0])
+	if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+		dpi, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return opts, errors.Wrapf(err, "bad fit DPI %q", parts[1])
+		}
+		opts.DPI = dpi
+	}
+	if len(parts) > 2 {
+		m, mul := strings.TrimSpace(parts[2]), 1.0
+		for _, u := range []struct {
+			Suffix string
+			Mul    float64
+		}{{"mm", 1}, {"cm", 10}, {"in", 25.4}} {
+			if strings.HasSuffix(m, u.Suffix) {
+				m, mul = strings.TrimSuffix(m, u.Suffix), u.Mul
+				break
+			}
+		}
+		margin, err := strconv.ParseFloat(m, 64)
+		if err != nil {
+			return opts, errors.Wrapf(err, "bad fit margin %q", parts[2])
+		}
+		opts.Margin = margin * mul
+	}
+	return opts, opts.Validate()
+}
+
+// Validate checks the options.
+func (o ImageFitOpts) Validate() error {
+	if o.IsZero() {
+		return nil
+	}
+	page, ok := imageFitPageSizes[strings.ToLower(o.PageSize)]
+	if !ok {
+		return errors.Errorf("unknown page size %q", o.PageSize)
+	}
+	if o.DPI < 0 || o.DPI > 1200 {
+		return errors.Errorf("bad DPI %d (0-1200)", o.DPI)
+	}
+	if o.Margin < 0 || 2*o.Margin >= page[0] {
+		return errors.Errorf("bad margin %gmm", o.Margin)
+	}
+	return nil
+}
+
+// IsZero reports whether no fitting is asked for.
+func (o ImageFitOpts) IsZero() bool {
+	return o.PageSize == ""
+}
+
+// String returns a short representation, usable in file names.
+func (o ImageFitOpts) String() string {
+	if o.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d-%g", strings.ToLower(o.PageSize), o.DPI, o.Margin)
+}
+
+// geometry returns the page size and the size of the area inside the margins,
+// in pixels, for the given orientation.
+func (o ImageFitOpts) geometry(landscape bool) (pageW, pageH, boxW, boxH int) {
+	page := imageFitPageSizes[strings.ToLower(o.PageSize)]
+	w, h := page[0], page[1]
+	if landscape {
+		w, h = h, w
+	}
+	dpi := o.DPI
+	if dpi == 0 {
+		dpi = defaultImageFitDPI
+	}
+	px := func(mm float64) int { return int(mm/25.4*float64(dpi) + 0.5) }
+	return px(w), px(h), px(w - 2*o.Margin), px(h - 2*o.Margin)
+}
+
+// args returns the gm convert arguments which scale down the oversized image
+// into the margins, and center it on the page.
+func (o ImageFitOpts) args(landscape bool) []string {
+	pageW, pageH, boxW, boxH := o.geometry(landscape)
+	dpi := o.DPI
+	if dpi == 0 {
+		dpi = defaultImageFitDPI
+	}
+	return []string{
+		"-resize", fmt.Sprintf("%dx%d>", boxW, boxH),
+		"-background", "white", "-gravity", "center",
+		"-extent", fmt.Sprintf("%dx%d", pageW, pageH),
+		"-units", "PixelsPerInch", "-density", strconv.Itoa(dpi),
+	}
+}
+
+const imageFitKey = "imageFit"
+
+// WithImageFitOpts returns a context which carries the given options for ImageToPdf.
+func WithImageFitOpts(ctx context.Context, opts ImageFitOpts) context.Context {
+	return context.WithValue(ctx, imageFitKey, opts)
+}
+
+func getImageFitOpts(ctx context.Context) ImageFitOpts {
+	if ctx == nil {
+		return ImageFitOpts{}
+	}
+	opts, _ := ctx.Value(imageFitKey).(ImageFitOpts)
+	return opts
+}
+
+// imageSize returns the size of the (first frame of the) image, using the image backend.
+func imageSize(ctx context.Context, fn string) (width, height int, err error) {
+	var out, errout bytes.Buffer
+	cmd := magickCommand("identify", "-format", "%w %h\n", fn+"[0]")
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	if err = runWithContext(ctx, cmd); err != nil {
+		return 0, 0, errors.Wrapf(err, "identify %s: %s", fn, errout.Bytes())
+	}
+	if _, err = fmt.Sscan(out.String(), &width, &height); err != nil {
+		return 0, 0, errors.Wrapf(err, "parse %q", out.String())
+	}
+	return width, height, nil
+}
+
+// ImageToPdfFit converts all the frames of the image srcfn to pages of destfn,
+// fitting them onto pages of the given size (the orientation follows the image's).
+func ImageToPdfFit(ctx context.Context, destfn, srcfn, imgtyp string, opts ImageFitOpts) error {
+	var landscape bool
+	if w, h, err := imageSize(ctx, srcfn); err != nil {
+		getLogger(ctx).Log("msg", "imageSize", "file", srcfn, "error", err)
+	} else {
+		landscape = w > h
+	}
+	args := append(append([]string{imgtyp + ":" + srcfn}, opts.args(landscape)...),
+		"-adjoin", "pdf:"+destfn)
+	var errout bytes.Buffer
+	cmd := magickCommand("convert", args...)
+	cmd.Stdout = &errout
+	cmd.Stderr = &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return errors.Wrapf(err, "convert %s: %s", srcfn, errout.Bytes())
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseImageFitOpts(t *testing.T) {
+	for i, tc := range []struct {
+		in   string
+		want ImageFitOpts
+		err  bool
+	}{
+		{"", ImageFitOpts{}, false},
+		{"A4", ImageFitOpts{PageSize: "A4"}, false},
+		{"Letter,300", ImageFitOpts{PageSize: "Letter", DPI: 300}, false},
+		{"a4,,1cm", ImageFitOpts{PageSize: "a4", Margin: 10}, false},
+		{"A4,150,0.5in", ImageFitOpts{PageSize: "A4", DPI: 150, Margin: 12.7}, false},
+		{"B5", ImageFitOpts{}, true},
+		{"A4,x", ImageFitOpts{}, true},
+		{"A4,150,200mm", ImageFitOpts{}, true},
+		{"A4,150,1,2", ImageFitOpts{}, true},
+	} {
+		got, err := ParseImageFitOpts(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%d. %q: got error %v, wanted error? %t", i, tc.in, err, tc.err)
+			continue
+		}
+		if !tc.err && got != tc.want {
+			t.Errorf("%d. %q: got %+v, wanted %+v", i, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestImageFitArgs(t *testing.T) {
+	opts := ImageFitOpts{PageSize: "A4", DPI: 100, Margin: 25.4}
+	want := []string{
+		"-resize", "627x969>",
+		"-background", "white", "-gravity", "center",
+		"-extent", "827x1169",
+		"-units", "PixelsPerInch", "-density", "100",
+	}
+	if got := opts.args(false); !reflect.DeepEqual(got, want) {
+		t.Errorf("portrait: got %q, wanted %q", got, want)
+	}
+	if _, _, w, h := opts.geometry(true); w != 969 || h != 627 {
+		t.Errorf("landscape: got %dx%d", w, h)
+	}
+}
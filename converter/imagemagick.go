@@ -0,0 +1,109 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// The image conversion backends for ConfImageBackend.
+const (
+	BackendGm = "gm" // GraphicsMagick
+	BackendIM = "im" // ImageMagick
+)
+
+// lookMagick returns the path of ImageMagick: "magick" (IM 7), or "convert" (IM 6).
+// As other programs are called "convert", too (such as the Windows filesystem
+// converter), that is accepted only if its -version says it is ImageMagick.
+func lookMagick() string {
+	if fn := lookPath("magick"); fn != "" {
+		return fn
+	}
+	fn := lookPath("convert")
+	if fn == "" {
+		return ""
+	}
+	if out, _ := exec.Command(fn, "-version").Output(); !bytes.Contains(out, []byte("ImageMagick")) {
+		return ""
+	}
+	return fn
+}
+
+// imageBackend returns the image conversion backend: ConfImageBackend if set,
+// otherwise GraphicsMagick if ConfGm is found, ImageMagick if ConfMagick is.
+func imageBackend() string {
+	switch b := strings.ToLower(*ConfImageBackend); b {
+	case BackendGm, BackendIM:
+		return b
+	case "":
+	default:
+		Log("msg", "unknown imageBackend, choosing automatically", "imageBackend", b)
+	}
+	if *ConfGm == "" && *ConfMagick != "" {
+		return BackendIM
+	}
+	return BackendGm
+}
+
+// magickCommand returns the command for the "convert" or "identify" subcommand
+// of the image backend.
+func magickCommand(sub string, args ...string) *exec.Cmd {
+	if imageBackend() == BackendGm {
+		return exec.Command(*ConfGm, append([]string{sub}, args...)...)
+	}
+	return imCommand(*ConfMagick, sub, args...)
+}
+
+// imCommand returns the ImageMagick command for sub ("convert" or "identify"):
+// "magick [identify] args..." for IM 7, "convert args..." or "identify args..." for IM 6.
+func imCommand(magick, sub string, args ...string) *exec.Cmd {
+	base := strings.TrimSuffix(strings.ToLower(filepath.Base(magick)), ".exe")
+	if base == "magick" {
+		if sub != "convert" {
+			args = append([]string{sub}, args...)
+		}
+		return exec.Command(magick, args...)
+	}
+	if sub != "convert" && base == "convert" {
+		// identify is next to convert
+		if dir := filepath.Dir(magick); dir != "." {
+			return exec.Command(filepath.Join(dir, sub+filepath.Ext(magick)), args...)
+		}
+		return exec.Command(sub, args...)
+	}
+	return exec.Command(magick, args...)
+}
+
+// ImageToPdfIM converts image to PDF using ImageMagick
+func ImageToPdfIM(ctx context.Context, w io.Writer, r io.Reader, contentType string) error {
+	args := append(densityArgs(ctx), "-", "-auto-orient", "pdf:-")
+	cmd := imCommand(*ConfMagick, "convert", args...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	errout := bytes.NewBuffer(nil)
+	cmd.Stderr = errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return errors.Wrapf(err, "%s converting %s: %s", *ConfMagick, contentType, errout.Bytes())
+	}
+	if len(errout.Bytes()) > 0 {
+		getLogger(ctx).Log("msg", "WARN magick convert", "ct", contentType, "error", errout.String())
+	}
+	return nil
+}
+
+// imageToPdf converts the image to PDF with the configured (or available) backend.
+func imageToPdf(ctx context.Context, w io.Writer, r io.Reader, contentType string) error {
+	if imageBackend() == BackendIM {
+		return ImageToPdfIM(ctx, w, r, contentType)
+	}
+	return ImageToPdfGm(ctx, w, r, contentType)
+}
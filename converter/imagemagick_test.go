@@ -0,0 +1,80 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIMCommand(t *testing.T) {
+	for i, tc := range []struct {
+		magick, sub string
+		want        []string
+	}{
+		{"/usr/bin/magick", "convert", []string{"/usr/bin/magick", "-", "pdf:-"}},
+		{"/usr/bin/magick", "identify", []string{"/usr/bin/magick", "identify", "-", "pdf:-"}},
+		{"/usr/bin/convert", "convert", []string{"/usr/bin/convert", "-", "pdf:-"}},
+		{"/usr/bin/convert", "identify", []string{filepath.Join("/usr/bin", "identify"), "-", "pdf:-"}},
+	} {
+		cmd := imCommand(tc.magick, tc.sub, "-", "pdf:-")
+		got := append([]string{cmd.Path}, cmd.Args[1:]...)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestImageBackend(t *testing.T) {
+	oBackend, oGm, oMagick := *ConfImageBackend, *ConfGm, *ConfMagick
+	defer func() { *ConfImageBackend, *ConfGm, *ConfMagick = oBackend, oGm, oMagick }()
+	for i, tc := range []struct {
+		backend, gm, magick, want string
+	}{
+		{"", "gm", "magick", BackendGm},
+		{"", "", "magick", BackendIM},
+		{"", "", "", BackendGm},
+		{"im", "gm", "magick", BackendIM},
+		{"GM", "", "magick", BackendGm},
+	} {
+		*ConfImageBackend, *ConfGm, *ConfMagick = tc.backend, tc.gm, tc.magick
+		if got := imageBackend(); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestLookMagick(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-magick-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	if err = os.Setenv("PATH", dir); err != nil {
+		t.Fatal(err)
+	}
+	convert := filepath.Join(dir, "convert")
+	for i, tc := range []struct {
+		version, want string
+	}{
+		{"Version: ImageMagick 6.9.7-4 Q16 x86_64 20170114", convert},
+		{"Converts FAT volumes to NTFS.", ""},
+	} {
+		if err = ioutil.WriteFile(convert, []byte("#!/bin/sh\necho '"+tc.version+"'\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if got := lookMagick(); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// imapTimeout is the deadline of one IMAP command (including fetching a message).
+const imapTimeout = 5 * time.Minute
+
+// IngestIMAP converts the unseen messages of ConfIMAPFolder into ConfIMAPOutDir
+// (see ingestIMAP), checking every interval, until ctx is cancelled.
+func IngestIMAP(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		unlock := RLockConfig()
+		n, err := ingestIMAP(ctx)
+		if err != nil {
+			Log("msg", "IMAP ingestion", "addr", *ConfIMAPAddr, "folder", *ConfIMAPFolder, "converted", n, "error", err)
+		} else if n > 0 {
+			Log("msg", "IMAP ingestion", "addr", *ConfIMAPAddr, "folder", *ConfIMAPFolder, "converted", n)
+		}
+		unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ingestIMAP converts the unseen messages of ConfIMAPFolder with MailToPdfZip
+// into ConfIMAPOutDir, and marks them as seen (or moves them to ConfIMAPMoveTo).
+// The messages failing to convert are logged, and left unseen.
+// It returns the number of the converted messages.
+func ingestIMAP(ctx context.Context) (int, error) {
+	if *ConfIMAPOutDir == "" {
+		return 0, errors.New("imapOutDir is not set")
+	}
+	c, err := dialIMAP(*ConfIMAPAddr, *ConfIMAPTLS)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = c.Logout() }()
+	if err = c.Login(*ConfIMAPUser, *ConfIMAPPassword); err != nil {
+		return 0, err
+	}
+	validity, err := c.Select(*ConfIMAPFolder)
+	if err != nil {
+		return 0, err
+	}
+	uids, err := c.SearchUnseen()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, uid := range uids {
+		select {
+		case <-ctx.Done():
+			return n, ctx.Err()
+		default:
+		}
+		if err = ingestMessage(ctx, c, validity, uid); err != nil {
+			Log("msg", "IMAP message", "folder", *ConfIMAPFolder, "uid", uid, "error", err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ingestMessage fetches and converts one message, in its own work directory.
+// The zip appears in ConfIMAPOutDir at once, named by the folder and the message's UID.
+func ingestMessage(ctx context.Context, c *imapConn, validity, uid uint32) error {
+	defer StartWork()()
+	dir, err := ioutil.TempDir(GetWorkdir(ctx), "imap-")
+	if err != nil {
+		return errors.Wrap(err, "create workdir")
+	}
+	ctx = context.WithValue(ctx, "workdir", dir)
+	if !KeepTempFiles(ctx) {
+		defer func() { _ = os.RemoveAll(dir) }()
+	}
+	fh, err := os.Create(filepath.Join(dir, "message.eml"))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fh.Close() }()
+	if err = c.FetchBody(fh, uid); err != nil {
+		return err
+	}
+	if _, err = fh.Seek(0, 0); err != nil {
+		return err
+	}
+	name := imapZipName(*ConfIMAPFolder, validity, uid)
+	tmpfn := filepath.Join(dir, name)
+	if err = MailToPdfZip(ctx, tmpfn, fh, "message/rfc822"); err != nil {
+		return errors.Wrap(err, "convert")
+	}
+	destfn := filepath.Join(*ConfIMAPOutDir, name)
+	if err = moveFile(tmpfn, destfn+".part"); err != nil {
+		return errors.Wrapf(err, "move to %s", *ConfIMAPOutDir)
+	}
+	if err = os.Rename(destfn+".part", destfn); err != nil {
+		return errors.Wrapf(err, "rename to %s", destfn)
+	}
+	if *ConfIMAPMoveTo != "" {
+		return c.Move(uid, *ConfIMAPMoveTo)
+	}
+	return c.MarkSeen(uid)
+}
+
+// imapZipName returns the name of the zip of the message,
+// unique while the folder's UIDVALIDITY does not change.
+func imapZipName(folder string, validity, uid uint32) string {
+	folder = strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, folder)
+	return fmt.Sprintf("%s-%d-%d.zip", folder, validity, uid)
+}
+
+// imapConn is a minimal IMAP4rev1 client, just for fetching the unseen messages.
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	seq  int
+}
+
+// dialIMAP connects to the IMAP server at addr (host:port).
+func dialIMAP(addr string, useTLS bool) (*imapConn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(d, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to %s", addr)
+	}
+	c, err := newIMAPConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "connect to %s", addr)
+	}
+	return c, nil
+}
+
+// newIMAPConn reads the greeting of the server.
+func newIMAPConn(conn net.Conn) (*imapConn, error) {
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	_ = conn.SetDeadline(time.Now().Add(imapTimeout))
+	line, err := c.readResponse(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "read greeting")
+	}
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return nil, errors.Errorf("bad greeting %q", line)
+	}
+	return c, nil
+}
+
+// Login logs in with the user name and password.
+func (c *imapConn) Login(user, password string) error {
+	u, err := imapQuote(user)
+	if err != nil {
+		return err
+	}
+	p, err := imapQuote(password)
+	if err != nil {
+		return err
+	}
+	_, err = c.command(nil, "LOGIN "+u+" "+p)
+	return err
+}
+
+// Select selects the folder, and returns its UIDVALIDITY.
+func (c *imapConn) Select(folder string) (uint32, error) {
+	f, err := imapQuote(folder)
+	if err != nil {
+		return 0, err
+	}
+	untagged, err := c.command(nil, "SELECT "+f)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range untagged {
+		if i := strings.Index(line, "[UIDVALIDITY "); i >= 0 {
+			s := line[i+len("[UIDVALIDITY "):]
+			if j := strings.IndexByte(s, ']'); j >= 0 {
+				s = s[:j]
+			}
+			v, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return 0, errors.Wrapf(err, "parse %q", line)
+			}
+			return uint32(v), nil
+		}
+	}
+	return 0, nil
+}
+
+// SearchUnseen returns the UIDs of the unseen messages of the selected folder.
+func (c *imapConn) SearchUnseen() ([]uint32, error) {
+	untagged, err := c.command(nil, "UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, line := range untagged {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "SEARCH" {
+			continue
+		}
+		for _, s := range fields[1:] {
+			uid, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return uids, errors.Wrapf(err, "parse %q", line)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// FetchBody writes the whole message into w, without marking it as seen.
+func (c *imapConn) FetchBody(w io.Writer, uid uint32) error {
+	untagged, err := c.command(w, "UID FETCH "+strconv.FormatUint(uint64(uid), 10)+" BODY.PEEK[]")
+	if err != nil {
+		return err
+	}
+	for _, line := range untagged {
+		if strings.Contains(line, "FETCH") && strings.Contains(line, "BODY[]") {
+			return nil
+		}
+	}
+	return errors.Errorf("no message with UID %d", uid)
+}
+
+// MarkSeen sets the \Seen flag of the message.
+func (c *imapConn) MarkSeen(uid uint32) error {
+	_, err := c.command(nil, "UID STORE "+strconv.FormatUint(uint64(uid), 10)+` +FLAGS.SILENT (\Seen)`)
+	return err
+}
+
+// Move moves the message into the folder, with MOVE (RFC 6851),
+// or if the server does not know it, with COPY and UID EXPUNGE (RFC 4315).
+func (c *imapConn) Move(uid uint32, folder string) error {
+	f, err := imapQuote(folder)
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatUint(uint64(uid), 10)
+	if _, err = c.command(nil, "UID MOVE "+id+" "+f); err == nil {
+		return nil
+	}
+	if _, err = c.command(nil, "UID COPY "+id+" "+f); err != nil {
+		return err
+	}
+	if _, err = c.command(nil, "UID STORE "+id+` +FLAGS.SILENT (\Seen \Deleted)`); err != nil {
+		return err
+	}
+	// a bare EXPUNGE would remove the other \Deleted messages of the folder, too;
+	// without UIDPLUS, the message is left flagged as deleted.
+	if _, err = c.command(nil, "UID EXPUNGE "+id); err != nil {
+		Log("msg", "IMAP UID EXPUNGE", "uid", uid, "error", err)
+	}
+	return nil
+}
+
+// Logout logs out, and closes the connection.
+func (c *imapConn) Logout() error {
+	_, err := c.command(nil, "LOGOUT")
+	if closeErr := c.conn.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// command sends the command, and reads the responses up to its tagged status.
+// The untagged responses are returned without the leading "* ",
+// the literals in them are written into lit (or discarded, if nil).
+func (c *imapConn) command(lit io.Writer, cmd string) ([]string, error) {
+	c.seq++
+	tag := "a" + strconv.Itoa(c.seq)
+	verb := cmd
+	if i := strings.IndexByte(cmd, ' '); i >= 0 {
+		if verb = cmd[:i]; verb == "UID" {
+			verb = cmd[:i+1+strings.IndexByte(cmd[i+1:]+" ", ' ')]
+		}
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := c.w.WriteString(tag + " " + cmd + "\r\n"); err != nil {
+		return nil, errors.Wrap(err, verb)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, errors.Wrap(err, verb)
+	}
+	var untagged []string
+	for {
+		line, err := c.readResponse(lit)
+		if err != nil {
+			return untagged, errors.Wrap(err, verb)
+		}
+		if strings.HasPrefix(line, "* ") {
+			untagged = append(untagged, line[2:])
+			continue
+		}
+		if !strings.HasPrefix(line, tag+" ") {
+			return untagged, errors.Errorf("%s: unexpected response %q", verb, line)
+		}
+		if status := line[len(tag)+1:]; !strings.HasPrefix(status, "OK") {
+			return untagged, errors.Errorf("%s: %s", verb, status)
+		}
+		return untagged, nil
+	}
+}
+
+// readResponse reads one response line, with its literals ("{123}" at the end
+// of a line, followed by that many bytes) written into lit.
+func (c *imapConn) readResponse(lit io.Writer) (string, error) {
+	var resp string
+	for {
+		line, err := c.r.ReadString('\n')
+		resp += strings.TrimRight(line, "\r\n")
+		if err != nil {
+			return resp, err
+		}
+		n, ok := imapLiteralSize(resp)
+		if !ok {
+			return resp, nil
+		}
+		w := lit
+		if w == nil {
+			w = ioutil.Discard
+		}
+		if _, err = io.CopyN(w, c.r, n); err != nil {
+			return resp, errors.Wrap(err, "read literal")
+		}
+	}
+}
+
+// imapLiteralSize returns the size of the literal announced at the end of the line.
+func imapLiteralSize(line string) (int64, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	i := strings.LastIndexByte(line, '{')
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(line[i+1:len(line)-1], 10, 64)
+	return n, err == nil && n >= 0
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return "", errors.Errorf("%q cannot be quoted", s)
+	}
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIMAPConn(t *testing.T) {
+	const msg = "From: a@example.com\r\nSubject: test\r\n\r\nbody\r\n"
+	// the scripted server: the responses for the commands in order
+	script := []struct {
+		cmd  string
+		resp []string
+	}{
+		{`LOGIN "user" "pa\"ss"`, nil},
+		{`SELECT "INBOX"`, []string{"* 3 EXISTS", "* OK [UIDVALIDITY 1234] UIDs valid"}},
+		{"UID SEARCH UNSEEN", []string{"* SEARCH 7 9"}},
+		{"UID FETCH 9 BODY.PEEK[]", []string{"* 2 FETCH (UID 9 BODY[] {" +
+			strconv.Itoa(len(msg)) + "}\r\n" + msg + ")"}},
+		{`UID MOVE 9 "Done"`, []string{"BAD"}},
+		{`UID COPY 9 "Done"`, nil},
+		{`UID STORE 9 +FLAGS.SILENT (\Seen \Deleted)`, nil},
+		{"UID EXPUNGE 9", []string{"* 2 EXPUNGE"}},
+		// without UIDPLUS, the message is left flagged as deleted
+		{`UID MOVE 7 "Done"`, []string{"BAD"}},
+		{`UID COPY 7 "Done"`, nil},
+		{`UID STORE 7 +FLAGS.SILENT (\Seen \Deleted)`, nil},
+		{"UID EXPUNGE 7", []string{"BAD"}},
+		{"LOGOUT", []string{"* BYE"}},
+	}
+	client, server := net.Pipe()
+	errch := make(chan string, 1)
+	go func() {
+		defer close(errch)
+		defer server.Close()
+		w := bufio.NewWriter(server)
+		r := bufio.NewReader(server)
+		w.WriteString("* OK ready\r\n")
+		w.Flush()
+		for _, s := range script {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				errch <- err.Error()
+				return
+			}
+			fields := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 2)
+			if len(fields) != 2 || fields[1] != s.cmd {
+				errch <- "got " + line + ", wanted " + s.cmd
+				return
+			}
+			status := "OK done"
+			for _, resp := range s.resp {
+				if resp == "BAD" {
+					status = "BAD unknown command"
+					continue
+				}
+				w.WriteString(resp + "\r\n")
+			}
+			w.WriteString(fields[0] + " " + status + "\r\n")
+			w.Flush()
+		}
+	}()
+
+	c, err := newIMAPConn(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Login("user", `pa"ss`); err != nil {
+		t.Fatal(err)
+	}
+	validity, err := c.Select("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity != 1234 {
+		t.Errorf("got UIDVALIDITY %d, wanted 1234", validity)
+	}
+	uids, err := c.SearchUnseen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(uids, []uint32{7, 9}) {
+		t.Errorf("got %v, wanted [7 9]", uids)
+	}
+	var buf bytes.Buffer
+	if err = c.FetchBody(&buf, 9); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != msg {
+		t.Errorf("got %q, wanted %q", buf.String(), msg)
+	}
+	if err = c.Move(9, "Done"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Move(7, "Done"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Logout(); err != nil {
+		t.Error(err)
+	}
+	if s, ok := <-errch; ok {
+		t.Error(s)
+	}
+}
+
+func TestIMAPZipName(t *testing.T) {
+	if got, want := imapZipName("INBOX/Számlák", 12, 3), "INBOX_Sz_ml_k-12-3.zip"; got != want {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+// PlanPart is the conversion plan of a file, or of a part of an email.
+type PlanPart struct {
+	Seq          int         `json:"seq,omitempty"`
+	Level        int         `json:"level,omitempty"`
+	FileName     string      `json:"fileName,omitempty"`
+	DeclaredType string      `json:"declaredType,omitempty"`
+	ContentType  string      `json:"contentType"`
+	Converter    string      `json:"converter,omitempty"`
+	Skip         bool        `json:"skip"`
+	Reason       string      `json:"reason,omitempty"`
+	Parts        []*PlanPart `json:"parts,omitempty"`
+}
+
+// Inspect returns the conversion plan for the file, without converting it:
+// the content-type FixContentType resolves to, and the converter GetConverter chooses.
+// For emails, the plan of each part is returned in Parts, as a tree.
+func Inspect(ctx context.Context, r io.Reader, contentType, fileName string) (*PlanPart, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize)
+	root := planPart(head, contentType, fileName)
+	if root.ContentType != "message/rfc822" {
+		return root, nil
+	}
+
+	// parents[i] is the last part seen on level i
+	parents := []*PlanPart{root}
+	err := i18nmail.Walk(
+		i18nmail.MailPart{ContentType: "message/rfc822", Body: br},
+		func(mp i18nmail.MailPart) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			var buf [sniffSize]byte
+			n, _ := io.ReadFull(mp.Body, buf[:])
+			ct := mp.ContentType
+			if len(mp.MediaType) > 0 {
+				ct = mime.FormatMediaType(ct, mp.MediaType)
+			}
+			pp := planPart(buf[:n], ct, headerGetFileName(mp.Header))
+			pp.Seq, pp.Level = mp.Seq, mp.Level
+			level := mp.Level
+			if level < 1 {
+				level = 1
+			}
+			if level > len(parents) {
+				level = len(parents)
+			}
+			parent := parents[level-1]
+			parent.Parts = append(parent.Parts, pp)
+			parents = append(parents[:level], pp)
+			return nil
+		},
+		false)
+	if err != nil {
+		return root, errors.Wrap(err, "walk mail")
+	}
+	return root, nil
+}
+
+// planPart returns the plan for one file.
+func planPart(head []byte, contentType, fileName string) *PlanPart {
+	pp := &PlanPart{FileName: fileName, DeclaredType: contentType}
+	var mediaType map[string]string
+	if contentType != "" {
+		if ct, params, err := mime.ParseMediaType(contentType); err == nil {
+			contentType, mediaType = ct, params
+		}
+	}
+	pp.ContentType = FixContentType(head, contentType, fileName)
+	if strings.HasPrefix(pp.ContentType, "multipart/") &&
+		pp.ContentType != "multipart/related" && pp.ContentType != "multipart/alternative" {
+		pp.Reason = "container"
+		return pp
+	}
+	c := GetConverter(pp.ContentType, mediaType)
+	if c == nil {
+		pp.Skip, pp.Reason = true, "no converter"
+		return pp
+	}
+	if !convertible(pp.ContentType, mediaType) {
+		pp.Skip, pp.Reason = true, "not allowed"
+		return pp
+	}
+	pp.Converter = converterName(c)
+	if pp.Converter == "Skip" {
+		pp.Skip, pp.Reason = true, "skipped"
+	}
+	return pp
+}
+
+var funcSuffix = regexp.MustCompile(`(?:\.func\d+)+$`)
+
+// converterName returns the (function) name of the converter,
+// "TextToPdf" for this package's, "pkg.Func" for others.
+func converterName(c Converter) string {
+	f := runtime.FuncForPC(reflect.ValueOf(c).Pointer())
+	if f == nil {
+		return "?"
+	}
+	name := funcSuffix.ReplaceAllString(path.Base(f.Name()), "")
+	return strings.TrimPrefix(name, "converter.")
+}
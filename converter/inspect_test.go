@@ -0,0 +1,43 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestInspect(t *testing.T) {
+	for i, tc := range []struct {
+		ct, fn, body string
+		want         PlanPart
+	}{
+		{"application/pdf", "a.pdf", "%PDF-1.4\n",
+			PlanPart{ContentType: "application/pdf", Converter: "PdfToPdf"}},
+		{"text/plain; charset=iso-8859-2", "a.txt", "abc",
+			PlanPart{ContentType: "text/plain", Converter: "NewTextConverter"}},
+		{"text/csv", "a.csv", "a,b\n",
+			PlanPart{ContentType: "text/csv", Converter: "NewCSVConverter"}},
+		{"application/x-pkcs7-signature", "smime.p7s", "\x30\x82",
+			PlanPart{ContentType: "application/x-pkcs7-signature", Converter: "Skip", Skip: true, Reason: "skipped"}},
+		{"audio/mpeg", "a.mp3", "ID3",
+			PlanPart{ContentType: "audio/mpeg", Skip: true, Reason: "not allowed"}},
+	} {
+		got, err := Inspect(context.Background(), strings.NewReader(tc.body), tc.ct, tc.fn)
+		if err != nil {
+			t.Errorf("%d. %v", i, err)
+			continue
+		}
+		if got.ContentType != tc.want.ContentType || got.Converter != tc.want.Converter ||
+			got.Skip != tc.want.Skip || got.Reason != tc.want.Reason {
+			t.Errorf("%d. got %+v, wanted %+v", i, got, tc.want)
+		}
+		if got.DeclaredType != tc.ct || got.FileName != tc.fn {
+			t.Errorf("%d. declared %q/%q, wanted %q/%q", i, got.DeclaredType, got.FileName, tc.ct, tc.fn)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// lofficeSafeSettings is the registrymodifications.xcu of the safe LibreOffice profiles:
+// only signed macros from trusted sources may run (MacroSecurityLevel=3, "very high",
+// without any trusted source), macro execution is disabled altogether,
+// and no links are followed and no warnings are shown on load.
+const lofficeSafeSettings = `<?xml version="1.0" encoding="UTF-8"?>
+<oor:items xmlns:oor="http://openoffice.org/2001/registry" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<item oor:path="/org.openoffice.Office.Common/Security/Scripting"><prop oor:name="MacroSecurityLevel" oor:op="fuse"><value>3</value></prop></item>
+<item oor:path="/org.openoffice.Office.Common/Security/Scripting"><prop oor:name="DisableMacrosExecution" oor:op="fuse"><value>true</value></prop></item>
+<item oor:path="/org.openoffice.Office.Common/Security/Scripting"><prop oor:name="BlockUntrustedRefererLinks" oor:op="fuse"><value>true</value></prop></item>
+<item oor:path="/org.openoffice.Office.Common/Security/Scripting"><prop oor:name="WarnAlienFormat" oor:op="fuse"><value>false</value></prop></item>
+<item oor:path="/org.openoffice.Office.Common/Misc"><prop oor:name="FirstRun" oor:op="fuse"><value>false</value></prop></item>
+</oor:items>
+`
+
+// writeLofficeSafeProfile writes the safe settings into the user profile at dir,
+// overwriting what LibreOffice may have changed there.
+func writeLofficeSafeProfile(dir string) error {
+	if dir == "" {
+		return errors.New("safe LibreOffice profile needs a directory")
+	}
+	fn := filepath.Join(dir, "user", "registrymodifications.xcu")
+	if b, err := ioutil.ReadFile(fn); err == nil && string(b) == lofficeSafeSettings {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0750); err != nil {
+		return errors.Wrap(err, "create LibreOffice profile")
+	}
+	return errors.Wrap(ioutil.WriteFile(fn, []byte(lofficeSafeSettings), 0640),
+		"write LibreOffice profile")
+}
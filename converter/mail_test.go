@@ -5,7 +5,10 @@
 package converter
 
 import (
+	"mime"
+	"mime/multipart"
 	"net/mail"
+	"os"
 	"strconv"
 	"testing"
 
@@ -45,9 +48,78 @@ func TestHeaderGetFileName(t *testing.T) {
 			map[string][]string{"Content-Description": {"20160519_1211_GKIU1AM.docx"}, "Content-Disposition": {"attachment; filename=\"20160519_1211_GKIU1AM.docx\"; size=271904; creation-date=\"Thu, 19 May 2016 10:15:00 GMT\"; modification-date=\"Thu, 19 May 2016 10:15:00 GMT\""}, "Content-Id": {"<59DA1D23CFE5BB419EE50F7DF8CE0CAC@example.com>"}, "Content-Transfer-Encoding": {"base64"}, "X-Hashoffullmessage": {"2WupAYCPqc_630rRYxms6hyEkgk="}, "X-Filename": {"20160519_1211_GKIU1AM.docx"}, "Content-Type": {"application/octet-stream; name=\"20160519_1211_GKIU1AM.docx\""}},
 			"20160519_1211_GKIU1AM.docx",
 		},
+		{
+			map[string][]string{"Content-Disposition": {`attachment; filename="=?utf-8?Q?k=C3=A1rbejelent=C5=91.pdf?="`}},
+			"kárbejelentő.pdf",
+		},
+		{
+			map[string][]string{"Content-Disposition": {`attachment; filename*=iso-8859-2''k%E1rbejelent%F5.pdf`}},
+			"kárbejelentő.pdf",
+		},
+		{
+			map[string][]string{"Content-Disposition": {"attachment; filename*0=\"k\"; filename*1*=%C3%A1rbejelent%C5%91.pdf"}},
+			"kárbejelentő.pdf",
+		},
+		{
+			map[string][]string{"Content-Disposition": {"inline"}, "Content-Type": {`application/pdf; name="=?iso-8859-2?B?a+FyYmVqZWxlbnT1LnBkZg==?="`}},
+			"kárbejelentő.pdf",
+		},
 	} {
 		if got := headerGetFileName(tc.Header); got != tc.Want {
 			t.Errorf("%d. got %q, wanted %q (%q).", tN, got, tc.Want, tc.Header)
 		}
 	}
 }
+
+func TestHeaderGetFileNameEncoded(t *testing.T) {
+	fh, err := os.Open("testdata/hungarian-filenames.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	msg, err := mail.ReadMessage(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for i, want := range []string{
+		"",
+		"árvíztűrő tükörfúrógép.pdf",
+		"árvíztűrő.txt",
+		"őszi levél.docx",
+		"Árvíztűrő tükörfúrógép.xlsx",
+		"Ügyfél adatlap.jpg",
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		if got := headerGetFileName(part.Header); got != want {
+			t.Errorf("%d. got %q, wanted %q (%q).", i, got, want, part.Header)
+		}
+	}
+}
+
+func TestIsRFC822(t *testing.T) {
+	for i, tc := range []struct {
+		head string
+		want bool
+	}{
+		{"From: a@example.com\r\nTo: b@example.com\r\nSubject: x\r\n\r\nbody", true},
+		{"Received: from x\n\tby y\nMIME-Version: 1.0\nContent-Type: text/plain; cha", true},
+		{"\xef\xbb\xbfReturn-Path: <a@example.com>\n", true},
+		{"Subject: no mail fields\n\nbody", false},
+		{"%PDF-1.4\n%\xe2\xe3\xcf\xd3\n", false},
+		{"From: a@example.com\nthis is not a header\n", false},
+		{" From: a@example.com\n", false},
+		{"", false},
+	} {
+		if got := isRFC822([]byte(tc.head)); got != tc.want {
+			t.Errorf("%d. got %t, wanted %t for %q", i, got, tc.want, tc.head)
+		}
+	}
+}
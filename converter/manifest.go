@@ -0,0 +1,121 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PartInfo describes the mail part an archive item is made of.
+type PartInfo struct {
+	Seq         int
+	FileName    string // the original file name
+	ContentType string
+	Error       string // the error of the conversion (or splitting)
+	Signed      bool   // the part is a digitally signed PDF
+	Warnings    []string
+}
+
+// ManifestEntry describes one converted part in the manifest (ManifestFn) of the zip.
+type ManifestEntry struct {
+	Seq         int      `json:"seq"`
+	FileName    string   `json:"fileName,omitempty"`
+	ContentType string   `json:"contentType,omitempty"`
+	Entries     []string `json:"entries"`
+	Pages       int      `json:"pages,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	Signed      bool     `json:"signed,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// zipName returns the name of the item in the zip, as ZipFiles names it.
+func (a ArchFileItem) zipName() string {
+	if a.Archive != "" {
+		return a.Archive
+	}
+	if a.Filename == "" {
+		return ""
+	}
+	return unsafeFn(filepath.Base(a.Filename), true)
+}
+
+// buildManifest groups the items by their part. The items without a part
+// (such as the mail body) get an entry each, with Seq -1.
+// With split, the pages are the number of the page PDFs (or images) of the part,
+// otherwise they're counted with PdfPageNum.
+func buildManifest(ctx context.Context, items []ArchFileItem, split bool) []ManifestEntry {
+	entries := make([]ManifestEntry, 0, len(items))
+	byPart := make(map[*PartInfo]int, len(items))
+	for _, item := range items {
+		name := item.zipName()
+		if name == "" || name == ErrTextFn || name == ManifestFn {
+			continue
+		}
+		i, ok := byPart[item.Part]
+		if !ok || item.Part == nil {
+			e := ManifestEntry{Seq: -1}
+			if p := item.Part; p != nil {
+				e.Seq, e.FileName, e.ContentType, e.Error = p.Seq, p.FileName, p.ContentType, p.Error
+				e.Signed, e.Warnings = p.Signed, p.Warnings
+			}
+			if item.Error != nil && e.Error == "" {
+				e.Error = item.Error.Error()
+			}
+			i = len(entries)
+			entries = append(entries, e)
+			byPart[item.Part] = i
+		}
+		e := &entries[i]
+		e.Entries = append(e.Entries, name)
+		if item.Error != nil || !strings.HasSuffix(item.Filename, ".pdf") {
+			continue
+		}
+		if split {
+			e.Pages++
+		} else if n, err := PdfPageNum(ctx, item.Filename); err == nil {
+			e.Pages += n
+		}
+	}
+	for i, e := range entries {
+		if split && e.Pages == 0 && e.Error == "" {
+			// images only
+			entries[i].Pages = len(e.Entries)
+		}
+		sort.Strings(entries[i].Entries)
+	}
+	sort.Stable(manifestBySeq(entries))
+	return entries
+}
+
+// manifestBySeq is a wrapper for []ManifestEntry for sort.Stable
+type manifestBySeq []ManifestEntry
+
+func (m manifestBySeq) Len() int           { return len(m) }
+func (m manifestBySeq) Less(i, j int) bool { return m[i].Seq < m[j].Seq }
+func (m manifestBySeq) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// writeManifest writes the manifest of the items as JSON into fn.
+func writeManifest(ctx context.Context, fn string, items []ArchFileItem, split bool) error {
+	fh, err := os.Create(fn)
+	if err != nil {
+		return errors.Wrap(err, fn)
+	}
+	enc := json.NewEncoder(fh)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(struct {
+		Parts []ManifestEntry `json:"parts"`
+	}{Parts: buildManifest(ctx, items, split)}); err != nil {
+		_ = fh.Close()
+		return errors.Wrap(err, fn)
+	}
+	return errors.Wrap(fh.Close(), fn)
+}
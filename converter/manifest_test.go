@@ -0,0 +1,41 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+func TestBuildManifest(t *testing.T) {
+	doc := &PartInfo{Seq: 3, FileName: "report.docx", ContentType: "application/vnd.ms-word"}
+	bad := &PartInfo{Seq: 2, FileName: "x.bin", ContentType: "application/x-foo"}
+	img := &PartInfo{Seq: 4, FileName: "a.png", ContentType: "image/png"}
+	items := []ArchFileItem{
+		{Filename: "/tmp/wd/01#003.application--vnd.ms-word.-1.pdf", Part: doc},
+		{Filename: "/tmp/wd/01#003.application--vnd.ms-word.-2.pdf", Part: doc},
+		{Archive: "application/01#002.application--x-foo.", Error: errors.New("no converter"), Part: bad},
+		{Filename: "/tmp/wd/00#000.text--html.pdf"},
+		{Filename: "/tmp/wd/01#004.image--png.-1.pdf", Part: img},
+		{Filename: "/tmp/wd/errors.txt", Archive: ErrTextFn},
+	}
+	got := buildManifest(context.Background(), items, true)
+	want := []ManifestEntry{
+		{Seq: -1, Entries: []string{"00#000.text--html.pdf"}, Pages: 1},
+		{Seq: 2, FileName: "x.bin", ContentType: "application/x-foo",
+			Entries: []string{"application/01#002.application--x-foo."}, Error: "no converter"},
+		{Seq: 3, FileName: "report.docx", ContentType: "application/vnd.ms-word",
+			Entries: []string{"01#003.application--vnd.ms-word.-1.pdf", "01#003.application--vnd.ms-word.-2.pdf"},
+			Pages:   2},
+		{Seq: 4, FileName: "a.png", ContentType: "image/png",
+			Entries: []string{"01#004.image--png.-1.pdf"}, Pages: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v,\nwanted\n%+v", got, want)
+	}
+}
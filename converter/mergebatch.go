@@ -0,0 +1,200 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/go/temp"
+)
+
+// mergeManifestFn is the name of the manifest of the merged batches, in mergeBatchDir.
+const mergeManifestFn = "merge-batches.manifest"
+
+// mergeBatchDirName is the directory of the merged batches under Workdir.
+// It outlives the request work directories, so a retried request finds the
+// batches merged by the failed one; it is removed by the workdir reaper.
+const mergeBatchDirName = "merge-batches"
+
+// mergeManifestMu protects the manifests.
+var mergeManifestMu sync.Mutex
+
+// needsBatches reports whether merging n files is done in batches (see mergeBatches).
+func needsBatches(n int) bool {
+	size := *ConfMergeBatchSize
+	return size > 1 && n > size
+}
+
+// mergeBatches merges the files in batches of size with merge, then the merged batches.
+//
+// Each merged batch is kept in mergeBatchDirName under Workdir, and recorded in its
+// manifest, keyed by the content of its input files - so when the merge is retried,
+// even by a new request (with a new request workdir), the already merged batches are
+// not merged again. The batches are removed when the whole merge succeeds.
+func mergeBatches(ctx context.Context, destfn string, filenames []string, size int,
+	merge func(ctx context.Context, destfn string, filenames ...string) error,
+) error {
+	Log := getLogger(ctx).Log
+	dir, batchDir := GetWorkdir(ctx), filepath.Join(Workdir, mergeBatchDirName)
+	if err := os.MkdirAll(batchDir, 0750); err != nil {
+		return errors.Wrap(err, "create merge batch dir")
+	}
+	mfn := filepath.Join(batchDir, mergeManifestFn)
+	done, err := readMergeManifest(mfn)
+	if err != nil {
+		return err
+	}
+	var keys, batches, kept []string
+	for i := 0; i < len(filenames); i += size {
+		end := i + size
+		if end > len(filenames) {
+			end = len(filenames)
+		}
+		key, err := mergeBatchKey(filenames[i:end])
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		// the batch is used from the request workdir, as a concurrent
+		// request may remove the kept one when its merge succeeds
+		fn := filepath.Join(dir, "merge-batch-"+key+".pdf")
+		if keptfn := done[key]; keptfn != "" && fileExists(keptfn) {
+			if err = temp.LinkOrCopy(keptfn, fn); err == nil {
+				Log("msg", "merged batch found", "batch", len(batches), "file", keptfn)
+				batches = append(batches, fn)
+				kept = append(kept, keptfn)
+				continue
+			}
+			Log("msg", "link merged batch", "file", keptfn, "error", err)
+		}
+		if err = merge(ctx, fn, filenames[i:end]...); err != nil {
+			_ = os.Remove(fn)
+			return errors.Wrapf(err, "merge batch %d", len(batches))
+		}
+		batches = append(batches, fn)
+		keptfn := filepath.Join(batchDir, "merge-batch-"+key+".pdf")
+		if err = temp.LinkOrCopy(fn, keptfn); err != nil {
+			Log("msg", "keep merged batch", "file", keptfn, "error", err)
+			continue
+		}
+		kept = append(kept, keptfn)
+		if err = appendMergeManifest(mfn, key, keptfn); err != nil {
+			return err
+		}
+	}
+	if len(batches) > size {
+		err = mergeBatches(ctx, destfn, batches, size, merge)
+	} else {
+		err = merge(ctx, destfn, batches...)
+	}
+	if err != nil || KeepTempFiles(ctx) {
+		return err
+	}
+	for _, fn := range batches {
+		_ = unlink(fn, "merged batch")
+	}
+	for _, fn := range kept {
+		_ = unlink(fn, "kept merged batch")
+	}
+	return removeMergeManifest(mfn, keys)
+}
+
+// mergeBatchKey returns the key of the batch: the hash of its files' contents,
+// so the same files saved again (by a retried request) have the same key,
+// and a changed (such as repaired) file makes a new batch.
+func mergeBatchKey(filenames []string) (string, error) {
+	h := sha1.New()
+	for _, fn := range filenames {
+		fh, err := os.Open(fn)
+		if err != nil {
+			return "", errors.Wrap(err, "open "+fn)
+		}
+		n, err := io.Copy(h, fh)
+		_ = fh.Close()
+		if err != nil {
+			return "", errors.Wrap(err, "read "+fn)
+		}
+		fmt.Fprintf(h, "\x00%d\n", n)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readMergeManifest reads the manifest: one "key<TAB>batch file" line per merged batch.
+func readMergeManifest(mfn string) (map[string]string, error) {
+	mergeManifestMu.Lock()
+	defer mergeManifestMu.Unlock()
+	done := make(map[string]string)
+	fh, err := os.Open(mfn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return done, nil
+		}
+		return nil, errors.Wrap(err, "open merge manifest")
+	}
+	defer func() { _ = fh.Close() }()
+	scan := bufio.NewScanner(fh)
+	for scan.Scan() {
+		if i := strings.IndexByte(scan.Text(), '\t'); i > 0 {
+			done[scan.Text()[:i]] = scan.Text()[i+1:]
+		}
+	}
+	return done, errors.Wrap(scan.Err(), "read merge manifest")
+}
+
+// appendMergeManifest records the merged batch in the manifest.
+func appendMergeManifest(mfn, key, batchfn string) error {
+	mergeManifestMu.Lock()
+	defer mergeManifestMu.Unlock()
+	fh, err := os.OpenFile(mfn, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.Wrap(err, "open merge manifest")
+	}
+	_, err = io.WriteString(fh, key+"\t"+batchfn+"\n")
+	if closeErr := fh.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "write merge manifest")
+}
+
+// removeMergeManifest removes the batches of keys from the manifest,
+// and the manifest itself if it became empty.
+func removeMergeManifest(mfn string, keys []string) error {
+	mergeManifestMu.Lock()
+	defer mergeManifestMu.Unlock()
+	b, err := ioutil.ReadFile(mfn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "read merge manifest")
+	}
+	drop := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		drop[k] = true
+	}
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if i := bytes.IndexByte(line, '\t'); i > 0 && !drop[string(line[:i])] {
+			buf.Write(line)
+		}
+	}
+	if buf.Len() == 0 {
+		return os.Remove(mfn)
+	}
+	return errors.Wrap(ioutil.WriteFile(mfn, buf.Bytes(), 0640), "write merge manifest")
+}
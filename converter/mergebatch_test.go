@@ -0,0 +1,103 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestMergeBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mergebatch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { Workdir = old }(Workdir)
+	Workdir = dir
+	logCtx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+
+	// newRequest returns the context of a new request, with its own workdir,
+	// and the inputs saved into it - as a retried request does
+	var want string
+	newRequest := func(name string) (context.Context, []string) {
+		reqdir := filepath.Join(dir, name)
+		if err := os.Mkdir(reqdir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		var filenames []string
+		want = ""
+		for i := 0; i < 7; i++ {
+			fn := filepath.Join(reqdir, strconv.Itoa(i)+".txt")
+			if err := ioutil.WriteFile(fn, []byte(strconv.Itoa(i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			filenames = append(filenames, fn)
+			want += strconv.Itoa(i)
+		}
+		return context.WithValue(logCtx, "workdir", reqdir), filenames
+	}
+
+	var calls int
+	failAt := -1
+	merge := func(ctx context.Context, destfn string, filenames ...string) error {
+		calls++
+		if calls == failAt {
+			return errors.New("merge failed")
+		}
+		var b []byte
+		for _, fn := range filenames {
+			p, err := ioutil.ReadFile(fn)
+			if err != nil {
+				return err
+			}
+			b = append(b, p...)
+		}
+		return ioutil.WriteFile(destfn, b, 0644)
+	}
+
+	// batches of 2: 01 23 45 6, then 0123 456, then the result
+	failAt = 3
+	ctx, filenames := newRequest("req1")
+	destfn := filepath.Join(GetWorkdir(ctx), "dest.txt")
+	if err = mergeBatches(ctx, destfn, filenames, 2, merge); err == nil {
+		t.Fatal("wanted error")
+	}
+	// the failed request's workdir is removed
+	if err = os.RemoveAll(GetWorkdir(ctx)); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, failAt = 0, -1
+	ctx, filenames = newRequest("req2")
+	destfn = filepath.Join(GetWorkdir(ctx), "dest.txt")
+	if err = mergeBatches(ctx, destfn, filenames, 2, merge); err != nil {
+		t.Fatal(err)
+	}
+	// 01 and 23 are already merged: 45, 6, then 0123, 456, and the result
+	if calls != 5 {
+		t.Errorf("got %d merges after retry, wanted 5", calls)
+	}
+	b, err := ioutil.ReadFile(destfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Errorf("got %q, wanted %q", b, want)
+	}
+	left, _ := filepath.Glob(filepath.Join(GetWorkdir(ctx), "merge-*"))
+	kept, _ := filepath.Glob(filepath.Join(dir, mergeBatchDirName, "*"))
+	if left = append(left, kept...); len(left) != 0 {
+		t.Errorf("left behind: %q", left)
+	}
+}
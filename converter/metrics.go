@@ -0,0 +1,51 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ConversionsTotal counts the conversions done by Convert,
+	// by content-type and outcome (ok, skip, error).
+	ConversionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "agostle",
+			Name:      "conversions_total",
+			Help:      "Number of conversions, by content-type and outcome.",
+		},
+		[]string{"content_type", "outcome"},
+	)
+
+	// ConversionsRunning is the number of the currently running
+	// conversions (tokens acquired from ConcLimit).
+	ConversionsRunning = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "agostle",
+			Name:      "conversions_running",
+			Help:      "Number of the currently running conversions.",
+		},
+		func() float64 { return float64(ConcLimit.InUse()) },
+	)
+)
+
+// Collectors returns the metrics of this package, to be registered by the user.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{CmdDuration, ConversionsTotal, ConversionsRunning}
+}
+
+// outcome returns the outcome label for the conversion error.
+func outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if errors.Cause(err) == ErrSkip {
+		return "skip"
+	}
+	return "error"
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// wordDecoder decodes the RFC 2047 encoded-words of any charset htmlindex knows.
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return transform.NewReader(input, enc.NewDecoder()), nil
+	},
+}
+
+// decodeFileName decodes the RFC 2047 encoded-words (=?utf-8?B?...?=) in the file name,
+// which some mailers put even into the quoted parameters.
+func decodeFileName(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	if d, err := wordDecoder.DecodeHeader(s); err == nil {
+		return d
+	}
+	return s
+}
+
+// mediaTypeParam returns the decoded parameter of the Content-Type or Content-Disposition
+// header value v, assembling the RFC 2231 continuations (name*0*=, name*1*=) and
+// decoding their charset - mime.ParseMediaType knows only utf-8 and us-ascii,
+// and leaves the continuations after an unknown charset undecoded.
+func mediaTypeParam(v, name string) string {
+	params := rawParams(v)
+	if s, ok := params[name+"*"]; ok {
+		return decode2231(s)
+	}
+	var charset string
+	var raw []byte
+	for i := 0; ; i++ {
+		k := name + "*" + strconv.Itoa(i)
+		if s, ok := params[k]; ok {
+			raw = append(raw, s...)
+			continue
+		}
+		s, ok := params[k+"*"]
+		if !ok {
+			if i == 0 {
+				break
+			}
+			return decodeCharset(raw, charset)
+		}
+		if i == 0 {
+			parts := strings.SplitN(s, "'", 3)
+			if len(parts) != 3 {
+				return ""
+			}
+			charset, s = parts[0], parts[2]
+		}
+		if d, err := url.PathUnescape(s); err == nil {
+			s = d
+		}
+		raw = append(raw, s...)
+	}
+	return decodeFileName(params[name])
+}
+
+// decode2231 decodes the RFC 2231 extended value: charset'language'percent-encoded.
+func decode2231(s string) string {
+	parts := strings.SplitN(s, "'", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	if d, err := url.PathUnescape(parts[2]); err == nil {
+		return decodeCharset([]byte(d), parts[0])
+	}
+	return ""
+}
+
+// decodeCharset converts b from the charset to UTF-8; b is returned as is for
+// UTF-8 and the unknown charsets.
+func decodeCharset(b []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(b)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(b)
+	}
+	if d, err := enc.NewDecoder().Bytes(b); err == nil {
+		return string(d)
+	}
+	return string(b)
+}
+
+// rawParams returns the parameters of the header value v, without any decoding,
+// keyed by their lowercased names.
+func rawParams(v string) map[string]string {
+	params := make(map[string]string)
+	i := strings.IndexByte(v, ';')
+	if i < 0 {
+		return params
+	}
+	v = v[i+1:]
+	for {
+		v = strings.TrimLeft(v, " \t\r\n;")
+		i = strings.IndexByte(v, '=')
+		if i <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(v[:i]))
+		v = strings.TrimLeft(v[i+1:], " \t")
+		var val string
+		if strings.HasPrefix(v, `"`) {
+			var buf []byte
+			j := 1
+			for ; j < len(v) && v[j] != '"'; j++ {
+				if v[j] == '\\' && j+1 < len(v) {
+					j++
+				}
+				buf = append(buf, v[j])
+			}
+			val, v = string(buf), v[min(j+1, len(v)):]
+		} else {
+			j := strings.IndexByte(v, ';')
+			if j < 0 {
+				j = len(v)
+			}
+			val, v = strings.TrimSpace(v[:j]), v[j:]
+		}
+		params[key] = val
+	}
+}
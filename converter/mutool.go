@@ -0,0 +1,203 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// mutoolBackends tells which PDF operations are done with mutool (MuPDF),
+// as the other tools are missing - see probeMutool.
+type mutoolBackends struct {
+	// Pdf is true if there's no pdftk, so mutool replaces it
+	// for splitting, merging and page counting (poppler is still preferred).
+	Pdf bool
+	// Render is true if there's neither poppler (pdftoppm, pdftocairo)
+	// nor GraphicsMagick, so the pages are rendered with mutool.
+	Render bool
+}
+
+var mutoolOk mutoolBackends // protected by popplerMu
+
+// getMutoolOk returns the mutool backends, as probed by LoadConfig.
+func getMutoolOk() mutoolBackends {
+	popplerMu.RLock()
+	defer popplerMu.RUnlock()
+	return mutoolOk
+}
+
+// probeMutool returns the operations mutool is needed for,
+// with the given usable poppler commands.
+func probeMutool(poppler map[string]string) mutoolBackends {
+	var mb mutoolBackends
+	if *ConfMutool == "" || lookPath(*ConfMutool) == "" {
+		return mb
+	}
+	mb.Pdf = *ConfPdftk == "" || lookPath(*ConfPdftk) == ""
+	mb.Render = (*ConfPdftoppm == "" || lookPath(*ConfPdftoppm) == "") &&
+		lookPath("pdftocairo") == "" &&
+		(*ConfGm == "" || lookPath(*ConfGm) == "")
+	return mb
+}
+
+// mutoolPageNum returns the number of pages of the PDF, and whether it is encrypted,
+// with "mutool info", killed when ctx is cancelled or ConfChildTimeout expires.
+func mutoolPageNum(ctx context.Context, srcfn string) (int, bool, error) {
+	var buf bytes.Buffer
+	cmd := exec.Command(*ConfMutool, "info", srcfn)
+	cmd.Stdout, cmd.Stderr = &buf, &buf
+	err := runWithContext(ctx, cmd)
+	out := buf.Bytes()
+	if err != nil {
+		return -1, false, errors.Wrapf(err, "mutool info %s: %s", srcfn, out)
+	}
+	encrypted := bytes.Contains(out, []byte("Encryption"))
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if bytes.HasPrefix(line, []byte("Pages:")) {
+			n, err := strconv.Atoi(string(bytes.TrimSpace(line[6:])))
+			return n, encrypted, err
+		}
+	}
+	return -1, encrypted, errors.Errorf("mutool info %s: no Pages", srcfn)
+}
+
+// mutoolPageFn returns the file name of the page of the split PDF,
+// named as pdfseparate does.
+func mutoolPageFn(destdir, prefix string, page int) string {
+	return filepath.Join(destdir, prefix+strconv.Itoa(page)+".pdf")
+}
+
+// mutoolExtractPage writes the page of srcfn into destfn, with "mutool merge".
+func mutoolExtractPage(ctx context.Context, destfn, srcfn string, page int) error {
+	return call(ctx, *ConfMutool, "merge", "-o", destfn, srcfn, strconv.Itoa(page))
+}
+
+// mutoolSplit splits the n pages of srcfn into destdir, one file per page.
+func mutoolSplit(ctx context.Context, srcfn, destdir, prefix string, n int) error {
+	for i := 1; i <= n; i++ {
+		if err := mutoolExtractPage(ctx, mutoolPageFn(destdir, prefix, i), srcfn, i); err != nil {
+			return errors.Wrapf(err, "split page %d of %s", i, srcfn)
+		}
+	}
+	return nil
+}
+
+// mutoolSplitChan splits the n pages of srcfn into destdir, sending each page
+// on the returned channel as soon as it is ready.
+func mutoolSplitChan(ctx context.Context, srcfn, destdir, prefix string, n int) <-chan SplitPage {
+	ch := make(chan SplitPage, 1)
+	keep := KeepTempFiles(ctx)
+	go func() {
+		defer close(ch)
+		abort := func(err error) {
+			if !keep {
+				_ = os.RemoveAll(destdir)
+			}
+			sendSplitPage(ctx, ch, SplitPage{Error: err})
+		}
+		for i := 1; i <= n; i++ {
+			if err := ctx.Err(); err != nil {
+				abort(err)
+				return
+			}
+			fn := mutoolPageFn(destdir, prefix, i)
+			if err := mutoolExtractPage(ctx, fn, srcfn, i); err != nil {
+				if ctx.Err() != nil {
+					abort(ctx.Err())
+					return
+				}
+				sendSplitPage(ctx, ch, SplitPage{Page: i, Error: errors.Wrapf(err, "split page %d of %s", i, srcfn)})
+				return
+			}
+			if ctx.Err() != nil || !sendSplitPage(ctx, ch, SplitPage{Page: i, Filename: fn}) {
+				abort(ctx.Err())
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// mutoolMerge merges the PDF files into destfn, with "mutool merge".
+func mutoolMerge(ctx context.Context, destfn string, filenames ...string) error {
+	args := append(append(make([]string, 0, len(filenames)+3), "merge", "-o", destfn), filenames...)
+	return call(ctx, *ConfMutool, args...)
+}
+
+// mutoolDrawArgs returns the arguments of "mutool draw" rendering the page
+// of srcfn as PNG into outfn, fitting into size ("WIDTHxHEIGHT" or "SIZE").
+func mutoolDrawArgs(outfn, srcfn, size string, page int) []string {
+	args := []string{"draw", "-q", "-F", "png", "-o", outfn}
+	if size != "" {
+		w, h := size, size
+		if i := strings.IndexByte(size, 'x'); i >= 0 {
+			w, h = size[:i], size[i+1:]
+		}
+		if w != "" {
+			args = append(args, "-w", w)
+		}
+		if h != "" {
+			args = append(args, "-h", h)
+		}
+	}
+	return append(args, srcfn, strconv.Itoa(page))
+}
+
+// mutoolDraw renders the page of srcfn into w, with "mutool draw".
+// mutool draws only PNG, so the other image types ("gif" or "jpeg")
+// are converted from that.
+func mutoolDraw(ctx context.Context, w io.Writer, srcfn, size string, page int, imgtyp string) error {
+	switch imgtyp {
+	case "png", "gif", "jpeg":
+	default:
+		return errors.Errorf("mutool renders only png, gif or jpeg, not %s", imgtyp)
+	}
+	outfn := srcfn + "-" + strconv.Itoa(page) + "-draw.png"
+	defer func() { _ = os.Remove(outfn) }()
+	if err := call(ctx, *ConfMutool, mutoolDrawArgs(outfn, srcfn, size, page)...); err != nil {
+		return errors.Wrapf(err, "render page %d of %s", page, srcfn)
+	}
+	if imgtyp == "png" {
+		_, err := copyFileTo(w, outfn)
+		return err
+	}
+	fh, err := os.Open(outfn)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fh.Close() }()
+	return convertPNG(w, bufio.NewReader(fh), imgtyp)
+}
+
+// convertPNG converts the PNG image read from r to imgtyp ("gif" or "jpeg").
+func convertPNG(w io.Writer, r io.Reader, imgtyp string) error {
+	img, err := png.Decode(r)
+	if err != nil {
+		return errors.Wrap(err, "decode png")
+	}
+	switch imgtyp {
+	case "gif":
+		err = gif.Encode(w, img, nil)
+	case "jpeg":
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	default:
+		return errors.Errorf("cannot convert png to %s", imgtyp)
+	}
+	return errors.Wrapf(err, "encode %s", imgtyp)
+}
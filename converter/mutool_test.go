@@ -0,0 +1,214 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestMutoolDrawArgs(t *testing.T) {
+	for i, tc := range []struct {
+		size string
+		want []string
+	}{
+		{"", []string{"draw", "-q", "-F", "png", "-o", "o.png", "a.pdf", "2"}},
+		{"256", []string{"draw", "-q", "-F", "png", "-o", "o.png", "-w", "256", "-h", "256", "a.pdf", "2"}},
+		{"640x480", []string{"draw", "-q", "-F", "png", "-o", "o.png", "-w", "640", "-h", "480", "a.pdf", "2"}},
+		{"x480", []string{"draw", "-q", "-F", "png", "-o", "o.png", "-h", "480", "a.pdf", "2"}},
+	} {
+		if got := mutoolDrawArgs("o.png", "a.pdf", tc.size, 2); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestProbeMutool(t *testing.T) {
+	oMutool, oPdftk, oPdftoppm, oGm := *ConfMutool, *ConfPdftk, *ConfPdftoppm, *ConfGm
+	defer func() { *ConfMutool, *ConfPdftk, *ConfPdftoppm, *ConfGm = oMutool, oPdftk, oPdftoppm, oGm }()
+
+	*ConfMutool, *ConfPdftk, *ConfPdftoppm, *ConfGm = "", "", "", ""
+	if mb := probeMutool(nil); mb.Pdf || mb.Render {
+		t.Errorf("no mutool, but got %+v", mb)
+	}
+	// any existing command will do
+	*ConfMutool = "sh"
+	if mb := probeMutool(nil); !mb.Pdf {
+		t.Errorf("mutool only, but got %+v", mb)
+	}
+	*ConfPdftk = "sh"
+	if mb := probeMutool(nil); mb.Pdf {
+		t.Errorf("pdftk exists, but got %+v", mb)
+	}
+}
+
+func TestMutoolSplitChanCancel(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-mutool-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the fake "mutool merge -o destfn srcfn page"
+	fake := filepath.Join(dir, "mutool")
+	if err = ioutil.WriteFile(fake, []byte("#!/bin/sh\nsleep 0.05\n: >\"$3\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { *ConfMutool = old }(*ConfMutool)
+	*ConfMutool = fake
+	destdir := filepath.Join(dir, "split")
+	if err = os.Mkdir(destdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(),
+		"logger", log.NewContext(log.NewNopLogger())))
+	defer cancel()
+	ch := mutoolSplitChan(ctx, "a.pdf", destdir, "a-", 100)
+	if p := <-ch; p.Error != nil || p.Page != 1 || p.Filename != mutoolPageFn(destdir, "a-", 1) {
+		t.Fatalf("got %#v, wanted page 1", p)
+	}
+	cancel()
+	waitRemoved(t, destdir)
+	for range ch {
+	}
+}
+
+func TestMutoolExtractText(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-mutool-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the fake "mutool draw -F txt -o dir/%d.txt srcfn" writes 11 pages
+	fake := filepath.Join(dir, "mutool")
+	script := "#!/bin/sh\ni=1\nwhile [ $i -le 11 ]; do\n" +
+		"\techo \"page $i\" >\"$(echo \"$5\" | sed \"s/%d/$i/\")\"\n\ti=$((i+1))\ndone\n"
+	if err = ioutil.WriteFile(fake, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(mutool, pdftotext, workdir string) {
+		*ConfMutool, *ConfPdftotext, Workdir = mutool, pdftotext, workdir
+	}(*ConfMutool, *ConfPdftotext, Workdir)
+	*ConfMutool, *ConfPdftotext, Workdir = fake, "", dir
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	text, err := PdfExtractText(ctx, "a.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want string
+	for i := 1; i <= 11; i++ {
+		want += "page " + strconv.Itoa(i) + "\n\f"
+	}
+	if text != want {
+		t.Errorf("got %q, wanted %q", text, want)
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 1 {
+		t.Errorf("temp files left: %d", len(fis))
+	}
+}
+
+func TestMutoolDrawGif(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-mutool-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pngfn := filepath.Join(dir, "page.png")
+	fh, err := os.Create(pngfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = png.Encode(fh, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	_ = fh.Close()
+	// the fake "mutool draw -q -F png -o outfn ..." copies the png
+	fake := filepath.Join(dir, "mutool")
+	if err = ioutil.WriteFile(fake, []byte("#!/bin/sh\ncp \""+pngfn+"\" \"$6\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { *ConfMutool = old }(*ConfMutool)
+	*ConfMutool = fake
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	var buf bytes.Buffer
+	if err = mutoolDraw(ctx, &buf, filepath.Join(dir, "a.pdf"), "", 1, "gif"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := gif.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 4 || cfg.Height != 3 {
+		t.Errorf("got %dx%d, wanted 4x3", cfg.Width, cfg.Height)
+	}
+	if err = mutoolDraw(ctx, &buf, filepath.Join(dir, "a.pdf"), "", 1, "tiff"); err == nil {
+		t.Error("tiff: wanted error")
+	}
+}
+
+func TestMutoolPageNumTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-mutool-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fake := filepath.Join(dir, "mutool")
+	if err = ioutil.WriteFile(fake, []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(mutool string, timeout time.Duration) {
+		*ConfMutool, *ConfChildTimeout = mutool, timeout
+	}(*ConfMutool, *ConfChildTimeout)
+	*ConfMutool, *ConfChildTimeout = fake, 100*time.Millisecond
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	start := time.Now()
+	if _, _, err = mutoolPageNum(ctx, "a.pdf"); err == nil {
+		t.Error("wanted timeout error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("mutool info is not killed, ran for %s", d)
+	}
+
+	// and when the request is cancelled
+	*ConfChildTimeout = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, _, err = mutoolPageNum(ctx, "a.pdf"); err == nil {
+		t.Error("wanted cancel error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("mutool info is not killed, ran for %s", d)
+	}
+}
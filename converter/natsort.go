@@ -0,0 +1,52 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import "strings"
+
+// NaturalLess compares the strings in natural order: runs of digits are
+// compared by their numeric value, so "page-2.pdf" < "page-10.pdf".
+func NaturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			da, db := digitPrefix(a), digitPrefix(b)
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			if len(da) != len(db) { // same value, less zero padding first
+				return len(da) < len(db)
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// NaturalOrder sorts strings with NaturalLess.
+type NaturalOrder []string
+
+func (s NaturalOrder) Len() int           { return len(s) }
+func (s NaturalOrder) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s NaturalOrder) Less(i, j int) bool { return NaturalLess(s[i], s[j]) }
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func digitPrefix(s string) string {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNaturalOrder(t *testing.T) {
+	for i, tc := range []struct {
+		in, want []string
+	}{
+		{[]string{"a.pdf-10.pdf", "a.pdf-2.pdf", "a.pdf-1.pdf"},
+			[]string{"a.pdf-1.pdf", "a.pdf-2.pdf", "a.pdf-10.pdf"}},
+		{[]string{"a.pdf-010.pdf", "a.pdf-002.pdf", "a.pdf-100.pdf"},
+			[]string{"a.pdf-002.pdf", "a.pdf-010.pdf", "a.pdf-100.pdf"}},
+		{[]string{"b", "a10b", "a2c", "a2b", "a"},
+			[]string{"a", "a2b", "a2c", "a10b", "b"}},
+		{[]string{"x01", "x1"}, []string{"x1", "x01"}},
+	} {
+		got := append([]string(nil), tc.in...)
+		sort.Sort(NaturalOrder(got))
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
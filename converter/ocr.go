@@ -0,0 +1,120 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// minTextLen is the number of non-space characters below which
+// a PDF is considered image-only by PdfNeedsOCR.
+const minTextLen = 16
+
+// PdfNeedsOCR reports whether srcfn has (almost) no text layer.
+func PdfNeedsOCR(ctx context.Context, srcfn string) (bool, error) {
+	text, err := PdfExtractText(ctx, srcfn)
+	if err != nil {
+		return false, err
+	}
+	return !hasText(text), nil
+}
+
+// hasText reports whether text has at least minTextLen non-space characters.
+func hasText(text string) bool {
+	var n int
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			if n++; n >= minTextLen {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PdfOCR writes srcfn to destfn with an invisible text layer recognized by
+// tesseract on the pages which have no text yet.
+// lang is the tesseract language, ConfOCRLang if empty.
+func PdfOCR(ctx context.Context, destfn, srcfn, lang string) error {
+	if *ConfTesseract == "" {
+		return errors.New("no tesseract configured")
+	}
+	if lang == "" {
+		lang = *ConfOCRLang
+	}
+	pages, err := PdfSplit(ctx, srcfn)
+	if err != nil {
+		return err
+	}
+	split := len(pages) > 1 || pages[0] != srcfn
+	if split && !KeepTempFiles(ctx) {
+		defer func() {
+			for _, fn := range pages {
+				_ = os.Remove(fn)
+			}
+		}()
+	}
+
+	results := make([]string, len(pages))
+	errs := make([]error, len(pages))
+	var wg sync.WaitGroup
+	for i, page := range pages {
+		wg.Add(1)
+		go func(i int, page string) {
+			defer wg.Done()
+			defer ConcLimit.Release(ConcLimit.Acquire())
+			if ctx.Err() != nil {
+				errs[i] = ctx.Err()
+				return
+			}
+			results[i], errs[i] = ocrPage(ctx, page, lang)
+		}(i, page)
+	}
+	wg.Wait()
+	defer func() {
+		for i, fn := range results {
+			if fn != "" && fn != pages[i] && !KeepTempFiles(ctx) {
+				_ = os.Remove(fn)
+			}
+		}
+	}()
+	for i, err := range errs {
+		if err != nil {
+			return errors.Wrapf(err, "page %d", i+1)
+		}
+	}
+	return PdfMerge(ctx, destfn, results...)
+}
+
+// ocrPage returns the page file with a text layer: the original
+// if it already has text, a new one recognized by tesseract otherwise.
+func ocrPage(ctx context.Context, page, lang string) (string, error) {
+	if text, err := PdfExtractText(ctx, page); err != nil {
+		return "", err
+	} else if strings.TrimSpace(text) != "" {
+		return page, nil
+	}
+	base := strings.TrimSuffix(page, ".pdf") + "-ocr"
+	imgfn := base + ".png"
+	if !KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(imgfn) }()
+	}
+	if err := call(ctx, *ConfGs, "-q", "-dNOPAUSE", "-dBATCH", "-P-", "-dSAFER",
+		"-sDEVICE=png16m", "-r300", "-sOutputFile="+imgfn, page,
+	); err != nil {
+		return "", errors.Wrapf(err, "render %s", page)
+	}
+	// tesseract appends the .pdf
+	if err := call(ctx, *ConfTesseract, imgfn, base, "-l", lang, "pdf"); err != nil {
+		return "", errors.Wrapf(err, "tesseract %s", imgfn)
+	}
+	return base + ".pdf", nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	oleMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+	zipMagic = []byte("PK\x03\x04")
+)
+
+// oleStreams maps the characteristic OLE stream names to the content-type.
+// The Outlook message streams are prefixes.
+var oleStreams = []struct {
+	Name, ContentType string
+}{
+	{"__substg1.0_", "application/x-ole-storage"},
+	{"__properties_version1.0", "application/x-ole-storage"},
+	{"__nameid_version1.0", "application/x-ole-storage"},
+	{"WordDocument", "application/msword"},
+	{"Workbook", "application/vnd.ms-excel"},
+	{"Book", "application/vnd.ms-excel"},
+	{"PowerPoint Document", "application/vnd.ms-powerpoint"},
+}
+
+// ooxmlDirs maps the OOXML part directories to the content-type.
+var ooxmlDirs = []struct {
+	Dir, ContentType string
+}{
+	{"word/", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xl/", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"ppt/", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+}
+
+// sniffOffice tells apart the genuine Outlook messages (application/x-ole-storage)
+// and the other (OLE2 or OOXML) Office documents, by their content.
+// It is needed as .msg files are often misnamed Office documents,
+// and all OLE2 files are application/x-ole-storage for mimemagic.
+// It returns the empty string if the content-type cannot be determined from head,
+// or it does not need fixing.
+func sniffOffice(head []byte, contentType, fileName string) string {
+	switch contentType {
+	case "", "application/octet-stream", "application/x-ole-storage", "application/vnd.ms-outlook":
+	default:
+		if strings.ToLower(filepath.Ext(fileName)) != ".msg" {
+			return ""
+		}
+	}
+	if bytes.HasPrefix(head, zipMagic) {
+		for _, d := range ooxmlDirs {
+			if bytes.Contains(head, []byte(d.Dir)) {
+				return d.ContentType
+			}
+		}
+		return ""
+	}
+	if !bytes.HasPrefix(head, oleMagic) {
+		return ""
+	}
+	names := oleDirNames(head)
+	for _, s := range oleStreams {
+		for _, nm := range names {
+			if nm == s.Name || strings.HasSuffix(s.Name, "_") && strings.HasPrefix(nm, s.Name) {
+				return s.ContentType
+			}
+		}
+	}
+	// the directory may be beyond head; look for the names anywhere
+	for _, s := range oleStreams {
+		if bytes.Contains(head, utf16LE(s.Name)) {
+			return s.ContentType
+		}
+	}
+	return ""
+}
+
+// oleDirNames returns the names of the entries of the first directory sector
+// of the OLE2 compound file, if it is in head.
+func oleDirNames(head []byte) []string {
+	if len(head) < 512 {
+		return nil
+	}
+	shift := binary.LittleEndian.Uint16(head[0x1E:])
+	if shift != 9 && shift != 12 {
+		return nil
+	}
+	size := 1 << shift
+	first := binary.LittleEndian.Uint32(head[0x30:])
+	if first >= 0xFFFFFFFA { // special sector ids
+		return nil
+	}
+	off := (int(first) + 1) * size
+	if off < 0 || off >= len(head) {
+		return nil
+	}
+	dir := head[off:]
+	if len(dir) > size {
+		dir = dir[:size]
+	}
+	var names []string
+	for ; len(dir) >= 128; dir = dir[128:] {
+		n := int(binary.LittleEndian.Uint16(dir[0x40:]))
+		if n < 2 || n > 64 || n%2 != 0 {
+			continue
+		}
+		u := make([]uint16, n/2-1) // without the terminating zero
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(dir[2*i:])
+		}
+		names = append(names, string(utf16.Decode(u)))
+	}
+	return names
+}
+
+// utf16LE returns s encoded as UTF-16LE.
+func utf16LE(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
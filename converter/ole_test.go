@@ -0,0 +1,102 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/textproto"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+// fakeOLE returns the head of an OLE2 compound file with the given directory entries.
+func fakeOLE(names ...string) []byte {
+	b := make([]byte, 2048)
+	copy(b, oleMagic)
+	binary.LittleEndian.PutUint16(b[0x1E:], 9) // 512 byte sectors
+	binary.LittleEndian.PutUint32(b[0x30:], 0) // directory in the first sector
+	dir := b[512:]
+	for i, nm := range append([]string{"Root Entry"}, names...) {
+		e := dir[i*128:]
+		u := utf16LE(nm)
+		copy(e, u)
+		binary.LittleEndian.PutUint16(e[0x40:], uint16(len(u)+2))
+	}
+	return b
+}
+
+func TestSniffOffice(t *testing.T) {
+	docx := append([]byte("PK\x03\x04\x14\x00\x06\x00\x08\x00"), "[Content_Types].xml....word/document.xml"...)
+	for i, tc := range []struct {
+		head   []byte
+		ct, fn string
+		want   string
+	}{
+		{fakeOLE("__substg1.0_0037001F", "__properties_version1.0"), "application/x-ole-storage", "a.msg", "application/x-ole-storage"},
+		{fakeOLE("WordDocument", "1Table"), "application/x-ole-storage", "a.msg", "application/msword"},
+		{fakeOLE("Workbook"), "application/octet-stream", "a.msg", "application/vnd.ms-excel"},
+		{fakeOLE("PowerPoint Document"), "", "", "application/vnd.ms-powerpoint"},
+		{docx, "application/x-ole-storage", "a.msg", ooxmlDirs[0].ContentType},
+		{docx, "application/zip", "a.msg", ooxmlDirs[0].ContentType},
+		{docx, "application/zip", "a.zip", ""},
+		{fakeOLE("WordDocument"), "application/msword", "a.doc", ""},
+		{[]byte("not an office document"), "application/x-ole-storage", "a.msg", ""},
+	} {
+		if got := sniffOffice(tc.head, tc.ct, tc.fn); got != tc.want {
+			t.Errorf("%d. (%q, %q): got %q, wanted %q", i, tc.ct, tc.fn, got, tc.want)
+		}
+	}
+
+	// the directory beyond the head
+	head := fakeOLE("WordDocument")
+	binary.LittleEndian.PutUint32(head[0x30:], 100)
+	if got := sniffOffice(head, "", "a.msg"); got != "application/msword" {
+		t.Errorf("directory beyond head: got %q", got)
+	}
+}
+
+func TestExtractingFilterOfficeMsg(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	doc := fakeOLE("WordDocument", "1Table")
+	inch := make(chan i18nmail.MailPart, 1)
+	outch := make(chan i18nmail.MailPart, 1)
+	errch := make(chan error, 1)
+	inch <- i18nmail.MailPart{
+		ContentType: "application/x-ole-storage",
+		Header:      textproto.MIMEHeader{"X-FileName": []string{"a.msg"}},
+		Body:        bytes.NewReader(doc),
+	}
+	close(inch)
+	go ExtractingFilter(ctx, inch, outch, nil, errch)
+
+	var n int
+	for part := range outch {
+		n++
+		if part.ContentType != "application/msword" {
+			t.Errorf("got %q, wanted application/msword", part.ContentType)
+		}
+		b, err := ioutil.ReadAll(part.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, doc) {
+			t.Errorf("body changed: got %d bytes, wanted %d", len(b), len(doc))
+		}
+	}
+	if n != 1 {
+		t.Errorf("got %d parts, wanted 1", n)
+	}
+	select {
+	case err := <-errch:
+		t.Errorf("error: %v", err)
+	default:
+	}
+}
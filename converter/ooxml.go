@@ -0,0 +1,118 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ooxmlContentTypesName is the name of the OOXML package's content-type list.
+const ooxmlContentTypesName = "[Content_Types].xml"
+
+// ooxmlMainTypes maps the content-types of the main parts, as listed in
+// [Content_Types].xml, to the content-type of the document.
+var ooxmlMainTypes = []struct {
+	Main, ContentType string
+}{
+	{"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.template"},
+	{"application/vnd.ms-word.document.macroEnabled.main+xml",
+		"application/vnd.ms-word.document.macroEnabled.12"},
+	{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.template"},
+	{"application/vnd.ms-excel.sheet.macroEnabled.main+xml",
+		"application/vnd.ms-excel.sheet.macroEnabled.12"},
+	{"application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{"application/vnd.openxmlformats-officedocument.presentationml.slideshow.main+xml",
+		"application/vnd.openxmlformats-officedocument.presentationml.slideshow"},
+	{"application/vnd.openxmlformats-officedocument.presentationml.template.main+xml",
+		"application/vnd.openxmlformats-officedocument.presentationml.template"},
+	{"application/vnd.ms-powerpoint.presentation.macroEnabled.main+xml",
+		"application/vnd.ms-powerpoint.presentation.macroEnabled.12"},
+}
+
+// needsOOXMLSniff reports whether the content-type may be a mislabeled OOXML document:
+// unknown, a plain zip, or an OOXML type (which may be the wrong one).
+func needsOOXMLSniff(contentType string) bool {
+	switch contentType {
+	case "", "application/octet-stream", "application/zip", "application/x-zip-compressed", "application/x-zip":
+		return true
+	}
+	return strings.HasPrefix(contentType, "application/vnd.openxmlformats-officedocument.")
+}
+
+// sniffOOXML returns the content-type of the OOXML document by the main part listed
+// in its [Content_Types].xml, if head is the beginning of an OOXML zip,
+// and that entry is in head (it is usually the first one).
+// It returns the empty string otherwise.
+func sniffOOXML(head []byte) string {
+	le := binary.LittleEndian
+	for off := 0; off+30 <= len(head) && bytes.HasPrefix(head[off:], zipMagic); {
+		h := head[off:]
+		flags, method := le.Uint16(h[6:]), le.Uint16(h[8:])
+		size, nameLen := int(le.Uint32(h[18:])), int(le.Uint16(h[26:]))
+		start := 30 + nameLen + int(le.Uint16(h[28:]))
+		if len(h) < start {
+			return ""
+		}
+		name, data := string(h[30:30+nameLen]), h[start:]
+		if size > 0 && size < len(data) {
+			data = data[:size]
+		}
+		if name == ooxmlContentTypesName {
+			var r io.Reader
+			switch method {
+			case 0: // stored
+				r = bytes.NewReader(data)
+			case 8: // deflated
+				r = flate.NewReader(bytes.NewReader(data))
+			default:
+				return ""
+			}
+			// the entry may be cut at the end of head: use what is there
+			b, _ := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+			return ooxmlContentType(b)
+		}
+		if flags&8 == 0 { // the sizes are in the header
+			off += start + size
+			continue
+		}
+		// the sizes are in the data descriptor after the data:
+		// only the end of the deflated data can be found
+		if method != 8 {
+			return ""
+		}
+		br := bytes.NewReader(data)
+		if _, err := io.Copy(ioutil.Discard, flate.NewReader(br)); err != nil {
+			return ""
+		}
+		off += start + len(data) - br.Len()
+		if off < len(head) && bytes.HasPrefix(head[off:], []byte("PK\x07\x08")) {
+			off += 4
+		}
+		off += 12 // crc32, compressed and uncompressed size
+	}
+	return ""
+}
+
+// ooxmlContentType returns the document content-type of the OOXML [Content_Types].xml.
+func ooxmlContentType(contentTypes []byte) string {
+	for _, m := range ooxmlMainTypes {
+		if bytes.Contains(contentTypes, []byte(`"`+m.Main+`"`)) {
+			return m.ContentType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fakeZip returns a zip with the given entries (name, content pairs), in order.
+func fakeZip(t *testing.T, method uint16, entries ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entries[i], Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fakeContentTypes returns a [Content_Types].xml with the main part's content-type.
+func fakeContentTypes(main string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+		`<Override PartName="/main.xml" ContentType="` + main + `"/></Types>`
+}
+
+func TestSniffOOXML(t *testing.T) {
+	const (
+		docx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		xlsx = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		pptx = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	)
+	word := fakeZip(t, zip.Deflate,
+		ooxmlContentTypesName, fakeContentTypes(docx+".main+xml"),
+		"word/document.xml", "<w:document/>")
+	sheet := fakeZip(t, zip.Store,
+		ooxmlContentTypesName, fakeContentTypes(xlsx+".main+xml"),
+		"xl/workbook.xml", "<workbook/>")
+	// [Content_Types].xml after an other (deflated) entry
+	slides := fakeZip(t, zip.Deflate,
+		"_rels/.rels", strings.Repeat("<Relationship/>", 100),
+		ooxmlContentTypesName, fakeContentTypes(pptx+".main+xml"))
+	template := fakeZip(t, zip.Deflate,
+		ooxmlContentTypesName, fakeContentTypes("application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml"))
+	plain := fakeZip(t, zip.Deflate, "a.txt", "alma", "word/b.txt", "korte")
+
+	for i, tc := range []struct {
+		body   []byte
+		ct, fn string
+		want   string
+	}{
+		{word, "application/zip", "", docx},
+		{word, "application/zip", "scan", docx},
+		{word, "application/zip", "scan.zip", docx},
+		{word, "application/x-zip-compressed", "a.bin", docx},
+		{word, "application/octet-stream", "", docx},
+		{word, "", "", docx},
+		{word, xlsx, "a.xlsx", docx},
+		{sheet, "application/zip", "", xlsx},
+		{slides, "application/zip", "x", pptx},
+		{template, "application/zip", "", "application/vnd.openxmlformats-officedocument.wordprocessingml.template"},
+		{plain, "application/zip", "a.zip", "application/zip"},
+		{word[:40], "application/zip", "", "application/zip"},
+	} {
+		if got := FixContentType(tc.body, tc.ct, tc.fn); got != tc.want {
+			t.Errorf("%d. (%q, %q): got %q, wanted %q", i, tc.ct, tc.fn, got, tc.want)
+		}
+	}
+}
@@ -14,8 +14,27 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
+// OutlookToPdfZip converts the Outlook .msg (application/x-ole-storage) to the
+// email with NewOLEStorageReader, and that to a zip of PDFs with MailToPdfZip.
+// The other OLE2 Office documents are converted with OfficeToPdf.
+func OutlookToPdfZip(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize)
+	if ct := sniffOffice(head, contentType, ""); ct != "" && ct != "application/x-ole-storage" {
+		getLogger(ctx).Log("msg", "not an Outlook message", "ct", ct)
+		return OfficeToPdf(ctx, destfn, br, ct)
+	}
+	rc, err := NewOLEStorageReader(ctx, br)
+	if err != nil {
+		return errors.Wrap(err, "convert .msg to email")
+	}
+	defer func() { _ = rc.Close() }()
+	return MailToPdfZip(ctx, destfn, rc, "message/rfc822")
+}
+
 // NewOLEStorageReader converts Outlook .msg files to .eml RFC822 email files.
 // For this it uses perl Email::Outlook::Message (thanks, @matijs), and returns
 // an io.Reader with the converted data.
@@ -24,10 +43,10 @@ import (
 // with `cpan -i Email::Outlook::Message`).
 //
 // See http://www.matijs.net/software/msgconv
-func NewOLEStorageReader(r io.Reader) (io.ReadCloser, error) {
+func NewOLEStorageReader(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
 	var buf bytes.Buffer
 	tr := io.TeeReader(r, &buf)
-	rc, err := newOLEStorageReaderDirect(tr)
+	rc, err := newOLEStorageReaderDirect(ctx, tr)
 	if err == nil {
 		br := bufio.NewReader(rc)
 		if _, err = br.Peek(1); err == nil {
@@ -48,7 +67,7 @@ func NewOLEStorageReader(r io.Reader) (io.ReadCloser, error) {
 	return newOLEStorageReaderDocker(io.MultiReader(bytes.NewReader(buf.Bytes()), r))
 }
 
-func newOLEStorageReaderDirect(r io.Reader) (io.ReadCloser, error) {
+func newOLEStorageReaderDirect(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
 	var err error
 	// Email::Outlook::Message needs a filename!
 	var remove bool
@@ -56,7 +75,7 @@ func newOLEStorageReaderDirect(r io.Reader) (io.ReadCloser, error) {
 	if ok {
 		defer in.Close()
 	} else {
-		in, err = ioutil.TempFile("", ".msg")
+		in, err = ioutil.TempFile(GetWorkdir(ctx), ".msg")
 		if err != nil {
 			return nil, err
 		}
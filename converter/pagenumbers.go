@@ -0,0 +1,146 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PageNumberOpts are the options of PdfNumberPages.
+// The zero value means the defaults.
+type PageNumberOpts struct {
+	// Format is the fmt format of the text, with the page number
+	// and the number of pages (if there are two verbs) as arguments.
+	// Defaults to "Page %d of %d".
+	Format string
+	// Position is top-left, top-center, top-right,
+	// bottom-left, bottom-center (the default) or bottom-right.
+	Position string
+	FontSize float64 // points, defaults to 10
+	Margin   float64 // distance from the page edge, in points, defaults to 20
+}
+
+func (o PageNumberOpts) withDefaults() PageNumberOpts {
+	if o.Format == "" {
+		o.Format = "Page %d of %d"
+	}
+	if o.Position == "" {
+		o.Position = "bottom-center"
+	}
+	if o.FontSize <= 0 {
+		o.FontSize = 10
+	}
+	if o.Margin <= 0 {
+		o.Margin = 20
+	}
+	return o
+}
+
+// Validate checks the options.
+func (o PageNumberOpts) Validate() error {
+	o = o.withDefaults()
+	if strings.Contains(pageNumberText(o.Format, 1, 1), "%!") {
+		return errors.Errorf("bad format %q (one or two %%d are allowed)", o.Format)
+	}
+	switch o.Position {
+	case "top-left", "top-center", "top-right",
+		"bottom-left", "bottom-center", "bottom-right":
+	default:
+		return errors.Errorf("bad position %q", o.Position)
+	}
+	return nil
+}
+
+// PdfNumberPages stamps page numbers ("Page X of Y") on every page of srcfn.
+// The stamp PDF is generated with Ghostscript, each page with the size of
+// the same page of srcfn, so mixed portrait and landscape pages are numbered right.
+func PdfNumberPages(ctx context.Context, destfn, srcfn string, opts PageNumberOpts) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	n, err := PdfPageNum(ctx, srcfn)
+	if err != nil {
+		return err
+	}
+	ps := pageNumbersPS(pdfPageSizes(ctx, srcfn, n), opts)
+
+	fh, err := ioutil.TempFile(GetWorkdir(ctx), "pagenumbers-")
+	if err != nil {
+		return errors.Wrap(err, "create page numbers file")
+	}
+	psfn := fh.Name()
+	stampfn := psfn + ".pdf"
+	if !LeaveTempFiles {
+		defer func() {
+			_ = os.Remove(psfn)
+			_ = os.Remove(stampfn)
+		}()
+	}
+	_, err = fh.Write(ps)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write %s", psfn)
+	}
+	if err = call(ctx, *ConfGs, "-q", "-dNOPAUSE", "-dBATCH", "-P-", "-dSAFER",
+		"-sDEVICE=pdfwrite", "-sOutputFile="+stampfn, psfn,
+	); err != nil {
+		return errors.Wrap(err, "generate page numbers")
+	}
+	// the stamp has as many pages as srcfn, multistamp puts them page by page
+	return PdfStamp(ctx, destfn, srcfn, stampfn)
+}
+
+// pageNumbersPS returns the PostScript program of len(sizes) pages,
+// each of its size and with its page number.
+func pageNumbersPS(sizes []pageSize, opts PageNumberOpts) []byte {
+	opts = opts.withDefaults()
+	n := len(sizes)
+	var buf bytes.Buffer
+	buf.WriteString("%!PS\n")
+	buf.WriteString(psLatin1Font("Helvetica"))
+	for i, size := range sizes {
+		width, height := size.Width, size.Height
+		y := opts.Margin
+		if strings.HasPrefix(opts.Position, "top-") {
+			y = height - opts.Margin - opts.FontSize
+		}
+		// x is computed from the string width (sw) on the stack
+		var x string
+		switch {
+		case strings.HasSuffix(opts.Position, "-left"):
+			x = fmt.Sprintf("pop %.2f", opts.Margin)
+		case strings.HasSuffix(opts.Position, "-right"):
+			x = fmt.Sprintf("%.2f exch sub", width-opts.Margin)
+		default:
+			x = fmt.Sprintf("2 div %.2f exch sub", width/2)
+		}
+		fmt.Fprintf(&buf, `<< /PageSize [%.2f %.2f] >> setpagedevice
+/Latin1Font findfont %.2f scalefont setfont 0 setgray
+(%s) dup stringwidth pop %s %.2f moveto show
+showpage
+`,
+			width, height,
+			opts.FontSize, psString(pageNumberText(opts.Format, i+1, n)), x, y)
+	}
+	return buf.Bytes()
+}
+
+// pageNumberText formats the page number i (and the number of pages n,
+// if format has two verbs).
+func pageNumberText(format string, i, n int) string {
+	if strings.Count(strings.Replace(format, "%%", "", -1), "%") == 1 {
+		return fmt.Sprintf(format, i)
+	}
+	return fmt.Sprintf(format, i, n)
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPageNumberOpts(t *testing.T) {
+	for i, tc := range []struct {
+		opts PageNumberOpts
+		err  bool
+	}{
+		{PageNumberOpts{}, false},
+		{PageNumberOpts{Format: "%d / %d", Position: "top-right"}, false},
+		{PageNumberOpts{Format: "- %d -"}, false},
+		{PageNumberOpts{Format: "%d/%d/%d"}, true},
+		{PageNumberOpts{Format: "%s of %d"}, true},
+		{PageNumberOpts{Position: "middle"}, true},
+	} {
+		if err := tc.opts.Validate(); (err != nil) != tc.err {
+			t.Errorf("%d. got %v, wanted error? %t", i, err, tc.err)
+		}
+	}
+}
+
+func TestPageNumbersPS(t *testing.T) {
+	ps := pageNumbersPS([]pageSize{{595, 842}, {842, 595}, {595, 842}},
+		PageNumberOpts{Position: "bottom-right"})
+	if n := bytes.Count(ps, []byte("showpage")); n != 3 {
+		t.Errorf("got %d pages, wanted 3", n)
+	}
+	if !bytes.Contains(ps, []byte("(Page 2 of 3)")) {
+		t.Errorf("no page 2 in\n%s", ps)
+	}
+	// the landscape page is numbered at its own right edge
+	pages := bytes.Split(ps, []byte("showpage"))
+	if !bytes.Contains(pages[1], []byte("[842.00 595.00]")) || !bytes.Contains(pages[1], []byte("822.00 exch sub")) {
+		t.Errorf("page 2 is not landscape:\n%s", pages[1])
+	}
+	if !bytes.Contains(pages[2], []byte("[595.00 842.00]")) || !bytes.Contains(pages[2], []byte("575.00 exch sub")) {
+		t.Errorf("page 3 is not portrait:\n%s", pages[2])
+	}
+}
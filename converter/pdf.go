@@ -11,16 +11,19 @@ import (
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf16"
 
 	"github.com/pkg/errors"
@@ -29,40 +32,63 @@ import (
 	"github.com/tgulacsi/go/temp"
 )
 
-var popplerOk = map[string]string{"pdfinfo": "", "pdfseparate": "", "pdfunite": ""}
-
-const (
-	pcNotChecked = 0
-	pcNothing    = -1
-	pcPdfClean   = 1
-	pcMutool     = 2
+var (
+	popplerMu = sync.RWMutex{} // protects popplerOk
+	popplerOk = map[string]string{"pdfinfo": "", "pdfseparate": "", "pdfunite": "", "pdfsig": "", "pdffonts": ""}
 )
 
-// PdfPageNum returns the number of pages
-func PdfPageNum(srcfn string) (numberofpages int, err error) {
-	if numberofpages, _, err = pdfPageNum(srcfn); err == nil {
+// getPopplerOk returns the current map of usable poppler commands.
+// The returned map must not be modified, as LoadConfig replaces it as a whole.
+func getPopplerOk() map[string]string {
+	popplerMu.RLock()
+	defer popplerMu.RUnlock()
+	return popplerOk
+}
+
+// ErrTooManyPages is returned when a document has more pages than ConfMaxPages.
+var ErrTooManyPages = errors.New("too many pages")
+
+// checkMaxPages returns ErrTooManyPages if n exceeds ConfMaxPages.
+func checkMaxPages(srcfn string, n int) error {
+	if limit := *ConfMaxPages; limit > 0 && n > limit {
+		return errors.Wrapf(ErrTooManyPages, "%s has %d pages (max %d)", srcfn, n, limit)
+	}
+	return nil
+}
+
+// PdfPageNum returns the number of pages.
+// The commands are killed when ctx is cancelled.
+func PdfPageNum(ctx context.Context, srcfn string) (numberofpages int, err error) {
+	if numberofpages, _, err = pdfPageNum(ctx, srcfn); err == nil {
 		return
 	}
-	if err := PdfClean(srcfn); err != nil {
-		Log("msg", "ERROR PdfClean", "file", srcfn, "error", err)
+	if ctx.Err() != nil {
+		return numberofpages, ctx.Err()
 	}
-	numberofpages, _, err = pdfPageNum(srcfn)
+	if err := PdfClean(ctx, srcfn); err != nil {
+		getLogger(ctx).Log("msg", "ERROR PdfClean", "file", srcfn, "error", err)
+	}
+	numberofpages, _, err = pdfPageNum(ctx, srcfn)
 	return
 }
 
-func pdfPageNum(srcfn string) (numberofpages int, encrypted bool, err error) {
+func pdfPageNum(ctx context.Context, srcfn string) (numberofpages int, encrypted bool, err error) {
 	numberofpages = -1
 
 	pdfinfo := false
 	var cmd *exec.Cmd
-	if popplerOk["pdfinfo"] != "" {
-		cmd = exec.Command(popplerOk["pdfinfo"], srcfn)
+	if prg := getPopplerOk()["pdfinfo"]; prg != "" {
+		cmd = exec.Command(prg, srcfn)
 		pdfinfo = true
+	} else if getMutoolOk().Pdf {
+		return mutoolPageNum(ctx, srcfn)
 	} else {
 		cmd = exec.Command(*ConfPdftk, srcfn, "dump_data_utf8")
 	}
-	out, e := cmd.CombinedOutput()
-	err = e
+	var buf bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &buf, &buf
+	err = runWithContext(ctx, cmd)
+	out := buf.Bytes()
 	if 0 == len(out) {
 		return
 	}
@@ -90,8 +116,8 @@ func pdfPageNum(srcfn string) (numberofpages int, encrypted bool, err error) {
 }
 
 // PdfSplit splits pdf to pages, returns those filenames
-func PdfSplit(srcfn string) (filenames []string, err error) {
-	if n, e := PdfPageNum(srcfn); err != nil {
+func PdfSplit(ctx context.Context, srcfn string) (filenames []string, err error) {
+	if n, e := PdfPageNum(ctx, srcfn); e != nil {
 		err = errors.Wrapf(e, "cannot determine page number of %s", srcfn)
 		return
 	} else if n == 0 {
@@ -100,34 +126,40 @@ func PdfSplit(srcfn string) (filenames []string, err error) {
 	} else if n == 1 {
 		filenames = append(filenames, srcfn)
 		return
+	} else if err = checkMaxPages(srcfn, n); err != nil {
+		return
 	}
 
-	if !filepath.IsAbs(srcfn) {
-		if srcfn, err = filepath.Abs(srcfn); err != nil {
-			return
-		}
-	}
-	destdir := filepath.Join(Workdir,
-		filepath.Base(srcfn)+"-"+strconv.Itoa(rand.Int())+"-split")
-	if !fileExists(destdir) {
-		if err = os.Mkdir(destdir, 0755); err != nil {
-			return
-		}
+	var destdir, prefix string
+	if srcfn, destdir, prefix, err = splitDestDir(ctx, srcfn); err != nil {
+		return
 	}
-	prefix := strings.Replace(filepath.Base(srcfn), "%", "!P!", -1) + "-"
 
-	if popplerOk["pdfseparate"] != "" {
-		if err = callAt(popplerOk["pdfseparate"],
-			destdir,
-			srcfn,
-			filepath.Join(destdir, prefix+"%d.pdf"),
-		); err != nil {
-			err = errors.Wrapf(err, "executing %s", popplerOk["pdfseparate"])
+	if err = splitPages(ctx, srcfn, destdir, prefix); err != nil {
+		switch {
+		case isEncryptionError(err):
+			// like PdfPageNum, try to clean the file once, and retry
+			Log("msg", "split failed on an encrypted file, cleaning it", "file", srcfn, "error", err)
+			if e := PdfClean(ctx, srcfn); e != nil {
+				Log("msg", "ERROR PdfClean", "file", srcfn, "error", e)
+				return
+			}
+		case isParseError(err):
+			// the file may be malformed: repair it once, and retry
+			Log("msg", "split failed, repairing the file", "file", srcfn, "error", err)
+			repfn := nakeFilename(srcfn) + "-repaired.pdf"
+			if e := PdfRepair(ctx, repfn, srcfn); e != nil {
+				Log("msg", "ERROR PdfRepair", "file", srcfn, "error", e)
+				return
+			}
+			if !LeaveTempFiles {
+				defer func() { _ = unlink(repfn, "repaired") }()
+			}
+			srcfn = repfn
+		default:
 			return
 		}
-	} else {
-		if err = callAt(*ConfPdftk, destdir, srcfn, "burst", "output", prefix+"%03d.pdf"); err != nil {
-			err = errors.Wrapf(err, "executing %s", *ConfPdftk)
+		if err = splitPages(ctx, srcfn, destdir, prefix); err != nil {
 			return
 		}
 	}
@@ -159,22 +191,283 @@ func PdfSplit(srcfn string) (filenames []string, err error) {
 		}
 	}
 	//log.Printf("splitted filenames: %s", filenames)
-	sort.Strings(filenames)
+	sort.Sort(NaturalOrder(filenames))
 	for i, fn = range filenames {
 		filenames[i] = filepath.Join(destdir, fn)
 	}
 	return filenames, nil
 }
 
-// PdfMerge merges pdf files into destfn
+// splitPages splits srcfn into destdir, with pdfseparate, pdftk or mutool.
+func splitPages(ctx context.Context, srcfn, destdir, prefix string) error {
+	if pdfseparate := getPopplerOk()["pdfseparate"]; pdfseparate != "" {
+		if err := callAt(ctx, pdfseparate,
+			destdir,
+			srcfn,
+			filepath.Join(destdir, prefix+"%d.pdf"),
+		); err != nil {
+			return errors.Wrapf(err, "executing %s", pdfseparate)
+		}
+		return nil
+	}
+	if getMutoolOk().Pdf {
+		n, _, err := pdfPageNum(ctx, srcfn)
+		if err != nil {
+			return err
+		}
+		return mutoolSplit(ctx, srcfn, destdir, prefix, n)
+	}
+	if err := callAt(ctx, *ConfPdftk, destdir, srcfn, "burst", "output", prefix+"%03d.pdf"); err != nil {
+		return errors.Wrapf(err, "executing %s", *ConfPdftk)
+	}
+	return nil
+}
+
+// isEncryptionError reports whether the error (with the command's output)
+// is caused by the PDF being encrypted or password protected.
+func isEncryptionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"password", "encrypt", "permission"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitDestDir returns the absolute srcfn, and creates the destination
+// directory for its split pages in the work directory of the request,
+// with the page file name prefix.
+func splitDestDir(ctx context.Context, srcfn string) (abs, destdir, prefix string, err error) {
+	if abs, err = filepath.Abs(srcfn); err != nil {
+		return
+	}
+	destdir = filepath.Join(GetWorkdir(ctx),
+		filepath.Base(abs)+"-"+strconv.Itoa(rand.Int())+"-split")
+	if !fileExists(destdir) {
+		if err = os.Mkdir(destdir, 0755); err != nil {
+			return
+		}
+	}
+	prefix = strings.Replace(filepath.Base(abs), "%", "!P!", -1) + "-"
+	return
+}
+
+// SplitPage is one page of a PDF, sent by PdfSplitChan.
+type SplitPage struct {
+	Page     int // 1-based
+	Filename string
+	Error    error
+}
+
+// PdfSplitChan splits the PDF to pages like PdfSplit, but sends each page
+// as soon as it is written - so the caller can process the first pages
+// while the rest is still being extracted.
+//
+// A page is complete when the next page's file appears, or the extracting
+// process has finished. Errors are sent as a SplitPage with Error set,
+// as the last item. Cancelling ctx stops the extraction, and removes the pages
+// (unless KeepTempFiles) - the receiver need not read the channel after that.
+func PdfSplitChan(ctx context.Context, srcfn string) (<-chan SplitPage, error) {
+	n, err := PdfPageNum(ctx, srcfn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot determine page number of %s", srcfn)
+	}
+	if n == 0 {
+		return nil, errors.New("0 pages in " + srcfn)
+	}
+	if n == 1 {
+		ch := make(chan SplitPage, 1)
+		ch <- SplitPage{Page: 1, Filename: srcfn}
+		close(ch)
+		return ch, nil
+	}
+	if err = checkMaxPages(srcfn, n); err != nil {
+		return nil, err
+	}
+
+	srcfn, destdir, prefix, err := splitDestDir(ctx, srcfn)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		cmd    *exec.Cmd
+		pageFn func(int) string
+	)
+	pdfseparate := getPopplerOk()["pdfseparate"]
+	if pdfseparate == "" && getMutoolOk().Pdf {
+		return mutoolSplitChan(ctx, srcfn, destdir, prefix, n), nil
+	}
+	if pdfseparate != "" {
+		cmd = exec.Command(pdfseparate, srcfn, filepath.Join(destdir, prefix+"%d.pdf"))
+		pageFn = func(i int) string { return filepath.Join(destdir, prefix+strconv.Itoa(i)+".pdf") }
+	} else {
+		cmd = exec.Command(*ConfPdftk, srcfn, "burst", "output", prefix+"%03d.pdf")
+		pageFn = func(i int) string { return filepath.Join(destdir, fmt.Sprintf("%s%03d.pdf", prefix, i)) }
+	}
+	cmd.Dir = destdir
+	return splitChan(ctx, cmd, destdir, n, pageFn)
+}
+
+// splitChan runs cmd, which writes the n pages into destdir (named by pageFn),
+// and sends each page on the returned channel when it is complete.
+// When ctx is done, the command is killed, and destdir is removed.
+func splitChan(ctx context.Context, cmd *exec.Cmd, destdir string, n int, pageFn func(int) string) (<-chan SplitPage, error) {
+	var errout bytes.Buffer
+	cmd.Stdout = &errout
+	cmd.Stderr = &errout
+	kill := killChan()
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "start %q", cmd.Args)
+	}
+	doneChild := startChild()
+	var waitErr error
+	exited := make(chan struct{})
+	go func() { waitErr = cmd.Wait(); doneChild(); close(exited) }()
+
+	keep := KeepTempFiles(ctx)
+	cancel := context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, *ConfChildTimeout)
+	}
+
+	ch := make(chan SplitPage, 1)
+	go func() {
+		defer cancel()
+		defer close(ch)
+		abort := func(err error) {
+			_ = killProcessGroup(cmd)
+			<-exited
+			if !keep {
+				_ = os.RemoveAll(destdir)
+			}
+			sendSplitPage(ctx, ch, SplitPage{Error: err})
+		}
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		var finished bool
+		for next := 1; next <= n; {
+			for next <= n && (finished || next < n && fileExists(pageFn(next+1))) {
+				fn := pageFn(next)
+				if !fileExists(fn) {
+					sendSplitPage(ctx, ch, SplitPage{Page: next, Error: errors.Errorf("page %d (%s) is missing", next, fn)})
+					return
+				}
+				if ctx.Err() != nil || !sendSplitPage(ctx, ch, SplitPage{Page: next, Filename: fn}) {
+					abort(ctx.Err())
+					return
+				}
+				next++
+			}
+			if finished {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				abort(ctx.Err())
+				return
+			case <-kill:
+				abort(errors.Wrapf(errKilled, "%q", cmd.Args))
+				return
+			case <-exited:
+				if waitErr != nil {
+					sendSplitPage(ctx, ch, SplitPage{Error: errors.Wrapf(waitErr, "%q: %s", cmd.Args, errout.Bytes())})
+					return
+				}
+				finished = true
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// sendSplitPage sends p on ch - unless ctx is done, and the receiver does not read ch.
+// It reports whether p has been sent.
+func sendSplitPage(ctx context.Context, ch chan<- SplitPage, p SplitPage) bool {
+	select {
+	case ch <- p:
+		return true
+	default:
+	}
+	select {
+	case ch <- p:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// PdfMerge merges pdf files into destfn.
+// If the context has a PdfVersion (see WithPdfVersion), the result is rewritten
+// with GhostScript to that version.
 func PdfMerge(ctx context.Context, destfn string, filenames ...string) error {
+	version := getPdfVersion(ctx)
 	if len(filenames) == 0 {
 		return errors.New("filenames required!")
 	} else if len(filenames) == 1 {
+		if version != "" {
+			if err := checkSignatures(ctx, filenames); err != nil {
+				return err
+			}
+			return xToX(ctx, destfn, filenames[0], false, "", version)
+		}
 		return temp.LinkOrCopy(filenames[0], destfn)
 	}
+	if err := checkSignatures(ctx, filenames); err != nil {
+		return err
+	}
+	// write into a temp file, and rename it to destfn only on success,
+	// so destfn never holds a half-written result.
+	fh, err := ioutil.TempFile(filepath.Dir(destfn), filepath.Base(destfn)+"-merge-")
+	if err != nil {
+		return errors.Wrap(err, "create temp file for merge")
+	}
+	tmpfn := fh.Name()
+	_ = fh.Close()
+	if err = pdfMerge(ctx, tmpfn, filenames...); err != nil && isParseError(err) {
+		// some files may be malformed: repair them, and retry once
+		var repaired []string
+		if filenames, repaired = repairPdfs(ctx, filenames); len(repaired) > 0 {
+			if !KeepTempFiles(ctx) {
+				defer func() {
+					for _, fn := range repaired {
+						_ = unlink(fn, "repaired")
+					}
+				}()
+			}
+			err = pdfMerge(ctx, tmpfn, filenames...)
+		}
+	}
+	if err == nil && version != "" {
+		vfn := tmpfn + "-v.pdf"
+		if err = xToX(ctx, vfn, tmpfn, false, "", version); err == nil {
+			err = os.Rename(vfn, tmpfn)
+		}
+		_ = os.Remove(vfn)
+	}
+	if err != nil {
+		_ = os.Remove(tmpfn)
+		return err
+	}
+	if err = pdfMakeDeterministic(tmpfn); err != nil {
+		_ = os.Remove(tmpfn)
+		return err
+	}
+	if err = os.Rename(tmpfn, destfn); err != nil {
+		_ = os.Remove(tmpfn)
+		return errors.Wrapf(err, "rename %s to %s", tmpfn, destfn)
+	}
+	return nil
+}
+
+func pdfMerge(ctx context.Context, destfn string, filenames ...string) error {
 	var buf bytes.Buffer
-	pdfunite := popplerOk["pdfunite"]
+	pdfunite := getPopplerOk()["pdfunite"]
 	if pdfunite != "" {
 		args := append(append(make([]string, 0, len(filenames)+1), filenames...),
 			destfn)
@@ -186,7 +479,20 @@ func PdfMerge(ctx context.Context, destfn string, filenames ...string) error {
 			return nil
 		}
 		Log("msg", "WARN pdfunite failed", "error", err, "errTxt", buf.String())
+		buf.Reset()
+	}
+	if getMutoolOk().Pdf {
+		return mutoolMerge(ctx, destfn, filenames...)
+	}
+	if needsBatches(len(filenames)) {
+		return mergeBatches(ctx, destfn, filenames, *ConfMergeBatchSize, pdftkMerge)
 	}
+	return pdftkMerge(ctx, destfn, filenames...)
+}
+
+// pdftkMerge merges the files into destfn with pdftk.
+func pdftkMerge(ctx context.Context, destfn string, filenames ...string) error {
+	var buf bytes.Buffer
 	args := append(append(make([]string, 0, len(filenames)+3), filenames...),
 		"cat", "output", destfn)
 	cmd := exec.Command(*ConfPdftk, args...)
@@ -198,10 +504,91 @@ func PdfMerge(ctx context.Context, destfn string, filenames ...string) error {
 	return nil
 }
 
+// repairPdfs repairs the malformed files (see pdfNeedsRepair) among filenames,
+// and returns filenames with the repaired ones replaced, and the repaired files.
+func repairPdfs(ctx context.Context, filenames []string) (fixed, repaired []string) {
+	Log := getLogger(ctx).Log
+	fixed = append(make([]string, 0, len(filenames)), filenames...)
+	for i, fn := range filenames {
+		if !pdfNeedsRepair(fn) {
+			continue
+		}
+		repfn := nakeFilename(fn) + "-repaired.pdf"
+		Log("msg", "merge failed, repairing the file", "file", fn)
+		if err := PdfRepair(ctx, repfn, fn); err != nil {
+			Log("msg", "ERROR PdfRepair", "file", fn, "error", err)
+			continue
+		}
+		fixed[i] = repfn
+		repaired = append(repaired, repfn)
+	}
+	return fixed, repaired
+}
+
+// PdfMergeTo merges the PDF files into one, and writes it to w.
+//
+// pdftk writes the result to its stdout, so it is streamed into w - but only after
+// the first mergeHoldSize bytes, as pdftk reads all its inputs before writing
+// anything: if it fails before that, nothing has been written to w, and PdfMerge
+// is used with a temp file. That is used also when pdfunite (preferred by PdfMerge)
+// or mutool is available, with ConfDeterministic or a PdfVersion (the result must be
+// patched or rewritten), and for the merges done in batches (see ConfMergeBatchSize).
+func PdfMergeTo(ctx context.Context, w io.Writer, filenames ...string) (int64, error) {
+	if len(filenames) == 0 {
+		return 0, errors.New("filenames required!")
+	}
+	version := getPdfVersion(ctx)
+	if len(filenames) == 1 && version == "" {
+		return copyFileTo(w, filenames[0])
+	}
+	if err := checkSignatures(ctx, filenames); err != nil {
+		return 0, err
+	}
+	if !*ConfDeterministic && !getMutoolOk().Pdf && getPopplerOk()["pdfunite"] == "" &&
+		version == "" && !needsBatches(len(filenames)) {
+		var buf bytes.Buffer
+		hw := &holdWriter{w: w, hold: mergeHoldSize}
+		args := append(append(make([]string, 0, len(filenames)+3), filenames...),
+			"cat", "output", "-")
+		cmd := exec.Command(*ConfPdftk, args...)
+		cmd.Stdout = hw
+		cmd.Stderr = io.MultiWriter(&buf, os.Stderr)
+		err := runWithContext(ctx, cmd)
+		if err == nil {
+			err = hw.Flush()
+			return hw.n, err
+		}
+		if hw.n > 0 {
+			return hw.n, errors.Wrap(err, buf.String())
+		}
+		getLogger(ctx).Log("msg", "WARN pdftk streaming merge failed", "error", err, "errTxt", buf.String())
+	}
+
+	fh, err := ioutil.TempFile(GetWorkdir(ctx), "pdfmerge-")
+	if err != nil {
+		return 0, err
+	}
+	destfn := fh.Name()
+	_ = fh.Close()
+	defer func() { _ = os.Remove(destfn) }()
+	if err = PdfMerge(ctx, destfn, filenames...); err != nil {
+		return 0, err
+	}
+	return copyFileTo(w, destfn)
+}
+
+func copyFileTo(w io.Writer, fn string) (int64, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = fh.Close() }()
+	return io.Copy(w, fh)
+}
+
 var (
 	alreadyCleaned = make(map[string]bool, 16)
 	cleanMtx       = sync.Mutex{}
-	pdfCleanStatus = int(0)
 )
 
 func getHash(fn string) string {
@@ -240,8 +627,9 @@ func isAlreadyCleaned(fn string) bool {
 	return false
 }
 
-// PdfClean cleans PDF from restrictions
-func PdfClean(fn string) (err error) {
+// PdfClean cleans PDF from restrictions, with the first of ConfCleanTools
+// which succeeds and leaves the file unencrypted.
+func PdfClean(ctx context.Context, fn string) (err error) {
 	if !filepath.IsAbs(fn) {
 		if fn, err = filepath.Abs(fn); err != nil {
 			return
@@ -251,55 +639,38 @@ func PdfClean(fn string) (err error) {
 		Log("msg", "PdfClean file %q is already cleaned.", fn)
 		return nil
 	}
-	cleanMtx.Lock()
-	if pdfCleanStatus == pcNotChecked { //first check
-		pdfCleanStatus = pcNothing
-		if ConfPdfClean != nil && *ConfPdfClean != "" {
-			if _, e := exec.LookPath(*ConfPdfClean); e != nil {
-				Log("msg", "no pdfclean (%q) exists?: %v", *ConfPdfClean, e)
-			} else {
-				pdfCleanStatus = pcPdfClean
-			}
-		}
-		if ConfMutool != nil && *ConfMutool != "" {
-			if _, e := exec.LookPath(*ConfMutool); e != nil {
-				Log("msg", "no mutool (%q) exists?: %v", *ConfMutool, e)
-			} else {
-				if pdfCleanStatus == pcNothing {
-					pdfCleanStatus = pcMutool
-				} else {
-					pdfCleanStatus |= pcMutool
-				}
-			}
-		}
-	}
-	pdfCleanStatus := pdfCleanStatus // to be able to unlock
-	cleanMtx.Unlock()
 
-	var cleaned, encrypted bool
-	if pdfCleanStatus != pcNothing {
-		var cleaner string
-		if pdfCleanStatus&pcPdfClean != 0 {
-			cleaner = *ConfPdfClean
-			err = call(cleaner, "-ggg", fn, fn+"-cleaned.pdf")
-		} else {
-			cleaner = *ConfMutool
-			err = call(cleaner, "clean", "-ggg", fn, fn+"-cleaned.pdf")
+	cleanedFn := fn + "-cleaned.pdf"
+	var tried []string
+	var cleaned bool
+	for _, tool := range cleanTools(*ConfCleanTools) {
+		if err = cleanWith(ctx, tool, cleanedFn, fn); err != nil {
+			if err == errToolMissing {
+				continue
+			}
+			Log("msg", "PdfClean", "tool", tool, "file", fn, "error", err)
+			tried = append(tried, tool+": "+err.Error())
+			continue
 		}
-		if err != nil {
-			return errors.Wrapf(err, "clean with "+cleaner)
+		if tool == "gs" { // gs rewrites the file without the encryption
+			cleaned = true
+			break
 		}
-		cleaned = true
-		_, encrypted, _ = pdfPageNum(fn + "-cleaned.pdf")
-		if encrypted {
-			Log("msg", "WARN "+cleaner+": file %q is encrypted!", fn)
+		if _, encrypted, _ := pdfPageNum(ctx, cleanedFn); !encrypted {
+			cleaned = true
+			break
 		}
-	} else if !cleaned || encrypted {
-		if err = PdfRewrite(fn+"-cleaned.pdf", fn); err != nil {
-			return
+		Log("msg", "WARN "+tool+": file %q is encrypted!", fn)
+		tried = append(tried, tool+": still encrypted")
+	}
+	if !cleaned {
+		_ = os.Remove(cleanedFn)
+		if len(tried) == 0 {
+			return errors.Errorf("no usable PDF cleaner in %q", *ConfCleanTools)
 		}
+		return errors.Errorf("clean %s: %s", fn, strings.Join(tried, "; "))
 	}
-	if err = os.Rename(fn+"-cleaned.pdf", fn); err != nil {
+	if err = os.Rename(cleanedFn, fn); err != nil {
 		return
 	}
 	cleanMtx.Lock()
@@ -314,69 +685,187 @@ func PdfClean(fn string) (err error) {
 	return nil
 }
 
-func call(what string, args ...string) error {
+// errToolMissing is returned by cleanWith for the tools not configured or not found.
+var errToolMissing = errors.New("tool is missing")
+
+// cleanTools returns the names in the comma-separated list of cleaners.
+func cleanTools(list string) []string {
+	var tools []string
+	for _, tool := range strings.Split(list, ",") {
+		if tool = strings.ToLower(strings.TrimSpace(tool)); tool != "" {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// cleanWith cleans srcfn into destfn with the named tool (mutool, pdfclean or gs).
+func cleanWith(ctx context.Context, tool, destfn, srcfn string) error {
+	var path string
+	switch tool {
+	case "mutool":
+		path = *ConfMutool
+	case "pdfclean":
+		path = *ConfPdfClean
+	case "gs":
+		path = *ConfGs
+	default:
+		Log("msg", "unknown PDF cleaner", "tool", tool)
+		return errToolMissing
+	}
+	if path == "" || lookPath(path) == "" {
+		return errToolMissing
+	}
+	switch tool {
+	case "mutool":
+		return call(ctx, path, "clean", "-ggg", srcfn, destfn)
+	case "pdfclean":
+		return call(ctx, path, "-ggg", srcfn, destfn)
+	default:
+		return PdfRewrite(ctx, destfn, srcfn, "", "")
+	}
+}
+
+func call(ctx context.Context, what string, args ...string) error {
 	cmd := exec.Command(what, args...)
-	return execute(cmd)
+	return execute(ctx, cmd)
 }
 
-func callAt(what, where string, args ...string) error {
+func callAt(ctx context.Context, what, where string, args ...string) error {
 	cmd := exec.Command(what, args...)
 	cmd.Stderr = os.Stderr
 	cmd.Dir = where
-	return execute(cmd)
+	return execute(ctx, cmd)
+}
+
+// execute runs the command, which is killed when the context is canceled.
+func execute(ctx context.Context, cmd *exec.Cmd) error {
+	return executeRetry(ctx, cmd, isTransient)
+}
+
+// executeRetry is execute, retrying the failures whose error output is
+// accepted by transient.
+func executeRetry(ctx context.Context, cmd *exec.Cmd, transient func(errout []byte) bool) error {
+	_, err := executeOutput(ctx, cmd, transient)
+	return err
 }
 
-func execute(cmd *exec.Cmd) error {
+// executeOutput is executeRetry, returning the (stdout and stderr) output of the last run, too.
+func executeOutput(ctx context.Context, cmd *exec.Cmd, transient func(errout []byte) bool) ([]byte, error) {
+	Log := getLogger(ctx).Log
 	errout := bytes.NewBuffer(nil)
 	cmd.Stderr = errout
 	cmd.Stdout = cmd.Stderr
-	err := runWithTimeout(cmd)
+	err := runWithContext(ctx, cmd)
+	// the input can be read only once, so only the commands without stdin are retried
+	for attempt := 1; err != nil && cmd.Stdin == nil &&
+		attempt <= *ConfExecRetries && transient(errout.Bytes()); attempt++ {
+		wait := retryBackoff(attempt)
+		Log("msg", "retrying", "args", cmd.Args, "attempt", attempt, "wait", wait,
+			"error", err, "errTxt", errout.String())
+		select {
+		case <-ctx.Done():
+			return errout.Bytes(), errors.Wrapf(ctx.Err(), "%#v while converting %s", cmd, errout.Bytes())
+		case <-time.After(wait):
+		}
+		retry := exec.Command(cmd.Path, cmd.Args[1:]...)
+		retry.Dir, retry.Env = cmd.Dir, cmd.Env
+		errout.Reset()
+		retry.Stderr = errout
+		retry.Stdout = retry.Stderr
+		cmd = retry
+		err = runWithContext(ctx, cmd)
+	}
 	if err != nil {
-		return errors.Wrapf(err, "%#v while converting %s", cmd, errout.Bytes())
+		return errout.Bytes(), errors.Wrapf(err, "%#v while converting %s", cmd, errout.Bytes())
 	}
 	if len(errout.Bytes()) > 0 {
-		Log("msg", "WARN execute %v: %s", cmd, errout.String())
+		Log("msg", "WARN execute", "args", cmd.Args, "errTxt", errout.String())
 	}
-	return nil
+	return errout.Bytes(), nil
 }
 
-func xToX(destfn, srcfn string, tops bool) (err error) {
+// transientSignatures are the error messages of the GraphicsMagick and GhostScript
+// resource shortage failures, which may succeed when retried.
+var transientSignatures = [][]byte{
+	[]byte("unable to acquire semaphore"),
+	[]byte("Resource temporarily unavailable"),
+	[]byte("Cannot allocate memory"),
+	[]byte("Memory allocation failed"),
+	[]byte("cache resources exhausted"),
+	[]byte("Too many open files"),
+	[]byte("VMerror"),
+}
+
+// isTransient reports whether the error output is of a transient failure.
+func isTransient(errout []byte) bool {
+	for _, sig := range transientSignatures {
+		if bytes.Contains(errout, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns the wait time before the attempt-th retry.
+func retryBackoff(attempt int) time.Duration {
+	return time.Duration(1<<uint(attempt-1)) * 500 * time.Millisecond
+}
+
+// xToX converts srcfn to PostScript (tops) or PDF with GhostScript,
+// the PDF with the given profile and version, and the extra pdfwrite options.
+func xToX(ctx context.Context, destfn, srcfn string, tops bool, profile GsProfile, version PdfVersion, extra ...string) (err error) {
 	var gsOpts []string
 	if tops {
-		gsOpts = []string{"-q", "-dNOPAUSE", "-dBATCH", "-P-", "-dSAFER",
-			"-sDEVICE=ps2write", "-sOutputFile=" + destfn, "-c", "save", "pop",
-			"-f", srcfn}
+		gsOpts = append([]string{"-q", "-dNOPAUSE", "-dBATCH", "-P-", "-dSAFER"},
+			gsLimitArgs()...)
+		gsOpts = append(gsOpts,
+			"-sDEVICE=ps2write", "-sOutputFile="+destfn, "-c", "save", "pop",
+			"-f", srcfn)
 	} else {
-		gsOpts = []string{"-P-", "-dSAFER", "-dNOPAUSE", "-dCompatibilityLevel=1.4",
-			"-dPDFSETTINGS=/printer", "-dUseCIEColor=true",
+		level := "-dCompatibilityLevel=" + string(version.orDefault())
+		gsOpts = append([]string{"-P-", "-dSAFER", "-dNOPAUSE", level},
+			pdfwriteOpts(*ConfGs, profile)...)
+		gsOpts = append(gsOpts, gsLimitArgs()...)
+		gsOpts = append(gsOpts, extra...)
+		gsOpts = append(gsOpts,
 			"-q", "-dBATCH", "-sDEVICE=pdfwrite", "-sstdout=%stderr",
-			"-sOutputFile=" + destfn,
-			"-P-", "-dSAFER", "-dCompatibilityLevel=1.4",
-			"-c", ".setpdfwrite", "-f", srcfn}
+			"-sOutputFile="+destfn,
+			"-P-", "-dSAFER", level,
+			"-c", ".setpdfwrite", "-f", srcfn)
 	}
 
-	if err = call(*ConfGs, gsOpts...); err != nil {
-		return errors.Wrapf(err, "converting %s to %s with %s",
+	cmd := gsCommand(gsOpts...)
+	if *ConfDeterministic {
+		cmd.Env = deterministicEnv()
+	}
+	if err = executeRetry(ctx, cmd, gsRetryable); err != nil {
+		return errors.Wrapf(gsLimitError(err), "converting %s to %s with %s",
 			srcfn, destfn, *ConfGs)
 	}
-	return nil
+	if tops {
+		return nil
+	}
+	return pdfMakeDeterministic(destfn)
 }
 
 // PdfToPs converts PDF to postscript
-func PdfToPs(destfn, srcfn string) error {
-	return xToX(destfn, srcfn, true)
+func PdfToPs(ctx context.Context, destfn, srcfn string) error {
+	return xToX(ctx, destfn, srcfn, true, "", "")
 }
 
-// PsToPdf converts postscript to PDF
-func PsToPdf(destfn, srcfn string) error {
-	return xToX(destfn, srcfn, false)
+// PsToPdf converts postscript to PDF, with the given GhostScript profile
+// (empty means ConfGsProfile) and PDF version (empty means ConfPdfVersion).
+func PsToPdf(ctx context.Context, destfn, srcfn string, profile GsProfile, version PdfVersion) error {
+	return xToX(ctx, destfn, srcfn, false, profile, version)
 }
 
-// PdfRewrite converts PDF to PDF (rewrites as PDF->PS->PDF)
-func PdfRewrite(destfn, srcfn string) error {
+// PdfRewrite converts PDF to PDF (rewrites as PDF->PS->PDF), with the given
+// GhostScript profile (empty means ConfGsProfile) and PDF version (empty means ConfPdfVersion).
+func PdfRewrite(ctx context.Context, destfn, srcfn string, profile GsProfile, version PdfVersion) error {
 	var err error
 	psfn := nakeFilename(srcfn) + "-pp.ps"
-	if err = PdfToPs(psfn, srcfn); err != nil {
+	if err = PdfToPs(ctx, psfn, srcfn); err != nil {
 		return err
 	}
 	if !LeaveTempFiles {
@@ -388,12 +877,65 @@ func PdfRewrite(destfn, srcfn string) error {
 	} else {
 		pdffn2 = destfn
 	}
-	if err = PsToPdf(pdffn2, psfn); err != nil {
+	if err = PsToPdf(ctx, pdffn2, psfn, profile, version); err != nil {
 		return err
 	}
 	return moveFile(pdffn2, destfn)
 }
 
+// PdfRepair tries to repair a malformed PDF, by rewriting it with GhostScript.
+func PdfRepair(ctx context.Context, destfn, srcfn string) error {
+	if err := PdfRewrite(ctx, destfn, srcfn, "", ""); err != nil {
+		return errors.Wrapf(err, "repair %s", srcfn)
+	}
+	Log("msg", "repaired", "src", srcfn, "dest", destfn)
+	return nil
+}
+
+// parseErrorSignatures are the error messages of poppler and pdftk,
+// for PDFs which are malformed, but may be repaired by PdfRepair.
+var parseErrorSignatures = []string{
+	"syntax error",
+	"couldn't read xref",
+	"couldn't find trailer",
+	"may not be a pdf",
+	"pdf file is damaged",
+	"trailer not found",
+	"rebuild failed",
+	"unexpected exception in open_reader",
+}
+
+// isParseError reports whether the error (with the command's output)
+// is caused by the PDF being malformed.
+func isParseError(err error) bool {
+	if err == nil || isEncryptionError(err) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range parseErrorSignatures {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfNeedsRepair reports whether pdfinfo (or pdftk) fails on the file,
+// or complains about its syntax.
+func pdfNeedsRepair(fn string) bool {
+	var cmd *exec.Cmd
+	if prg := getPopplerOk()["pdfinfo"]; prg != "" {
+		cmd = exec.Command(prg, fn)
+	} else {
+		cmd = exec.Command(*ConfPdftk, fn, "dump_data_utf8")
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return !isEncryptionError(errors.Wrapf(err, "%s", out))
+	}
+	return isParseError(errors.New(string(out)))
+}
+
 // PdfDumpFields dumps the field names from the given PDF.
 func PdfDumpFields(inpfn string) ([]string, error) {
 	pr, pw := io.Pipe()
@@ -424,6 +966,107 @@ func PdfDumpFields(inpfn string) ([]string, error) {
 	return fields, nil
 }
 
+// PdfField describes one form field of a PDF, as dumped by pdftk.
+type PdfField struct {
+	Name          string   `json:"name"`
+	AltName       string   `json:"altName,omitempty"`
+	Type          string   `json:"type"`
+	Flags         int      `json:"flags,omitempty"`
+	Value         string   `json:"value,omitempty"`
+	DefaultValue  string   `json:"defaultValue,omitempty"`
+	Justification string   `json:"justification,omitempty"`
+	MaxLength     int      `json:"maxLength,omitempty"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// PDF field flag for radio buttons (bit 16).
+const pdfFieldFlagRadio = 1 << 15
+
+// pdfFieldType maps pdftk's FieldType to text/checkbox/radio/choice/signature.
+func pdfFieldType(typ string, flags int) string {
+	switch typ {
+	case "Text":
+		return "text"
+	case "Button":
+		if flags&pdfFieldFlagRadio != 0 {
+			return "radio"
+		}
+		return "checkbox"
+	case "Choice":
+		return "choice"
+	case "Signature":
+		return "signature"
+	}
+	return strings.ToLower(typ)
+}
+
+// PdfDumpFieldsFull dumps the form fields from the given PDF,
+// with their type, value and allowed options.
+func PdfDumpFieldsFull(ctx context.Context, inpfn string) ([]PdfField, error) {
+	var buf bytes.Buffer
+	cmd := exec.Command(*ConfPdftk, inpfn, "dump_data_fields_utf8", "output", "-")
+	cmd.Stdout = &buf
+	if err := runWithContext(ctx, cmd); err != nil {
+		return nil, errors.Wrapf(err, "pdftk dump_data_fields_utf8")
+	}
+	return parseDumpDataFields(&buf)
+}
+
+// parseDumpDataFields parses the output of pdftk dump_data_fields_utf8:
+// records separated by "---" lines, each line is "Key: Value".
+func parseDumpDataFields(r io.Reader) ([]PdfField, error) {
+	var (
+		fields []PdfField
+		f      *PdfField
+		typ    string
+	)
+	flush := func() {
+		if f != nil && f.Name != "" {
+			f.Type = pdfFieldType(typ, f.Flags)
+			fields = append(fields, *f)
+		}
+		f, typ = nil, ""
+	}
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		line := scan.Text()
+		if strings.TrimSpace(line) == "---" {
+			flush()
+			continue
+		}
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			continue
+		}
+		if f == nil {
+			f = new(PdfField)
+		}
+		k, v := line[:i], strings.TrimSpace(line[i+2:])
+		switch k {
+		case "FieldType":
+			typ = v
+		case "FieldName":
+			f.Name = v
+		case "FieldNameAlt":
+			f.AltName = v
+		case "FieldFlags":
+			f.Flags, _ = strconv.Atoi(v)
+		case "FieldValue":
+			f.Value = v
+		case "FieldValueDefault":
+			f.DefaultValue = v
+		case "FieldJustification":
+			f.Justification = v
+		case "FieldMaxLength":
+			f.MaxLength, _ = strconv.Atoi(v)
+		case "FieldStateOption":
+			f.Options = append(f.Options, v)
+		}
+	}
+	flush()
+	return fields, errors.Wrap(scan.Err(), "scan fields")
+}
+
 type xfdf struct {
 	Fields []string
 	Values map[string]string
@@ -454,8 +1097,8 @@ func (xf xfdf) WriteTo(w io.Writer) (int64, error) {
 }
 
 // PdfDumpFdf dumps the FDF from the given PDF.
-func PdfDumpFdf(destfn, inpfn string) error {
-	if err := call(*ConfPdftk, inpfn, "generate_fdf", "output", destfn); err != nil {
+func PdfDumpFdf(ctx context.Context, destfn, inpfn string) error {
+	if err := call(ctx, *ConfPdftk, inpfn, "generate_fdf", "output", destfn); err != nil {
 		return errors.Wrapf(err, "pdftk generate_fdf")
 	}
 	return nil
@@ -464,14 +1107,37 @@ func PdfDumpFdf(destfn, inpfn string) error {
 var fillFdfMu sync.Mutex
 
 // PdfFillFdf fills the FDF and generates PDF.
-func PdfFillFdf(destfn, inpfn string, values map[string]string) error {
+func PdfFillFdf(ctx context.Context, destfn, inpfn string, values map[string]string) error {
+	return pdfFillFdf(ctx, destfn, inpfn, values, false)
+}
+
+// PdfFillFdfFlatten fills the FDF like PdfFillFdf, and flattens the form
+// in the same pass, so the filled values become static content.
+func PdfFillFdfFlatten(ctx context.Context, destfn, inpfn string, values map[string]string) error {
+	return pdfFillFdf(ctx, destfn, inpfn, values, true)
+}
+
+func pdfFillFdf(ctx context.Context, destfn, inpfn string, values map[string]string, flatten bool) error {
 	if len(values) == 0 {
-		return copyFile(inpfn, destfn)
+		if !flatten {
+			return copyFile(inpfn, destfn)
+		}
+		return execute(ctx, exec.Command(*ConfPdftk, pdfFillArgs(destfn, inpfn, false, flatten)...))
 	}
-	fp, err := getFdf(inpfn)
+	fp, err := getFdf(ctx, inpfn)
 	if err != nil {
 		return err
 	}
+	var unknown []string
+	for k := range values {
+		if _, ok := fp.Values[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &UnknownFieldsError{Fields: unknown}
+	}
 	for k, v := range values {
 		if err = fp.Set(k, v); err != nil {
 			return err
@@ -482,22 +1148,45 @@ func PdfFillFdf(destfn, inpfn string, values map[string]string) error {
 		return err
 	}
 
-	cmd := exec.Command(*ConfPdftk, inpfn, "fill_form", "-", "output", destfn)
+	// the FDF (with the UTF-16BE values) is the same with flattening, too
+	cmd := exec.Command(*ConfPdftk, pdfFillArgs(destfn, inpfn, true, flatten)...)
 	cmd.Stdin = bytes.NewReader(buf.Bytes())
-	return execute(cmd)
+	return execute(ctx, cmd)
 }
 
-func getFdf(inpfn string) (fieldParts, error) {
+// pdfFillArgs returns the pdftk arguments for filling inpfn with the FDF read
+// from stdin (if fill), and flattening the result into destfn (if flatten).
+func pdfFillArgs(destfn, inpfn string, fill, flatten bool) []string {
+	args := append(make([]string, 0, 6), inpfn)
+	if fill {
+		args = append(args, "fill_form", "-")
+	}
+	args = append(args, "output", destfn)
+	if flatten {
+		args = append(args, "flatten")
+	}
+	return args
+}
+
+func getFdf(ctx context.Context, inpfn string) (fieldParts, error) {
 	var fp fieldParts
 	hsh, err := fileContentHash(inpfn)
 	if err != nil {
 		return fp, err
 	}
-	fdfFn := filepath.Join(Workdir, base64.URLEncoding.EncodeToString(hsh.Sum(nil))+".fdf")
-	if f, err := os.Open(fdfFn + ".gob"); err == nil {
+	key := base64.URLEncoding.EncodeToString(hsh.Sum(nil))
+	fdfFn := filepath.Join(Workdir, key+".fdf")
+	gobFn := fdfFn + ".v2.gob"
+	if fp, ok := fdfCache.get(key); ok {
+		touchFile(gobFn)
+		return fp, nil
+	}
+	if f, err := os.Open(gobFn); err == nil {
 		err = gob.NewDecoder(f).Decode(&fp)
 		f.Close()
 		if err == nil {
+			touchFile(gobFn)
+			fdfCache.add(key, fp, *ConfFdfCacheSize)
 			return fp, nil
 		}
 		Log("msg", "ERROR decoding %s: %v", f.Name, err)
@@ -510,7 +1199,7 @@ func getFdf(inpfn string) (fieldParts, error) {
 			os.Remove(fdfFn)
 		} else {
 			fillFdfMu.Lock()
-			err = PdfDumpFdf(fdfFn, inpfn)
+			err = PdfDumpFdf(ctx, fdfFn, inpfn)
 			fillFdfMu.Unlock()
 			if err != nil {
 				return fp, err
@@ -523,9 +1212,16 @@ func getFdf(inpfn string) (fieldParts, error) {
 
 	fp = splitFdf(fdf)
 
-	f, err := os.OpenFile(fdfFn+".gob", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	// The FDF does not tell the checkboxes from the text fields when they're empty.
+	if fields, err := PdfDumpFieldsFull(ctx, inpfn); err != nil {
+		Log("msg", "PdfDumpFieldsFull", "file", inpfn, "error", err)
+	} else {
+		fp.setButtons(fields)
+	}
+
+	f, err := os.OpenFile(gobFn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 	if err != nil {
-		Log("msg", "cannot create %q: %v", fdfFn+".gob", err)
+		Log("msg", "cannot create %q: %v", gobFn, err)
 	} else {
 		fillFdfMu.Lock()
 		err = gob.NewEncoder(f).Encode(fp)
@@ -539,20 +1235,59 @@ func getFdf(inpfn string) (fieldParts, error) {
 			}
 		}
 	}
+	fdfCache.add(key, fp, *ConfFdfCacheSize)
 
 	return fp, nil
 }
 
+// touchFile sets the modification time of the file to now,
+// so the cache pruning (see pruneFdfCache) keeps it.
+func touchFile(fn string) {
+	now := time.Now()
+	_ = os.Chtimes(fn, now, now)
+}
+
+// UnknownFieldsError is returned by PdfFillFdf when some of the given
+// field names does not exist in the PDF form.
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "fields not exist: " + strings.Join(e.Fields, ", ")
+}
+
+// InvalidFieldValueError is returned by PdfFillFdf when a checkbox or
+// radio button field is set to a state it does not have.
+type InvalidFieldValueError struct {
+	Field, Value string
+	Allowed      []string
+}
+
+func (e *InvalidFieldValueError) Error() string {
+	return fmt.Sprintf("field %q: invalid state %q (allowed: %s)",
+		e.Field, e.Value, strings.Join(e.Allowed, ", "))
+}
+
 type FieldSetter interface {
 	Set(key, value string) error
 }
 
 var fieldPartV = []byte("\n<<\n/V ()\n")
 
+// fieldPartVRx matches the empty text values, and the (named) states
+// of checkboxes and radio buttons.
+var fieldPartVRx = regexp.MustCompile(`\n<<\n/V (?:\(\)|/[^\s/()<>\[\]{}%]*)\n`)
+
+// pdfOffState is the off state of every checkbox and radio button.
+const pdfOffState = "Off"
+
 type fieldParts struct {
 	Parts  [][]byte
 	Fields []string
 	Values map[string]string
+	// Buttons holds the states of the checkbox and radio button fields.
+	Buttons map[string][]string
 }
 
 func (fp fieldParts) WriteTo(w io.Writer) (n int64, err error) {
@@ -565,8 +1300,13 @@ func (fp fieldParts) WriteTo(w io.Writer) (n int64, err error) {
 			break
 		}
 		cew.Write(part)
-		val := fp.Values[fp.Fields[i]]
-		if len(val) == 0 {
+		key := fp.Fields[i]
+		val := fp.Values[key]
+		if _, ok := fp.Buttons[key]; ok {
+			// unset buttons are left as "/V /", as pdftk generates them
+			cew.Write(fpv1[:len(fpv1)-1])
+			cew.Write([]byte("/" + pdfName(val) + "\n"))
+		} else if len(val) == 0 {
 			cew.Write(fieldPartV)
 		} else {
 			cew.Write(fpv1)
@@ -591,24 +1331,114 @@ func (fp fieldParts) Set(key, value string) error {
 		Log("msg", "unknown field %q", fp.Fields)
 		return errors.New("field " + key + " not exist")
 	}
+	if states, ok := fp.Buttons[key]; ok {
+		state, err := buttonState(states, value)
+		if err != nil {
+			return &InvalidFieldValueError{Field: key, Value: value, Allowed: states}
+		}
+		value = state
+	}
 	fp.Values[key] = value
 	return nil
 }
 
+// setButtons marks the checkbox and radio button fields, with their states.
+func (fp *fieldParts) setButtons(fields []PdfField) {
+	for _, f := range fields {
+		if f.Type != "checkbox" && f.Type != "radio" {
+			continue
+		}
+		if _, ok := fp.Values[f.Name]; !ok {
+			continue
+		}
+		if fp.Buttons == nil {
+			fp.Buttons = make(map[string][]string)
+		}
+		if len(f.Options) == 0 {
+			fp.Buttons[f.Name] = nil // as gob decodes it
+		} else {
+			fp.Buttons[f.Name] = f.Options
+		}
+	}
+}
+
+// buttonState returns the state to be set for the value.
+// The empty value and "Off" means the off state; a checkbox with only one
+// on state also accepts true/false, yes/no, on/off and 1/0.
+func buttonState(states []string, value string) (string, error) {
+	if value == "" || value == pdfOffState {
+		return pdfOffState, nil
+	}
+	if len(states) == 0 {
+		return value, nil
+	}
+	var on []string
+	for _, s := range states {
+		if s == value {
+			return value, nil
+		}
+		if s != pdfOffState {
+			on = append(on, s)
+		}
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1", "x":
+		if len(on) == 1 {
+			return on[0], nil
+		}
+	case "false", "no", "off", "0":
+		return pdfOffState, nil
+	}
+	return "", errors.Errorf("invalid state %q", value)
+}
+
+// pdfName escapes s to be used as a PDF name (without the leading /).
+func pdfName(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x21 || c > 0x7e || strings.IndexByte("#/()<>[]{}%", c) >= 0 {
+			fmt.Fprintf(&buf, "#%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// pdfUnName is the inverse of pdfName.
+func pdfUnName(s string) string {
+	if strings.IndexByte(s, '#') < 0 {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				buf.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
 func splitFdf(fdf []byte) fieldParts {
 	var fp fieldParts
-	for {
-		i := bytes.Index(fdf, fieldPartV)
-		if i < 0 {
-			fp.Parts = append(fp.Parts, fdf)
-			break
-		}
-		fp.Parts = append(fp.Parts, fdf[:i])
-		fdf = fdf[i+len(fieldPartV):]
+	var states []string
+	var prev int
+	for _, loc := range fieldPartVRx.FindAllIndex(fdf, -1) {
+		fp.Parts = append(fp.Parts, fdf[prev:loc[0]])
+		// "\n<<\n/V " + value + "\n"
+		states = append(states, string(fdf[loc[0]+7:loc[1]-1]))
+		prev = loc[1]
 	}
+	fp.Parts = append(fp.Parts, fdf[prev:])
 	fp.Fields = make([]string, 0, len(fp.Parts)-1)
 	fp.Values = make(map[string]string, len(fp.Parts)-1)
-	for _, part := range fp.Parts {
+	for n, part := range fp.Parts {
 		i := bytes.Index(part, []byte("/T ("))
 		if i < 0 {
 			continue
@@ -621,12 +1451,53 @@ func splitFdf(fdf []byte) fieldParts {
 		key := string(part[i+4 : j])
 		fp.Fields = append(fp.Fields, key)
 		fp.Values[key] = ""
+		if n > 0 && strings.HasPrefix(states[n-1], "/") {
+			if fp.Buttons == nil {
+				fp.Buttons = make(map[string][]string)
+			}
+			fp.Buttons[key] = nil
+			fp.Values[key] = pdfUnName(states[n-1][1:])
+		}
 	}
 	return fp
 }
 
 var _ io.Writer = (*countErrWriter)(nil)
 
+// mergeHoldSize is the size of the output held back by PdfMergeTo.
+const mergeHoldSize = 64 << 10
+
+// holdWriter holds back the first hold bytes written to it, then writes through to w.
+// Flush writes the held back bytes; n is the number of bytes written to w.
+type holdWriter struct {
+	w    io.Writer
+	hold int
+	buf  bytes.Buffer
+	n    int64
+}
+
+func (hw *holdWriter) Write(p []byte) (int, error) {
+	if hw.n == 0 && hw.buf.Len()+len(p) <= hw.hold {
+		return hw.buf.Write(p)
+	}
+	if err := hw.Flush(); err != nil {
+		return 0, err
+	}
+	n, err := hw.w.Write(p)
+	hw.n += int64(n)
+	return n, err
+}
+
+// Flush writes the held back bytes to w.
+func (hw *holdWriter) Flush() error {
+	if hw.buf.Len() == 0 {
+		return nil
+	}
+	n, err := hw.buf.WriteTo(hw.w)
+	hw.n += n
+	return err
+}
+
 type countErrWriter struct {
 	w   io.Writer
 	err error
@@ -0,0 +1,60 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PdfExtractAttachments extracts the embedded files of srcfn into destdir,
+// and returns the extracted file names (an empty slice if there is none).
+func PdfExtractAttachments(srcfn, destdir string) ([]string, error) {
+	if *ConfPdftk == "" {
+		return nil, errors.New("pdftk is needed for extracting attachments")
+	}
+	if err := os.MkdirAll(destdir, 0750); err != nil {
+		return nil, errors.Wrap(err, destdir)
+	}
+	before, err := dirNames(destdir)
+	if err != nil {
+		return nil, err
+	}
+	if err = call(context.Background(), *ConfPdftk, srcfn, "unpack_files", "output", destdir+string(filepath.Separator)); err != nil {
+		return nil, errors.Wrapf(err, "unpack files of %s", srcfn)
+	}
+	after, err := dirNames(destdir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(after))
+	for nm := range after {
+		if _, ok := before[nm]; !ok {
+			files = append(files, filepath.Join(destdir, nm))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// dirNames returns the names of the regular files in the directory.
+func dirNames(dir string) (map[string]struct{}, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, dir)
+	}
+	names := make(map[string]struct{}, len(fis))
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() {
+			names[fi.Name()] = struct{}{}
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-dirnames-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if names, err := dirNames(dir); err != nil || len(names) != 0 {
+		t.Fatalf("empty dir: got %v, %v", names, err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.xml"), nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	names, err := dirNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := names["a.xml"]; !ok || len(names) != 1 {
+		t.Errorf("got %v, wanted only a.xml", names)
+	}
+}
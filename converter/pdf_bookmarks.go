@@ -0,0 +1,156 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrNoBookmarks is returned by PdfSplitByBookmarks for PDFs without (top-level) bookmarks.
+var ErrNoBookmarks = errors.New("no bookmarks")
+
+// pdfBookmark is one entry of the outline, as dumped by pdftk.
+type pdfBookmark struct {
+	Title       string
+	Level, Page int
+}
+
+// pdfSection is a page range of the PDF, started by a top-level bookmark.
+type pdfSection struct {
+	Title       string
+	First, Last int
+}
+
+// PdfSplitByBookmarks splits the PDF at its top-level bookmarks,
+// returning one file per section, named after the bookmark's title.
+// The pages before the first bookmark belong to the first section.
+// Returns ErrNoBookmarks if the PDF has no bookmarks.
+func PdfSplitByBookmarks(ctx context.Context, srcfn string) ([]string, error) {
+	var out, errout bytes.Buffer
+	cmd := exec.Command(*ConfPdftk, srcfn, "dump_data_utf8")
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return nil, errors.Wrapf(err, "%q: %s", cmd.Args, errout.Bytes())
+	}
+	n, err := pdfDumpPageNum(out.Bytes())
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot determine page number of %s", srcfn)
+	}
+	sections := bookmarkSections(parseBookmarks(bytes.NewReader(out.Bytes())), n)
+	if len(sections) == 0 {
+		return nil, errors.Wrap(ErrNoBookmarks, srcfn)
+	}
+	if err = checkMaxPages(srcfn, n); err != nil {
+		return nil, err
+	}
+
+	srcfn, destdir, prefix, err := splitDestDir(ctx, srcfn)
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, 0, len(sections))
+	for i, s := range sections {
+		title := s.Title
+		if title == "" {
+			title = "section"
+		}
+		fn := filepath.Join(destdir, fmt.Sprintf("%s%02d-%s.pdf", prefix, i+1, safeFn(title, true)))
+		if err = call(ctx, *ConfPdftk, srcfn, "cat", fmt.Sprintf("%d-%d", s.First, s.Last), "output", fn); err != nil {
+			return filenames, errors.Wrapf(err, "extract pages %d-%d of %s", s.First, s.Last, srcfn)
+		}
+		filenames = append(filenames, fn)
+	}
+	return filenames, nil
+}
+
+// pdfDumpPageNum returns the NumberOfPages of the pdftk dump_data output.
+func pdfDumpPageNum(dump []byte) (int, error) {
+	const key = "NumberOfPages:"
+	i := bytes.Index(dump, []byte(key))
+	if i < 0 {
+		return 0, errors.New("no " + key)
+	}
+	line := dump[i+len(key):]
+	if j := bytes.IndexByte(line, '\n'); j >= 0 {
+		line = line[:j]
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(line)))
+}
+
+// parseBookmarks parses the Bookmark* entries of the pdftk dump_data output.
+func parseBookmarks(r io.Reader) []pdfBookmark {
+	var bms []pdfBookmark
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "BookmarkBegin" {
+			bms = append(bms, pdfBookmark{})
+			continue
+		}
+		i := strings.Index(line, ": ")
+		if len(bms) == 0 || i < 0 || !strings.HasPrefix(line, "Bookmark") {
+			continue
+		}
+		bm := &bms[len(bms)-1]
+		val := line[i+2:]
+		switch line[:i] {
+		case "BookmarkTitle":
+			bm.Title = strings.TrimSpace(val)
+		case "BookmarkLevel":
+			bm.Level, _ = strconv.Atoi(val)
+		case "BookmarkPageNumber":
+			bm.Page, _ = strconv.Atoi(val)
+		}
+	}
+	return bms
+}
+
+// bookmarkSections returns the page ranges between the top-level bookmarks,
+// of a document with n pages. Bookmarks pointing to the same page as the
+// previous are dropped, as are the ones pointing outside of the document.
+func bookmarkSections(bms []pdfBookmark, n int) []pdfSection {
+	top := make([]pdfBookmark, 0, len(bms))
+	for _, bm := range bms {
+		if bm.Level == 1 && bm.Page >= 1 && bm.Page <= n {
+			top = append(top, bm)
+		}
+	}
+	sort.Stable(bookmarksByPage(top))
+	sections := make([]pdfSection, 0, len(top))
+	for _, bm := range top {
+		if k := len(sections) - 1; k >= 0 {
+			if sections[k].First == bm.Page {
+				continue
+			}
+			sections[k].Last = bm.Page - 1
+		}
+		sections = append(sections, pdfSection{Title: bm.Title, First: bm.Page})
+	}
+	if len(sections) == 0 {
+		return nil
+	}
+	sections[0].First = 1
+	sections[len(sections)-1].Last = n
+	return sections
+}
+
+// bookmarksByPage is a wrapper for []pdfBookmark for sort.Stable
+type bookmarksByPage []pdfBookmark
+
+func (b bookmarksByPage) Len() int           { return len(b) }
+func (b bookmarksByPage) Less(i, j int) bool { return b[i].Page < b[j].Page }
+func (b bookmarksByPage) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,68 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const bookmarksDump = `InfoBegin
+InfoKey: Title
+InfoValue: Combined
+BookmarkBegin
+BookmarkTitle: Első levél
+BookmarkLevel: 1
+BookmarkPageNumber: 2
+BookmarkBegin
+BookmarkTitle: Részlet
+BookmarkLevel: 2
+BookmarkPageNumber: 3
+BookmarkBegin
+BookmarkTitle: Invoice
+BookmarkLevel: 1
+BookmarkPageNumber: 5
+BookmarkBegin
+BookmarkTitle: Invoice copy
+BookmarkLevel: 1
+BookmarkPageNumber: 5
+BookmarkBegin
+BookmarkTitle: Attachments
+BookmarkLevel: 1
+BookmarkPageNumber: 7
+NumberOfPages: 9
+PageMediaBegin
+PageMediaNumber: 1
+`
+
+func TestBookmarkSections(t *testing.T) {
+	bms := parseBookmarks(strings.NewReader(bookmarksDump))
+	if len(bms) != 5 {
+		t.Fatalf("got %d bookmarks, wanted 5: %+v", len(bms), bms)
+	}
+	if bms[1] != (pdfBookmark{Title: "Részlet", Level: 2, Page: 3}) {
+		t.Errorf("got %+v", bms[1])
+	}
+	n, err := pdfDumpPageNum([]byte(bookmarksDump))
+	if err != nil || n != 9 {
+		t.Fatalf("page num: got %d, %v", n, err)
+	}
+	want := []pdfSection{
+		{Title: "Első levél", First: 1, Last: 4},
+		{Title: "Invoice", First: 5, Last: 6},
+		{Title: "Attachments", First: 7, Last: 9},
+	}
+	if got := bookmarkSections(bms, n); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v", got, want)
+	}
+
+	if got := bookmarkSections(nil, 3); got != nil {
+		t.Errorf("no bookmarks: got %+v", got)
+	}
+	if got := bookmarkSections([]pdfBookmark{{Title: "x", Level: 1, Page: 12}}, 3); got != nil {
+		t.Errorf("out of range: got %+v", got)
+	}
+}
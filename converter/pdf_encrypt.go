@@ -0,0 +1,222 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Permissions are the operations allowed on an encrypted PDF
+// (without knowing the owner password).
+type Permissions struct {
+	Printing, Copying, Modification bool
+}
+
+// ParsePermissions parses the comma separated list of "print", "copy" and "modify",
+// or "all", or "none" (the same as the empty string).
+func ParsePermissions(s string) (Permissions, error) {
+	var p Permissions
+	for _, f := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case "", "none":
+		case "all":
+			p = Permissions{Printing: true, Copying: true, Modification: true}
+		case "print", "printing":
+			p.Printing = true
+		case "copy", "copying":
+			p.Copying = true
+		case "modify", "modification":
+			p.Modification = true
+		default:
+			return p, errors.Errorf("unknown permission %q (allowed: print, copy, modify, all, none)", f)
+		}
+	}
+	return p, nil
+}
+
+// String returns the permissions in the format ParsePermissions accepts.
+func (p Permissions) String() string {
+	var parts []string
+	if p.Printing {
+		parts = append(parts, "print")
+	}
+	if p.Copying {
+		parts = append(parts, "copy")
+	}
+	if p.Modification {
+		parts = append(parts, "modify")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
+
+// pdftkArgs returns the "allow" arguments for pdftk.
+func (p Permissions) pdftkArgs() []string {
+	var args []string
+	if p.Printing {
+		args = append(args, "Printing")
+	}
+	if p.Copying {
+		args = append(args, "CopyContents")
+	}
+	if p.Modification {
+		args = append(args, "ModifyContents")
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return append([]string{"allow"}, args...)
+}
+
+// bits returns the permission flags of the PDF standard security handler
+// (PDF 32000-1:2008, Table 22), as mutool wants them.
+func (p Permissions) bits() int32 {
+	// bits 7-8 and 13-32 must be 1; extraction for accessibility (bit 10) is always allowed
+	flags := int32(-3904) | 1<<9
+	if p.Printing {
+		flags |= 1<<2 | 1<<11
+	}
+	if p.Modification {
+		flags |= 1<<3 | 1<<5 | 1<<8 | 1<<10
+	}
+	if p.Copying {
+		flags |= 1 << 4
+	}
+	return flags
+}
+
+// EncryptOpts are the options of PdfEncrypt.
+type EncryptOpts struct {
+	UserPassword, OwnerPassword string
+	Permissions                 Permissions
+}
+
+// IsZero reports whether no encryption is asked for.
+func (o EncryptOpts) IsZero() bool {
+	return o.UserPassword == "" && o.OwnerPassword == ""
+}
+
+// Validate the options.
+func (o EncryptOpts) Validate() error {
+	for _, pw := range []string{o.UserPassword, o.OwnerPassword} {
+		if strings.ContainsAny(pw, "\x00\r\n") {
+			return errors.New("bad character in password")
+		}
+	}
+	if o.IsZero() && o.Permissions != (Permissions{}) {
+		return errors.New("permissions need a password")
+	}
+	return nil
+}
+
+// String returns a fingerprint of the options, usable in (cache) file names;
+// the passwords are not included, only a hash of them.
+func (o EncryptOpts) String() string {
+	if o.IsZero() {
+		return ""
+	}
+	hsh := sha1.Sum([]byte(o.UserPassword + "\x00" + o.OwnerPassword + "\x00" + o.Permissions.String()))
+	return hex.EncodeToString(hsh[:8])
+}
+
+const encryptOptsKey = "encryptOpts"
+
+// WithEncryptOpts returns a context which makes the email conversions
+// encrypt the resulting PDFs.
+func WithEncryptOpts(ctx context.Context, opts EncryptOpts) context.Context {
+	return context.WithValue(ctx, encryptOptsKey, opts)
+}
+
+func getEncryptOpts(ctx context.Context) EncryptOpts {
+	if ctx == nil {
+		return EncryptOpts{}
+	}
+	opts, _ := ctx.Value(encryptOptsKey).(EncryptOpts)
+	return opts
+}
+
+// PdfEncrypt writes srcfn encrypted (with 128-bit AES) to destfn.
+// The user password is needed to open the file, and may be empty;
+// the owner password allows everything, and the user password is used if it is empty.
+// Without the owner password, only the operations in perms are allowed.
+//
+// pdftk is used if available, mutool otherwise; it is killed when ctx is cancelled.
+func PdfEncrypt(ctx context.Context, destfn, srcfn, userPw, ownerPw string, perms Permissions) error {
+	opts := EncryptOpts{UserPassword: userPw, OwnerPassword: ownerPw, Permissions: perms}
+	if opts.IsZero() {
+		return errors.New("PdfEncrypt: no password")
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if ownerPw == "" {
+		ownerPw = userPw
+	}
+	tmpfn := destfn + "-encrypted.pdf"
+	defer func() { _ = os.Remove(tmpfn) }()
+	var cmd *exec.Cmd
+	if *ConfPdftk != "" {
+		args := []string{srcfn, "output", tmpfn, "encrypt_128bit", "owner_pw", ownerPw}
+		if userPw != "" {
+			args = append(args, "user_pw", userPw)
+		}
+		cmd = exec.Command(*ConfPdftk, append(args, perms.pdftkArgs()...)...)
+	} else if *ConfMutool != "" {
+		cmd = exec.Command(*ConfMutool, "clean", "-E", "aes-128", "-O", ownerPw, "-U", userPw,
+			"-P", strconv.Itoa(int(perms.bits())), srcfn, tmpfn)
+	} else {
+		return errors.New("PdfEncrypt: neither pdftk nor mutool is available")
+	}
+	// not with execute, as that would log the arguments (the passwords)
+	var errout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &errout, &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return errors.New(scrubPasswords(
+			fmt.Sprintf("PdfEncrypt with %s: %v: %s", filepath.Base(cmd.Path), err, errout.Bytes()),
+			userPw, ownerPw))
+	}
+	return moveFile(tmpfn, destfn)
+}
+
+// scrubPasswords replaces the passwords in s (an error message with the command line).
+func scrubPasswords(s string, passwords ...string) string {
+	for _, pw := range passwords {
+		if pw != "" {
+			s = strings.Replace(s, pw, "***", -1)
+		}
+	}
+	return s
+}
+
+// encryptFiles encrypts the PDF files with the options.
+// As opposed to rewriteFiles, this is not best effort:
+// the files must not be delivered unencrypted.
+func encryptFiles(ctx context.Context, files []ArchFileItem, opts EncryptOpts) error {
+	for _, f := range files {
+		if f.Error != nil || f.File != nil || !strings.HasSuffix(f.Filename, ".pdf") {
+			continue
+		}
+		if err := PdfEncrypt(ctx, f.Filename, f.Filename,
+			opts.UserPassword, opts.OwnerPassword, opts.Permissions,
+		); err != nil {
+			getLogger(ctx).Log("msg", "PdfEncrypt", "file", f.Filename, "error", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePermissions(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Permissions
+		err  bool
+	}{
+		{"", Permissions{}, false},
+		{"none", Permissions{}, false},
+		{"print", Permissions{Printing: true}, false},
+		{"print, copy", Permissions{Printing: true, Copying: true}, false},
+		{"all", Permissions{Printing: true, Copying: true, Modification: true}, false},
+		{"print,fly", Permissions{}, true},
+	} {
+		got, err := ParsePermissions(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: got error %v, wanted error? %t", tc.in, err, tc.err)
+			continue
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("%q: got %+v, wanted %+v", tc.in, got, tc.want)
+		}
+		if err == nil {
+			if back, _ := ParsePermissions(got.String()); back != got {
+				t.Errorf("%q: String %q parsed back as %+v", tc.in, got.String(), back)
+			}
+		}
+	}
+}
+
+func TestPermissionsArgs(t *testing.T) {
+	if args := (Permissions{}).pdftkArgs(); args != nil {
+		t.Errorf("no permissions: got %q", args)
+	}
+	p := Permissions{Printing: true, Copying: true}
+	if got, want := p.pdftkArgs(), []string{"allow", "Printing", "CopyContents"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if got := p.bits(); got&(1<<2) == 0 || got&(1<<4) == 0 || got&(1<<3) != 0 {
+		t.Errorf("bits: got %b", uint32(got))
+	}
+	if got := (Permissions{}).bits(); got >= 0 || got&(1<<2|1<<3|1<<4) != 0 {
+		t.Errorf("no permission bits: got %b", uint32(got))
+	}
+}
+
+func TestEncryptOpts(t *testing.T) {
+	opts := EncryptOpts{OwnerPassword: "s3cret", Permissions: Permissions{Printing: true}}
+	if err := opts.Validate(); err != nil {
+		t.Errorf("owner password only: %v", err)
+	}
+	if s := opts.String(); s == "" || strings.Contains(s, "s3cret") {
+		t.Errorf("String: got %q", s)
+	}
+	if err := (EncryptOpts{Permissions: Permissions{Copying: true}}).Validate(); err == nil {
+		t.Error("permissions without password: wanted error")
+	}
+	if got := scrubPasswords("pdftk owner_pw s3cret failed", "", "s3cret"); strings.Contains(got, "s3cret") {
+		t.Errorf("scrubPasswords: got %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PdfGetInfo returns the document info (Title, Author, Subject, Keywords...) of srcfn.
+func PdfGetInfo(ctx context.Context, srcfn string) (map[string]string, error) {
+	out, err := outputWithContext(ctx, exec.Command(*ConfPdftk, srcfn, "dump_data_utf8"))
+	if err == nil {
+		return parseDumpDataInfo(out), nil
+	}
+	pdfinfo := getPopplerOk()["pdfinfo"]
+	if pdfinfo == "" {
+		return nil, errors.Wrapf(err, "pdftk dump_data_utf8 %s", srcfn)
+	}
+	if out, err = outputWithContext(ctx, exec.Command(pdfinfo, "-enc", "UTF-8", srcfn)); err != nil {
+		return nil, errors.Wrapf(err, "pdfinfo %s", srcfn)
+	}
+	return parsePdfinfoInfo(out), nil
+}
+
+// parseDumpDataInfo parses the InfoKey/InfoValue pairs of pdftk's dump_data output.
+func parseDumpDataInfo(out []byte) map[string]string {
+	info := make(map[string]string)
+	var key string
+	scan := bufio.NewScanner(bytes.NewReader(out))
+	for scan.Scan() {
+		line := scan.Text()
+		switch {
+		case strings.HasPrefix(line, "InfoBegin"):
+			key = ""
+		case strings.HasPrefix(line, "InfoKey: "):
+			key = html.UnescapeString(line[9:])
+		case strings.HasPrefix(line, "InfoValue: ") && key != "":
+			info[key] = html.UnescapeString(line[11:])
+			key = ""
+		}
+	}
+	return info
+}
+
+// pdfinfoKeys are the document info keys printed by pdfinfo.
+var pdfinfoKeys = []string{"Title", "Subject", "Keywords", "Author",
+	"Creator", "Producer", "CreationDate", "ModDate"}
+
+// parsePdfinfoInfo parses the document info keys of pdfinfo's output.
+func parsePdfinfoInfo(out []byte) map[string]string {
+	info := make(map[string]string)
+	scan := bufio.NewScanner(bytes.NewReader(out))
+	for scan.Scan() {
+		line := scan.Text()
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		for _, k := range pdfinfoKeys {
+			if line[:i] == k {
+				info[k] = strings.TrimSpace(line[i+1:])
+				break
+			}
+		}
+	}
+	return info
+}
+
+var infoEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;",
+	"\r\n", " ", "\n", " ", "\r", " ")
+
+// PdfSetInfo writes srcfn to destfn, with its document info updated with info.
+func PdfSetInfo(ctx context.Context, destfn, srcfn string, info map[string]string) error {
+	keys := make([]string, 0, len(info))
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString("InfoBegin\nInfoKey: " + infoEscaper.Replace(k) +
+			"\nInfoValue: " + infoEscaper.Replace(info[k]) + "\n")
+	}
+	return pdftkUpdateInfo(ctx, destfn, srcfn, buf.Bytes())
+}
+
+// pdftkUpdateInfo writes srcfn to destfn, updated with the given pdftk info data
+// (document info, bookmarks...).
+func pdftkUpdateInfo(ctx context.Context, destfn, srcfn string, data []byte) error {
+	fh, err := ioutil.TempFile(GetWorkdir(ctx), "info-")
+	if err != nil {
+		return errors.Wrap(err, "create info file")
+	}
+	infofn := fh.Name()
+	if !LeaveTempFiles {
+		defer func() { _ = os.Remove(infofn) }()
+	}
+	if _, err = fh.Write(data); err != nil {
+		_ = fh.Close()
+		return errors.Wrapf(err, "write %s", infofn)
+	}
+	if err = fh.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", infofn)
+	}
+	if err = call(ctx, *ConfPdftk, srcfn, "update_info_utf8", infofn, "output", destfn); err != nil {
+		return errors.Wrapf(err, "update info of %s", srcfn)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDumpDataInfo(t *testing.T) {
+	out := []byte(`InfoBegin
+InfoKey: Title
+InfoValue: Árvíztűrő &amp; tükörfúrógép
+InfoBegin
+InfoKey: Producer
+InfoValue: LibreOffice
+PdfID0: 2b4e9f
+NumberOfPages: 2
+`)
+	want := map[string]string{
+		"Title":    "Árvíztűrő & tükörfúrógép",
+		"Producer": "LibreOffice",
+	}
+	if got := parseDumpDataInfo(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestParsePdfinfoInfo(t *testing.T) {
+	out := []byte(`Title:          Árvíztűrő: tükörfúrógép
+Producer:       LibreOffice
+Pages:          2
+Encrypted:      no
+`)
+	want := map[string]string{
+		"Title":    "Árvíztűrő: tükörfúrógép",
+		"Producer": "LibreOffice",
+	}
+	if got := parsePdfinfoInfo(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+}
+
+func TestBookmarkData(t *testing.T) {
+	got := string(bookmarkData([]Bookmark{
+		{Title: "a <b>\nc", Page: 1},
+		{Title: "árvíz", Level: 2, Page: 3},
+	}))
+	want := `BookmarkBegin
+BookmarkTitle: a &lt;b&gt; c
+BookmarkLevel: 1
+BookmarkPageNumber: 1
+BookmarkBegin
+BookmarkTitle: árvíz
+BookmarkLevel: 2
+BookmarkPageNumber: 3
+`
+	if got != want {
+		t.Errorf("got\n%s\nwanted\n%s", got, want)
+	}
+}
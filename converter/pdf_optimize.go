@@ -0,0 +1,52 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PdfOptimize writes the compressed, garbage-collected srcfn to destfn,
+// with mutool if available, Ghostscript (one pdfwrite pass, with the PdfVersion
+// of the context) otherwise.
+// If the result would be larger than the input, destfn will be a copy of srcfn.
+func PdfOptimize(ctx context.Context, destfn, srcfn string) error {
+	Log := getLogger(ctx).Log
+	sfi, err := os.Stat(srcfn)
+	if err != nil {
+		return errors.Wrap(err, "stat source")
+	}
+	tmpfn := destfn + "-optimized.pdf"
+	defer func() { _ = os.Remove(tmpfn) }()
+	var tool string
+	if *ConfMutool != "" {
+		tool = *ConfMutool
+		err = call(ctx, tool, "clean", "-gggg", "-z", srcfn, tmpfn)
+	} else {
+		tool = *ConfGs
+		err = xToX(ctx, tmpfn, srcfn, false, "", getPdfVersion(ctx))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "optimize %s with %s", srcfn, tool)
+	}
+	dfi, err := os.Stat(tmpfn)
+	if err != nil {
+		return errors.Wrapf(err, "optimize %s with %s: no output", srcfn, tool)
+	}
+	Log("msg", "PdfOptimize", "tool", tool, "src", srcfn, "before", sfi.Size(), "after", dfi.Size())
+	if dfi.Size() >= sfi.Size() {
+		Log("msg", "PdfOptimize did not decrease the size, keeping the original", "src", srcfn)
+		err = copyFile(srcfn, destfn)
+	} else {
+		err = moveFile(tmpfn, destfn)
+	}
+	if err != nil {
+		return err
+	}
+	return pdfMakeDeterministic(destfn)
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestPdfOptimizeGs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-optimize-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(gs, mutool string, m int64) {
+		*ConfGs, *ConfMutool, *ConfGsMemLimit = gs, mutool, m
+	}(*ConfGs, *ConfMutool, *ConfGsMemLimit)
+	*ConfMutool, *ConfGsMemLimit = "", 0
+
+	// the fake gs records its arguments, and writes a small output file
+	*ConfGs = filepath.Join(dir, "gs")
+	script := "#!/bin/sh\necho \"$@\" >>" + filepath.Join(dir, "args") + "\n" +
+		"for a; do case \"$a\" in -sOutputFile=*) echo '%PDF-1.5' >\"${a#-sOutputFile=}\";; esac; done\n"
+	if err = ioutil.WriteFile(*ConfGs, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	srcfn, destfn := filepath.Join(dir, "src.pdf"), filepath.Join(dir, "dest.pdf")
+	if err = ioutil.WriteFile(srcfn, []byte("%PDF-1.4\n"+strings.Repeat("big ", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	ctx = WithPdfVersion(ctx, "1.5")
+	if err = PdfOptimize(ctx, destfn, srcfn); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(destfn); strings.TrimSpace(string(b)) != "%PDF-1.5" {
+		t.Errorf("got %q, wanted the optimized file", b)
+	}
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []string // without the --version of pdfwriteOpts
+	for _, line := range strings.Split(strings.TrimSpace(string(args)), "\n") {
+		if line != "--version" {
+			runs = append(runs, line)
+		}
+	}
+	if len(runs) != 1 || !strings.Contains(runs[0], "-sDEVICE=pdfwrite") ||
+		!strings.Contains(runs[0], "-dCompatibilityLevel=1.5") {
+		t.Errorf("wanted one pdfwrite pass, got %q", runs)
+	}
+}
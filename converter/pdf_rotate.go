@@ -0,0 +1,79 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// rotationDirection returns the pdftk page rotation suffix for degrees
+// (relative to the page's current orientation), "" for no rotation.
+func rotationDirection(degrees int) (string, error) {
+	if degrees%90 != 0 {
+		return "", errors.Errorf("rotation must be a multiple of 90, got %d", degrees)
+	}
+	switch (degrees%360 + 360) % 360 {
+	case 90:
+		return "right", nil
+	case 180:
+		return "down", nil
+	case 270:
+		return "left", nil
+	}
+	return "", nil
+}
+
+// PdfRotate rotates all pages of srcfn by degrees (clockwise, multiple of 90),
+// and writes the result to destfn.
+func PdfRotate(ctx context.Context, destfn, srcfn string, degrees int) error {
+	dir, err := rotationDirection(degrees)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		return copyFile(srcfn, destfn)
+	}
+	if err = call(ctx, *ConfPdftk, srcfn, "cat", "1-end"+dir, "output", destfn); err != nil {
+		return errors.Wrapf(err, "rotate %s by %d", srcfn, degrees)
+	}
+	return nil
+}
+
+// PdfRotatePages rotates the pages of srcfn individually - perPage maps
+// the (1-based) page number to the rotation degrees.
+// Pages not in perPage are left intact.
+func PdfRotatePages(ctx context.Context, destfn, srcfn string, perPage map[int]int) error {
+	n, err := PdfPageNum(ctx, srcfn)
+	if err != nil {
+		return err
+	}
+	for p := range perPage {
+		if p < 1 || p > n {
+			return errors.Errorf("page %d is out of range (1-%d)", p, n)
+		}
+	}
+	args := make([]string, 0, n+4)
+	args = append(args, srcfn, "cat")
+	var rotated bool
+	for i := 1; i <= n; i++ {
+		dir, err := rotationDirection(perPage[i])
+		if err != nil {
+			return errors.Wrapf(err, "page %d", i)
+		}
+		rotated = rotated || dir != ""
+		args = append(args, strconv.Itoa(i)+dir)
+	}
+	if !rotated {
+		return copyFile(srcfn, destfn)
+	}
+	args = append(args, "output", destfn)
+	if err = call(ctx, *ConfPdftk, args...); err != nil {
+		return errors.Wrapf(err, "rotate pages of %s", srcfn)
+	}
+	return nil
+}
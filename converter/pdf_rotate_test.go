@@ -0,0 +1,35 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import "testing"
+
+func TestRotationDirection(t *testing.T) {
+	for _, tc := range []struct {
+		degrees int
+		want    string
+		err     bool
+	}{
+		{0, "", false},
+		{90, "right", false},
+		{180, "down", false},
+		{270, "left", false},
+		{360, "", false},
+		{-90, "left", false},
+		{-180, "down", false},
+		{-270, "right", false},
+		{45, "", true},
+		{100, "", true},
+	} {
+		got, err := rotationDirection(tc.degrees)
+		if (err != nil) != tc.err {
+			t.Errorf("%d: got error %v, wanted error? %t", tc.degrees, err, tc.err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%d: got %q, wanted %q", tc.degrees, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ParsePageRanges parses the comma-separated page ranges, such as "5-10",
+// "3,1,2" or "1,4-end", of a document with n pages, and returns the pages in order.
+// A range may be descending ("10-5"), and the pages may repeat.
+// The pages outside of 1-n are ErrPageOutOfRange errors.
+func ParsePageRanges(s string, n int) ([]int, error) {
+	page := func(s string) (int, error) {
+		if s == "end" {
+			return n, nil
+		}
+		p, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errors.Errorf("bad page %q", s)
+		}
+		if p < 1 || p > n {
+			return 0, errors.Wrapf(ErrPageOutOfRange, "page %d of %d", p, n)
+		}
+		return p, nil
+	}
+	var pages []int
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		from, to := r, r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			from, to = strings.TrimSpace(r[:i]), strings.TrimSpace(r[i+1:])
+		}
+		a, err := page(from)
+		if err != nil {
+			return nil, err
+		}
+		b, err := page(to)
+		if err != nil {
+			return nil, err
+		}
+		if a <= b {
+			for p := a; p <= b; p++ {
+				pages = append(pages, p)
+			}
+		} else {
+			for p := a; p >= b; p-- {
+				pages = append(pages, p)
+			}
+		}
+	}
+	if len(pages) == 0 {
+		return nil, errors.Errorf("no pages in %q", s)
+	}
+	return pages, nil
+}
+
+// PdfMergeSelect writes the given (1-based) pages of srcfn, in the given order,
+// into destfn as one PDF - with pdftk cat; mutool merge is used only if
+// there's no pdftk. The pages should be checked with ParsePageRanges.
+func PdfMergeSelect(ctx context.Context, destfn, srcfn string, pages []int) error {
+	if len(pages) == 0 {
+		return errors.New("no pages selected")
+	}
+	if err := checkMaxPages(srcfn, len(pages)); err != nil {
+		return err
+	}
+	list := make([]string, len(pages))
+	for i, p := range pages {
+		if p < 1 {
+			return errors.Wrapf(ErrPageOutOfRange, "page %d", p)
+		}
+		list[i] = strconv.Itoa(p)
+	}
+	var err error
+	switch {
+	case *ConfPdftk != "" && lookPath(*ConfPdftk) != "":
+		args := append(append(make([]string, 0, len(list)+4), srcfn, "cat"), list...)
+		err = call(ctx, *ConfPdftk, append(args, "output", destfn)...)
+	case *ConfMutool != "" && lookPath(*ConfMutool) != "":
+		err = call(ctx, *ConfMutool, "merge", "-o", destfn, srcfn, strings.Join(list, ","))
+	default:
+		return errors.New("PdfMergeSelect: neither pdftk nor mutool is available")
+	}
+	if err != nil {
+		return errors.Wrapf(err, "select pages of %s", srcfn)
+	}
+	return nil
+}
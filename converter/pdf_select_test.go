@@ -0,0 +1,98 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestParsePageRanges(t *testing.T) {
+	for i, tc := range []struct {
+		ranges string
+		want   []int
+		err    error
+	}{
+		{"5-10", []int{5, 6, 7, 8, 9, 10}, nil},
+		{"3,1,2", []int{3, 1, 2}, nil},
+		{"1, 1,2-1", []int{1, 1, 2, 1}, nil},
+		{"9-end", []int{9, 10}, nil},
+		{"end-8", []int{10, 9, 8}, nil},
+		{"end", []int{10}, nil},
+		{"11", nil, ErrPageOutOfRange},
+		{"0-2", nil, ErrPageOutOfRange},
+		{"a-b", nil, nil},
+		{" , ", nil, nil},
+	} {
+		got, err := ParsePageRanges(tc.ranges, 10)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%d. %q: wanted error, got %v", i, tc.ranges, got)
+			} else if tc.err != nil && errors.Cause(err) != tc.err {
+				t.Errorf("%d. %q: got error %v, wanted %v", i, tc.ranges, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. %q: %v", i, tc.ranges, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. %q: got %v, wanted %v", i, tc.ranges, got, tc.want)
+		}
+	}
+}
+
+func TestPdfMergeSelectPdftk(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-select-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the fake tools write their name and arguments into ARGS
+	argsfn := filepath.Join(dir, "args")
+	fake := func(name string) string {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, []byte("#!/bin/sh\necho "+name+" \"$@\" >"+argsfn+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	defer func(pdftk, mutool string) { *ConfPdftk, *ConfMutool = pdftk, mutool }(*ConfPdftk, *ConfMutool)
+	*ConfPdftk, *ConfMutool = fake("pdftk"), fake("mutool")
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	for i, tc := range []struct {
+		pdftk bool
+		want  string
+	}{
+		{true, "pdftk a.pdf cat 3 1 1 output b.pdf"},
+		{false, "mutool merge -o b.pdf a.pdf 3,1,1"},
+	} {
+		if !tc.pdftk {
+			*ConfPdftk = ""
+		}
+		if err = PdfMergeSelect(ctx, "b.pdf", "a.pdf", []int{3, 1, 1}); err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		b, _ := ioutil.ReadFile(argsfn)
+		if got := strings.TrimSpace(string(b)); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
@@ -9,11 +9,18 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/kylelemons/godebug/diff"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
 )
 
 func TestDumpFields(t *testing.T) {
@@ -38,7 +45,7 @@ func TestGetFdf(t *testing.T) {
 	defer os.RemoveAll(Workdir)
 
 	s := time.Now()
-	fp1, err := getFdf("testdata/f1040.pdf")
+	fp1, err := getFdf(context.Background(), "testdata/f1040.pdf")
 	t.Logf("PDF -> FDF vanilla route: %s", time.Since(s))
 	if err != nil {
 		t.Errorf("getFdf: %v", err)
@@ -52,7 +59,7 @@ func TestGetFdf(t *testing.T) {
 	}
 
 	s = time.Now()
-	fp2, err := getFdf("testdata/f1040.pdf")
+	fp2, err := getFdf(context.Background(), "testdata/f1040.pdf")
 	t.Logf("gob -> FDF route: %s", time.Since(s))
 	if err != nil {
 		t.Errorf("getFdf2: %v", err)
@@ -370,11 +377,12 @@ trailer
 `)
 
 	fp := splitFdf(fdf)
-	if len(fp.Parts) != 64 {
-		t.Errorf("wanted 64 parts, got %d", len(fp.Parts))
+	// 63 text fields and 6 checkboxes
+	if len(fp.Parts) != 70 {
+		t.Errorf("wanted 70 parts, got %d", len(fp.Parts))
 	}
-	if len(fp.Fields) != 63 {
-		t.Errorf("wanted 63 fields, got %d", len(fp.Fields))
+	if len(fp.Fields) != 69 {
+		t.Errorf("wanted 69 fields, got %d", len(fp.Fields))
 	}
 	t.Logf("splitted=%q (%d)", fp, len(fp.Parts))
 
@@ -684,3 +692,531 @@ trailer
 		t.Errorf("mismatch: %s", df)
 	}
 }
+
+func TestIsEncryptionError(t *testing.T) {
+	for i, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("exit status 1 while converting Error: Unable to find file."), false},
+		{errors.New("exit status 3 while converting OWNER PASSWORD REQUIRED, but not given (or incorrect)"), true},
+		{errors.New("Permission Error: Not allowed to extract pages"), true},
+	} {
+		if got := isEncryptionError(tc.err); got != tc.want {
+			t.Errorf("%d. %v: got %t, wanted %t", i, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestIsParseError(t *testing.T) {
+	for i, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("exit status 1 while converting Error: Unable to find file."), false},
+		{errors.New("exit status 1 while converting Syntax Error: Couldn't read xref table"), true},
+		{errors.New("Error: Unexpected Exception in open_reader()\nPdfReader not initialized"), true},
+		{errors.New("Syntax Error: Incorrect password"), false},
+	} {
+		if got := isParseError(tc.err); got != tc.want {
+			t.Errorf("%d. %v: got %t, wanted %t", i, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestCheckMaxPages(t *testing.T) {
+	defer func(limit int) { *ConfMaxPages = limit }(*ConfMaxPages)
+	for i, tc := range []struct {
+		limit, n int
+		want     error
+	}{
+		{0, 50000, nil},
+		{10, 1, nil},
+		{10, 10, nil},
+		{10, 11, ErrTooManyPages},
+	} {
+		*ConfMaxPages = tc.limit
+		if got := errors.Cause(checkMaxPages("x.pdf", tc.n)); got != tc.want {
+			t.Errorf("%d. %d/%d: got %v, wanted %v", i, tc.n, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestPdfSplitPageNumError(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-split-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the fake pdftk fails, so the page number cannot be determined
+	fake := filepath.Join(dir, "pdftk")
+	if err = ioutil.WriteFile(fake, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	srcfn := filepath.Join(dir, "a.pdf")
+	if err = ioutil.WriteFile(srcfn, []byte("%PDF-1.4 broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(pdftk, mutool, clean, workdir string, limit int) {
+		*ConfPdftk, *ConfMutool, *ConfCleanTools, Workdir, *ConfMaxPages = pdftk, mutool, clean, workdir, limit
+	}(*ConfPdftk, *ConfMutool, *ConfCleanTools, Workdir, *ConfMaxPages)
+	*ConfPdftk, *ConfMutool, *ConfCleanTools, Workdir, *ConfMaxPages = fake, "", "", dir, 10
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	if fns, err := PdfSplit(ctx, srcfn); err == nil || !strings.Contains(err.Error(), "page number") {
+		t.Fatalf("split without the page number: got %q, %v", fns, err)
+	}
+	fis, _ := ioutil.ReadDir(dir)
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), "-split") {
+			t.Errorf("split is run: %s", fi.Name())
+		}
+	}
+}
+
+func TestPdfPageNumCancel(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-pagenum-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fake := filepath.Join(dir, "pdftk")
+	if err = ioutil.WriteFile(fake, []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(pdftk, mutool string) { *ConfPdftk, *ConfMutool = pdftk, mutool }(*ConfPdftk, *ConfMutool)
+	*ConfPdftk, *ConfMutool = fake, ""
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(),
+		"logger", log.NewContext(log.NewNopLogger())), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if n, err := PdfPageNum(ctx, filepath.Join(dir, "a.pdf")); err == nil {
+		t.Errorf("got %d pages after cancel", n)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the page counting is not killed, ran for %s", d)
+	}
+}
+
+func TestSplitDestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-split-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reqdir := filepath.Join(dir, "req")
+	if err = os.Mkdir(reqdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { Workdir = old }(Workdir)
+	Workdir = dir
+
+	ctx := context.WithValue(context.Background(), "workdir", reqdir)
+	_, destdir, prefix, err := splitDestDir(ctx, "a%b.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(destdir) != reqdir {
+		t.Errorf("got %q, wanted it in the request workdir %q", destdir, reqdir)
+	}
+	if prefix != "a!P!b.pdf-" {
+		t.Errorf("got prefix %q", prefix)
+	}
+}
+
+func TestHasText(t *testing.T) {
+	for in, want := range map[string]bool{
+		"":                       false,
+		"\f\f\f":                 false,
+		" 1 \f 2 \f":             false,
+		"Árvíztűrő tükörfúrógép": true,
+	} {
+		if got := hasText(in); got != want {
+			t.Errorf("%q: got %t, wanted %t", in, got, want)
+		}
+	}
+}
+
+func TestPdfwriteOpts(t *testing.T) {
+	for i, tc := range []struct {
+		out  string
+		want int
+	}{
+		{"9.50\n", 9},
+		{"10.02.1", 10},
+		{"8.71", 8},
+		{"", 0},
+	} {
+		if got := parseGsMajorVersion([]byte(tc.out)); got != tc.want {
+			t.Errorf("%d. got %d, wanted %d", i, got, tc.want)
+		}
+	}
+
+	gsVersionMu.Lock()
+	gsVersionCache["gs-old"], gsVersionCache["gs-new"] = 8, 9
+	gsVersionMu.Unlock()
+	if got := pdfwriteOpts("gs-new", GsEbook); len(got) != 1 || got[0] != "-dPDFSETTINGS=/ebook" {
+		t.Errorf("gs 9: got %q", got)
+	}
+	if got := pdfwriteOpts("gs-old", ""); len(got) != 2 || got[1] != "-dUseCIEColor=true" {
+		t.Errorf("gs 8: got %q", got)
+	}
+	if err := GsProfile("best").Validate(); err == nil {
+		t.Errorf("unknown profile should be invalid")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for i, tc := range []struct {
+		out  string
+		want bool
+	}{
+		{"gm convert: unable to acquire semaphore (Resource temporarily unavailable).", true},
+		{"GPL Ghostscript 9.50: Unrecoverable error, exit code 1\nError: /VMerror in --showpage--", true},
+		{"Error: /syntaxerror in --token--", false},
+		{"gm convert: Improper image header (x.jpg).", false},
+		{"", false},
+	} {
+		if got := isTransient([]byte(tc.out)); got != tc.want {
+			t.Errorf("%d. got %t, wanted %t", i, got, tc.want)
+		}
+	}
+	if d := retryBackoff(3); d != 2*time.Second {
+		t.Errorf("backoff(3): got %s", d)
+	}
+}
+
+func TestParseDumpDataFields(t *testing.T) {
+	const out = `---
+FieldType: Text
+FieldName: topmostSubform[0].Page1[0].f1_01[0]
+FieldNameAlt: First name
+FieldFlags: 8388608
+FieldValue: John
+FieldJustification: Left
+FieldMaxLength: 20
+---
+FieldType: Button
+FieldName: c1_1
+FieldFlags: 0
+FieldValue: Off
+FieldStateOption: 1
+FieldStateOption: Off
+---
+FieldType: Button
+FieldName: r1
+FieldFlags: 49152
+FieldStateOption: A
+FieldStateOption: B
+---
+FieldType: Choice
+FieldName: ch
+FieldValueDefault: x
+FieldStateOption: x
+FieldStateOption: y
+`
+	fields, err := parseDumpDataFields(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PdfField{
+		{Name: "topmostSubform[0].Page1[0].f1_01[0]", AltName: "First name", Type: "text",
+			Flags: 8388608, Value: "John", Justification: "Left", MaxLength: 20},
+		{Name: "c1_1", Type: "checkbox", Value: "Off", Options: []string{"1", "Off"}},
+		{Name: "r1", Type: "radio", Flags: 49152, Options: []string{"A", "B"}},
+		{Name: "ch", Type: "choice", DefaultValue: "x", Options: []string{"x", "y"}},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got\n%+v,\nwanted\n%+v", fields, want)
+	}
+}
+
+func TestFillButtons(t *testing.T) {
+	fp := splitFdf([]byte(`%FDF-1.2
+1 0 obj
+<<
+/FDF
+<<
+/Fields [
+<<
+/V ()
+/T (name)
+>>
+<<
+/V /
+/T (agree)
+>>
+<<
+/V /B#20c
+/T (choice)
+>>
+<<
+/V ()
+/T (empty)
+>>]
+>>
+>>
+endobj
+%%EOF
+`))
+	if len(fp.Fields) != 4 {
+		t.Fatalf("wanted 4 fields, got %q", fp.Fields)
+	}
+	if got := fp.Values["choice"]; got != "B c" {
+		t.Errorf("choice: got %q, wanted %q", got, "B c")
+	}
+	fp.setButtons([]PdfField{
+		{Name: "name", Type: "text"},
+		{Name: "agree", Type: "checkbox", Options: []string{"Off", "Yes"}},
+		{Name: "choice", Type: "radio", Options: []string{"A", "B c", "Off"}},
+		{Name: "empty", Type: "checkbox", Options: []string{"On", "Off"}},
+	})
+	for _, tc := range []struct {
+		key, value string
+		err        bool
+	}{
+		{"name", "Árvíztűrő", false},
+		{"agree", "true", false},
+		{"choice", "C", true},
+		{"choice", "A", false},
+		{"empty", "On", false},
+		{"empty", "off", false},
+	} {
+		err := fp.Set(tc.key, tc.value)
+		if tc.err {
+			if _, ok := err.(*InvalidFieldValueError); !ok {
+				t.Errorf("%s=%q: wanted InvalidFieldValueError, got %v", tc.key, tc.value, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s=%q: %v", tc.key, tc.value, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := fp.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"/V /Yes\n/T (agree)", "/V /A\n/T (choice)", "/V /Off\n/T (empty)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not in %q", want, got)
+		}
+	}
+	if !strings.Contains(got, "/V (\xfe\xff") {
+		t.Errorf("text value not written: %q", got)
+	}
+}
+
+func TestPdfFillArgs(t *testing.T) {
+	for i, tc := range []struct {
+		fill, flatten bool
+		want          []string
+	}{
+		{true, false, []string{"in.pdf", "fill_form", "-", "output", "out.pdf"}},
+		{true, true, []string{"in.pdf", "fill_form", "-", "output", "out.pdf", "flatten"}},
+		{false, true, []string{"in.pdf", "output", "out.pdf", "flatten"}},
+	} {
+		if got := pdfFillArgs("out.pdf", "in.pdf", tc.fill, tc.flatten); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+// formPdf returns a one page PDF with a text field named name.
+func formPdf(name string) []byte {
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [4 0 R] /DA (/Helv 12 Tf 0 g) /DR << /Font << /Helv 5 0 R >> >> >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Annots [4 0 R] /Resources << /Font << /Helv 5 0 R >> >> >>",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (" + name + ") /Rect [50 700 550 730] /P 3 0 R /F 4 /DA (/Helv 12 Tf 0 g) >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Name /Helv >>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = buf.Len()
+		buf.WriteString(strconv.Itoa(i+1) + " 0 obj\n" + o + "\nendobj\n")
+	}
+	xref := buf.Len()
+	buf.WriteString("xref\n0 " + strconv.Itoa(len(objs)+1) + "\n0000000000 65535 f \n")
+	for _, off := range offsets {
+		s := strconv.Itoa(off)
+		buf.WriteString(strings.Repeat("0", 10-len(s)) + s + " 00000 n \n")
+	}
+	buf.WriteString("trailer\n<< /Size " + strconv.Itoa(len(objs)+1) + " /Root 1 0 R >>\nstartxref\n" +
+		strconv.Itoa(xref) + "\n%%EOF\n")
+	return buf.Bytes()
+}
+
+func TestPdfFillFdfFlattenHungarian(t *testing.T) {
+	if *ConfPdftk == "" || (*ConfPdftotext == "" && *ConfMutool == "") {
+		t.Skip("pdftk and pdftotext or mutool are needed")
+	}
+	dir, err := ioutil.TempDir("", "agostle-flatten-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { Workdir = old }(Workdir)
+	Workdir = dir
+
+	inpfn, destfn := filepath.Join(dir, "form.pdf"), filepath.Join(dir, "filled.pdf")
+	if err = ioutil.WriteFile(inpfn, formPdf("name"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	const value = "Árvíztűrő tükörfúrógép"
+	if err = PdfFillFdfFlatten(ctx, destfn, inpfn, map[string]string{"name": value}); err != nil {
+		t.Fatal(err)
+	}
+	// the value is static text now
+	txt, err := PdfExtractText(ctx, destfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(txt, value) {
+		t.Errorf("got %q, wanted %q in the text", txt, value)
+	}
+	fields, err := PdfDumpFields(destfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 0 {
+		t.Errorf("the form is not flattened: %v", fields)
+	}
+}
+
+func TestPdfCleanTools(t *testing.T) {
+	if got, want := cleanTools(" MuTool, ,pdfclean,gs "), []string{"mutool", "pdfclean", "gs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanTools: got %q, wanted %q", got, want)
+	}
+
+	fh, err := ioutil.TempFile("", "agostle-clean-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := fh.Name()
+	_ = fh.Close()
+	defer os.Remove(fn)
+	old := *ConfCleanTools
+	defer func() { *ConfCleanTools = old }()
+	*ConfCleanTools = "nonexistent"
+	if err = PdfClean(context.Background(), fn); err == nil || !strings.Contains(err.Error(), "no usable PDF cleaner") {
+		t.Errorf("got %v, wanted no usable PDF cleaner", err)
+	}
+	if isAlreadyCleaned(fn) {
+		t.Error("failed clean is cached")
+	}
+}
+
+func TestHoldWriter(t *testing.T) {
+	var buf bytes.Buffer
+	hw := &holdWriter{w: &buf, hold: 4}
+	if _, err := hw.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 || hw.n != 0 {
+		t.Fatalf("written %q before reaching the hold size", buf.String())
+	}
+	if _, err := hw.Write([]byte("def")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "abcdef" || hw.n != 6 {
+		t.Errorf("got %q (%d), wanted everything written after the hold size", buf.String(), hw.n)
+	}
+
+	buf.Reset()
+	hw = &holdWriter{w: &buf, hold: 4}
+	_, _ = hw.Write([]byte("ab"))
+	if err := hw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ab" || hw.n != 2 {
+		t.Errorf("got %q (%d) after Flush", buf.String(), hw.n)
+	}
+}
+
+// fakeSplit returns a command writing n pages (p1.pdf, p2.pdf...) into dir, slowly.
+func fakeSplit(t *testing.T, dir string, n int) (*exec.Cmd, func(int) string) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	cmd := exec.Command("sh", "-c", `i=1; while [ $i -le $0 ]; do : >p$i.pdf; sleep 0.05; i=$((i+1)); done`, strconv.Itoa(n))
+	cmd.Dir = dir
+	return cmd, func(i int) string { return filepath.Join(dir, "p"+strconv.Itoa(i)+".pdf") }
+}
+
+// waitRemoved waits for dir to be removed.
+func waitRemoved(t *testing.T, dir string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return
+		}
+	}
+	t.Errorf("%s has not been removed", dir)
+}
+
+func TestSplitChan(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	dir, err := ioutil.TempDir("", "agostle-splitchan-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd, pageFn := fakeSplit(t, dir, 5)
+	ch, err := splitChan(ctx, cmd, dir, 5, pageFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []int
+	for p := range ch {
+		if p.Error != nil {
+			t.Fatal(p.Error)
+		}
+		if p.Filename != pageFn(p.Page) {
+			t.Errorf("page %d: got %q, wanted %q", p.Page, p.Filename, pageFn(p.Page))
+		}
+		pages = append(pages, p.Page)
+	}
+	if !reflect.DeepEqual(pages, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("got pages %v, wanted 1-5", pages)
+	}
+}
+
+func TestSplitChanCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(),
+		"logger", log.NewContext(log.NewNopLogger())))
+	defer cancel()
+	dir, err := ioutil.TempDir("", "agostle-splitchan-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd, pageFn := fakeSplit(t, dir, 100)
+	ch, err := splitChan(ctx, cmd, dir, 100, pageFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := <-ch; p.Error != nil || p.Page != 1 {
+		t.Fatalf("got %#v, wanted page 1", p)
+	}
+	// stop reading: the sender must not block, and must remove the pages
+	cancel()
+	waitRemoved(t, dir)
+	for p := range ch {
+		if p.Error == nil && p.Page > 3 {
+			t.Errorf("got page %d after cancel", p.Page)
+		}
+	}
+}
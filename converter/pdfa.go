@@ -0,0 +1,148 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrNoPDFAValidator is returned by PdfValidatePDFA when there's no validator (veraPDF).
+var ErrNoPDFAValidator = errors.New("PDF/A validator not available (veraPDF is needed)")
+
+// pdfaFailures are the Ghostscript messages which mean that the output
+// is not conformant.
+var pdfaFailures = []string{
+	"Reverting to normal PDF output",
+	"Aborting",
+	"**** Error",
+}
+
+// PdfToPDFA converts srcfn to PDF/A (level is "1b" or "2b", "1" or "2"), using Ghostscript.
+// If Ghostscript says the result is not conformant, destfn is removed, and an error is returned.
+func PdfToPDFA(ctx context.Context, destfn, srcfn string, level string) error {
+	level = strings.TrimSuffix(strings.ToLower(level), "b")
+	switch level {
+	case "1", "2":
+	default:
+		return errors.Errorf("unsupported PDF/A level %q (1b or 2b)", level)
+	}
+	args := append([]string{"-P-", "-dSAFER", "-dNOPAUSE", "-dBATCH", "-q",
+		"-dPDFA=" + level, "-dPDFACompatibilityPolicy=1",
+		"-sColorConversionStrategy=UseDeviceIndependentColor",
+		"-sProcessColorModel=DeviceRGB"},
+		gsLimitArgs()...)
+	args = append(args,
+		"-sDEVICE=pdfwrite", "-sstdout=%stderr",
+		"-sOutputFile="+destfn,
+		"PDFA_def.ps", srcfn)
+	out, err := executeOutput(ctx, gsCommand(args...), gsRetryable)
+	if err != nil {
+		_ = os.Remove(destfn)
+		return errors.Wrapf(gsLimitError(err), "converting %s to PDF/A-%sb", srcfn, level)
+	}
+	if msg := pdfaFailure(out); msg != "" {
+		_ = os.Remove(destfn)
+		return errors.Errorf("converting %s to PDF/A-%sb: %s", srcfn, level, msg)
+	}
+	return nil
+}
+
+// pdfaFailure returns the first line of the Ghostscript output which means
+// a PDF/A conversion failure, or "".
+func pdfaFailure(out []byte) string {
+	scan := bufio.NewScanner(bytes.NewReader(out))
+	for scan.Scan() {
+		line := scan.Text()
+		for _, f := range pdfaFailures {
+			if strings.Contains(line, f) {
+				return strings.TrimSpace(line)
+			}
+		}
+	}
+	return ""
+}
+
+// PdfValidatePDFA checks the PDF/A conformance of srcfn (of the level claimed
+// in its metadata) with veraPDF, and returns whether it is conformant, and the
+// violated rules.
+//
+// Ghostscript cannot check an existing file, so without veraPDF (ConfVeraPDF)
+// it returns ErrNoPDFAValidator - instead of a false pass.
+func PdfValidatePDFA(ctx context.Context, srcfn string) (bool, []string, error) {
+	if *ConfVeraPDF == "" || lookPath(*ConfVeraPDF) == "" {
+		return false, nil, ErrNoPDFAValidator
+	}
+	var out, errout bytes.Buffer
+	cmd := exec.Command(*ConfVeraPDF, "--format", "xml", srcfn)
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	// veraPDF exits with 1 for non-conformant files, so the error is checked
+	// only if the report cannot be parsed.
+	runErr := runWithTimeout(ctx, cmd)
+	ok, violations, err := parseVeraPDFReport(out.Bytes())
+	if err != nil {
+		if runErr != nil {
+			err = runErr
+		}
+		return false, nil, errors.Wrapf(err, "veraPDF %s: %s", srcfn, errout.Bytes())
+	}
+	return ok, violations, nil
+}
+
+// veraPDFReport is the part of veraPDF's XML (machine readable) report we need.
+type veraPDFReport struct {
+	Jobs []struct {
+		Validation *struct {
+			Profile   string `xml:"profileName,attr"`
+			Compliant bool   `xml:"isCompliant,attr"`
+			Rules     []struct {
+				Specification string `xml:"specification,attr"`
+				Clause        string `xml:"clause,attr"`
+				TestNumber    string `xml:"testNumber,attr"`
+				Status        string `xml:"status,attr"`
+				Description   string `xml:"description"`
+			} `xml:"details>rule"`
+		} `xml:"validationReport"`
+		Exception *struct {
+			Message string `xml:"exceptionMessage"`
+		} `xml:"taskException"`
+	} `xml:"jobs>job"`
+}
+
+// parseVeraPDFReport parses the XML report of veraPDF, returning the conformance
+// and the failed rules as "specification clause-test: description".
+func parseVeraPDFReport(report []byte) (bool, []string, error) {
+	var rep veraPDFReport
+	if err := xml.Unmarshal(report, &rep); err != nil {
+		return false, nil, errors.Wrap(err, "parse veraPDF report")
+	}
+	if len(rep.Jobs) == 0 {
+		return false, nil, errors.New("no job in the veraPDF report")
+	}
+	job := rep.Jobs[0]
+	if job.Validation == nil {
+		if job.Exception != nil {
+			return false, nil, errors.Errorf("veraPDF: %s", strings.TrimSpace(job.Exception.Message))
+		}
+		return false, nil, errors.New("no validation in the veraPDF report")
+	}
+	var violations []string
+	for _, r := range job.Validation.Rules {
+		if r.Status != "failed" {
+			continue
+		}
+		violations = append(violations, r.Specification+" "+r.Clause+"-"+r.TestNumber+": "+
+			strings.Join(strings.Fields(r.Description), " "))
+	}
+	return job.Validation.Compliant, violations, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestPdfaFailure(t *testing.T) {
+	for i, tc := range []struct {
+		out, want string
+	}{
+		{"", ""},
+		{"GPL Ghostscript 9.20: Setting Overprint Mode to 1\n", ""},
+		{"GPL Ghostscript 9.20: Setting Overprint Mode to 1\n" +
+			"   **** Error: Transparency is not allowed in PDF/A-1\n   Reverting to normal PDF output\n",
+			"**** Error: Transparency is not allowed in PDF/A-1"},
+	} {
+		if got := pdfaFailure([]byte(tc.out)); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestPdfToPDFA(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-pdfa-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(gs string, m int64) { *ConfGs, *ConfGsMemLimit = gs, m }(*ConfGs, *ConfGsMemLimit)
+	*ConfGsMemLimit = 0
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+
+	// the fake gs writes the output file, and the message in $MSG
+	*ConfGs = filepath.Join(dir, "gs")
+	script := "#!/bin/sh\nfor a; do case \"$a\" in -sOutputFile=*) echo '%PDF-1.4' >\"${a#-sOutputFile=}\";; esac; done\n" +
+		"echo \"$MSG\"\n"
+	if err = ioutil.WriteFile(*ConfGs, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("MSG")
+	destfn := filepath.Join(dir, "a.pdf")
+	for i, tc := range []struct {
+		msg    string
+		wantOK bool
+	}{
+		{"GPL Ghostscript 9.20: Setting Overprint Mode to 1", true},
+		{"   **** Error: Transparency is not allowed in PDF/A-1", false},
+	} {
+		_ = os.Setenv("MSG", tc.msg)
+		err := PdfToPDFA(ctx, destfn, "src.pdf", "1b")
+		_, statErr := os.Stat(destfn)
+		if tc.wantOK && (err != nil || statErr != nil) {
+			t.Errorf("%d. got %v (stat: %v)", i, err, statErr)
+		} else if !tc.wantOK && (err == nil || !os.IsNotExist(statErr)) {
+			t.Errorf("%d. got %v, wanted error, and %s removed (stat: %v)", i, err, destfn, statErr)
+		}
+	}
+}
+
+func TestParseVeraPDFReport(t *testing.T) {
+	const failed = `<?xml version="1.0" encoding="utf-8"?>
+<report>
+  <jobs>
+    <job>
+      <item size="1234"><name>/tmp/a.pdf</name></item>
+      <validationReport profileName="PDF/A-1B validation profile" statement="PDF file is not compliant with Validation Profile requirements." isCompliant="false">
+        <details passedRules="120" failedRules="2" passedChecks="300" failedChecks="3">
+          <rule specification="ISO 19005-1:2005" clause="6.1.7" testNumber="1" status="failed" failedChecks="1">
+            <description>The stream dictionary shall not contain
+              the F key</description>
+          </rule>
+          <rule specification="ISO 19005-1:2005" clause="6.3.5" testNumber="2" status="failed" failedChecks="2">
+            <description>Font programs shall be embedded</description>
+          </rule>
+        </details>
+      </validationReport>
+    </job>
+  </jobs>
+</report>`
+	ok, violations, err := parseVeraPDFReport([]byte(failed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ISO 19005-1:2005 6.1.7-1: The stream dictionary shall not contain the F key",
+		"ISO 19005-1:2005 6.3.5-2: Font programs shall be embedded",
+	}
+	if ok || !reflect.DeepEqual(violations, want) {
+		t.Errorf("got %t, %q; wanted false, %q", ok, violations, want)
+	}
+
+	const passed = `<report><jobs><job><validationReport isCompliant="true">
+<details passedRules="120" failedRules="0"></details></validationReport></job></jobs></report>`
+	if ok, violations, err = parseVeraPDFReport([]byte(passed)); err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(violations) != 0 {
+		t.Errorf("got %t, %q; wanted true", ok, violations)
+	}
+
+	const exception = `<report><jobs><job><taskException type="PARSE">
+<exceptionMessage>Couldn't parse stream</exceptionMessage></taskException></job></jobs></report>`
+	if _, _, err = parseVeraPDFReport([]byte(exception)); err == nil {
+		t.Error("wanted error for the exception")
+	}
+}
+
+func TestPdfValidatePDFANoValidator(t *testing.T) {
+	old := *ConfVeraPDF
+	defer func() { *ConfVeraPDF = old }()
+	*ConfVeraPDF = ""
+	if _, _, err := PdfValidatePDFA(context.Background(), "a.pdf"); err != ErrNoPDFAValidator {
+		t.Errorf("got %v, wanted ErrNoPDFAValidator", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrSignedPdf is returned when a digitally signed PDF would be rewritten,
+// and ConfPreserveSignatures is set.
+var ErrSignedPdf = errors.New("the PDF is digitally signed")
+
+// PdfHasSignature reports whether the PDF contains a digital signature,
+// using pdfsig if available, pdftk otherwise (where any signature field counts).
+func PdfHasSignature(ctx context.Context, srcfn string) (bool, error) {
+	if pdfsig := getPopplerOk()["pdfsig"]; pdfsig != "" {
+		// pdfsig exits with non-zero status when there's no signature,
+		// so its output is needed regardless of the error.
+		var buf bytes.Buffer
+		cmd := exec.Command(pdfsig, srcfn)
+		cmd.Stdout, cmd.Stderr = &buf, &buf
+		err := runWithContext(ctx, cmd)
+		out := buf.Bytes()
+		if signed, ok := parsePdfsig(out); ok {
+			return signed, nil
+		}
+		if err == nil {
+			err = errors.New("unknown output")
+		}
+		return false, errors.Wrapf(err, "pdfsig %s: %s", srcfn, out)
+	}
+	fields, err := PdfDumpFieldsFull(ctx, srcfn)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range fields {
+		if f.Type == "signature" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parsePdfsig parses the output of pdfsig; ok is false if it's not understood.
+func parsePdfsig(out []byte) (signed, ok bool) {
+	switch {
+	case bytes.Contains(out, []byte("does not contain any signatures")):
+		return false, true
+	case bytes.Contains(out, []byte("Signature #")):
+		return true, true
+	}
+	return false, false
+}
+
+// signatureGuard reports whether the op (such as "splitting") may rewrite
+// the file of the item: it may, unless the file is signed and ConfPreserveSignatures is set.
+// For signed files, a warning is logged and recorded in the item's part.
+func signatureGuard(ctx context.Context, item ArchFileItem, op string) bool {
+	if item.Part == nil || !item.Part.Signed {
+		return true
+	}
+	name := item.Part.FileName
+	if name == "" {
+		name = filepath.Base(item.Filename)
+	}
+	if *ConfPreserveSignatures {
+		getLogger(ctx).Log("msg", "signed PDF is kept as is", "file", item.Filename, "op", op)
+		item.Part.Warnings = append(item.Part.Warnings,
+			name+": digitally signed, kept as is (no "+op+")")
+		return false
+	}
+	getLogger(ctx).Log("msg", "WARN rewriting invalidates the digital signature", "file", item.Filename, "op", op)
+	item.Part.Warnings = append(item.Part.Warnings,
+		name+": the digital signature is invalidated by "+op)
+	return true
+}
+
+// checkSignatures returns ErrSignedPdf for the first signed PDF of filenames
+// (which would be invalidated by merging them), if ConfPreserveSignatures is set.
+// Without it, the files are not probed at all.
+func checkSignatures(ctx context.Context, filenames []string) error {
+	if !*ConfPreserveSignatures {
+		return nil
+	}
+	for _, fn := range filenames {
+		signed, err := PdfHasSignature(ctx, fn)
+		if err != nil {
+			getLogger(ctx).Log("msg", "PdfHasSignature", "file", fn, "error", err)
+			continue
+		}
+		if signed {
+			return errors.Wrap(ErrSignedPdf, fn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+func TestParsePdfsig(t *testing.T) {
+	for i, tc := range []struct {
+		out        string
+		signed, ok bool
+	}{
+		{"File 'a.pdf' does not contain any signatures\n", false, true},
+		{`Digital Signature Info of: a.pdf
+Signature #1:
+  - Signer Certificate Common Name: John Doe
+  - Signature Validation: Signature is Valid.
+`, true, true},
+		{"Syntax Error: Couldn't read xref table\n", false, false},
+	} {
+		signed, ok := parsePdfsig([]byte(tc.out))
+		if signed != tc.signed || ok != tc.ok {
+			t.Errorf("%d. got %t,%t, wanted %t,%t", i, signed, ok, tc.signed, tc.ok)
+		}
+	}
+}
+
+func TestSignatureGuard(t *testing.T) {
+	defer func(preserve bool) { *ConfPreserveSignatures = preserve }(*ConfPreserveSignatures)
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+
+	plain := ArchFileItem{Filename: "/tmp/a.pdf", Part: &PartInfo{Seq: 1}}
+	signed := ArchFileItem{Filename: "/tmp/b.pdf", Part: &PartInfo{Seq: 2, FileName: "b.pdf", Signed: true}}
+	for _, preserve := range []bool{false, true} {
+		*ConfPreserveSignatures = preserve
+		signed.Part.Warnings = nil
+		if !signatureGuard(ctx, plain, "splitting") {
+			t.Errorf("preserve=%t: unsigned file is kept", preserve)
+		}
+		if got := signatureGuard(ctx, signed, "splitting"); got == preserve {
+			t.Errorf("preserve=%t: got %t for the signed file", preserve, got)
+		}
+		if len(plain.Part.Warnings) != 0 || len(signed.Part.Warnings) != 1 {
+			t.Errorf("preserve=%t: got warnings %q and %q", preserve, plain.Part.Warnings, signed.Part.Warnings)
+		}
+	}
+
+	entries := buildManifest(ctx, []ArchFileItem{signed}, false)
+	if len(entries) != 1 || !entries[0].Signed || len(entries[0].Warnings) != 1 {
+		t.Errorf("manifest: got %+v", entries)
+	}
+}
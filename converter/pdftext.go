@@ -0,0 +1,85 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PdfExtractText returns the text of srcfn, with the pages separated by
+// form feeds, using pdftotext or mutool.
+// For PDFs without text (such as scanned images) it returns "".
+func PdfExtractText(ctx context.Context, srcfn string) (string, error) {
+	var text string
+	if *ConfPdftotext != "" {
+		cmd := exec.Command(*ConfPdftotext, "-enc", "UTF-8", srcfn, "-")
+		var out, errout bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &errout
+		if err := runWithTimeout(ctx, cmd); err != nil {
+			return "", errors.Wrapf(err, "%q: %s", cmd.Args, errout.Bytes())
+		}
+		text = out.String()
+	} else if *ConfMutool != "" {
+		var err error
+		if text, err = mutoolExtractText(ctx, srcfn); err != nil {
+			return "", err
+		}
+	} else {
+		return "", errors.New("neither pdftotext nor mutool is available")
+	}
+	if strings.Trim(text, " \t\r\n\f") == "" {
+		return "", nil
+	}
+	return text, nil
+}
+
+// mutoolExtractText returns the text of srcfn with mutool, which writes
+// the pages into separate files, so they can be ended with form feeds,
+// as pdftotext does.
+func mutoolExtractText(ctx context.Context, srcfn string) (string, error) {
+	dir, err := ioutil.TempDir(GetWorkdir(ctx), "text-")
+	if err != nil {
+		return "", errors.Wrap(err, "create temp dir")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	cmd := exec.Command(*ConfMutool, "draw", "-F", "txt", "-o", filepath.Join(dir, "%d.txt"), srcfn)
+	var errout bytes.Buffer
+	cmd.Stderr = &errout
+	if err = runWithTimeout(ctx, cmd); err != nil {
+		return "", errors.Wrapf(err, "%q: %s", cmd.Args, errout.Bytes())
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "read "+dir)
+	}
+	pages := make([]int, 0, len(fis))
+	for _, fi := range fis {
+		if n, err := strconv.Atoi(strings.TrimSuffix(fi.Name(), ".txt")); err == nil {
+			pages = append(pages, n)
+		}
+	}
+	sort.Ints(pages)
+	var buf bytes.Buffer
+	for _, n := range pages {
+		b, err := ioutil.ReadFile(filepath.Join(dir, strconv.Itoa(n)+".txt"))
+		if err != nil {
+			return "", errors.Wrapf(err, "page %d", n)
+		}
+		buf.Write(b)
+		buf.WriteByte('\f')
+	}
+	return buf.String(), nil
+}
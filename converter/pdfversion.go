@@ -0,0 +1,78 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PdfVersion is the version (-dCompatibilityLevel) of the PDFs written by GhostScript.
+type PdfVersion string
+
+// pdfVersions are the versions GhostScript's pdfwrite can write.
+var pdfVersions = []PdfVersion{"1.3", "1.4", "1.5", "1.6", "1.7"}
+
+// Validate checks that the version is supported (or empty, which means the default).
+func (v PdfVersion) Validate() error {
+	if v == "" {
+		return nil
+	}
+	for _, ok := range pdfVersions {
+		if v == ok {
+			return nil
+		}
+	}
+	s := make([]string, len(pdfVersions))
+	for i, ok := range pdfVersions {
+		s[i] = string(ok)
+	}
+	return errors.Errorf("unsupported PDF version %q (%s)", string(v), strings.Join(s, ", "))
+}
+
+// orDefault returns ConfPdfVersion for the empty version.
+func (v PdfVersion) orDefault() PdfVersion {
+	if v != "" {
+		return v
+	}
+	if *ConfPdfVersion != "" {
+		return PdfVersion(*ConfPdfVersion)
+	}
+	return "1.4"
+}
+
+const pdfVersionKey = "pdfVersion"
+
+// WithPdfVersion returns a context which carries the version of the PDFs
+// rewritten (or merged, see PdfMerge) during the conversion.
+func WithPdfVersion(ctx context.Context, version PdfVersion) context.Context {
+	return context.WithValue(ctx, pdfVersionKey, version)
+}
+
+// PdfSetVersion rewrites srcfn into destfn (which may be the same) with the given
+// PDF version, in one GhostScript pdfwrite pass: unlike PdfRewrite, it does not
+// go through PostScript, so it keeps the annotations, forms and links.
+func PdfSetVersion(ctx context.Context, destfn, srcfn string, version PdfVersion) error {
+	dst := destfn
+	if destfn == srcfn {
+		dst = nakeFilename(srcfn) + "-v.pdf"
+	}
+	if err := xToX(ctx, dst, srcfn, false, "", version); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return moveFile(dst, destfn)
+}
+
+func getPdfVersion(ctx context.Context) PdfVersion {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(pdfVersionKey).(PdfVersion)
+	return v
+}
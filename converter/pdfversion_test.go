@@ -0,0 +1,89 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestPdfVersion(t *testing.T) {
+	for _, v := range []PdfVersion{"", "1.3", "1.4", "1.7"} {
+		if err := v.Validate(); err != nil {
+			t.Errorf("%q: %v", v, err)
+		}
+	}
+	for _, v := range []PdfVersion{"1.8", "2.0", "1", "1.4 -dNOSAFER"} {
+		if err := v.Validate(); err == nil {
+			t.Errorf("%q: wanted error", v)
+		}
+	}
+	old := *ConfPdfVersion
+	defer func() { *ConfPdfVersion = old }()
+	*ConfPdfVersion = "1.5"
+	if got := PdfVersion("").orDefault(); got != "1.5" {
+		t.Errorf("got %q, wanted the configured 1.5", got)
+	}
+	if got := getPdfVersion(WithPdfVersion(context.Background(), "1.7")).orDefault(); got != "1.7" {
+		t.Errorf("got %q, wanted 1.7", got)
+	}
+}
+
+func TestPdfSetVersion(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "agostle-pdfversion-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	// the fake gs records its arguments, and writes the output file
+	fake := filepath.Join(dir, "gs")
+	script := "#!/bin/sh\necho \"$@\" >>" + filepath.Join(dir, "args") + "\n" +
+		"for a; do case \"$a\" in -sOutputFile=*) echo '%PDF-1.7' >\"${a#-sOutputFile=}\";; esac; done\n"
+	if err = ioutil.WriteFile(fake, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(gs string, m int64) { *ConfGs, *ConfGsMemLimit = gs, m }(*ConfGs, *ConfGsMemLimit)
+	*ConfGs, *ConfGsMemLimit = fake, 0
+
+	fn := filepath.Join(dir, "a.pdf")
+	if err = ioutil.WriteFile(fn, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	if err = PdfSetVersion(ctx, fn, fn, "1.7"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); strings.TrimSpace(string(b)) != "%PDF-1.7" {
+		t.Errorf("got %q, wanted the rewritten file", b)
+	}
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string // without the --version of pdfwriteOpts
+	for _, line := range strings.Split(strings.TrimSpace(string(args)), "\n") {
+		if line != "--version" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 1 ||
+		!strings.Contains(lines[0], "-sDEVICE=pdfwrite") || !strings.Contains(lines[0], "-dCompatibilityLevel=1.7") {
+		t.Errorf("wanted one pdfwrite pass, got %q", lines)
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 3 {
+		t.Errorf("temp file left: %d files", len(fis))
+	}
+}
@@ -5,22 +5,72 @@
 package converter
 
 import (
+	"bytes"
 	"os/exec"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 
-	"github.com/tgulacsi/go/proc"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func runWithTimeout(cmd *exec.Cmd) error {
-	err := proc.RunWithTimeout(int(*ConfChildTimeout/time.Second), cmd)
+// CmdDuration is the histogram of the external command run times, by tool.
+// It is not registered by this package.
+var CmdDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "agostle",
+		Name:      "command_duration_seconds",
+		Help:      "Wall-clock duration of the external commands.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+	},
+	[]string{"tool"},
+)
+
+// killAll is closed by KillChildren.
+var (
+	killAllMu sync.Mutex
+	killAll   = make(chan struct{})
+)
+
+func killChan() chan struct{} {
+	killAllMu.Lock()
+	defer killAllMu.Unlock()
+	return killAll
+}
+
+// KillChildren kills the running child processes, and makes the
+// later started ones fail - to be called only when shutting down.
+func KillChildren() {
+	killAllMu.Lock()
+	defer killAllMu.Unlock()
+	select {
+	case <-killAll:
+	default:
+		close(killAll)
+	}
+}
+
+// errKilled is returned for the commands killed by KillChildren.
+var errKilled = errors.New("killed at shutdown")
+
+// runWithTimeout runs the command, killing it when the context is canceled,
+// ConfChildTimeout expires, or at KillChildren; it logs with the context's logger.
+func runWithTimeout(ctx context.Context, cmd *exec.Cmd) error {
+	start := time.Now()
+	err := runUntilDone(ctx, *ConfChildTimeout, cmd)
+	logCommand(getLogger(ctx), cmd, start, err)
 	if err != nil {
-		Log("msg", "ERROR runWithTimeout", "args", cmd.Args, "error", err)
+		getLogger(ctx).Log("msg", "ERROR runWithTimeout", "args", cmd.Args, "error", err)
 	}
 	return err
 }
 
+// runWithContext runs the command, killing it when the context is canceled,
+// the timeout (the context's deadline or ConfChildTimeout) expires, or at KillChildren.
 func runWithContext(ctx context.Context, cmd *exec.Cmd) error {
 	select {
 	case <-ctx.Done():
@@ -32,9 +82,80 @@ func runWithContext(ctx context.Context, cmd *exec.Cmd) error {
 	if ok {
 		timeout = deadline.Sub(time.Now())
 	}
-	seconds := int(timeout / time.Second)
-	if seconds <= 0 {
-		return cmd.Run()
+	start := time.Now()
+	err := runUntilDone(ctx, timeout, cmd)
+	logCommand(getLogger(ctx), cmd, start, err)
+	return err
+}
+
+// outputWithContext runs the command with runWithContext, and returns its standard output.
+func outputWithContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err := runWithContext(ctx, cmd)
+	return buf.Bytes(), err
+}
+
+// runUntilDone starts the command in its own process group and waits for it to finish,
+// killing the group when the context is done, the timeout expires, or at KillChildren.
+func runUntilDone(ctx context.Context, timeout time.Duration, cmd *exec.Cmd) error {
+	kill := killChan()
+	select {
+	case <-kill:
+		return errors.Wrapf(errKilled, "not started %q", cmd.Args)
+	default:
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer startChild()()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = killProcessGroup(cmd)
+		<-done
+		return errors.Wrapf(ctx.Err(), "killed %q", cmd.Args)
+	case <-kill:
+		_ = killProcessGroup(cmd)
+		<-done
+		return errors.Wrapf(errKilled, "%q", cmd.Args)
+	case <-expired:
+		_ = killProcessGroup(cmd)
+		<-done
+		return errors.Errorf("%q timed out after %s", cmd.Args, timeout)
+	}
+}
+
+// logCommand logs the finished command's tool name, argument count, exit status
+// and duration, and records the duration in CmdDuration.
+func logCommand(logger *log.Context, cmd *exec.Cmd, start time.Time, err error) {
+	dur := time.Since(start)
+	tool := filepath.Base(cmd.Path)
+	CmdDuration.WithLabelValues(tool).Observe(dur.Seconds())
+	if logger == nil {
+		return
+	}
+	logger.Log("msg", "command", "tool", tool, "argc", len(cmd.Args),
+		"exit", exitStatus(cmd, err), "duration", dur.String())
+}
+
+// exitStatus returns the exit status of the command as a string.
+func exitStatus(cmd *exec.Cmd, err error) string {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.String()
+	}
+	if err != nil {
+		return "not started"
 	}
-	return proc.RunWithTimeout(int(timeout/time.Second), cmd)
+	return "unknown"
 }
@@ -0,0 +1,90 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRunWithContextCancel(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip(err)
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(),
+		"logger", log.NewContext(log.NewNopLogger())))
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := runWithContext(ctx, exec.Command("sleep", "10"))
+	if err == nil {
+		t.Fatal("wanted error for the canceled command")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the command was not killed, ran for %s", d)
+	}
+}
+
+func TestRunWithContextKillsGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on windows")
+	}
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip(err)
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(),
+		"logger", log.NewContext(log.NewNopLogger())))
+	time.AfterFunc(100*time.Millisecond, cancel)
+	// the grandchild holds the stdout open: Wait returns only when it is killed, too
+	var buf bytes.Buffer
+	cmd := exec.Command("sh", "-c", "sleep 10 & wait")
+	cmd.Stdout = &buf
+	start := time.Now()
+	if err := runWithContext(ctx, cmd); err == nil {
+		t.Fatal("wanted error for the canceled command")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the grandchild was not killed, ran for %s", d)
+	}
+	if n := ActiveWork(); n != 0 {
+		t.Errorf("%d active work after the command has been killed", n)
+	}
+}
+
+func TestKillChildren(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip(err)
+	}
+	killAllMu.Lock()
+	old := killAll
+	killAll = make(chan struct{})
+	killAllMu.Unlock()
+	defer func() {
+		killAllMu.Lock()
+		killAll = old
+		killAllMu.Unlock()
+	}()
+
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+	time.AfterFunc(100*time.Millisecond, KillChildren)
+	start := time.Now()
+	err := runWithContext(ctx, exec.Command("sleep", "10"))
+	if errors.Cause(err) != errKilled {
+		t.Fatalf("wanted errKilled, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the command was not killed, ran for %s", d)
+	}
+	if err = runWithContext(ctx, exec.Command("sleep", "10")); errors.Cause(err) != errKilled {
+		t.Errorf("started after KillChildren: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+// Progress is a progress report of a mail conversion, sent for each part
+// when its conversion starts ("start") and ends ("done" or "error").
+type Progress struct {
+	Event       string `json:"event"`
+	Seq         int    `json:"seq"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Error       string `json:"error,omitempty"` // the reason, see progressReason
+	// Done is the number of the finished parts, Total is the number of
+	// the parts found so far (the mail is converted while it is read).
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// ProgressFunc receives the progress reports. It must not block.
+type ProgressFunc func(Progress)
+
+const progressKey = "progress"
+
+// WithProgress returns a context which carries the ProgressFunc
+// the mail conversions report their progress to.
+func WithProgress(ctx context.Context, f ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey, f)
+}
+
+// GetProgress returns the ProgressFunc of the context, or nil.
+func GetProgress(ctx context.Context) ProgressFunc {
+	if ctx == nil {
+		return nil
+	}
+	f, _ := ctx.Value(progressKey).(ProgressFunc)
+	return f
+}
+
+const progressTrackerKey = "progressTracker"
+
+// progressTracker counts the parts of one (possibly nested) mail conversion.
+type progressTracker struct {
+	mu          sync.Mutex
+	f           ProgressFunc
+	done, total int
+}
+
+// withProgressTracker returns a context with a progressTracker - the one
+// of ctx, if it has one already (for the embedded mails), or nil if there's no ProgressFunc.
+func withProgressTracker(ctx context.Context) (context.Context, *progressTracker) {
+	if pt, ok := ctx.Value(progressTrackerKey).(*progressTracker); ok {
+		return ctx, pt
+	}
+	f := GetProgress(ctx)
+	if f == nil {
+		return ctx, nil
+	}
+	pt := &progressTracker{f: f}
+	return context.WithValue(ctx, progressTrackerKey, pt), pt
+}
+
+// getProgressTracker returns the progressTracker of the context, or nil.
+func getProgressTracker(ctx context.Context) *progressTracker {
+	pt, _ := ctx.Value(progressTrackerKey).(*progressTracker)
+	return pt
+}
+
+// start reports the start of the conversion of the part. A nil tracker is a no-op.
+func (pt *progressTracker) start(mp i18nmail.MailPart) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	pt.total++
+	p := pt.progress("start", mp)
+	pt.mu.Unlock()
+	pt.f(p)
+}
+
+// finish reports the end of the conversion of the part. A nil tracker is a no-op.
+// Only the reason of the error is reported (see progressReason), the error itself
+// is logged by the caller.
+func (pt *progressTracker) finish(mp i18nmail.MailPart, err error) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	pt.done++
+	p := pt.progress("done", mp)
+	pt.mu.Unlock()
+	if err != nil {
+		p.Event, p.Error = "error", progressReason(err)
+	}
+	pt.f(p)
+}
+
+// progressReason returns the reason of the failed conversion, for the progress
+// reports. It is not the error text, as that contains the internal paths and the
+// output of the tools, and the progress stream is readable by anyone with the job id.
+func progressReason(err error) string {
+	switch cause := errors.Cause(err); cause {
+	case ErrNoConverter, ErrResourceLimit, ErrTooManyPages, ErrContentTypeNotAllowed, ErrSignedPdf:
+		return cause.Error()
+	case context.Canceled, context.DeadlineExceeded:
+		return "timeout"
+	}
+	return "conversion failed"
+}
+
+func (pt *progressTracker) progress(event string, mp i18nmail.MailPart) Progress {
+	return Progress{
+		Event:       event,
+		Seq:         mp.Seq,
+		FileName:    headerGetFileName(mp.Header),
+		ContentType: mp.ContentType,
+		Done:        pt.done,
+		Total:       pt.total,
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/go/i18nmail"
+)
+
+func TestProgressTracker(t *testing.T) {
+	ctx, pt := withProgressTracker(context.Background())
+	if pt != nil {
+		t.Fatal("tracker without ProgressFunc")
+	}
+	// no listener: no-op
+	getProgressTracker(ctx).start(i18nmail.MailPart{})
+
+	var got []Progress
+	ctx, pt = withProgressTracker(WithProgress(context.Background(), func(p Progress) { got = append(got, p) }))
+	if pt == nil {
+		t.Fatal("no tracker")
+	}
+	// embedded mails use the same tracker
+	if _, pt2 := withProgressTracker(ctx); pt2 != pt {
+		t.Error("nested conversion got a new tracker")
+	}
+	a := i18nmail.MailPart{Seq: 1, ContentType: "text/plain"}
+	b := i18nmail.MailPart{Seq: 2, ContentType: "image/png",
+		Header: textproto.MIMEHeader{"Content-Disposition": {`attachment; filename="a.png"`}}}
+	pt.start(a)
+	pt.start(b)
+	pt.finish(a, nil)
+	pt.finish(b, errors.New("/tmp/agostle/01BX5ZZKBK/a.png: gm: exit status 1: bad image"))
+	want := []Progress{
+		{Event: "start", Seq: 1, ContentType: "text/plain", Total: 1},
+		{Event: "start", Seq: 2, FileName: "a.png", ContentType: "image/png", Total: 2},
+		{Event: "done", Seq: 1, ContentType: "text/plain", Done: 1, Total: 2},
+		{Event: "error", Seq: 2, FileName: "a.png", ContentType: "image/png", Error: "conversion failed", Done: 2, Total: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, wanted %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d. got %+v, wanted %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestProgressReason(t *testing.T) {
+	for i, tc := range []struct {
+		err  error
+		want string
+	}{
+		{errors.New("/tmp/agostle/x/a.doc: exit status 1"), "conversion failed"},
+		{errors.Wrap(ErrNoConverter, "/tmp/agostle/x/a.xyz"), "no converter"},
+		{errors.Wrapf(ErrResourceLimit, "gs %s", "/tmp/agostle/x/a.pdf"), "resource limit reached"},
+		{errors.Wrap(context.DeadlineExceeded, "/tmp/agostle/x/a.pdf"), "timeout"},
+	} {
+		got := progressReason(tc.err)
+		if got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+		if strings.Contains(got, "/tmp") {
+			t.Errorf("%d. path in %q", i, got)
+		}
+	}
+}
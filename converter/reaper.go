@@ -0,0 +1,291 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// work tracks the start time of the running conversions, and the number of
+// the running child processes.
+var work = struct {
+	sync.Mutex
+	seq      uint64
+	started  map[uint64]time.Time
+	children int
+}{started: make(map[uint64]time.Time)}
+
+// StartWork registers a running conversion (such as a HTTP request),
+// whose files must not be removed by ReapWorkdir.
+// The returned function must be called when the conversion has finished.
+func StartWork() func() {
+	work.Lock()
+	work.seq++
+	id := work.seq
+	work.started[id] = time.Now()
+	work.Unlock()
+	return func() {
+		work.Lock()
+		delete(work.started, id)
+		work.Unlock()
+	}
+}
+
+// startChild registers a running child process, till the returned function is called.
+func startChild() func() {
+	work.Lock()
+	work.children++
+	work.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			work.Lock()
+			work.children--
+			work.Unlock()
+		})
+	}
+}
+
+// ActiveWork returns the number of the running conversions (see StartWork)
+// and child processes - the latter may run outside of any conversion (IMAP ingestion).
+func ActiveWork() int {
+	work.Lock()
+	defer work.Unlock()
+	return len(work.started) + work.children
+}
+
+// oldestWork returns the start time of the oldest running conversion,
+// or now if there is none.
+func oldestWork(now time.Time) time.Time {
+	oldest := now
+	work.Lock()
+	for _, t := range work.started {
+		if t.Before(oldest) {
+			oldest = t
+		}
+	}
+	work.Unlock()
+	return oldest
+}
+
+// ReapWorkdir removes the files and directories in Workdir older than ttl,
+// checking every ttl/2, until ctx is cancelled.
+// Only a configured (ConfWorkdir) Workdir is reaped: the default,
+// os.TempDir(), is shared with other programs.
+//
+// Entries modified since the start of the oldest running conversion are
+// kept, so the split pages and other temp files are not removed under
+// a running conversion.
+func ReapWorkdir(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		unlock := RLockConfig()
+		if dir := reapableWorkdir(); dir == "" {
+			Log("msg", "no workdir is configured, not reaping the shared temp dir", "dir", Workdir)
+		} else if n := reapDir(dir, ttl, time.Now()); n > 0 {
+			Log("msg", "reaped workdir", "dir", dir, "removed", n)
+		}
+		unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReapDebugWorkdirs removes the kept workdirs of the debug requests
+// (see KeepRequestWorkdir) from Workdir, when they become older than ttl.
+func ReapDebugWorkdirs(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		unlock := RLockConfig()
+		if n := reapDebugDirs(Workdir, ttl, time.Now()); n > 0 {
+			Log("msg", "reaped debug workdirs", "dir", Workdir, "removed", n)
+		}
+		unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapableWorkdir returns Workdir if it is configured, "" if it is
+// the default os.TempDir().
+func reapableWorkdir() string {
+	if *ConfWorkdir == "" {
+		return ""
+	}
+	return Workdir
+}
+
+// reapDir removes the entries of dir not modified since now-ttl,
+// and since the start of the oldest running conversion.
+func reapDir(dir string, ttl time.Duration, now time.Time) int {
+	return reapDirFunc(dir, ttl, now, nil)
+}
+
+// reapDebugDirs removes the kept debug workdirs from dir, as reapDir.
+func reapDebugDirs(dir string, ttl time.Duration, now time.Time) int {
+	return reapDirFunc(dir, ttl, now, func(fi os.FileInfo) bool {
+		return fi.IsDir() && strings.HasSuffix(fi.Name(), DebugWorkdirSuffix)
+	})
+}
+
+// reapDirFunc is reapDir for only the entries accepted by match (all, if it is nil).
+func reapDirFunc(dir string, ttl time.Duration, now time.Time, match func(os.FileInfo) bool) int {
+	limit := now.Add(-ttl)
+	if oldest := oldestWork(now); oldest.Before(limit) {
+		limit = oldest
+	}
+	dh, err := os.Open(dir)
+	if err != nil {
+		Log("msg", "reapDir open", "dir", dir, "error", err)
+		return 0
+	}
+	fis, err := dh.Readdir(-1)
+	_ = dh.Close()
+	if err != nil {
+		Log("msg", "reapDir list", "dir", dir, "error", err)
+	}
+	var n int
+	for _, fi := range fis {
+		if match != nil && !match(fi) {
+			continue
+		}
+		fn := filepath.Join(dir, fi.Name())
+		if !lastModified(fn, fi).Before(limit) {
+			continue
+		}
+		if err := os.RemoveAll(fn); err != nil {
+			Log("msg", "reapDir remove", "file", fn, "error", err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// ReapFdfCache prunes the cached FDFs in Workdir (see pruneFdfCache)
+// to ConfFdfCacheFiles forms not older than ConfFdfCacheTTL,
+// checking every interval, until ctx is cancelled.
+func ReapFdfCache(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		unlock := RLockConfig()
+		if n := pruneFdfCache(Workdir, *ConfFdfCacheFiles, *ConfFdfCacheTTL, time.Now()); n > 0 {
+			Log("msg", "pruned fdf cache", "dir", Workdir, "removed", n)
+		}
+		unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneFdfCache removes the cached FDFs (the files named as fdfCacheKey accepts) of dir
+// beyond the maxFiles most recently used forms, and the ones not used since maxAge.
+// The files of one form are removed together; zero maxFiles or maxAge means no limit.
+// Files modified since the start of the oldest running conversion are kept.
+func pruneFdfCache(dir string, maxFiles int, maxAge time.Duration, now time.Time) int {
+	if maxFiles <= 0 && maxAge <= 0 {
+		return 0
+	}
+	dh, err := os.Open(dir)
+	if err != nil {
+		Log("msg", "pruneFdfCache open", "dir", dir, "error", err)
+		return 0
+	}
+	fis, err := dh.Readdir(-1)
+	_ = dh.Close()
+	if err != nil {
+		Log("msg", "pruneFdfCache list", "dir", dir, "error", err)
+	}
+	forms := make(map[string]*fdfFiles)
+	for _, fi := range fis {
+		name := fi.Name()
+		key, ok := fdfCacheKey(name)
+		if fi.IsDir() || !ok {
+			continue
+		}
+		f := forms[key]
+		if f == nil {
+			f = &fdfFiles{}
+			forms[key] = f
+		}
+		f.names = append(f.names, name)
+		if fi.ModTime().After(f.modified) {
+			f.modified = fi.ModTime()
+		}
+	}
+	sorted := make(fdfFilesByAge, 0, len(forms))
+	for _, f := range forms {
+		sorted = append(sorted, f)
+	}
+	sort.Sort(sorted)
+
+	oldest := oldestWork(now)
+	var n int
+	for i, f := range sorted {
+		if !f.modified.Before(oldest) {
+			continue
+		}
+		if !(maxFiles > 0 && i >= maxFiles || maxAge > 0 && f.modified.Before(now.Add(-maxAge))) {
+			continue
+		}
+		for _, name := range f.names {
+			fn := filepath.Join(dir, name)
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				Log("msg", "pruneFdfCache remove", "file", fn, "error", err)
+				continue
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// fdfFiles are the cached files of one form.
+type fdfFiles struct {
+	names    []string
+	modified time.Time
+}
+
+// fdfFilesByAge is a wrapper for sort.Sort, the most recently modified first.
+type fdfFilesByAge []*fdfFiles
+
+func (a fdfFilesByAge) Len() int           { return len(a) }
+func (a fdfFilesByAge) Less(i, j int) bool { return a[i].modified.After(a[j].modified) }
+func (a fdfFilesByAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// lastModified returns the modification time of the file,
+// or the latest one of anything in it, if it is a directory.
+func lastModified(fn string, fi os.FileInfo) time.Time {
+	last := fi.ModTime()
+	if !fi.IsDir() {
+		return last
+	}
+	_ = filepath.Walk(fn, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReapDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-reaper-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for _, fn := range []string{"old.fdf", "new.fdf", "old-split/1.pdf", "mixed-split/1.pdf", "mixed-split/2.pdf"} {
+		fn = filepath.Join(dir, fn)
+		_ = os.MkdirAll(filepath.Dir(fn), 0755)
+		if err = ioutil.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if fn != filepath.Join(dir, "new.fdf") && fn != filepath.Join(dir, "mixed-split/2.pdf") {
+			_ = os.Chtimes(fn, old, old)
+		}
+	}
+	for _, d := range []string{"old-split", "mixed-split"} {
+		_ = os.Chtimes(filepath.Join(dir, d), old, old)
+	}
+
+	// a conversion running for 3 hours protects everything
+	done := StartWork()
+	work.Lock()
+	for id := range work.started {
+		work.started[id] = now.Add(-3 * time.Hour)
+	}
+	work.Unlock()
+	if n := reapDir(dir, time.Hour, now); n != 0 {
+		t.Errorf("removed %d under a running conversion", n)
+	}
+	done()
+
+	if n := reapDir(dir, time.Hour, now); n != 2 {
+		t.Errorf("removed %d, wanted 2", n)
+	}
+	for fn, want := range map[string]bool{
+		"old.fdf": false, "new.fdf": true, "old-split": false, "mixed-split": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, fn)); (err == nil) != want {
+			t.Errorf("%s: exists? %t, wanted %t", fn, err == nil, want)
+		}
+	}
+}
+
+func TestReapDebugDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-reaper-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkdir := Workdir
+	defer func() { Workdir = oldWorkdir }()
+	Workdir = dir
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for _, reqid := range []string{"old", "new", "other"} {
+		dn, err := NewRequestWorkdir(reqid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reqid != "other" {
+			if dn, err = KeepRequestWorkdir(dn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if reqid != "new" {
+			_ = os.Chtimes(dn, old, old)
+		}
+	}
+
+	if n := reapDebugDirs(dir, time.Hour, now); n != 1 {
+		t.Errorf("removed %d, wanted 1", n)
+	}
+	for fn, want := range map[string]bool{
+		"old" + DebugWorkdirSuffix: false, "new" + DebugWorkdirSuffix: true, "other": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, fn)); (err == nil) != want {
+			t.Errorf("%s: exists? %t, wanted %t", fn, err == nil, want)
+		}
+	}
+}
+
+func TestReapableWorkdir(t *testing.T) {
+	defer func(conf, wd string) { *ConfWorkdir, Workdir = conf, wd }(*ConfWorkdir, Workdir)
+	*ConfWorkdir, Workdir = "", os.TempDir()
+	if dir := reapableWorkdir(); dir != "" {
+		t.Errorf("the default temp dir %q is reaped", dir)
+	}
+	*ConfWorkdir, Workdir = "/var/lib/agostle", "/var/lib/agostle"
+	if dir := reapableWorkdir(); dir != Workdir {
+		t.Errorf("got %q, wanted %q", dir, Workdir)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RegisterFlag modifies the registration of a converter or extension.
+type RegisterFlag uint8
+
+// Override makes the registered converter or extension win over the built-in one.
+const Override = RegisterFlag(1)
+
+type registered struct {
+	Converter   Converter
+	ContentType string
+	Override    bool
+}
+
+var (
+	registryMu sync.RWMutex
+	converters = make(map[string]registered)
+	extensions = make(map[string]registered)
+)
+
+func hasOverride(flags []RegisterFlag) bool {
+	for _, f := range flags {
+		if f&Override != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterConverter registers c as the converter for contentType.
+// The built-in converters win, unless the Override flag is given;
+// a nil c removes the registration.
+func RegisterConverter(contentType string, c Converter, flags ...RegisterFlag) {
+	contentType = strings.ToLower(contentType)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if c == nil {
+		delete(converters, contentType)
+		return
+	}
+	converters[contentType] = registered{Converter: c, Override: hasOverride(flags)}
+}
+
+// RegisterExt registers contentType for the file extension (without the leading dot),
+// used by FixContentType. The built-in ExtContentType wins, unless the Override flag
+// is given; an empty contentType removes the registration.
+func RegisterExt(ext, contentType string, flags ...RegisterFlag) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if contentType == "" {
+		delete(extensions, ext)
+		return
+	}
+	extensions[ext] = registered{ContentType: contentType, Override: hasOverride(flags)}
+}
+
+// registeredConverter returns the registered converter for the content-type,
+// and whether it overrides the built-in one.
+func registeredConverter(contentType string) (Converter, bool) {
+	registryMu.RLock()
+	r := converters[strings.ToLower(contentType)]
+	registryMu.RUnlock()
+	return r.Converter, r.Override
+}
+
+// extContentType returns the content-type for the extension (without the leading dot),
+// from the registered and the built-in (ExtContentType) extensions.
+func extContentType(ext string) (string, bool) {
+	ext = strings.ToLower(ext)
+	registryMu.RLock()
+	r, ok := extensions[ext]
+	registryMu.RUnlock()
+	if ok && r.Override {
+		return r.ContentType, true
+	}
+	if ct, builtin := ExtContentType[ext]; builtin {
+		return ct, true
+	}
+	return r.ContentType, ok
+}
+
+// builtinContentTypes are the content-types handled by GetConverter's switch,
+// besides the ones in ExtContentType.
+var builtinContentTypes = []string{
+	"application/pdf", "application/rtf", "application/msword",
+	"text/plain", "text/html", "message/rfc822", "application/vnd.ms-outlook",
+	"multipart/related", "multipart/alternative",
+}
+
+// ConvertibleTypes returns the sorted list of the content-types which have a
+// converter: the built-in and the registered ones.
+func ConvertibleTypes() []string {
+	seen := make(map[string]struct{}, len(ExtContentType)+len(builtinContentTypes))
+	for _, ct := range ExtContentType {
+		seen[ct] = struct{}{}
+	}
+	for _, ct := range builtinContentTypes {
+		seen[ct] = struct{}{}
+	}
+	registryMu.RLock()
+	for ct := range converters {
+		seen[ct] = struct{}{}
+	}
+	for _, r := range extensions {
+		seen[r.ContentType] = struct{}{}
+	}
+	registryMu.RUnlock()
+	types := make([]string, 0, len(seen))
+	for ct := range seen {
+		if convertible(ct, nil) {
+			types = append(types, ct)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRegisterConverter(t *testing.T) {
+	custom := func(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+		return nil
+	}
+	same := func(a, b Converter) bool {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	defer func() {
+		for _, ct := range []string{"application/x-custom", "text/x-custom", "text/html"} {
+			RegisterConverter(ct, nil)
+		}
+	}()
+
+	if GetConverter("application/x-custom", nil) != nil {
+		t.Fatal("application/x-custom should not have a converter")
+	}
+	RegisterConverter("application/x-custom", custom)
+	if c := GetConverter("application/x-custom", nil); !same(c, custom) {
+		t.Errorf("application/x-custom: got %v", c)
+	}
+
+	// the generic text/* converter loses to the registered one
+	RegisterConverter("text/x-custom", custom)
+	if c := GetConverter("text/x-custom", nil); !same(c, custom) {
+		t.Errorf("text/x-custom: got %v", c)
+	}
+
+	// built-in wins without Override
+	RegisterConverter("text/html", custom)
+	if c := GetConverter("text/html", nil); !same(c, HTMLToPdf) {
+		t.Errorf("text/html without Override: got %v", c)
+	}
+	RegisterConverter("text/html", custom, Override)
+	if c := GetConverter("text/html", nil); !same(c, custom) {
+		t.Errorf("text/html with Override: got %v", c)
+	}
+}
+
+func TestRegisterExt(t *testing.T) {
+	defer func() {
+		RegisterExt("xcst", "")
+		RegisterExt("docx", "")
+	}()
+	RegisterExt(".xcst", "application/x-custom")
+	if ct, ok := extContentType("XCST"); !ok || ct != "application/x-custom" {
+		t.Errorf("xcst: got %q, %t", ct, ok)
+	}
+	RegisterExt("docx", "application/x-custom")
+	if ct, _ := extContentType("docx"); ct != ExtContentType["docx"] {
+		t.Errorf("docx without Override: got %q", ct)
+	}
+	RegisterExt("docx", "application/x-custom", Override)
+	if ct, _ := extContentType("docx"); ct != "application/x-custom" {
+		t.Errorf("docx with Override: got %q", ct)
+	}
+}
+
+func TestConvertibleTypes(t *testing.T) {
+	defer RegisterConverter("application/x-custom", nil)
+	RegisterConverter("application/x-custom", func(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+		return nil
+	})
+	types := ConvertibleTypes()
+	has := make(map[string]bool, len(types))
+	for _, ct := range types {
+		has[ct] = true
+	}
+	for _, ct := range []string{"application/pdf", "message/rfc822", "image/png", "application/x-custom"} {
+		if !has[ct] {
+			t.Errorf("%s is missing from %q", ct, types)
+		}
+	}
+	if has["application/x-pkcs7-signature"] {
+		t.Errorf("skipped type listed: %q", types)
+	}
+}
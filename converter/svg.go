@@ -0,0 +1,75 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// SVGToPdf converts SVG (image/svg+xml) to PDF.
+// With ConfRsvgConvert, the output stays vector graphics,
+// otherwise the SVG is embedded into a HTML document and converted with HTMLToPdf.
+func SVGToPdf(ctx context.Context, destfn string, r io.Reader, contentType string) error {
+	getLogger(ctx).Log("msg", "Converting into", "ct", contentType, "dest", destfn)
+	if *ConfRsvgConvert == "" {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "read svg")
+		}
+		return HTMLToPdf(ctx, destfn, bytes.NewReader(svgToHTML(b)), "text/html")
+	}
+	var inpfn string
+	if fh, ok := r.(*os.File); ok && fileExists(fh.Name()) {
+		inpfn = fh.Name()
+	}
+	if inpfn == "" {
+		inpfn = nakeFilename(destfn) + ".svg"
+		fh, err := os.Create(inpfn)
+		if err != nil {
+			return err
+		}
+		if !KeepTempFiles(ctx) {
+			defer func() { _ = unlink(inpfn, "SVGToPdf") }()
+		}
+		_, err = io.Copy(fh, r)
+		if closeErr := fh.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var errout bytes.Buffer
+	cmd := exec.Command(*ConfRsvgConvert, "-f", "pdf", "-o", destfn, inpfn)
+	cmd.Stdout = &errout
+	cmd.Stderr = &errout
+	if err := runWithContext(ctx, cmd); err != nil {
+		return errors.Wrapf(err, "%s %s: %s", *ConfRsvgConvert, inpfn, errout.Bytes())
+	}
+	return nil
+}
+
+// svgProlog matches the XML declaration, the DOCTYPE and the comments before the <svg> element.
+var svgProlog = regexp.MustCompile(`^(?s:\s*(?:<\?xml.*?\?>|<!DOCTYPE[^>]*>|<!--.*?-->)\s*)*`)
+
+// svgToHTML embeds the SVG into a HTML document, without its XML prolog.
+func svgToHTML(svg []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><style>body { margin: 0; } svg { max-width: 100%; height: auto; }</style></head>
+<body>
+`)
+	buf.Write(svgProlog.ReplaceAll(svg, nil))
+	buf.WriteString("\n</body></html>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,29 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSvgToHTML(t *testing.T) {
+	svg := []byte(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<!-- drawn by hand -->
+<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10"/></svg>`)
+	got := svgToHTML(svg)
+	for _, bad := range []string{"<?xml", "<!DOCTYPE svg", "<!--"} {
+		if bytes.Contains(got, []byte(bad)) {
+			t.Errorf("%q remained in %s", bad, got)
+		}
+	}
+	if !bytes.Contains(got, []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10">`)) {
+		t.Errorf("svg element is missing from %s", got)
+	}
+	if GetConverter("image/svg+xml", nil) == nil {
+		t.Error("no converter for image/svg+xml")
+	}
+}
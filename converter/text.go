@@ -5,9 +5,16 @@
 package converter
 
 import (
+	"bufio"
+	"bytes"
 	"io"
+	"unicode/utf8"
 
 	"golang.org/x/net/context"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 
 	"github.com/tgulacsi/go/text"
 )
@@ -33,3 +40,79 @@ func NewTextConverter(charset string) Converter {
 		return TextToPdf(ctx, destfn, NewTextReader(ctx, r, charset), contentType)
 	}
 }
+
+// sniffLen is the length of the prefix sniffCharset looks at.
+const sniffLen = 64 << 10
+
+// sniffCharset detects the charset of the text read from r (by BOM, or by
+// looking at the first sniffLen bytes), and returns it with a reader
+// which returns the text converted to UTF-8.
+//
+// Non-UTF-8 text is assumed to be ISO-8859-2, or Windows-1250 if it contains
+// bytes from the C1 control range (0x80-0x9f), which are printable only in the latter.
+func sniffCharset(r io.Reader) (string, io.Reader) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	b, _ := br.Peek(sniffLen)
+	var (
+		charset string
+		enc     encoding.Encoding
+	)
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		br.Discard(len(bomUTF8))
+		return "utf-8", br
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		charset, enc = "utf-16be", unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		charset, enc = "utf-16le", unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case validUTF8Prefix(b):
+		return "utf-8", br
+	case hasC1(b):
+		charset, enc = "windows-1250", charmap.Windows1250
+	default:
+		charset, enc = "iso-8859-2", charmap.ISO8859_2
+	}
+	return charset, transform.NewReader(br, enc.NewDecoder())
+}
+
+var bomUTF8 = []byte{0xef, 0xbb, 0xbf}
+
+// stripBOM returns a reader which skips a leading UTF-8 byte order mark,
+// and transcodes UTF-16 text (marked by its BOM) to UTF-8.
+func stripBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	b, _ := br.Peek(len(bomUTF8))
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		br.Discard(len(bomUTF8))
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		return transform.NewReader(br, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		return transform.NewReader(br, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder())
+	}
+	return br
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8, allowing an incomplete
+// rune at the end (as b may be cut in the middle of it).
+func validUTF8Prefix(b []byte) bool {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				b = b[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// hasC1 reports whether b contains any byte from the C1 control range.
+func hasC1(b []byte) bool {
+	for _, c := range b {
+		if 0x80 <= c && c <= 0x9f {
+			return true
+		}
+	}
+	return false
+}
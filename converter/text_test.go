@@ -7,13 +7,18 @@ package converter
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 	"unicode/utf16"
 
 	"golang.org/x/net/context"
 
+	"github.com/go-kit/kit/log"
+
 	//"bitbucket.org/zombiezen/gopdf/pdf"
 	//"github.com/mawicks/PDFiG/pdf"
 	//"github.com/signintech/gopdf/src/gopdf"
@@ -85,10 +90,62 @@ func TestLoHtmlPdf(t *testing.T) {
 	io.WriteString(out, `</body></html>`)
 	out.Close()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	err = lofficeConvert(ctx, "/tmp", "/tmp/b.html")
+	err = lofficeConvert(ctx, "/tmp", "/tmp/b.html", "")
 	cancel()
 	if err != nil {
 		t.Errorf("error converting with loffice: %s", err)
 	}
 	//os.Remove("/tmp/b.html")
 }
+
+func TestTextBOM(t *testing.T) {
+	for _, fn := range []string{"bom-utf8.txt", "bom-utf16le.txt", "bom-utf16be.txt"} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, r := sniffCharset(bytes.NewReader(b))
+		html, err := ioutil.ReadAll(textToHTML(r, 80))
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		checkNoBOM(t, fn+" (sniffed)", string(html))
+
+		// a BOM surviving the charset decoding must be dropped, too
+		html, err = ioutil.ReadAll(textToHTML(bytes.NewReader(b), 80))
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		checkNoBOM(t, fn, string(html))
+
+		if *ConfWkhtmltopdf == "" || (*ConfPdftotext == "" && *ConfMutool == "") {
+			continue
+		}
+		dir, err := ioutil.TempDir("", "agostle-bom-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		destfn := filepath.Join(dir, fn+".pdf")
+		ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+		if err = TextToPdf(ctx, destfn, bytes.NewReader(b), "text/plain"); err != nil {
+			t.Errorf("%s: %v", fn, err)
+			continue
+		}
+		txt, err := PdfExtractText(ctx, destfn)
+		if err != nil {
+			t.Errorf("%s: %v", fn, err)
+			continue
+		}
+		checkNoBOM(t, fn+" (pdf)", txt)
+	}
+}
+
+func checkNoBOM(t *testing.T, name, s string) {
+	if strings.Contains(s, "\ufeff") || strings.Contains(s, "\u00ef\u00bb\u00bf") {
+		t.Errorf("%s: BOM artifact in %q", name, s)
+	}
+	if !strings.Contains(s, "Árvíztűrő tükörfúrógép") {
+		t.Errorf("%s: text is missing from %q", name, s)
+	}
+}
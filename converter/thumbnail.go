@@ -0,0 +1,107 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ErrPageOutOfRange is returned when the asked page is not in the PDF.
+var ErrPageOutOfRange = errors.New("page out of range")
+
+// PdfThumbnail renders the page (1-based; the first if 0) of srcfn
+// as a PNG image of the given size ("WIDTHxHEIGHT" or "SIZE" for a square) into w.
+func PdfThumbnail(ctx context.Context, srcfn string, w io.Writer, size string, page int) error {
+	return PdfThumbnailAs(ctx, srcfn, w, size, page, "png")
+}
+
+// PdfThumbnailAs is like PdfThumbnail, but with the image type: "png" or "jpeg".
+// It uses pdftoppm if available, GraphicsMagick otherwise, or mutool
+// if neither is.
+func PdfThumbnailAs(ctx context.Context, srcfn string, w io.Writer, size string, page int, imgtyp string) error {
+	switch imgtyp {
+	case "png", "jpeg":
+	case "jpg":
+		imgtyp = "jpeg"
+	default:
+		return errors.Errorf("unknown image type %q (png or jpeg)", imgtyp)
+	}
+	if page <= 0 {
+		page = 1
+	}
+	n, err := PdfPageNum(ctx, srcfn)
+	if err != nil {
+		return errors.Wrapf(err, "page number of %s", srcfn)
+	}
+	if page > n {
+		return errors.Wrapf(ErrPageOutOfRange, "page %d of %d", page, n)
+	}
+
+	if *ConfPdftoppm == "" && getMutoolOk().Render {
+		return mutoolDraw(ctx, w, srcfn, size, page, imgtyp)
+	}
+
+	fh, err := ioutil.TempFile(GetWorkdir(ctx), "thumbnail-")
+	if err != nil {
+		return err
+	}
+	root := fh.Name()
+	_ = fh.Close()
+	_ = os.Remove(root)
+	var cmd *exec.Cmd
+	var outfn string
+	if *ConfPdftoppm != "" {
+		p := strconv.Itoa(page)
+		args := append([]string{"-f", p, "-l", p, "-singlefile", "-" + imgtyp, "-cropbox"},
+			scaleToArgs(size)...)
+		cmd = exec.Command(*ConfPdftoppm, append(args, srcfn, root)...)
+		outfn = root + "." + map[string]string{"png": "png", "jpeg": "jpg"}[imgtyp]
+	} else {
+		args := []string{"convert", srcfn + "[" + strconv.Itoa(page-1) + "]"}
+		if size != "" {
+			args = append(args, "-resize", size)
+		}
+		outfn = root + "." + imgtyp
+		cmd = exec.Command(*ConfGm, append(args, imgtyp+":"+outfn)...)
+	}
+	defer func() { _ = os.Remove(outfn) }()
+	var errout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &errout, &errout
+	if err = runWithContext(ctx, cmd); err != nil {
+		return errors.Wrapf(err, "%s: %s", strings.Join(cmd.Args, " "), errout.Bytes())
+	}
+	ifh, err := os.Open(outfn)
+	if err != nil {
+		return errors.Wrapf(err, "%s: no output: %s", cmd.Args[0], errout.Bytes())
+	}
+	defer func() { _ = ifh.Close() }()
+	_, err = io.Copy(w, ifh)
+	return err
+}
+
+// scaleToArgs returns the scaling arguments of pdftoppm and pdftocairo for the size
+// ("WIDTHxHEIGHT", or "SIZE" for the longer side).
+func scaleToArgs(size string) []string {
+	if size == "" {
+		return nil
+	}
+	i := strings.IndexByte(size, 'x')
+	if i <= 0 || size[:i] == size[i+1:] {
+		if i > 0 {
+			size = size[:i]
+		}
+		return []string{"-scale-to", size}
+	}
+	return []string{"-scale-to-x", size[:i], "-scale-to-y", size[i+1:]}
+}
@@ -0,0 +1,23 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScaleToArgs(t *testing.T) {
+	for size, want := range map[string][]string{
+		"":        nil,
+		"200":     {"-scale-to", "200"},
+		"200x200": {"-scale-to", "200"},
+		"200x100": {"-scale-to-x", "200", "-scale-to-y", "100"},
+	} {
+		if got := scaleToArgs(size); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q, wanted %q", size, got, want)
+		}
+	}
+}
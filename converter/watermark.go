@@ -0,0 +1,242 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// PdfStamp overlays the (single page) stampPdf on every page of srcfn.
+func PdfStamp(ctx context.Context, destfn, srcfn, stampPdf string) error {
+	if err := call(ctx, *ConfPdftk, srcfn, "multistamp", stampPdf, "output", destfn); err != nil {
+		return errors.Wrapf(err, "stamp %s with %s", srcfn, stampPdf)
+	}
+	return nil
+}
+
+// WatermarkOpts are the options of PdfTextWatermark.
+// The zero value means the defaults.
+type WatermarkOpts struct {
+	Color    string  // #rrggbb, defaults to gray
+	Opacity  float64 // 0-1, defaults to 0.3
+	Rotation float64 // degrees, counter-clockwise, defaults to 45
+	FontSize float64 // points, defaults to 72
+}
+
+func (o WatermarkOpts) withDefaults() WatermarkOpts {
+	if o.Color == "" {
+		o.Color = "#808080"
+	}
+	if o.Opacity <= 0 || o.Opacity > 1 {
+		o.Opacity = 0.3
+	}
+	if o.Rotation == 0 {
+		o.Rotation = 45
+	}
+	if o.FontSize <= 0 {
+		o.FontSize = 72
+	}
+	return o
+}
+
+// PdfTextWatermark stamps text on every page of srcfn, as a transparent watermark.
+// The stamp PDF is generated with Ghostscript, with the size of srcfn's first page.
+func PdfTextWatermark(ctx context.Context, destfn, srcfn, text string, opts WatermarkOpts) error {
+	width, height := pdfPageSize(ctx, srcfn)
+	ps, err := watermarkPS(text, opts, width, height)
+	if err != nil {
+		return err
+	}
+	fh, err := ioutil.TempFile(GetWorkdir(ctx), "watermark-")
+	if err != nil {
+		return errors.Wrap(err, "create watermark file")
+	}
+	psfn := fh.Name()
+	stampfn := psfn + ".pdf"
+	if !LeaveTempFiles {
+		defer func() {
+			_ = os.Remove(psfn)
+			_ = os.Remove(stampfn)
+		}()
+	}
+	_, err = fh.Write(ps)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write %s", psfn)
+	}
+	if err = call(ctx, *ConfGs, "-q", "-dNOPAUSE", "-dBATCH", "-P-", "-dSAFER",
+		"-sDEVICE=pdfwrite", "-sOutputFile="+stampfn, psfn,
+	); err != nil {
+		return errors.Wrap(err, "generate watermark")
+	}
+	return PdfStamp(ctx, destfn, srcfn, stampfn)
+}
+
+// watermarkPS returns the PostScript program drawing the watermark text
+// in the middle of a width x height page.
+func watermarkPS(text string, opts WatermarkOpts, width, height float64) ([]byte, error) {
+	opts = opts.withDefaults()
+	c := strings.TrimPrefix(opts.Color, "#")
+	rgb, err := strconv.ParseUint(c, 16, 32)
+	if err != nil || len(c) != 6 {
+		return nil, errors.Errorf("bad color %q (#rrggbb)", opts.Color)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `%%!PS
+<< /PageSize [%.2f %.2f] >> setpagedevice
+/opacity %.2f def
+/.setfillconstantalpha where {
+  pop opacity .setfillconstantalpha opacity .setstrokeconstantalpha
+} {
+  [ /ca opacity /CA opacity /SetTransparency pdfmark
+} ifelse
+%s/Latin1Font findfont %.2f scalefont setfont
+%.3f %.3f %.3f setrgbcolor
+%.2f %.2f translate %.2f rotate
+(%s) dup stringwidth pop 2 div neg %.2f moveto show
+showpage
+`,
+		width, height,
+		opts.Opacity,
+		psLatin1Font("Helvetica-Bold"), opts.FontSize,
+		float64(rgb>>16&0xff)/255, float64(rgb>>8&0xff)/255, float64(rgb&0xff)/255,
+		width/2, height/2, opts.Rotation,
+		psString(text), -opts.FontSize/3,
+	)
+	return buf.Bytes(), nil
+}
+
+// psLatin1Font returns the PostScript code defining /Latin1Font as the
+// named font re-encoded to ISO-8859-1 (see psString).
+func psLatin1Font(name string) string {
+	return "/" + name + ` findfont dup length dict begin
+  { 1 index /FID ne { def } { pop pop } ifelse } forall
+  /Encoding ISOLatin1Encoding def
+  currentdict
+end
+/Latin1Font exch definefont pop
+`
+}
+
+// psString escapes s for a PostScript string literal, in ISO-8859-1
+// (characters outside it are replaced with '?').
+func psString(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case ' ' <= r && r < 0x7f:
+			buf.WriteRune(r)
+		case 0xa0 <= r && r <= 0xff:
+			fmt.Fprintf(&buf, "\\%03o", r)
+		default:
+			buf.WriteByte('?')
+		}
+	}
+	return buf.String()
+}
+
+// pdfPageSize returns the size of the first page of srcfn in points,
+// A4 if it cannot be determined.
+func pdfPageSize(ctx context.Context, srcfn string) (width, height float64) {
+	width, height = 595, 842
+	pdfinfo := getPopplerOk()["pdfinfo"]
+	if pdfinfo == "" {
+		return
+	}
+	out, err := outputWithContext(ctx, exec.Command(pdfinfo, srcfn))
+	if err != nil {
+		getLogger(ctx).Log("msg", "pdfinfo", "file", srcfn, "error", err)
+		return
+	}
+	if w, h, ok := parsePageSize(out); ok {
+		return w, h
+	}
+	return
+}
+
+// parsePageSize parses the "Page size: 595.276 x 841.89 pts (A4)" line of pdfinfo.
+func parsePageSize(out []byte) (width, height float64, ok bool) {
+	i := bytes.Index(out, []byte("Page size:"))
+	if i < 0 {
+		return
+	}
+	fields := strings.Fields(string(out[i+10:]))
+	if len(fields) < 3 || fields[1] != "x" {
+		return
+	}
+	var err error
+	if width, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return
+	}
+	if height, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return
+	}
+	return width, height, true
+}
+
+// pageSize is the size of a page, in points.
+type pageSize struct {
+	Width, Height float64
+}
+
+// pdfPageSizes returns the size of each of the n pages of srcfn, in points.
+// The pages whose size cannot be determined get the size of srcfn's first page.
+func pdfPageSizes(ctx context.Context, srcfn string, n int) []pageSize {
+	width, height := pdfPageSize(ctx, srcfn)
+	sizes := make([]pageSize, n)
+	for i := range sizes {
+		sizes[i] = pageSize{Width: width, Height: height}
+	}
+	pdfinfo := getPopplerOk()["pdfinfo"]
+	if pdfinfo == "" || n == 0 {
+		return sizes
+	}
+	out, err := outputWithContext(ctx, exec.Command(pdfinfo,
+		"-f", "1", "-l", strconv.Itoa(n), srcfn))
+	if err != nil {
+		getLogger(ctx).Log("msg", "pdfinfo", "file", srcfn, "error", err)
+		return sizes
+	}
+	parsePageSizes(sizes, out)
+	return sizes
+}
+
+// parsePageSizes parses the "Page    2 size: 841.89 x 595.276 pts (A4)" lines
+// of pdfinfo -f -l into sizes.
+func parsePageSizes(sizes []pageSize, out []byte) {
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) < 6 || fields[0] != "Page" || fields[2] != "size:" || fields[4] != "x" {
+			continue
+		}
+		i, err := strconv.Atoi(fields[1])
+		if err != nil || i < 1 || i > len(sizes) {
+			continue
+		}
+		w, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			continue
+		}
+		h, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			continue
+		}
+		sizes[i-1] = pageSize{Width: w, Height: h}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import "testing"
+
+func TestPsString(t *testing.T) {
+	for in, want := range map[string]string{
+		"COPY":        "COPY",
+		`a(b)\c`:      `a\(b\)\\c`,
+		"Árvíz":       `\301rv\355z`,
+		"tűz\n":       "t?z?",
+		"Kovács Éva)": `Kov\341cs \311va\)`,
+	} {
+		if got := psString(in); got != want {
+			t.Errorf("%q: got %q, wanted %q", in, got, want)
+		}
+	}
+}
+
+func TestParsePageSize(t *testing.T) {
+	w, h, ok := parsePageSize([]byte("Pages:          1\nPage size:      595.276 x 841.89 pts (A4)\n"))
+	if !ok || w != 595.276 || h != 841.89 {
+		t.Errorf("got %f x %f (%t)", w, h, ok)
+	}
+	if _, _, ok = parsePageSize([]byte("Pages: 1\n")); ok {
+		t.Errorf("wanted failure")
+	}
+}
+
+func TestParsePageSizes(t *testing.T) {
+	sizes := []pageSize{{595, 842}, {595, 842}, {595, 842}}
+	parsePageSizes(sizes, []byte("Pages:          3\n"+
+		"Page    1 size: 595.276 x 841.89 pts (A4)\n"+
+		"Page    1 rot:  0\n"+
+		"Page    2 size: 841.89 x 595.276 pts (A4)\n"+
+		"Page    2 rot:  0\n"))
+	want := []pageSize{{595.276, 841.89}, {841.89, 595.276}, {595, 842}}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Errorf("%d. got %v, wanted %v", i, sizes[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// WkhtmltopdfOptions are the page layout options for wkhtmltopdf.
+// The zero value means the wkhtmltopdf defaults.
+type WkhtmltopdfOptions struct {
+	PageSize    string // A4, Letter...
+	Orientation string // Portrait or Landscape
+	// margins, with units (such as 10mm)
+	MarginTop, MarginBottom, MarginLeft, MarginRight string
+	Grayscale                                        bool
+	// HeaderHTML and FooterHTML are either an http(s) URL,
+	// or inline HTML, which is saved to a temp file for wkhtmltopdf.
+	HeaderHTML, FooterHTML string
+}
+
+var (
+	rePageSize = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	reMargin   = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(mm|cm|in|px)?$`)
+)
+
+// Validate checks the options.
+func (o WkhtmltopdfOptions) Validate() error {
+	if o.PageSize != "" && !rePageSize.MatchString(o.PageSize) {
+		return errors.Errorf("bad page size %q", o.PageSize)
+	}
+	switch strings.ToLower(o.Orientation) {
+	case "", "portrait", "landscape":
+	default:
+		return errors.Errorf("bad orientation %q", o.Orientation)
+	}
+	for _, m := range []string{o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight} {
+		if m != "" && !reMargin.MatchString(m) {
+			return errors.Errorf("bad margin %q", m)
+		}
+	}
+	for _, h := range []string{o.HeaderHTML, o.FooterHTML} {
+		if isURL(h) && strings.ContainsAny(h, " \t\r\n") {
+			return errors.Errorf("bad header/footer URL %q", h)
+		}
+	}
+	return nil
+}
+
+// isURL reports whether the header/footer is an URL, not inline HTML.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// IsZero reports whether the options are all default.
+func (o WkhtmltopdfOptions) IsZero() bool {
+	return o == WkhtmltopdfOptions{}
+}
+
+// String returns a short representation, usable in file names.
+func (o WkhtmltopdfOptions) String() string {
+	if o.IsZero() {
+		return ""
+	}
+	g := ""
+	if o.Grayscale {
+		g = "g"
+	}
+	parts := []string{o.PageSize, o.Orientation,
+		o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight, g}
+	if o.HeaderHTML != "" || o.FooterHTML != "" {
+		hsh := sha1.Sum([]byte(o.HeaderHTML + "\x00" + o.FooterHTML))
+		parts = append(parts, hex.EncodeToString(hsh[:4]))
+	}
+	return strings.Join(parts, "-")
+}
+
+// args returns the command line arguments for wkhtmltopdf.
+func (o WkhtmltopdfOptions) args() []string {
+	var args []string
+	if o.PageSize != "" {
+		args = append(args, "--page-size", o.PageSize)
+	}
+	if o.Orientation != "" {
+		args = append(args, "--orientation", strings.Title(strings.ToLower(o.Orientation)))
+	}
+	for _, m := range [][2]string{
+		{"--margin-top", o.MarginTop},
+		{"--margin-bottom", o.MarginBottom},
+		{"--margin-left", o.MarginLeft},
+		{"--margin-right", o.MarginRight},
+	} {
+		if m[1] != "" {
+			args = append(args, m[0], m[1])
+		}
+	}
+	if o.Grayscale {
+		args = append(args, "--grayscale")
+	}
+	return args
+}
+
+// headerFooterArgs returns the --header-html and --footer-html arguments.
+// Inline HTML is written to temp files in dir, which are removed by the
+// returned cleanup function (unless LeaveTempFiles is set).
+func (o WkhtmltopdfOptions) headerFooterArgs(dir string) ([]string, func(), error) {
+	var args, tbd []string
+	cleanup := func() {
+		if LeaveTempFiles {
+			return
+		}
+		for _, fn := range tbd {
+			_ = os.Remove(fn)
+		}
+	}
+	for _, h := range [][2]string{
+		{"--header-html", o.HeaderHTML},
+		{"--footer-html", o.FooterHTML},
+	} {
+		if h[1] == "" {
+			continue
+		}
+		if isURL(h[1]) {
+			args = append(args, h[0], h[1])
+			continue
+		}
+		fh, err := ioutil.TempFile(dir, "wkhtml"+h[0][1:]+"-")
+		if err != nil {
+			cleanup()
+			return nil, nil, errors.Wrap(err, "create "+h[0][2:]+" file")
+		}
+		tbd = append(tbd, fh.Name())
+		_, err = fh.WriteString(h[1])
+		if closeErr := fh.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, errors.Wrap(err, fh.Name())
+		}
+		// wkhtmltopdf decides on the content type by the extension
+		fn := fh.Name() + ".html"
+		if err = os.Rename(fh.Name(), fn); err != nil {
+			cleanup()
+			return nil, nil, errors.Wrap(err, fn)
+		}
+		tbd[len(tbd)-1] = fn
+		args = append(args, h[0], fn)
+	}
+	return args, cleanup, nil
+}
+
+const wkhtmltopdfOptionsKey = "wkhtmltopdfOptions"
+
+// WithWkhtmltopdfOptions returns a context which carries the given options
+// for HTMLToPdf.
+func WithWkhtmltopdfOptions(ctx context.Context, opts WkhtmltopdfOptions) context.Context {
+	return context.WithValue(ctx, wkhtmltopdfOptionsKey, opts)
+}
+
+func getWkhtmltopdfOptions(ctx context.Context) WkhtmltopdfOptions {
+	if ctx == nil {
+		return WkhtmltopdfOptions{}
+	}
+	opts, _ := ctx.Value(wkhtmltopdfOptionsKey).(WkhtmltopdfOptions)
+	return opts
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWkhtmltopdfOptions(t *testing.T) {
+	for i, tc := range []struct {
+		opts WkhtmltopdfOptions
+		args []string
+		err  bool
+	}{
+		{WkhtmltopdfOptions{}, nil, false},
+		{WkhtmltopdfOptions{PageSize: "Letter", Orientation: "landscape"},
+			[]string{"--page-size", "Letter", "--orientation", "Landscape"}, false},
+		{WkhtmltopdfOptions{MarginTop: "10mm", MarginLeft: "1.5cm", Grayscale: true},
+			[]string{"--margin-top", "10mm", "--margin-left", "1.5cm", "--grayscale"}, false},
+		{WkhtmltopdfOptions{PageSize: "A4; rm -rf"}, nil, true},
+		{WkhtmltopdfOptions{Orientation: "sideways"}, nil, true},
+		{WkhtmltopdfOptions{MarginRight: "--quiet"}, nil, true},
+	} {
+		if err := tc.opts.Validate(); (err != nil) != tc.err {
+			t.Errorf("%d. Validate: got %v, wanted error? %t", i, err, tc.err)
+			continue
+		}
+		if tc.err {
+			continue
+		}
+		if got := tc.opts.args(); !reflect.DeepEqual(got, tc.args) {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.args)
+		}
+	}
+}
+
+func TestWkhtmltopdfHeaderFooter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-wkhtml-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := WkhtmltopdfOptions{
+		HeaderHTML: "http://example.com/header.html",
+		FooterHTML: "<html><body>footer</body></html>",
+	}
+	if err = opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	args, cleanup, err := opts.headerFooterArgs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 4 || args[0] != "--header-html" || args[1] != opts.HeaderHTML || args[2] != "--footer-html" {
+		t.Fatalf("got %q", args)
+	}
+	if !strings.HasSuffix(args[3], ".html") {
+		t.Errorf("footer file %q should have .html extension", args[3])
+	}
+	if b, err := ioutil.ReadFile(args[3]); err != nil {
+		t.Fatal(err)
+	} else if string(b) != opts.FooterHTML {
+		t.Errorf("footer file: got %q, wanted %q", b, opts.FooterHTML)
+	}
+	cleanup()
+	if _, err = os.Stat(args[3]); !os.IsNotExist(err) {
+		t.Errorf("footer file %q should be removed, got %v", args[3], err)
+	}
+
+	if (WkhtmltopdfOptions{HeaderHTML: "http://a b"}).Validate() == nil {
+		t.Errorf("URL with space should be invalid")
+	}
+	if s := opts.String(); s == "" || s == (WkhtmltopdfOptions{}).String() {
+		t.Errorf("String should contain the header/footer hash, got %q", s)
+	}
+}
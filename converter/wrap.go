@@ -0,0 +1,99 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+const tabWidth = 8
+
+// lineWrapper is a writer which breaks the lines longer than width runes.
+// Tabs are kept, counted up to the next tab stop; multibyte UTF-8
+// sequences are never broken.
+type lineWrapper struct {
+	w       io.Writer
+	width   int
+	col     int
+	pending []byte
+	buf     []byte
+}
+
+func newLineWrapper(w io.Writer, width int) *lineWrapper {
+	return &lineWrapper{w: w, width: width}
+}
+
+func (lw *lineWrapper) Write(p []byte) (int, error) {
+	if lw.width <= 0 {
+		return lw.w.Write(p)
+	}
+	b := p
+	if len(lw.pending) != 0 {
+		b = append(lw.pending, p...)
+		lw.pending = nil
+	}
+	lw.buf = lw.buf[:0]
+	for len(b) != 0 {
+		if !utf8.FullRune(b) {
+			lw.pending = append(lw.pending, b...)
+			break
+		}
+		r, size := utf8.DecodeRune(b)
+		switch r {
+		case '\n':
+			lw.col = 0
+		case '\r':
+		case '\t':
+			next := (lw.col/tabWidth + 1) * tabWidth
+			if lw.col != 0 && next > lw.width {
+				lw.buf = append(lw.buf, '\n')
+				next = tabWidth
+			}
+			lw.col = next
+		default:
+			if lw.col >= lw.width {
+				lw.buf = append(lw.buf, '\n')
+				lw.col = 0
+			}
+			lw.col++
+		}
+		lw.buf = append(lw.buf, b[:size]...)
+		b = b[size:]
+	}
+	if _, err := lw.w.Write(lw.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes out the remaining incomplete rune, if any.
+func (lw *lineWrapper) Close() error {
+	if len(lw.pending) == 0 {
+		return nil
+	}
+	_, err := lw.w.Write(lw.pending)
+	lw.pending = nil
+	return err
+}
+
+const textWrapWidthKey = "textWrapWidth"
+
+// WithTextWrapWidth returns a context which carries the line width
+// the text conversions wrap at, overriding ConfTextWrapWidth (0 means no wrapping).
+func WithTextWrapWidth(ctx context.Context, width int) context.Context {
+	return context.WithValue(ctx, textWrapWidthKey, width)
+}
+
+func getTextWrapWidth(ctx context.Context) int {
+	if ctx != nil {
+		if width, ok := ctx.Value(textWrapWidthKey).(int); ok {
+			return width
+		}
+	}
+	return *ConfTextWrapWidth
+}
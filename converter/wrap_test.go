@@ -0,0 +1,57 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+func TestLineWrapper(t *testing.T) {
+	for i, tc := range []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"abcdef", 0, "abcdef"},
+		{"abcdef", 3, "abc\ndef"},
+		{"abc\ndef", 3, "abc\ndef"},
+		{"abcdefg", 3, "abc\ndef\ng"},
+		{"árvíztűrő", 4, "árví\nztűr\nő"},
+		{"a\tb\tc", 10, "a\tb\n\tc"},
+		{"\t\tx", 16, "\t\t\nx"},
+		{"a\r\nb", 1, "a\r\nb"},
+	} {
+		var buf bytes.Buffer
+		lw := newLineWrapper(&buf, tc.width)
+		// write byte by byte, to cut the multibyte runes
+		for j := 0; j < len(tc.in); j++ {
+			if _, err := lw.Write([]byte{tc.in[j]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+		if !utf8.Valid(buf.Bytes()) {
+			t.Errorf("%d. invalid UTF-8: %q", i, buf.Bytes())
+		}
+	}
+}
+
+func TestTextWrapWidth(t *testing.T) {
+	if got := getTextWrapWidth(context.Background()); got != *ConfTextWrapWidth {
+		t.Errorf("got %d, wanted the default %d", got, *ConfTextWrapWidth)
+	}
+	if got := getTextWrapWidth(WithTextWrapWidth(context.Background(), 0)); got != 0 {
+		t.Errorf("got %d, wanted 0", got)
+	}
+}
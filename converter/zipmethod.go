@@ -0,0 +1,84 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// compressedTypes are the content-types which are already compressed,
+// so they're stored in the zip files as is.
+var compressedTypes = map[string]bool{
+	"application/pdf":              true,
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/rar":              true,
+	"image/jpeg":                   true,
+	"image/png":                    true,
+	"image/gif":                    true,
+	"image/heic":                   true,
+	"image/heif":                   true,
+	"image/webp":                   true,
+}
+
+// isCompressedType reports whether the content-type is already compressed.
+// The OOXML and ODF documents are zip files, too.
+func isCompressedType(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	return compressedTypes[contentType] ||
+		strings.HasPrefix(contentType, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(contentType, "application/vnd.oasis.") ||
+		strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "video/")
+}
+
+// zipLevel returns ConfZipCompression, or the default level if it is invalid.
+func zipLevel() int {
+	if level := *ConfZipCompression; level >= flate.HuffmanOnly && level <= flate.BestCompression {
+		return level
+	}
+	return flate.DefaultCompression
+}
+
+// zipMethod returns the compression method for the zip entry of the given
+// name (and content-type, if known): Store for the already compressed ones
+// (or if ConfZipCompression is 0), Deflate for the rest.
+func zipMethod(name, contentType string) uint16 {
+	if zipLevel() == flate.NoCompression {
+		return zip.Store
+	}
+	if contentType == "" {
+		if ext := filepath.Ext(name); len(ext) > 1 {
+			var ok bool
+			if contentType, ok = extContentType(ext[1:]); !ok {
+				contentType = mime.TypeByExtension(ext)
+			}
+		}
+	}
+	if isCompressedType(contentType) {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// newZipWriter returns a zip.Writer deflating with the ConfZipCompression level.
+func newZipWriter(w io.Writer) *zip.Writer {
+	zw := zip.NewWriter(w)
+	level := zipLevel()
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	return zw
+}
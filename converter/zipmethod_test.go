@@ -0,0 +1,65 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipMethod(t *testing.T) {
+	defer func(level int) { *ConfZipCompression = level }(*ConfZipCompression)
+	dir, err := ioutil.TempDir("", "agostle-zip-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var items []ArchFileItem
+	for _, nm := range []string{"a.pdf", "b.png", "c.txt", "d.fdf", "e.docx"} {
+		fn := filepath.Join(dir, nm)
+		if err = ioutil.WriteFile(fn, bytes.Repeat([]byte(nm), 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, ArchFileItem{Filename: fn, Archive: nm})
+	}
+
+	for _, tc := range []struct {
+		level int
+		want  map[string]uint16
+	}{
+		{-1, map[string]uint16{"a.pdf": zip.Store, "b.png": zip.Store, "c.txt": zip.Deflate,
+			"d.fdf": zip.Deflate, "e.docx": zip.Store}},
+		{9, map[string]uint16{"a.pdf": zip.Store, "c.txt": zip.Deflate}},
+		{0, map[string]uint16{"a.pdf": zip.Store, "c.txt": zip.Store, "d.fdf": zip.Store}},
+	} {
+		*ConfZipCompression = tc.level
+		var buf bytes.Buffer
+		if err = ZipFiles(&buf, false, false, items...); err != nil {
+			t.Fatalf("%d. ZipFiles: %v", tc.level, err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if want, ok := tc.want[f.Name]; ok && f.Method != want {
+				t.Errorf("level %d: %s: got method %d, wanted %d", tc.level, f.Name, f.Method, want)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil || len(b) != 1000*len(f.Name) {
+				t.Errorf("level %d: %s: read %d bytes: %v", tc.level, f.Name, len(b), err)
+			}
+		}
+	}
+}
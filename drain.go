@@ -0,0 +1,75 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	"gopkg.in/tylerb/graceful.v1"
+)
+
+var (
+	serversMu sync.Mutex
+	servers   []*graceful.Server
+
+	drainOnce    sync.Once
+	drainStarted = make(chan struct{})
+)
+
+// registerServer registers the server to be stopped by drainAndExit.
+func registerServer(s *graceful.Server) {
+	serversMu.Lock()
+	servers = append(servers, s)
+	serversMu.Unlock()
+}
+
+// drainAndExit stops accepting new requests, waits (up to ConfDrainTimeout)
+// for the running conversions to finish, kills the remaining child processes,
+// then exits with the given code. It returns immediately, draining in the background.
+func drainAndExit(code int) {
+	drainOnce.Do(func() {
+		close(drainStarted)
+		go drain(code)
+	})
+}
+
+func drain(code int) {
+	Log := logger.With("fn", "drain").Log
+	timeout := *converter.ConfDrainTimeout
+	Log("msg", "draining", "active", converter.ActiveWork(), "timeout", timeout)
+	serversMu.Lock()
+	for _, s := range servers {
+		go s.Stop(timeout)
+	}
+	serversMu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for converter.ActiveWork() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := converter.ActiveWork(); n > 0 {
+		Log("msg", "drain timed out, killing the child processes", "active", n)
+		converter.KillChildren()
+		// let the handlers return their errors
+		time.Sleep(time.Second)
+	}
+	Log("msg", "SUICIDE for ask!")
+	os.Exit(code)
+}
+
+// exitUnlessDraining exits with the code - or waits for drain to exit,
+// if it has been started (as that stops the servers, too).
+func exitUnlessDraining(code int) {
+	select {
+	case <-drainStarted:
+		select {}
+	default:
+		os.Exit(code)
+	}
+}
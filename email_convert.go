@@ -13,10 +13,12 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"golang.org/x/net/context"
@@ -24,6 +26,8 @@ import (
 	"github.com/tgulacsi/agostle/converter"
 
 	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
 )
 
 var emailConvertServer = kithttp.NewServer(
@@ -31,21 +35,66 @@ var emailConvertServer = kithttp.NewServer(
 	emailConvertEP,
 	emailConvertDecode,
 	emailConvertEncode,
-	kithttp.ServerBefore(append([]kithttp.RequestFunc{SaveRequest}, defaultBeforeFuncs...)...),
+	kithttp.ServerBefore(defaultBeforeFuncs...),
 	kithttp.ServerAfter(kithttp.SetContentType("application/zip")),
+	kithttp.ServerErrorEncoder(errorEncoder),
 )
 
 type convertParams struct {
 	ContentType, OutImg, ImgSize string
-	Splitted                     bool
+	Splitted, ImagesOnly         bool
+	Wkhtmltopdf                  converter.WkhtmltopdfOptions
+	Quality                      converter.GsProfile
+	Fit                          converter.ImageFitOpts
+	Encrypt                      converter.EncryptOpts
+	// Wrap is the line width of the text conversions, -1 if not given.
+	Wrap int
+	// Density is the DPI of the image conversions, 0 if not given.
+	Density int
+	// EmbedFonts asks for embedding all the fonts into the PDFs.
+	EmbedFonts bool
+	// Version is the PDF version the PDFs are written with, "" for leaving them as is.
+	Version converter.PdfVersion
+	// SourceHTML asks for the HTML the parts were converted from, in the zip.
+	SourceHTML bool
 }
 
 func (p convertParams) String() string {
 	c := "m"
-	if p.Splitted {
+	if p.ImagesOnly {
+		c = "i"
+	} else if p.Splitted {
 		c = "s"
 	}
-	return strings.Replace(p.ContentType, "/", "--", -1) + "_" + strings.Replace(p.OutImg, "/", "--", -1) + "_" + p.ImgSize + "_" + c
+	s := strings.Replace(p.ContentType, "/", "--", -1) + "_" + strings.Replace(p.OutImg, "/", "--", -1) + "_" + p.ImgSize + "_" + c
+	if w := p.Wkhtmltopdf.String(); w != "" {
+		s += "_" + w
+	}
+	if p.Quality != "" {
+		s += "_q" + string(p.Quality)
+	}
+	if f := p.Fit.String(); f != "" {
+		s += "_f" + f
+	}
+	if e := p.Encrypt.String(); e != "" {
+		s += "_e" + e
+	}
+	if p.Wrap >= 0 {
+		s += "_w" + strconv.Itoa(p.Wrap)
+	}
+	if p.Density > 0 {
+		s += "_d" + strconv.Itoa(p.Density)
+	}
+	if p.EmbedFonts {
+		s += "_ef"
+	}
+	if p.Version != "" {
+		s += "_v" + string(p.Version)
+	}
+	if p.SourceHTML {
+		s += "_src"
+	}
+	return s
 }
 
 var etagRe = regexp.MustCompile(`"[^"]+"`)
@@ -57,25 +106,83 @@ type emailConvertRequest struct {
 }
 
 func emailConvertDecode(ctx context.Context, r *http.Request) (interface{}, error) {
-	req := emailConvertRequest{Params: convertParams{
-		Splitted: r.Form.Get("splitted") == "1",
-		OutImg:   r.Form.Get("outimg"),
-		ImgSize:  r.Form.Get("imgsize"),
-	}}
+	var req emailConvertRequest
+	var err error
+	// read the file first, as that parses the (multipart) form, too
+	req.Input, err = getOneRequestFile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Params = convertParams{
+		Splitted:   r.FormValue("splitted") == "1",
+		EmbedFonts: r.FormValue("embedFonts") == "1",
+		SourceHTML: r.FormValue("sourceHTML") == "1",
+		OutImg:     r.FormValue("outimg"),
+		ImgSize:    r.FormValue("imgsize"),
+		Wrap:       -1,
+	}
+	if s := r.FormValue("imageSize"); s != "" {
+		req.Params.ImgSize = s
+	}
 	if req.Params.ImgSize == "" {
 		req.Params.ImgSize = defaultImageSize
 	}
-	for _, a := range r.Header["Accept"] {
-		if strings.HasPrefix(a, "image/") {
-			req.Params.OutImg = a
-			break
+	req.Params.Wkhtmltopdf = converter.WkhtmltopdfOptions{
+		PageSize:     r.FormValue("pageSize"),
+		Orientation:  r.FormValue("orientation"),
+		MarginTop:    r.FormValue("marginTop"),
+		MarginBottom: r.FormValue("marginBottom"),
+		MarginLeft:   r.FormValue("marginLeft"),
+		MarginRight:  r.FormValue("marginRight"),
+		Grayscale:    r.FormValue("grayscale") == "1",
+		HeaderHTML:   r.FormValue("headerHtml"),
+		FooterHTML:   r.FormValue("footerHtml"),
+	}
+	if err = req.Params.Wkhtmltopdf.Validate(); err != nil {
+		_ = req.Input.Close()
+		return nil, err
+	}
+	req.Params.Quality = converter.GsProfile(r.FormValue("quality"))
+	if err = req.Params.Quality.Validate(); err != nil {
+		_ = req.Input.Close()
+		return nil, err
+	}
+	if req.Params.Fit, err = converter.ParseImageFitOpts(r.FormValue("fit")); err != nil {
+		_ = req.Input.Close()
+		return nil, err
+	}
+	if s := r.FormValue("wrap"); s != "" {
+		if req.Params.Wrap, err = strconv.Atoi(s); err != nil || req.Params.Wrap < 0 {
+			_ = req.Input.Close()
+			return nil, badRequest(errors.Errorf("bad wrap width %q", s))
 		}
 	}
-	var err error
-	req.Input, err = getOneRequestFile(ctx, r)
-	if err != nil {
+	if req.Params.Density, err = getDensity(r); err != nil {
+		_ = req.Input.Close()
+		return nil, err
+	}
+	if req.Params.Version, err = getPdfVersion(r); err != nil {
+		_ = req.Input.Close()
+		return nil, err
+	}
+	// Accept: image/gif asks for the rendered pages only
+	if a := acceptedImage(r.Header["Accept"]); a != "" {
+		req.Params.OutImg, req.Params.Splitted, req.Params.ImagesOnly = a, true, true
+	}
+	if req.Params.Encrypt, err = getEncryptOpts(r); err != nil {
+		_ = req.Input.Close()
 		return nil, err
 	}
+	if !req.Params.Encrypt.IsZero() && req.Params.OutImg != "" {
+		_ = req.Input.Close()
+		return nil, badRequest(errors.New("images cannot be encrypted"))
+	}
+	if req.Params.OutImg != "" {
+		if err = converter.ValidImageSize(req.Params.ImgSize); err != nil {
+			_ = req.Input.Close()
+			return nil, badRequest(err)
+		}
+	}
 	contentType := req.Input.Header.Get("Content-Type")
 	if contentType == "" || contentType == "application/octet-stream" {
 		contentType = "message/rfc822"
@@ -147,36 +254,112 @@ func emailConvertEP(ctx context.Context, request interface{}) (response interfac
 	}
 
 	h := sha1.New()
-	inpFn, err := readerToFile(io.TeeReader(req.Input, h), req.Input.Filename)
-	if err != nil {
-		return resp, fmt.Errorf("cannot read input file: %v", err)
+	var input io.Reader
+	// the unspooled mails are converted as they're read, the rest spooled first
+	stream := *converter.ConfStreamMail && req.Params.ContentType == "message/rfc822" &&
+		!isSpooled(req.Input.ReadCloser)
+	if stream {
+		input = io.TeeReader(req.Input, h)
+		// the hash is known only at the end; moved into the cache (in Workdir) then
+		fh, err := ioutil.TempFile(converter.GetWorkdir(ctx), "result-stream-"+reqPrefix(ctx))
+		if err != nil {
+			return resp, err
+		}
+		tmpFn := fh.Name()
+		_ = fh.Close()
+		defer func() { _ = os.Remove(tmpFn) }()
+		resp.outFn = tmpFn
+	} else {
+		inpFn, err := readerToFile(ctx, io.TeeReader(req.Input, h), req.Input.Filename)
+		if err != nil {
+			return resp, fmt.Errorf("cannot read input file: %v", err)
+		}
+		if !converter.KeepTempFiles(ctx) {
+			defer func() { _ = os.Remove(inpFn) }()
+		}
+		hsh := base64.URLEncoding.EncodeToString(h.Sum(nil))
+		if resp.outFn, err = getCachedFn(hsh); err == nil {
+			return resp, nil
+		}
+		fh, err := os.Open(inpFn)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = fh.Close() }()
+		input = fh
 	}
-	if !converter.LeaveTempFiles {
-		defer func() { _ = os.Remove(inpFn) }()
+	if !req.Params.Wkhtmltopdf.IsZero() {
+		ctx = converter.WithWkhtmltopdfOptions(ctx, req.Params.Wkhtmltopdf)
 	}
-	hsh := base64.URLEncoding.EncodeToString(h.Sum(nil))
-	if resp.outFn, err = getCachedFn(hsh); err == nil {
-		return resp, nil
+	if req.Params.Quality != "" {
+		ctx = converter.WithGsProfile(ctx, req.Params.Quality)
 	}
-
-	input, err := os.Open(inpFn)
-	if err != nil {
-		return nil, err
+	if !req.Params.Fit.IsZero() {
+		ctx = converter.WithImageFitOpts(ctx, req.Params.Fit)
+	}
+	if !req.Params.Encrypt.IsZero() {
+		ctx = converter.WithEncryptOpts(ctx, req.Params.Encrypt)
+	}
+	if req.Params.Wrap >= 0 {
+		ctx = converter.WithTextWrapWidth(ctx, req.Params.Wrap)
+	}
+	if req.Params.Density > 0 {
+		ctx = converter.WithImageDensity(ctx, req.Params.Density)
+	}
+	if req.Params.EmbedFonts {
+		ctx = converter.WithEmbedFonts(ctx, true)
+	}
+	if req.Params.Version != "" {
+		ctx = converter.WithPdfVersion(ctx, req.Params.Version)
+	}
+	if req.Params.SourceHTML {
+		ctx = converter.WithOriginalHTML(ctx, true)
 	}
 
-	if !req.Params.Splitted && req.Params.OutImg == "" {
+	if req.Params.ImagesOnly {
+		err = converter.MailToImageZip(ctx, resp.outFn, input, req.Params.ContentType,
+			req.Params.OutImg, req.Params.ImgSize)
+	} else if !req.Params.Splitted && req.Params.OutImg == "" {
 		err = converter.MailToPdfZip(ctx, resp.outFn, input, req.Params.ContentType)
 	} else {
 		err = converter.MailToSplittedPdfZip(ctx, resp.outFn, input, req.Params.ContentType,
 			req.Params.Splitted, req.Params.OutImg, req.Params.ImgSize)
 	}
+	if stream {
+		// read the rest for the hash, and see whether the body was complete
+		if _, readErr := io.Copy(ioutil.Discard, input); readErr != nil {
+			if isTooLarge(readErr) {
+				return resp, tooLarge(readErr)
+			}
+			return resp, fmt.Errorf("cannot read input file: %v", readErr)
+		}
+	}
 	if err != nil {
-		Log("msg", "MailToSplittedPdfZip from", "from", input, "out", resp.outFn, "params", req.Params, "error", err)
+		Log("msg", "MailToSplittedPdfZip from", "from", req.Input.Filename, "out", resp.outFn, "params", req.Params, "error", err)
 		return resp, err
 	}
+	if stream {
+		tmpFn := resp.outFn
+		hsh := base64.URLEncoding.EncodeToString(h.Sum(nil))
+		if resp.outFn, err = getCachedFn(hsh); err == nil {
+			return resp, nil
+		}
+		if err = os.Rename(tmpFn, resp.outFn); err != nil {
+			return resp, err
+		}
+	}
 	return resp, nil
 }
 
+// isSpooled reports whether the uploaded file is already on the disk.
+func isSpooled(r io.Reader) bool {
+	switch r.(type) {
+	case spooledFile, *os.File:
+		return true
+	}
+	return false
+}
+
 type emailConvertResponse struct {
 	r           *http.Request
 	outFn, hsh  string
@@ -195,6 +378,48 @@ func emailConvertEncode(ctx context.Context, w http.ResponseWriter, response int
 	return nil
 }
 
+// getDensity returns the image density (DPI) from the density form field,
+// capped at converter.MaxImageDensity; 0 if not given.
+func getDensity(r *http.Request) (int, error) {
+	s := r.FormValue("density")
+	if s == "" {
+		return 0, nil
+	}
+	density, err := strconv.Atoi(s)
+	if err != nil || density <= 0 {
+		return 0, badRequest(errors.Errorf("bad density %q", s))
+	}
+	if density > converter.MaxImageDensity {
+		density = converter.MaxImageDensity
+	}
+	return density, nil
+}
+
+// getPdfVersion returns the PDF version asked by the pdfVersion form field.
+func getPdfVersion(r *http.Request) (converter.PdfVersion, error) {
+	v := converter.PdfVersion(r.FormValue("pdfVersion"))
+	if err := v.Validate(); err != nil {
+		return "", badRequest(err)
+	}
+	return v, nil
+}
+
+// acceptedImage returns the first image/gif or image/png from the Accept headers.
+func acceptedImage(accept []string) string {
+	for _, a := range accept {
+		for _, part := range strings.Split(a, ",") {
+			if i := strings.IndexByte(part, ';'); i >= 0 {
+				part = part[:i]
+			}
+			switch part = strings.TrimSpace(part); part {
+			case "image/gif", "image/png":
+				return part
+			}
+		}
+	}
+	return ""
+}
+
 func SaveRequest(ctx context.Context, r *http.Request) context.Context {
 	return context.WithValue(ctx, "http.Request", r)
 }
@@ -0,0 +1,86 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+func TestEmailConvertImageSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-email-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	defer func(old string) { converter.Workdir = old }(converter.Workdir)
+	converter.Workdir = dir
+
+	for i, tc := range []struct {
+		accept  string
+		fields  map[string]string
+		wantBad bool
+	}{
+		{"image/gif", map[string]string{"imgsize": "big"}, true},
+		{"", map[string]string{"outimg": "image/png", "imageSize": "640x"}, true},
+		{"image/gif", map[string]string{"imgsize": "0x100"}, true},
+	} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		w, err := mw.CreateFormFile("file", "a.eml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("Subject: a\r\n\r\nbody\r\n"))
+		for k, v := range tc.fields {
+			if err = mw.WriteField(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/email/convert", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		emailConvertServer.ServeHTTP(rec, r)
+		if tc.wantBad && rec.Code != http.StatusBadRequest {
+			t.Errorf("%d. got %d, wanted %d: %s", i, rec.Code, http.StatusBadRequest, rec.Body.Bytes())
+		}
+	}
+
+	// valid size: decoded
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	w, err := mw.CreateFormFile("file", "a.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("Subject: a\r\n\r\nbody\r\n"))
+	_ = mw.WriteField("imgsize", "320x200")
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/email/convert", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Accept", "image/gif")
+	req, err := emailConvertDecode(r.Context(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecr := req.(emailConvertRequest)
+	_ = ecr.Input.Close()
+	if !ecr.Params.ImagesOnly || ecr.Params.ImgSize != "320x200" {
+		t.Errorf("got %+v", ecr.Params)
+	}
+}
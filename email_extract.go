@@ -0,0 +1,67 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+var emailExtractServer = kithttp.NewServer(
+	context.Background(),
+	emailExtractEP,
+	emailExtractDecode,
+	emailExtractEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("application/zip")),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+func emailExtractDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	return getOneRequestFile(ctx, r)
+}
+
+// emailExtractEP returns the name of the zip file of the raw attachments.
+func emailExtractEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	f := request.(reqFile)
+	defer func() { _ = f.Close() }()
+	fh, err := ioutil.TempFile(converter.GetWorkdir(ctx), "extract-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp file")
+	}
+	outfn := fh.Name()
+	_ = fh.Close()
+	if err = converter.MailToAttachmentsZip(ctx, outfn, f, "message/rfc822"); err != nil {
+		_ = os.Remove(outfn)
+		getLogger(ctx).Log("msg", "MailToAttachmentsZip", "file", f.Filename, "error", err)
+		return nil, err
+	}
+	return outfn, nil
+}
+
+func emailExtractEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	outfn := response.(string)
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(outfn) }()
+	}
+	fh, err := os.Open(outfn)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fh.Close() }()
+	w.Header().Set("Content-Disposition", `attachment; filename="attachments.zip"`)
+	_, err = io.Copy(w, fh)
+	return err
+}
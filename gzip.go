@@ -0,0 +1,123 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// gzipResponse compresses the text-like responses with gzip,
+// if the client accepts it. Already compressed bodies (zip, pdf, images)
+// are written as is.
+func gzipResponse(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header["Accept-Encoding"]) {
+			h(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		h(gw, r)
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header values allow gzip.
+func acceptsGzip(accept []string) bool {
+	for _, a := range accept {
+		for _, enc := range strings.Split(a, ",") {
+			enc = strings.TrimSpace(enc)
+			name, q := enc, ""
+			if i := strings.IndexByte(enc, ';'); i >= 0 {
+				name, q = strings.TrimSpace(enc[:i]), strings.Replace(enc[i+1:], " ", "", -1)
+			}
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			return !(q == "q=0" || strings.HasPrefix(q, "q=0.0") && strings.Trim(q[5:], "0") == "")
+		}
+	}
+	return false
+}
+
+// compressible reports whether the content-type is worth compressing.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/javascript",
+		"application/vnd.fdf":
+		return true
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide sets up compression, based on the response headers.
+func (w *gzipResponseWriter) decide(code int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	hdr := w.Header()
+	if hdr.Get("Content-Encoding") != "" || !compressible(hdr.Get("Content-Type")) {
+		return
+	}
+	hdr.Del("Content-Length")
+	hdr.Set("Content-Encoding", "gzip")
+	hdr.Add("Vary", "Accept-Encoding")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	w.decide(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.decide(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush flushes the compressed data, and the underlying ResponseWriter.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the gzip stream.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+var inspectServer = kithttp.NewServer(
+	context.Background(),
+	inspectEP,
+	inspectDecode,
+	inspectEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("application/json")),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+func inspectDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	return getOneRequestFile(ctx, r)
+}
+
+// inspectEP returns the conversion plan of the uploaded file, without converting it.
+func inspectEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	f := request.(reqFile)
+	defer func() { _ = f.Close() }()
+	plan, err := converter.Inspect(ctx, f, f.Header.Get("Content-Type"), f.Filename)
+	if err != nil {
+		getLogger(ctx).Log("msg", "Inspect", "file", f.Filename, "error", err)
+		return nil, err
+	}
+	return plan, nil
+}
+
+func inspectEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	return json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,278 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+// The clients may get a job id with a POST to /jobs/, name their conversion
+// requests with it in an X-Job-Id header (or a jobId query parameter), and
+// follow the progress of them at /jobs/{id}/events, as Server-Sent Events.
+// The ids are random and generated by the server, so nobody else can follow the job.
+
+var jobIDRx = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+const (
+	// jobLinger is the time a finished job's last event is kept for the late subscribers.
+	jobLinger = 1 * time.Minute
+	// jobIdle is the time a created job is kept without a conversion request using it.
+	jobIdle = 5 * time.Minute
+	// sseKeepAlive is the interval of the keep-alive comments of the event stream.
+	sseKeepAlive = 15 * time.Second
+)
+
+// job is the progress broker of one conversion request.
+type job struct {
+	id       string
+	mu       sync.Mutex
+	subs     map[chan converter.Progress]struct{}
+	last     *converter.Progress
+	running  bool
+	finished bool
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*job)
+)
+
+// newJob creates a job with a new random id, which is removed after jobIdle
+// if no conversion request has used it.
+func newJob() (*job, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generate job id")
+	}
+	j := &job{id: hex.EncodeToString(b), subs: make(map[chan converter.Progress]struct{})}
+	jobsMu.Lock()
+	jobs[j.id] = j
+	jobsMu.Unlock()
+	time.AfterFunc(jobIdle, j.expire)
+	return j, nil
+}
+
+// getJob returns the job with the id, or nil if there is no such job.
+func getJob(id string) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return jobs[id]
+}
+
+func removeJob(j *job) {
+	jobsMu.Lock()
+	if jobs[j.id] == j {
+		delete(jobs, j.id)
+	}
+	jobsMu.Unlock()
+}
+
+// publish sends the progress to the subscribers; the slow ones miss it.
+func (j *job) publish(p converter.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.last = &p
+	for ch := range j.subs {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// finish sends the "finish" event, and closes the subscriptions.
+func (j *job) finish() {
+	j.mu.Lock()
+	p := converter.Progress{Event: "finish"}
+	if j.last != nil {
+		p.Done, p.Total = j.last.Done, j.last.Total
+	}
+	j.last = &p
+	for ch := range j.subs {
+		select {
+		case ch <- p:
+		default:
+		}
+		close(ch)
+		delete(j.subs, ch)
+	}
+	j.running, j.finished = false, true
+	j.mu.Unlock()
+	time.AfterFunc(jobLinger, func() {
+		j.mu.Lock()
+		finished := j.finished // not restarted
+		j.mu.Unlock()
+		if finished {
+			removeJob(j)
+		}
+	})
+}
+
+// expire removes the job, and closes the subscriptions, if it has never been started.
+func (j *job) expire() {
+	j.mu.Lock()
+	idle := !j.running && !j.finished
+	if idle {
+		for ch := range j.subs {
+			close(ch)
+			delete(j.subs, ch)
+		}
+	}
+	j.mu.Unlock()
+	if idle {
+		removeJob(j)
+	}
+}
+
+// subscribe returns a channel of the progress events (starting with the last one),
+// which is closed when the job finishes; call the returned function to unsubscribe.
+func (j *job) subscribe() (<-chan converter.Progress, func()) {
+	ch := make(chan converter.Progress, 16)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.last != nil {
+		ch <- *j.last
+	}
+	if j.finished {
+		close(ch)
+		return ch, func() {}
+	}
+	j.subs[ch] = struct{}{}
+	return ch, func() {
+		j.mu.Lock()
+		if _, ok := j.subs[ch]; ok {
+			delete(j.subs, ch)
+			close(ch)
+		}
+		j.mu.Unlock()
+	}
+}
+
+// withJob reports the progress of the request's conversion to the job named
+// by the X-Job-Id header or the jobId query parameter, if any.
+// The job must have been created by a POST to /jobs/.
+func withJob(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Job-Id")
+		if id == "" {
+			id = r.URL.Query().Get("jobId")
+		}
+		if id == "" {
+			h(w, r)
+			return
+		}
+		var j *job
+		if jobIDRx.MatchString(id) {
+			j = getJob(id)
+		}
+		if j == nil {
+			writeError(r.Context(), w, errors.Errorf("unknown job id %q", id), http.StatusBadRequest)
+			return
+		}
+		j.mu.Lock()
+		j.running, j.finished, j.last = true, false, nil
+		j.mu.Unlock()
+		defer j.finish()
+		h(w, r.WithContext(converter.WithProgress(r.Context(), j.publish)))
+	}
+}
+
+// jobsHandler creates a job for a POST to /jobs/, returning its id as {"id": "..."},
+// and streams the progress of the job as Server-Sent Events, at /jobs/{id}/events.
+// The stream may be opened before the conversion starts.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if id == "" && r.Method == "POST" {
+		j, err := newJob()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(struct {
+			ID string `json:"id"`
+		}{j.id})
+		return
+	}
+	if !strings.HasSuffix(id, "/events") {
+		http.NotFound(w, r)
+		return
+	}
+	id = strings.TrimSuffix(id, "/events")
+	var j *job
+	if jobIDRx.MatchString(id) {
+		j = getJob(id)
+	}
+	if j == nil {
+		http.Error(w, fmt.Sprintf("unknown job id %q", id), http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ch, unsubscribe := j.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case p, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, p); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes the progress as a Server-Sent Event named after p.Event.
+func writeEvent(w http.ResponseWriter, p converter.Progress) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", p.Event, b)
+	return err
+}
+
+// progressFromRequest copies the ProgressFunc set by withJob into ctx.
+func progressFromRequest(ctx context.Context, r *http.Request) context.Context {
+	if f := converter.GetProgress(r.Context()); f != nil {
+		ctx = converter.WithProgress(ctx, f)
+	}
+	return ctx
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+func TestJobIDs(t *testing.T) {
+	newID := func() string {
+		w := httptest.NewRecorder()
+		jobsHandler(w, httptest.NewRequest("POST", "/jobs/", nil))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /jobs/: got %d, wanted %d", w.Code, http.StatusCreated)
+		}
+		var resp struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !jobIDRx.MatchString(resp.ID) {
+			t.Fatalf("bad id %q", resp.ID)
+		}
+		return resp.ID
+	}
+	id := newID()
+	if other := newID(); other == id {
+		t.Errorf("got the same id twice: %q", id)
+	}
+
+	// the clients cannot choose the ids
+	w := httptest.NewRecorder()
+	jobsHandler(w, httptest.NewRequest("GET", "/jobs/my-job/events", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("events of an unknown job: got %d, wanted %d", w.Code, http.StatusNotFound)
+	}
+	var called bool
+	h := withJob(func(w http.ResponseWriter, r *http.Request) {
+		called = converter.GetProgress(r.Context()) != nil
+	})
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/convert", nil)
+	r.Header.Set("X-Job-Id", "my-job")
+	h(w, r)
+	if w.Code != http.StatusBadRequest || called {
+		t.Errorf("unknown job: got %d (called=%t), wanted %d", w.Code, called, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/convert?jobId="+id, nil)
+	h(w, r)
+	if !called {
+		t.Errorf("known job: got %d, the handler is not called with the progress", w.Code)
+	}
+	if j := getJob(id); j == nil || !j.finished {
+		t.Errorf("the job is not finished: %+v", j)
+	}
+}
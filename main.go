@@ -10,6 +10,7 @@ import (
 	stdlog "log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -89,6 +90,18 @@ func main() {
 
 	Log := logger.Log
 	var closeLogfile func() error
+	// applyFlags applies the command line flags over the (re)loaded config,
+	// so they are kept across reloads.
+	applyFlags := func() {
+		if leaveTempFiles {
+			converter.LeaveTempFiles = true
+		}
+		if timeout > 0 && timeout != *converter.ConfChildTimeout {
+			Log("msg", "Setting timeout", "from", *converter.ConfChildTimeout, "to", timeout)
+			*converter.ConfChildTimeout = timeout
+		}
+		sortBeforeMerge = *converter.ConfSortBeforeMerge
+	}
 	cobra.OnInitialize(func() {
 		var err error
 		if closeLogfile, err = logToFile(logFile); err != nil {
@@ -99,8 +112,7 @@ func main() {
 			i18nmail.Debugf = nil
 		}
 		Log("leave_tempfiles?", leaveTempFiles)
-		converter.LeaveTempFiles = leaveTempFiles
-		converter.Concurrency = concurrency
+		converter.SetConcurrency(concurrency)
 		if configFile == "" {
 			if self, err := osext.Executable(); err != nil {
 				Log("msg", "Cannot determine executable file name", "error", err)
@@ -116,21 +128,16 @@ func main() {
 			}
 		}
 		Log("msg", "Loading config", "file", configFile)
-		if err := converter.LoadConfig(configFile); err != nil {
+		if err := converter.ReloadConfig(configFile, applyFlags); err != nil {
 			Log("msg", "Parsing config", "file", configFile, "error", err)
 			os.Exit(1)
 		}
-		if timeout > 0 && timeout != *converter.ConfChildTimeout {
-			Log("msg", "Setting timeout", "from", *converter.ConfChildTimeout, "to", timeout)
-			*converter.ConfChildTimeout = timeout
-		}
 		if closeLogfile == nil {
 			if closeLogfile, err = logToFile(*converter.ConfLogFile); err != nil {
 				Log("error", err)
 			}
 		}
 
-		sortBeforeMerge = *converter.ConfSortBeforeMerge
 		Log("msg", "commands",
 			"pdftk", *converter.ConfPdftk,
 			"loffice", *converter.ConfLoffice,
@@ -141,6 +148,7 @@ func main() {
 		)
 		Log("msg", "parameters",
 			"sortBeforeMerge", sortBeforeMerge,
+			"concurrency", converter.ConcLimit.Limit(),
 			"workdir", converter.Workdir,
 			"listen", *converter.ConfListenAddr,
 			"childTimeout", *converter.ConfChildTimeout,
@@ -195,17 +203,18 @@ func main() {
 			Long:  "serve [-savereq] addr.to.listen.on:port",
 			Run: func(cmd *cobra.Command, args []string) {
 				addr := getListenAddr(args)
+				go reloadConfigOnSignal(configFile, applyFlags)
 				if updateURL == "" || regularUpdates == 0 {
-					Log("msg", newHTTPServer(addr, savereq).ListenAndServe())
-					os.Exit(1)
+					Log("msg", listenAndServe(newHTTPServer(addr, savereq)))
+					exitUnlessDraining(1)
 				}
 				overseer.Run(overseer.Config{
 					Debug: true,
 					Program: func(state overseer.State) {
 						if state.Listener == nil {
 							Log("msg", "overseer gave nil listener! Will try "+addr)
-							Log("msg", newHTTPServer(addr, savereq).ListenAndServe())
-							os.Exit(1)
+							Log("msg", listenAndServe(newHTTPServer(addr, savereq)))
+							exitUnlessDraining(1)
 						}
 						startHTTPServerListener(state.Listener, savereq)
 					},
@@ -232,6 +241,23 @@ func main() {
 	}
 }
 
+// reloadConfigOnSignal reloads the config file whenever one of the reloadSignals arrives,
+// and applies the command line flags over it (see converter.ReloadConfig).
+func reloadConfigOnSignal(configFile string, applyFlags func()) {
+	if len(reloadSignals) == 0 {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, reloadSignals...)
+	Log := logger.With("fn", "reloadConfig").Log
+	for sig := range c {
+		Log("msg", "Reloading config", "file", configFile, "signal", sig)
+		if err := converter.ReloadConfig(configFile, applyFlags); err != nil {
+			Log("msg", "Parsing config", "file", configFile, "error", err)
+		}
+	}
+}
+
 func logToFile(fn string) (func() error, error) {
 	if fn == "" {
 		return nil, nil
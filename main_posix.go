@@ -5,3 +5,11 @@
 // license that can be found in the LICENSE file.
 
 package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals which make the server reload its config.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
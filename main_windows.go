@@ -23,6 +23,9 @@ import (
 
 const exeName = "agostle.exe"
 
+// reloadSignals is empty, as there is no SIGHUP on Windows.
+var reloadSignals []os.Signal
+
 func init() {
 	topCmd = []string{"tasklist", "/v", "/fi", "USERNAME eq " + os.Getenv("USER")}
 
@@ -63,7 +66,7 @@ func (p *program) Start(S service.Service) error {
 func (p *program) run() {
 	p.Server = newHTTPServer(getListenAddr(nil), false)
 	logger.Log("msg", "run")
-	if err := p.Server.ListenAndServe(); err != nil {
+	if err := listenAndServe(p.Server); err != nil {
 		logger.Log("error", err)
 		os.Exit(1)
 	}
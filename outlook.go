@@ -25,6 +25,7 @@ var outlookToEmailServer = kithttp.NewServer(
 	outlookToEmailEncode,
 	kithttp.ServerBefore(defaultBeforeFuncs...),
 	kithttp.ServerAfter(kithttp.SetContentType("mail/rfc822")),
+	kithttp.ServerErrorEncoder(errorEncoder),
 )
 
 func outlookToEmailDecode(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -34,7 +35,7 @@ func outlookToEmailDecode(ctx context.Context, r *http.Request) (interface{}, er
 func outlookToEmailEP(ctx context.Context, request interface{}) (response interface{}, err error) {
 	f := request.(reqFile)
 	defer func() { _ = f.Close() }()
-	return converter.NewOLEStorageReader(f)
+	return converter.NewOLEStorageReader(ctx, f)
 }
 
 func outlookToEmailEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/net/context"
@@ -52,21 +53,25 @@ Example:
 		pdfCmd.AddCommand(mergeCmd)
 	}
 
-	splitCmd := &cobra.Command{
-		Use:     "split",
-		Short:   "splits the given PDF into one per page",
-		Aliases: []string{"pdf_split"},
-		Run: func(cmd *cobra.Command, args []string) {
-			fn := inpFromArgs(args)
-			if err := splitPdfZip(out, fn); err != nil {
-				Log("msg", "splitPdfZip", "out", out, "fn", fn, "error", err)
-				os.Exit(1)
-			}
-		},
+	{
+		var bookmarks bool
+		splitCmd := &cobra.Command{
+			Use:     "split",
+			Short:   "splits the given PDF into one per page (or per top-level bookmark)",
+			Aliases: []string{"pdf_split"},
+			Run: func(cmd *cobra.Command, args []string) {
+				fn := inpFromArgs(args)
+				if err := splitPdfZip(out, fn, bookmarks); err != nil {
+					Log("msg", "splitPdfZip", "out", out, "fn", fn, "error", err)
+					os.Exit(1)
+				}
+			},
+		}
+		splitCmd.Flags().StringVarP(&out, "out", "o", "", "output file")
+		splitCmd.Flags().BoolVar(&bookmarks, "bookmarks", false, "split at the top-level bookmarks, not per page")
+		agostleCmd.AddCommand(splitCmd)
+		pdfCmd.AddCommand(splitCmd)
 	}
-	splitCmd.Flags().StringVarP(&out, "out", "o", "", "output file")
-	agostleCmd.AddCommand(splitCmd)
-	pdfCmd.AddCommand(splitCmd)
 
 	countCmd := &cobra.Command{
 		Use:     "count",
@@ -119,28 +124,52 @@ Example:
 		agostleCmd.AddCommand(topdfCmd)
 	}
 
+	rotateCmd := &cobra.Command{
+		Use:     "rotate [-o output] input.pdf degrees",
+		Short:   "rotate all pages of the PDF by degrees (multiple of 90)",
+		Aliases: []string{"pdf_rotate"},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 2 {
+				Log("msg", "rotate needs the input file and the degrees")
+				os.Exit(1)
+			}
+			if err := rotatePdf(out, args[0], args[1]); err != nil {
+				Log("msg", "rotatePdf", "out", out, "args", args, "error", err)
+				os.Exit(1)
+			}
+		},
+	}
+	rotateCmd.Flags().StringVarP(&out, "out", "o", "-", "output file")
+	pdfCmd.AddCommand(rotateCmd)
+
+	var flatten bool
 	fillPdfCmd := &cobra.Command{
-		Use:     "fill [-o output] input.pdf key1=value1 key2=value2...",
+		Use:     "fill [-o output] [--flatten] input.pdf key1=value1 key2=value2...",
 		Short:   "fill PDF form",
 		Aliases: []string{"pdf_fill", "fill_form", "pdf_fill_form"},
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := fillFdf(out, args[0], args[1:]...); err != nil {
+			if err := fillFdf(out, flatten, args[0], args[1:]...); err != nil {
 				Log("msg", "fillPdf", "out", out, "args", args, "error", err)
 				os.Exit(1)
 			}
 		},
 	}
 	fillPdfCmd.Flags().StringVarP(&out, "out", "o", "-", "output file")
+	fillPdfCmd.Flags().BoolVar(&flatten, "flatten", false, "flatten the form after filling")
 	agostleCmd.AddCommand(fillPdfCmd)
 	pdfCmd.AddCommand(fillPdfCmd)
 }
 
-func splitPdfZip(outfn, inpfn string) error {
+func splitPdfZip(outfn, inpfn string, bookmarks bool) error {
 	var changed bool
 	if inpfn, changed = ensureFilename(inpfn, false); changed {
 		defer func() { _ = os.Remove(inpfn) }()
 	}
-	filenames, err := converter.PdfSplit(inpfn)
+	split := converter.PdfSplit
+	if bookmarks {
+		split = converter.PdfSplitByBookmarks
+	}
+	filenames, err := split(ctx, inpfn)
 	if err != nil {
 		return err
 	}
@@ -152,7 +181,8 @@ func splitPdfZip(outfn, inpfn string) error {
 	for i, nm := range filenames {
 		files[i] = converter.ArchFileItem{Filename: nm}
 	}
-	ze := converter.ZipFiles(outfh, false, false, files...)
+	// the file names of the sections have their titles escaped
+	ze := converter.ZipFiles(outfh, false, bookmarks, files...)
 	closeErr := outfh.Close()
 	if ze != nil {
 		return ze
@@ -175,7 +205,7 @@ func cleanPdf(outfn, inpfn string) error {
 	}
 	outfn, changed = ensureFilename(outfn, true)
 	fmt.Fprintf(os.Stderr, "inpfn=%s outfn=%s\n", inpfn, outfn)
-	if err := converter.PdfRewrite(outfn, inpfn); err != nil {
+	if err := converter.PdfRewrite(ctx, outfn, inpfn, "", ""); err != nil {
 		if changed {
 			_ = os.Remove(outfn)
 		}
@@ -195,7 +225,7 @@ func toPdf(outfn, inpfn string, mime string) error {
 }
 
 func countPdf(inpfn string) error {
-	n, err := converter.PdfPageNum(inpfn)
+	n, err := converter.PdfPageNum(context.Background(), inpfn)
 	if err != nil {
 		return err
 	}
@@ -203,7 +233,32 @@ func countPdf(inpfn string) error {
 	return nil
 }
 
-func fillFdf(outfn, inpfn string, kv ...string) error {
+func rotatePdf(outfn, inpfn, degrees string) error {
+	d, err := strconv.Atoi(degrees)
+	if err != nil {
+		return err
+	}
+	var changed bool
+	if inpfn, changed = ensureFilename(inpfn, false); changed {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	dst, changed := ensureFilename(outfn, true)
+	if changed {
+		defer func() { _ = os.Remove(dst) }()
+	}
+	if err = converter.PdfRotate(ctx, dst, inpfn, d); err != nil || !changed {
+		return err
+	}
+	fh, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, fh)
+	_ = fh.Close()
+	return err
+}
+
+func fillFdf(outfn string, flatten bool, inpfn string, kv ...string) error {
 	values := make(map[string]string, len(kv))
 	for _, txt := range kv {
 		i := strings.IndexByte(txt, '=')
@@ -213,5 +268,8 @@ func fillFdf(outfn, inpfn string, kv ...string) error {
 		}
 		values[txt[:i]] = txt[i+1:]
 	}
-	return converter.PdfFillFdf(outfn, inpfn, values)
+	if flatten {
+		return converter.PdfFillFdfFlatten(ctx, outfn, inpfn, values)
+	}
+	return converter.PdfFillFdf(ctx, outfn, inpfn, values)
 }
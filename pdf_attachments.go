@@ -0,0 +1,76 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+var pdfAttachmentsServer = kithttp.NewServer(
+	context.Background(),
+	pdfAttachmentsEP,
+	pdfAttachmentsDecode,
+	pdfAttachmentsEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("application/zip")),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+func pdfAttachmentsDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	return getOneRequestFile(ctx, r)
+}
+
+// pdfAttachmentsEP returns the directory of the extracted attachments.
+func pdfAttachmentsEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	f := request.(reqFile)
+	defer func() { _ = f.Close() }()
+	inpfn, err := readerToFile(ctx, f, f.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", f.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	dn, err := ioutil.TempDir(converter.GetWorkdir(ctx), "attachments-"+reqPrefix(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	files, err := converter.PdfExtractAttachments(inpfn, dn)
+	if err != nil {
+		_ = os.RemoveAll(dn)
+		getLogger(ctx).Log("msg", "PdfExtractAttachments", "inp", inpfn, "error", err)
+		return nil, err
+	}
+	return pdfAttachments{dir: dn, files: files}, nil
+}
+
+type pdfAttachments struct {
+	dir   string
+	files []string
+}
+
+func pdfAttachmentsEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(pdfAttachments)
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.RemoveAll(res.dir) }()
+	}
+	items := make([]converter.ArchFileItem, len(res.files))
+	for i, fn := range res.files {
+		items[i] = converter.ArchFileItem{Filename: fn, Archive: filepath.Base(fn)}
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="attachments.zip"`)
+	return converter.ZipFiles(w, false, false, items...)
+}
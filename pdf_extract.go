@@ -0,0 +1,105 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+// pdfExtractServer returns the given page ranges of the PDF as one PDF,
+// at /pdf/extract.
+var pdfExtractServer = kithttp.NewServer(
+	context.Background(),
+	pdfExtractEP,
+	pdfExtractDecode,
+	pdfExtractEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+type pdfExtractRequest struct {
+	Input reqFile
+	// Ranges are the comma-separated page ranges, see converter.ParsePageRanges.
+	Ranges string
+}
+
+type pdfExtractResponse struct {
+	fileName string
+	// name is the file name offered to the client
+	name string
+}
+
+func pdfExtractDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	f, err := getOneRequestFile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req := pdfExtractRequest{Input: f, Ranges: strings.TrimSpace(r.FormValue("ranges"))}
+	if req.Ranges == "" {
+		_ = f.Close()
+		return nil, badRequest(errors.New("ranges is required"))
+	}
+	return req, nil
+}
+
+// pdfExtractEP validates the ranges against the number of pages,
+// and extracts them with converter.PdfMergeSelect.
+func pdfExtractEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	req := request.(pdfExtractRequest)
+	defer func() { _ = req.Input.Close() }()
+	inpfn, err := readerToFile(ctx, req.Input, req.Input.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", req.Input.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	n, err := converter.PdfPageNum(ctx, inpfn)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := converter.ParsePageRanges(req.Ranges, n)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	outfn, err := tempFilename(ctx, "extract-")
+	if err != nil {
+		return nil, err
+	}
+	if err = converter.PdfMergeSelect(ctx, outfn, inpfn, pages); err != nil {
+		_ = os.Remove(outfn)
+		getLogger(ctx).Log("msg", "PdfMergeSelect", "inp", inpfn, "ranges", req.Ranges, "error", err)
+		return nil, err
+	}
+	return pdfExtractResponse{fileName: outfn, name: convertedName(req.Input.Filename) + ".pdf"}, nil
+}
+
+func pdfExtractEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(pdfExtractResponse)
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(resp.fileName) }()
+	}
+	fh, err := os.Open(resp.fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fh.Close() }()
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": resp.name}))
+	_, err = io.Copy(w, fh)
+	return err
+}
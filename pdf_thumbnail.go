@@ -0,0 +1,99 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+const defaultThumbnailSize = "256"
+
+var pdfThumbnailServer = kithttp.NewServer(
+	context.Background(),
+	pdfThumbnailEP,
+	pdfThumbnailDecode,
+	pdfThumbnailEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+type pdfThumbnailRequest struct {
+	Input  reqFile
+	Size   string
+	Page   int
+	Format string
+}
+
+type pdfThumbnailResponse struct {
+	ContentType string
+	Image       []byte
+}
+
+func pdfThumbnailDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	f, err := getOneRequestFile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req := pdfThumbnailRequest{Input: f, Size: r.FormValue("size"), Page: 1, Format: "png"}
+	if req.Size == "" {
+		req.Size = defaultThumbnailSize
+	}
+	if s := r.FormValue("page"); s != "" {
+		if req.Page, err = strconv.Atoi(s); err != nil || req.Page < 1 {
+			_ = f.Close()
+			return nil, badRequest(errors.Errorf("bad page %q", s))
+		}
+	}
+	switch s := r.FormValue("format"); s {
+	case "", "png", "image/png":
+	case "jpeg", "jpg", "image/jpeg":
+		req.Format = "jpeg"
+	default:
+		_ = f.Close()
+		return nil, badRequest(errors.Errorf("unknown format %q (png or jpeg)", s))
+	}
+	return req, nil
+}
+
+// pdfThumbnailEP renders the asked page of the PDF.
+func pdfThumbnailEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	req := request.(pdfThumbnailRequest)
+	defer func() { _ = req.Input.Close() }()
+	inpfn, err := readerToFile(ctx, req.Input, req.Input.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", req.Input.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	var buf bytes.Buffer
+	if err = converter.PdfThumbnailAs(ctx, inpfn, &buf, req.Size, req.Page, req.Format); err != nil {
+		getLogger(ctx).Log("msg", "PdfThumbnail", "inp", inpfn, "page", req.Page, "error", err)
+		if errors.Cause(err) == converter.ErrPageOutOfRange {
+			return nil, badRequest(err)
+		}
+		return nil, err
+	}
+	return pdfThumbnailResponse{ContentType: "image/" + req.Format, Image: buf.Bytes()}, nil
+}
+
+func pdfThumbnailEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(pdfThumbnailResponse)
+	w.Header().Set("Content-Type", resp.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Image)))
+	_, err := w.Write(resp.Image)
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+// pdfValidateServer reports the PDF/A conformance of the PDF, at /pdf/validate.
+var pdfValidateServer = kithttp.NewServer(
+	context.Background(),
+	pdfValidateEP,
+	pdfValidateDecode,
+	pdfValidateEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("application/json")),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+type pdfValidateResponse struct {
+	Conformant bool     `json:"conformant"`
+	Violations []string `json:"violations"`
+}
+
+func pdfValidateDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	return getOneRequestFile(ctx, r)
+}
+
+// pdfValidateEP validates the PDF with converter.PdfValidatePDFA.
+func pdfValidateEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	f := request.(reqFile)
+	defer func() { _ = f.Close() }()
+	inpfn, err := readerToFile(ctx, f, f.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", f.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	ok, violations, err := converter.PdfValidatePDFA(ctx, inpfn)
+	if err != nil {
+		getLogger(ctx).Log("msg", "PdfValidatePDFA", "inp", inpfn, "error", err)
+		return nil, err
+	}
+	if violations == nil {
+		violations = []string{}
+	}
+	return pdfValidateResponse{Conformant: ok, Violations: violations}, nil
+}
+
+func pdfValidateEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	return json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+var pdfFillServer = kithttp.NewServer(
+	context.Background(),
+	pdfFillEP,
+	pdfFillDecode,
+	pdfMergeEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("application/pdf")),
+	kithttp.ServerErrorEncoder(pdfFillErrorEncoder),
+)
+
+var pdfFieldsServer = kithttp.NewServer(
+	context.Background(),
+	pdfFieldsEP,
+	pdfFieldsDecode,
+	pdfFieldsEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("application/json")),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+type pdfFillRequest struct {
+	Input   reqFile
+	Values  map[string]string
+	Flatten bool
+}
+
+func pdfFillDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	f, err := getOneRequestFile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req := pdfFillRequest{Input: f, Flatten: r.FormValue("flatten") == "1"}
+	if s := r.FormValue("values"); s != "" {
+		if err := json.Unmarshal([]byte(s), &req.Values); err != nil {
+			_ = f.Close()
+			return nil, errors.Wrapf(err, "parse values %q", s)
+		}
+	}
+	return req, nil
+}
+
+func pdfFillEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	req, ok := request.(pdfFillRequest)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("awaited pdfFillRequest, got %T", request))
+	}
+	defer func() { _ = req.Input.Close() }()
+	Log := getLogger(ctx).With("fn", "pdfFillEP").Log
+
+	inpfn, err := readerToFile(ctx, req.Input, req.Input.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", req.Input.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	dst, err := tempFilename(ctx, "pdffill-")
+	if err != nil {
+		return nil, err
+	}
+	fill := converter.PdfFillFdf
+	if req.Flatten {
+		fill = converter.PdfFillFdfFlatten
+	}
+	if err = fill(ctx, dst, inpfn, req.Values); err != nil {
+		Log("msg", "PdfFillFdf", "dst", dst, "inp", inpfn, "error", err)
+		_ = os.Remove(dst)
+		return nil, err
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(dst)
+	return f, nil
+}
+
+// pdfFillErrorEncoder returns 400 for unknown field names, listing them,
+// and for invalid checkbox/radio button states.
+func pdfFillErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	if e, ok := err.(kithttp.Error); ok {
+		if ufe, ok := errors.Cause(e.Err).(*converter.UnknownFieldsError); ok {
+			writeError(ctx, w, ufe, http.StatusBadRequest)
+			return
+		}
+		if ife, ok := errors.Cause(e.Err).(*converter.InvalidFieldValueError); ok {
+			writeError(ctx, w, ife, http.StatusBadRequest)
+			return
+		}
+	}
+	errorEncoder(ctx, err, w)
+}
+
+func pdfFieldsDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	return getOneRequestFile(ctx, r)
+}
+
+func pdfFieldsEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	f := request.(reqFile)
+	defer func() { _ = f.Close() }()
+	inpfn, err := readerToFile(ctx, f, f.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", f.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	fields, err := converter.PdfDumpFieldsFull(ctx, inpfn)
+	if err != nil {
+		getLogger(ctx).Log("msg", "PdfDumpFieldsFull", "inp", inpfn, "error", err)
+		return nil, err
+	}
+	if fields == nil {
+		fields = []converter.PdfField{}
+	}
+	return fields, nil
+}
+
+func pdfFieldsEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	return json.NewEncoder(w).Encode(response)
+}
@@ -7,9 +7,12 @@ package main
 import (
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	"golang.org/x/net/context"
 
@@ -27,14 +30,51 @@ var pdfMergeServer = kithttp.NewServer(
 	pdfMergeEncode,
 	kithttp.ServerBefore(defaultBeforeFuncs...),
 	kithttp.ServerAfter(kithttp.SetContentType("application/pdf")),
+	kithttp.ServerErrorEncoder(errorEncoder),
 )
 
+// pdfMergeDecode decodes the merge request.
+//
+// The order of the merged files is given by the order field: a comma-separated list
+// of the form field names or file names of the uploaded files.
+// Without it, the files are merged in the order of their form field names -
+// or sorted by file name, if sort=1 is given (or sortBeforeMerge is true, and sort=0 is not).
+//
+// With convert=1, the non-PDF files are converted to PDF first (see pdfMergeEP).
 func pdfMergeDecode(ctx context.Context, r *http.Request) (interface{}, error) {
-	inputs, err := getRequestFiles(r)
+	inputs, err := getRequestFiles(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	req := pdfMergeRequest{Inputs: inputs}
+	req := pdfMergeRequest{Inputs: inputs,
+		Watermark:   r.FormValue("watermark"),
+		PageNumbers: r.FormValue("pageNumbers") == "1",
+		Bookmarks:   r.FormValue("bookmarks") == "1",
+		Optimize:    r.FormValue("optimize") == "1",
+		Convert:     r.FormValue("convert") == "1",
+	}
+	if req.Encrypt, err = getEncryptOpts(r); err != nil {
+		for _, f := range inputs {
+			_ = f.Close()
+		}
+		return nil, err
+	}
+	if req.Version, err = getPdfVersion(r); err != nil {
+		for _, f := range inputs {
+			_ = f.Close()
+		}
+		return nil, err
+	}
+	if order := r.FormValue("order"); order != "" {
+		if req.Inputs, err = orderFiles(inputs, strings.Split(order, ",")); err != nil {
+			for _, f := range inputs {
+				_ = f.Close()
+			}
+			return nil, badRequest(err)
+		}
+		req.Sort = NoSort
+		return req, nil
+	}
 	switch r.URL.Query().Get("sort") {
 	case "0":
 		req.Sort = NoSort
@@ -58,50 +98,269 @@ func pdfMergeEP(ctx context.Context, request interface{}) (response interface{},
 	}()
 
 	Log := logger.With("fn", "pdfMergeEP").Log
-	if sortBeforeMerge && req.Sort != NoSort || !sortBeforeMerge && req.Sort != DoSort {
+	if req.Sort != NoSort && (sortBeforeMerge || req.Sort == DoSort) {
 		Log("msg", "sorting filenames, as requested", "ask", req.Sort, "config", sortBeforeMerge)
 		sort.Sort(ByName(req.Inputs))
 	}
 
+	if req.Version != "" {
+		ctx = converter.WithPdfVersion(ctx, req.Version)
+	}
 	filenames := make([]string, len(req.Inputs))
-	if !converter.LeaveTempFiles {
-		defer func() {
-			for _, fn := range filenames {
-				if fn != "" {
-					_ = os.Remove(fn)
-				}
-			}
-		}()
+	stream := &pdfMergeStream{ctx: ctx, filenames: filenames,
+		watermark: req.Watermark, pageNumbers: req.PageNumbers, optimize: req.Optimize,
+		encrypt: req.Encrypt}
+	if req.Bookmarks {
+		stream.titles = make([]string, len(req.Inputs))
+		for i, f := range req.Inputs {
+			stream.titles[i] = strings.TrimSuffix(f.Filename, filepath.Ext(f.Filename))
+		}
 	}
 	for i, f := range req.Inputs {
-		if filenames[i], err = readerToFile(f.ReadCloser, f.Filename); err != nil {
-			return nil, fmt.Errorf("error saving %q: %s", f.Filename, err)
+		if filenames[i], err = readerToFile(ctx, f.ReadCloser, f.Filename); err != nil {
+			_ = stream.Close()
+			return nil, errors.Wrapf(err, "error saving %q", f.Filename)
 		}
 		select {
 		case <-ctx.Done():
+			_ = stream.Close()
 			return nil, ctx.Err()
 		default:
 		}
 	}
+	if req.Convert {
+		if err = stream.convert(req.Inputs); err != nil {
+			_ = stream.Close()
+			return nil, err
+		}
+	}
+	// the merge itself is done by pdfMergeEncode, straight into the response.
+	return stream, nil
+}
+
+// pdfMergeStream merges the files when written to a writer.
+type pdfMergeStream struct {
+	ctx         context.Context
+	filenames   []string
+	watermark   string
+	pageNumbers bool
+	titles      []string // bookmark titles, if bookmarks are asked for
+	optimize    bool
+	encrypt     converter.EncryptOpts
+	// skipped lists the files which could not be converted, see skippedNote.
+	skipped []string
+}
+
+// convert converts the non-PDF files to PDF, in place. The files which cannot be
+// converted are left out of the merge, and noted in skipped; but it is an error
+// if none is left.
+func (s *pdfMergeStream) convert(inputs []reqFile) error {
+	Log := getLogger(s.ctx).Log
+	filenames := s.filenames[:0]
+	var titles []string
+	var firstErr error
+	for i, fn := range s.filenames {
+		f := inputs[i]
+		err := convertToPdf(s.ctx, fn, f.Header.Get("Content-Type"), f.Filename)
+		if err != nil {
+			Log("msg", "convert for merge", "file", f.Filename, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			s.skipped = append(s.skipped, skippedNote(f.Filename, err))
+			_ = os.Remove(fn)
+			continue
+		}
+		filenames = append(filenames, fn)
+		if s.titles != nil {
+			titles = append(titles, s.titles[i])
+		}
+	}
+	s.filenames = filenames
+	if s.titles != nil {
+		s.titles = titles
+	}
+	if len(filenames) == 0 {
+		return errors.Wrap(firstErr, "no file could be converted")
+	}
+	return nil
+}
+
+// errSeveralFiles is returned by convertToPdf for the inputs converted into a zip.
+var errSeveralFiles = errors.New("converted into several files")
+
+// skippedNote returns the X-Skipped-File header value of the file which could not
+// be converted: the base name (RFC 2047 encoded if needed) and the reason.
+// The reason is not the error text, as that contains the internal paths.
+func skippedNote(fileName string, err error) string {
+	reason := "conversion failed"
+	switch errors.Cause(err) {
+	case converter.ErrNoConverter:
+		reason = "no converter"
+	case converter.ErrResourceLimit, errSeveralFiles:
+		reason = errors.Cause(err).Error()
+	case context.Canceled, context.DeadlineExceeded:
+		reason = "timeout"
+	}
+	return mime.QEncoding.Encode("utf-8", baseName(fileName)) + "; " + reason
+}
 
-	dst, err := tempFilename("pdfmerge-")
+// convertToPdf converts the file to PDF with converter.Convert, replacing it,
+// if it is not a PDF already.
+func convertToPdf(ctx context.Context, fn, contentType, fileName string) error {
+	fh, err := os.Open(fn)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if err := converter.PdfMerge(ctx, dst, filenames...); err != nil {
-		Log("msg", "PdfMerge", "dst", dst, "filenames", filenames, "error", err)
-		return nil, err
+	defer func() { _ = fh.Close() }()
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(fh, head)
+	if converter.FixContentType(head[:n], contentType, fileName) == "application/pdf" {
+		return nil
+	}
+	if _, err = fh.Seek(0, 0); err != nil {
+		return err
+	}
+	tmpfn := fn + ".pdf"
+	if err = converter.Convert(ctx, tmpfn, fh, contentType, fileName); err != nil {
+		_ = os.Remove(tmpfn)
+		return err
+	}
+	if isZip(tmpfn) {
+		_ = os.Remove(tmpfn)
+		return errSeveralFiles
+	}
+	_ = fh.Close()
+	return os.Rename(tmpfn, fn)
+}
+
+func (s *pdfMergeStream) WriteTo(w io.Writer) (int64, error) {
+	if s.watermark != "" || s.pageNumbers || s.titles != nil || s.optimize || !s.encrypt.IsZero() {
+		return s.writePostProcessed(w)
 	}
-	f, err := os.Open(dst)
+	n, err := converter.PdfMergeTo(s.ctx, w, s.filenames...)
 	if err != nil {
-		return nil, err
+		getLogger(s.ctx).Log("msg", "PdfMergeTo", "filenames", s.filenames, "written", n, "error", err)
+	}
+	return n, err
+}
+
+// writePostProcessed merges the files, numbers the pages, stamps
+// the watermark on the result, adds the bookmarks, optimizes and encrypts it
+// (as requested), and writes it to w.
+func (s *pdfMergeStream) writePostProcessed(w io.Writer) (int64, error) {
+	Log := getLogger(s.ctx).Log
+	var marks []converter.Bookmark
+	if s.titles != nil {
+		var err error
+		if marks, err = converter.MergeBookmarks(s.ctx, s.filenames, s.titles); err != nil {
+			Log("msg", "MergeBookmarks", "filenames", s.filenames, "error", err)
+			return 0, err
+		}
+	}
+	merged, err := tempFilename(s.ctx, "pdfmerge-")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = os.Remove(merged) }()
+	if err = converter.PdfMerge(s.ctx, merged, s.filenames...); err != nil {
+		Log("msg", "PdfMerge", "dst", merged, "filenames", s.filenames, "error", err)
+		return 0, err
+	}
+	src := merged
+	step := func(prefix string, f func(dst, src string) error) error {
+		dst, err := tempFilename(s.ctx, prefix)
+		if err != nil {
+			return err
+		}
+		prev := src
+		defer func() { _ = os.Remove(prev) }()
+		if err = f(dst, prev); err != nil {
+			_ = os.Remove(dst)
+			return err
+		}
+		src = dst
+		return nil
+	}
+	if s.pageNumbers {
+		if err = step("pdfmerge-pagenumbers-", func(dst, inp string) error {
+			return converter.PdfNumberPages(s.ctx, dst, inp, converter.PageNumberOpts{})
+		}); err != nil {
+			Log("msg", "PdfNumberPages", "src", src, "error", err)
+			return 0, err
+		}
+	}
+	if s.watermark != "" {
+		if err = step("pdfmerge-watermark-", func(dst, inp string) error {
+			return converter.PdfTextWatermark(s.ctx, dst, inp, s.watermark, converter.WatermarkOpts{})
+		}); err != nil {
+			Log("msg", "PdfTextWatermark", "src", src, "error", err)
+			return 0, err
+		}
+	}
+	if len(marks) != 0 {
+		if err = step("pdfmerge-bookmarks-", func(dst, inp string) error {
+			return converter.PdfAddBookmarks(s.ctx, dst, inp, marks)
+		}); err != nil {
+			Log("msg", "PdfAddBookmarks", "src", src, "error", err)
+			return 0, err
+		}
+	}
+	if s.optimize {
+		if err = step("pdfmerge-optimize-", func(dst, inp string) error {
+			return converter.PdfOptimize(s.ctx, dst, inp)
+		}); err != nil {
+			Log("msg", "PdfOptimize", "src", src, "error", err)
+			return 0, err
+		}
 	}
-	_ = os.Remove(dst)
-	return f, nil
+	if !s.encrypt.IsZero() {
+		if err = step("pdfmerge-encrypt-", func(dst, inp string) error {
+			return converter.PdfEncrypt(s.ctx, dst, inp,
+				s.encrypt.UserPassword, s.encrypt.OwnerPassword, s.encrypt.Permissions)
+		}); err != nil {
+			Log("msg", "PdfEncrypt", "src", src, "error", err)
+			return 0, err
+		}
+	}
+	defer func() { _ = os.Remove(src) }()
+	fh, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = fh.Close() }()
+	return io.Copy(w, fh)
+}
+
+// Close removes the input files.
+func (s *pdfMergeStream) Close() error {
+	if converter.KeepTempFiles(s.ctx) {
+		return nil
+	}
+	for _, fn := range s.filenames {
+		if fn != "" {
+			_ = os.Remove(fn)
+		}
+	}
+	return nil
 }
 
 func pdfMergeEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
-	Log := logger.Log
+	Log := getLogger(ctx).Log
+	if s, ok := response.(*pdfMergeStream); ok {
+		defer func() { _ = s.Close() }()
+		w.Header().Set("Content-Disposition", `attachment; filename="merged.pdf"`)
+		for _, skipped := range s.skipped {
+			w.Header().Add("X-Skipped-File", skipped)
+		}
+		n, err := s.WriteTo(w)
+		if err != nil && n == 0 {
+			// nothing has been written, so the error encoder can send the proper status
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("X-Skipped-File")
+		}
+		return err
+	}
 	if f, ok := response.(interface {
 		Stat() (os.FileInfo, error)
 	}); ok {
@@ -121,8 +380,45 @@ func pdfMergeEncode(ctx context.Context, w http.ResponseWriter, response interfa
 }
 
 type pdfMergeRequest struct {
-	Sort   sortMode
-	Inputs []reqFile
+	Sort        sortMode
+	Inputs      []reqFile
+	Watermark   string
+	PageNumbers bool
+	Bookmarks   bool
+	Optimize    bool
+	Encrypt     converter.EncryptOpts
+	// Version is the PDF version the result is written with, "" for leaving it as is.
+	Version converter.PdfVersion
+	// Convert asks for converting the non-PDF inputs to PDF before the merge.
+	Convert bool
+}
+
+// orderFiles returns the files in the given order: each name is a form field name,
+// or a file name (see pdfMergeDecode). All the files must be listed, exactly once.
+func orderFiles(files []reqFile, order []string) ([]reqFile, error) {
+	ordered := make([]reqFile, 0, len(files))
+	used := make([]bool, len(files))
+	for _, name := range order {
+		name = strings.TrimSpace(name)
+		found := -1
+		for i, f := range files {
+			if !used[i] && (f.Field == name || f.Filename == name) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("order: no (more) file named %q", name)
+		}
+		used[found] = true
+		ordered = append(ordered, files[found])
+	}
+	for i, f := range files {
+		if !used[i] {
+			return nil, fmt.Errorf("order: %q (%q) is not listed", f.Filename, f.Field)
+		}
+	}
+	return ordered, nil
 }
 
 type sortMode uint8
@@ -137,4 +433,4 @@ type ByName []reqFile
 
 func (b ByName) Len() int           { return len(b) }
 func (b ByName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
-func (b ByName) Less(i, j int) bool { return b[i].Filename < b[j].Filename }
+func (b ByName) Less(i, j int) bool { return converter.NaturalLess(b[i].Filename, b[j].Filename) }
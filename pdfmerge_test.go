@@ -0,0 +1,138 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/tgulacsi/agostle/converter"
+)
+
+func TestPdfMergeConvertSkip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-merge-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	defer func(old string) { converter.Workdir = old }(converter.Workdir)
+	converter.Workdir = dir
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range []struct{ field, name, ct, content string }{
+		{"f1", "c.pdf", "application/pdf", "%PDF-1.4 c"},
+		{"f2", "b.xyz", "application/x-unknown-thing", "b"},
+		{"f3", "a.pdf", "application/pdf", "%PDF-1.4 a"},
+	} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="`+f.field+`"; filename="`+f.name+`"`)
+		h.Set("Content-Type", f.ct)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(f.content))
+	}
+	for k, v := range map[string]string{"order": "f3,f2,f1", "convert": "1", "bookmarks": "1"} {
+		if err = mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/pdf/merge", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	req, err := pdfMergeDecode(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := pdfMergeEP(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := resp.(*pdfMergeStream)
+	defer func() { _ = s.Close() }()
+
+	// the unconvertable b.xyz is skipped, and noted; the rest is in the asked order
+	var got []string
+	for _, fn := range s.filenames {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	if want := []string{"%PDF-1.4 a", "%PDF-1.4 c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, wanted %q", got, want)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(s.titles, want) {
+		t.Errorf("got titles %q, wanted %q", s.titles, want)
+	}
+	if want := []string{"b.xyz; no converter"}; !reflect.DeepEqual(s.skipped, want) {
+		t.Errorf("got skipped %q, wanted %q", s.skipped, want)
+	}
+}
+
+func TestPdfMergeTooLarge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agostle-merge-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	defer func(old string) { converter.Workdir = old }(converter.Workdir)
+	converter.Workdir = dir
+	ctx := context.WithValue(context.Background(), "logger", log.NewContext(log.NewNopLogger()))
+
+	// the body is over the cap only while it is read to the file.
+	w := httptest.NewRecorder()
+	body := http.MaxBytesReader(w, ioutil.NopCloser(strings.NewReader("%PDF-1.4 too large")), 4)
+	var f reqFile
+	f.Filename, f.ReadCloser = "a.pdf", body
+	_, err = pdfMergeEP(ctx, pdfMergeRequest{Inputs: []reqFile{f}})
+	if err == nil {
+		t.Fatal("wanted error for the over-cap body")
+	}
+	errorEncoder(ctx, err, w)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d (%v), wanted %d", w.Code, err, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestSkippedNote(t *testing.T) {
+	for i, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"a.docx", errors.New("/tmp/agostle/x/a.docx: exit status 1"), "a.docx; conversion failed"},
+		{`C:\Users\x\a.docx`, errors.Wrap(converter.ErrNoConverter, "/tmp/x"), "a.docx; no converter"},
+		{"árvíztűrő.doc", errSeveralFiles, "=?utf-8?q?=C3=A1rv=C3=ADzt=C5=B1r=C5=91.doc?=; converted into several files"},
+		{"a\r\nX-Evil: 1.doc", context.DeadlineExceeded, "=?utf-8?q?a=0D=0AX-Evil:_1.doc?=; timeout"},
+	} {
+		got := skippedNote(tc.name, tc.err)
+		if got != tc.want {
+			t.Errorf("%d. got %q, wanted %q", i, got, tc.want)
+		}
+		if strings.ContainsAny(got, "\r\n") || strings.Contains(got, "/tmp") {
+			t.Errorf("%d. unsafe header value %q", i, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+
+	"github.com/pkg/errors"
+)
+
+var pdfTextServer = kithttp.NewServer(
+	context.Background(),
+	pdfTextEP,
+	pdfTextDecode,
+	pdfTextEncode,
+	kithttp.ServerBefore(defaultBeforeFuncs...),
+	kithttp.ServerAfter(kithttp.SetContentType("text/plain; charset=utf-8")),
+	kithttp.ServerErrorEncoder(errorEncoder),
+)
+
+func pdfTextDecode(ctx context.Context, r *http.Request) (interface{}, error) {
+	return getOneRequestFile(ctx, r)
+}
+
+func pdfTextEP(ctx context.Context, request interface{}) (response interface{}, err error) {
+	f := request.(reqFile)
+	defer func() { _ = f.Close() }()
+	inpfn, err := readerToFile(ctx, f, f.Filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "save %q", f.Filename)
+	}
+	if !converter.KeepTempFiles(ctx) {
+		defer func() { _ = os.Remove(inpfn) }()
+	}
+	text, err := converter.PdfExtractText(ctx, inpfn)
+	if err != nil {
+		getLogger(ctx).Log("msg", "PdfExtractText", "inp", inpfn, "error", err)
+		return nil, err
+	}
+	return text, nil
+}
+
+func pdfTextEncode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	_, err := io.WriteString(w, response.(string))
+	return err
+}
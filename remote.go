@@ -0,0 +1,282 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+// maxRedirects is the number of redirects followed when fetching a remote URL.
+const maxRedirects = 10
+
+// errForbiddenHost is returned for hosts denied by ConfURLAllowHosts/ConfURLDenyHosts.
+var errForbiddenHost = errors.New("host is not allowed")
+
+// remoteRequest is the JSON body for converting a remote document.
+type remoteRequest struct {
+	URL string `json:"url"`
+}
+
+// isJSONRequest reports whether the request body is JSON.
+func isJSONRequest(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
+
+// getRemoteRequestFile reads the {"url":"..."} JSON request body,
+// and downloads the document from that URL.
+func getRemoteRequestFile(ctx context.Context, r *http.Request) (reqFile, error) {
+	defer func() { _ = r.Body.Close() }()
+	var req remoteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		if isTooLarge(err) {
+			return reqFile{}, tooLarge(err)
+		}
+		return reqFile{}, badRequest(errors.Wrap(err, "parse JSON request"))
+	}
+	if req.URL == "" {
+		return reqFile{}, badRequest(errors.New("no url given"))
+	}
+	return fetchURL(ctx, req.URL)
+}
+
+// fetchURL downloads the document from rawurl into the request's work directory.
+// Only http and https URLs of the allowed hosts (see hostAllowed) are fetched,
+// with the size limited by ConfMaxRequestSize, and the time by ConfURLTimeout.
+func fetchURL(ctx context.Context, rawurl string) (reqFile, error) {
+	Log := getLogger(ctx).With("fn", "fetchURL", "url", rawurl).Log
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return reqFile{}, badRequest(errors.Wrapf(err, "parse %q", rawurl))
+	}
+	if err = checkURL(u); err != nil {
+		return reqFile{}, err
+	}
+	if timeout := *converter.ConfURLTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return reqFile{}, badRequest(err)
+	}
+	resp, err := remoteClient.Do(req.WithContext(ctx))
+	if err != nil {
+		Log("msg", "fetch", "error", err)
+		if he := httpErrorOf(err); he != nil {
+			return reqFile{}, he
+		}
+		return reqFile{}, &httpError{Code: http.StatusBadGateway, Err: errors.Wrapf(err, "fetch %q", rawurl)}
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return reqFile{}, &httpError{Code: http.StatusBadGateway,
+			Err: errors.Errorf("fetch %q: %s", rawurl, resp.Status)}
+	}
+	maxSize := *converter.ConfMaxRequestSize
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return reqFile{}, tooLarge(errors.Errorf("remote document too large (%d bytes, max. %d)", resp.ContentLength, maxSize))
+	}
+
+	fn := remoteFilename(u, resp.Header.Get("Content-Disposition"))
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	tfn, err := readerToFile(ctx, body, fn)
+	if err != nil {
+		return reqFile{}, &httpError{Code: http.StatusBadGateway, Err: errors.Wrapf(err, "download %q", rawurl)}
+	}
+	fh, err := os.Open(tfn)
+	if err != nil {
+		return reqFile{}, err
+	}
+	if fi, statErr := fh.Stat(); statErr == nil && maxSize > 0 && fi.Size() > maxSize {
+		_ = fh.Close()
+		_ = os.Remove(tfn)
+		return reqFile{}, tooLarge(errors.Errorf("remote document too large (max. %d bytes)", maxSize))
+	}
+	f := reqFile{ReadCloser: fh}
+	f.Filename = fn
+	f.Header = make(textproto.MIMEHeader, 1)
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		f.Header.Set("Content-Type", ct)
+	}
+	Log("msg", "fetched", "file", tfn, "content-type", f.Header.Get("Content-Type"))
+	return f, nil
+}
+
+// httpErrorOf returns the *httpError from the error chain of the HTTP client, or nil.
+func httpErrorOf(err error) *httpError {
+	for err != nil {
+		switch e := err.(type) {
+		case *httpError:
+			return e
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case interface {
+			Cause() error
+		}:
+			err = e.Cause()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkURL returns an error if the URL's scheme is not http(s), or its host is not allowed.
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return badRequest(errors.Errorf("scheme %q is not allowed (only http and https)", u.Scheme))
+	}
+	if u.Hostname() == "" {
+		return badRequest(errors.New("no host in the URL"))
+	}
+	if _, ok := hostAllowed(u.Hostname()); !ok {
+		return &httpError{Code: http.StatusForbidden, Err: errors.Wrap(errForbiddenHost, u.Hostname())}
+	}
+	return nil
+}
+
+// remoteFilename returns the file name from the Content-Disposition header,
+// or from the last element of the URL's path.
+func remoteFilename(u *url.URL, disposition string) string {
+	if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+		return path.Base(strings.Replace(params["filename"], "\\", "/", -1))
+	}
+	if fn := path.Base(u.Path); fn != "." && fn != "/" {
+		return fn
+	}
+	return "download"
+}
+
+// hostAllowed reports whether the host is allowed by ConfURLDenyHosts and ConfURLAllowHosts,
+// and whether it is explicitly listed in ConfURLAllowHosts.
+func hostAllowed(host string) (listed, allowed bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchHosts(*converter.ConfURLDenyHosts, host) {
+		return false, false
+	}
+	allow := strings.TrimSpace(*converter.ConfURLAllowHosts)
+	if allow == "" {
+		return false, true
+	}
+	listed = matchHosts(allow, host)
+	return listed, listed
+}
+
+// matchHosts reports whether the host matches any of the comma-separated patterns:
+// "example.com" matches only itself, "*.example.com" matches its subdomains, too.
+func matchHosts(patterns, host string) bool {
+	for _, p := range strings.Split(patterns, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "*.") {
+			if host == p[2:] || strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateIP reports whether the address is not on the public internet.
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range []string{
+		"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "0.0.0.0/8",
+		"fc00::/7",
+	} {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// remoteDial checks the host before connecting, and connects only to the
+// resolved addresses which are allowed - so the checks hold for redirects,
+// and cannot be circumvented by DNS rebinding.
+func remoteDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	listed, allowed := hostAllowed(host)
+	if !allowed {
+		return nil, &httpError{Code: http.StatusForbidden, Err: errors.Wrap(errForbiddenHost, host)}
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	err = &httpError{Code: http.StatusForbidden, Err: errors.Wrap(errForbiddenHost, host+" (private address)")}
+	for _, ip := range ips {
+		if !listed && isPrivateIP(ip.IP) {
+			continue
+		}
+		var conn net.Conn
+		if conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               nil, // the proxy would circumvent remoteDial's checks
+		DialContext:         remoteDial,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return checkURL(req.URL)
+	},
+}
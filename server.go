@@ -9,6 +9,7 @@ package main
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +22,7 @@ import (
 	"net/textproto"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -47,6 +49,7 @@ var (
 // newHTTPServer returns a new, stoppable HTTP server
 func newHTTPServer(address string, saveReq bool) *graceful.Server {
 	onceOnStart.Do(onStart)
+	defer converter.RLockConfig()() // a reload (SIGHUP) may be running already
 
 	if saveReq {
 		defaultBeforeFuncs = append(defaultBeforeFuncs, dumpRequest)
@@ -59,23 +62,41 @@ func newHTTPServer(address string, saveReq bool) *graceful.Server {
 	H := func(path string, handleFunc http.HandlerFunc) {
 		mux.HandleFunc(path,
 			prometheus.InstrumentHandler(strings.Replace(path[1:], "/", "_", -1),
-				handleFunc))
+				withConfig(trackWork(withWorkdir(logAccess(withJob(limitRequestSize(gzipResponse(handleFunc)))))))))
 	}
 	H("/pdf/merge", pdfMergeServer.ServeHTTP)
+	H("/pdf/fill", pdfFillServer.ServeHTTP)
+	H("/pdf/fields", pdfFieldsServer.ServeHTTP)
+	H("/pdf/text", pdfTextServer.ServeHTTP)
+	H("/pdf/attachments", pdfAttachmentsServer.ServeHTTP)
+	H("/pdf/thumbnail", pdfThumbnailServer.ServeHTTP)
+	H("/pdf/extract", pdfExtractServer.ServeHTTP)
+	H("/pdf/validate", pdfValidateServer.ServeHTTP)
+	H("/convert", convertServer.ServeHTTP)
 	H("/email/convert", emailConvertServer.ServeHTTP)
+	H("/email/extract", emailExtractServer.ServeHTTP)
 	H("/outlook", outlookToEmailServer.ServeHTTP)
+	H("/inspect", inspectServer.ServeHTTP)
+	mux.Handle("/jobs/", http.HandlerFunc(jobsHandler))
+	mux.Handle("/healthz", withConfig(healthzPage))
 	mux.Handle("/_admin/stop", http.HandlerFunc(adminStopHandler))
-	mux.Handle("/", http.HandlerFunc(statusPage))
+	mux.Handle("/", withConfig(statusPage))
 
 	s := &graceful.Server{
 		Server: &http.Server{
-			Addr:         address,
-			ReadTimeout:  300 * time.Second,
-			WriteTimeout: 1800 * time.Second,
-			Handler:      mux,
+			Addr:           address,
+			ReadTimeout:    *converter.ConfReadTimeout,
+			WriteTimeout:   *converter.ConfWriteTimeout,
+			IdleTimeout:    *converter.ConfIdleTimeout,
+			MaxHeaderBytes: *converter.ConfMaxHeaderBytes,
+			Handler:        requireAuth(mux),
 		},
 		Timeout: 5 * time.Minute,
 	}
+	if err := configureHTTP2(s.Server); err != nil {
+		logger.Log("msg", "configure HTTP/2", "error", err)
+	}
+	registerServer(s)
 	return s
 }
 
@@ -88,6 +109,16 @@ func SetRequestID(ctx context.Context, name string) context.Context {
 	}
 	return context.WithValue(ctx, name, NewULID().String())
 }
+
+// reqPrefix returns the request id from the context, followed by a "-",
+// to be used in temp file names; or the empty string, if there is no request id.
+func reqPrefix(ctx context.Context) string {
+	if v, ok := ctx.Value("reqid").(string); ok && v != "" {
+		return v + "-"
+	}
+	return ""
+}
+
 func GetRequestID(ctx context.Context, name string) string {
 	if v, ok := ctx.Value(name).(string); ok && v != "" {
 		return v
@@ -106,19 +137,129 @@ var defaultBeforeFuncs = []kithttp.RequestFunc{
 func prepareContext(ctx context.Context, r *http.Request) context.Context {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	ctx = context.WithValue(ctx, "cancel", cancel)
+	// set by withWorkdir
+	for _, k := range []string{"reqid", "workdir"} {
+		if v, ok := r.Context().Value(k).(string); ok && v != "" {
+			ctx = context.WithValue(ctx, k, v)
+		}
+	}
+	if v := r.Context().Value("debug"); v != nil {
+		ctx = context.WithValue(ctx, "debug", v)
+	}
+	// set by withJob
+	ctx = progressFromRequest(ctx, r)
 	ctx = SetRequestID(ctx, "")
-	lgr := getLogger(ctx)
-	lgr = lgr.With(
-		"reqid", GetRequestID(ctx, ""),
+	ctx = context.WithValue(ctx, "logger", requestLogger(ctx, r))
+	ctx = SaveRequest(ctx, r)
+	logAccept(ctx, r)
+	return ctx
+}
+
+// requestLogger returns the logger of the context, with the request id,
+// the path, the method and the client's IP address.
+func requestLogger(ctx context.Context, r *http.Request) *log.Context {
+	lgr := getLogger(ctx).With(
+		"reqid", GetRequestID(ctx, "reqid"),
 		"path", r.URL.Path,
 		"method", r.Method,
 	)
 	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
 		lgr = lgr.With("ip", host)
 	}
-	ctx = context.WithValue(ctx, "logger", lgr)
-	logAccept(ctx, r)
-	return ctx
+	return lgr
+}
+
+// httpError is an error with a HTTP status code.
+type httpError struct {
+	Code int
+	Err  error
+}
+
+func (he *httpError) Error() string { return he.Err.Error() }
+
+// badRequest marks err as the client's fault.
+func badRequest(err error) error {
+	return &httpError{Code: http.StatusBadRequest, Err: err}
+}
+
+// errorCode returns the HTTP status code for the error: 400 for bad requests
+// (and decoding errors), 415 for the content-types not allowed,
+// 500 for everything else.
+func errorCode(err error) int {
+	code := http.StatusInternalServerError
+	if e, ok := err.(kithttp.Error); ok {
+		if e.Domain == kithttp.DomainDecode {
+			code = http.StatusBadRequest
+		}
+		err = e.Err
+	}
+	for err != nil {
+		if he, ok := err.(*httpError); ok {
+			return he.Code
+		}
+		if err == converter.ErrContentTypeNotAllowed || err == converter.ErrNoConverter {
+			return http.StatusUnsupportedMediaType
+		}
+		if err == converter.ErrTooManyPages || err == converter.ErrResourceLimit {
+			return http.StatusRequestEntityTooLarge
+		}
+		if err == converter.ErrSignedPdf {
+			return http.StatusUnprocessableEntity
+		}
+		if err == converter.ErrNoPDFAValidator {
+			return http.StatusNotImplemented
+		}
+		c, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+	return code
+}
+
+// errorEncoder is the common kithttp.ErrorEncoder.
+func errorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	writeError(ctx, w, err, errorCode(err))
+}
+
+// writeError writes the error with the given status code - as
+// {"error":"...","reqid":"..."} if the request accepts JSON, as plain text otherwise.
+func writeError(ctx context.Context, w http.ResponseWriter, err error, code int) {
+	if e, ok := err.(kithttp.Error); ok {
+		err = e.Err
+	}
+	getLogger(ctx).Log("msg", "error", "code", code, "error", err)
+	r, _ := ctx.Value("http.Request").(*http.Request)
+	if r == nil || !acceptsJSON(r.Header["Accept"]) {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	reqID, _ := ctx.Value("reqid").(string)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		ReqID string `json:"reqid,omitempty"`
+	}{Error: err.Error(), ReqID: reqID})
+}
+
+// acceptsJSON reports whether the Accept headers list application/json.
+func acceptsJSON(accept []string) bool {
+	for _, a := range accept {
+		for _, part := range strings.Split(a, ",") {
+			if i := strings.IndexByte(part, ';'); i >= 0 {
+				part = part[:i]
+			}
+			if strings.TrimSpace(part) == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func dumpRequest(ctx context.Context, req *http.Request) context.Context {
@@ -143,95 +284,118 @@ func startHTTPServerListener(listener net.Listener, saveReq bool) {
 	s := newHTTPServer("", saveReq)
 	Log := logger.Log
 	Log("msg", "Start listening on", "listener", listener)
+	listener, err := tlsListener(listener)
+	if err != nil {
+		Log("msg", "TLS", "error", err)
+		os.Exit(1)
+	}
 	if err := s.Serve(listener); err != nil {
 		Log("msg", "Serve", "error", err)
-		os.Exit(1)
 	}
+	exitUnlessDraining(1)
 }
 
+// adminStopHandler drains the server (see drainAndExit), then exits with 3.
 func adminStopHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Refresh", "3;URL=/")
 	w.WriteHeader(200)
-	fmt.Fprintf(w, `Stopping...`)
-	go func() {
-		time.Sleep(time.Millisecond * 500)
-		logger.Log("msg", "SUICIDE for ask!")
-		os.Exit(3)
-	}()
+	fmt.Fprintf(w, `Stopping (waiting for %d conversions and child processes to finish)...`, converter.ActiveWork())
+	drainAndExit(3)
 }
 
 type reqFile struct {
 	multipart.FileHeader
 	io.ReadCloser
+	Field string // the name of the form field
 }
 
 // getOneRequestFile reads the first file from the request (if multipart/),
+// downloads the document if the body is a {"url":"..."} JSON,
 // or returns the body if not
 func getOneRequestFile(ctx context.Context, r *http.Request) (reqFile, error) {
 	f := reqFile{ReadCloser: r.Body}
 	contentType := r.Header.Get("Content-Type")
 	getLogger(ctx).Log("msg", "readRequestOneFile", "content-type", contentType)
+	if isJSONRequest(r) {
+		return getRemoteRequestFile(ctx, r)
+	}
 	if !strings.HasPrefix(contentType, "multipart/") {
 		f.FileHeader.Header = textproto.MIMEHeader(r.Header)
+		setDebug(ctx, r)
 		return f, nil
 	}
 	defer func() { _ = r.Body.Close() }()
-	if err := r.ParseMultipartForm(1 << 20); err != nil {
-		return f, errors.New("error parsing request as multipart-form: " + err.Error())
+	files, err := parseMultipartForm(ctx, r)
+	if err != nil {
+		return f, err
 	}
-	if r.MultipartForm == nil || len(r.MultipartForm.File) == 0 {
-		return f, errors.New("no files?")
+	if len(files) == 0 {
+		return f, badRequest(errors.New("no files?"))
 	}
-
-	for _, fileHeaders := range r.MultipartForm.File {
-		for _, fileHeader := range fileHeaders {
-			var err error
-			if f.ReadCloser, err = fileHeader.Open(); err != nil {
-				return f, fmt.Errorf("error opening part %q: %s", fileHeader.Filename, err)
-			}
-			f.FileHeader = *fileHeader
-			return f, nil
-		}
+	// only the first file is used
+	for _, other := range files[1:] {
+		_ = other.Close()
 	}
-	return reqFile{}, nil
+	return files[0], nil
 }
 
-// getRequestFiles reads the files from the request, and calls readerToFile on them
-func getRequestFiles(r *http.Request) ([]reqFile, error) {
+// getRequestFiles reads the files from the request (see parseMultipartForm).
+func getRequestFiles(ctx context.Context, r *http.Request) ([]reqFile, error) {
 	if r.Body != nil {
 		defer func() { _ = r.Body.Close() }()
 	}
-	err := r.ParseMultipartForm(1 << 20)
+	files, err := parseMultipartForm(ctx, r)
 	if err != nil {
-		return nil, errors.New("cannot parse request as multipart-form: " + err.Error())
+		return nil, err
 	}
-	if r.MultipartForm == nil || len(r.MultipartForm.File) == 0 {
-		return nil, errors.New("no files?")
+	if len(files) == 0 {
+		return nil, badRequest(errors.New("no files?"))
 	}
+	// in the order of the field names, and in upload order within a field.
+	sort.Stable(byField(files))
+	return files, nil
+}
 
-	files := make([]reqFile, 0, len(r.MultipartForm.File))
-	for _, fileHeaders := range r.MultipartForm.File {
-		for _, fileHeader := range fileHeaders {
-			f := reqFile{FileHeader: *fileHeader}
-			if f.ReadCloser, err = fileHeader.Open(); err != nil {
-				return nil, fmt.Errorf("error reading part %q: %s", fileHeader.Filename, err)
-			}
-			files = append(files, f)
+// setDebug turns on the debug mode of the request (see converter.SetDebug)
+// if its debug form field (or query parameter) is "1", and ConfAllowDebug is set:
+// the temp files of the request are left in its workdir (which is kept till
+// ConfDebugTTL), and the original HTML parts are saved.
+func setDebug(ctx context.Context, r *http.Request) {
+	v := r.URL.Query().Get("debug")
+	if r.PostForm != nil {
+		if pv := r.PostForm.Get("debug"); pv != "" {
+			v = pv
 		}
 	}
-	if len(files) == 0 {
-		return nil, errors.New("no files??")
+	if v != "1" {
+		return
+	}
+	if !*converter.ConfAllowDebug {
+		getLogger(ctx).Log("msg", "debug mode is not allowed (see allowDebug)")
+		return
+	}
+	if converter.SetDebug(r.Context()) {
+		getLogger(ctx).Log("msg", "debug mode", "workdir", converter.GetWorkdir(ctx))
 	}
-	return files, nil
 }
 
+// byField is a wrapper for []reqFile for sort.Stable, ordering by the form field names.
+type byField []reqFile
+
+func (b byField) Len() int           { return len(b) }
+func (b byField) Less(i, j int) bool { return b[i].Field < b[j].Field }
+func (b byField) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
 // readerToFile copies the reader to a temp file and returns its name or error
-func readerToFile(r io.Reader, prefix string) (filename string, err error) {
-	dfh, e := ioutil.TempFile("", "agostle-"+baseName(prefix)+"-")
+func readerToFile(ctx context.Context, r io.Reader, prefix string) (filename string, err error) {
+	dfh, e := ioutil.TempFile(converter.GetWorkdir(ctx), "agostle-"+reqPrefix(ctx)+baseName(prefix)+"-")
 	if e != nil {
 		err = e
 		return
 	}
+	if sf, ok := r.(spooledFile); ok {
+		r = sf.File
+	}
 	if sfh, ok := r.(*os.File); ok {
 		filename = dfh.Name()
 		_ = dfh.Close()
@@ -241,13 +405,110 @@ func readerToFile(r io.Reader, prefix string) (filename string, err error) {
 	}
 	if _, err = io.Copy(dfh, r); err == nil {
 		filename = dfh.Name()
+	} else if isTooLarge(err) {
+		err = tooLarge(err)
 	}
 	_ = dfh.Close()
+	if err != nil {
+		_ = os.Remove(dfh.Name())
+	}
 	return
 }
 
-func tempFilename(prefix string) (filename string, err error) {
-	fh, e := ioutil.TempFile("", prefix)
+// withConfig holds the config read-locked while serving the request,
+// so a config reload (SIGHUP) waits for it, instead of changing the config under it.
+func withConfig(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer converter.RLockConfig()()
+		h(w, r)
+	}
+}
+
+// trackWork registers the request as a running conversion for the workdir reaper.
+func trackWork(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer converter.StartWork()()
+		h(w, r)
+	}
+}
+
+// withWorkdir gives each request its own work directory (named by the request id)
+// under converter.Workdir, and removes it when the request is served.
+func withWorkdir(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqid := NewULID().String()
+		dir, err := converter.NewRequestWorkdir(reqid)
+		if err != nil {
+			logger.Log("msg", "NewRequestWorkdir", "reqid", reqid, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "reqid", reqid)
+		ctx = context.WithValue(ctx, "workdir", dir)
+		ctx = converter.WithDebug(ctx)
+		defer func() {
+			if converter.IsDebug(ctx) {
+				if kept, err := converter.KeepRequestWorkdir(dir); err != nil {
+					logger.Log("msg", "KeepRequestWorkdir", "dir", dir, "error", err)
+				} else {
+					logger.Log("msg", "debug: leaving the request's workdir", "reqid", reqid, "dir", kept)
+				}
+				return
+			}
+			if err := converter.RemoveRequestWorkdir(dir); err != nil {
+				logger.Log("msg", "RemoveRequestWorkdir", "dir", dir, "error", err)
+			}
+		}()
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// getEncryptOpts returns the PDF encryption options from the form fields
+// userPassword, ownerPassword and permissions (see converter.ParsePermissions).
+func getEncryptOpts(r *http.Request) (converter.EncryptOpts, error) {
+	opts := converter.EncryptOpts{
+		UserPassword:  r.FormValue("userPassword"),
+		OwnerPassword: r.FormValue("ownerPassword"),
+	}
+	var err error
+	if opts.Permissions, err = converter.ParsePermissions(r.FormValue("permissions")); err != nil {
+		return opts, badRequest(err)
+	}
+	if err = opts.Validate(); err != nil {
+		return opts, badRequest(err)
+	}
+	return opts, nil
+}
+
+// limitRequestSize limits the size of the request body to ConfMaxRequestSize.
+// As the multipart forms are parsed whole, this limits the total size of
+// all the uploaded files.
+func limitRequestSize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n := *converter.ConfMaxRequestSize; n > 0 && r.Body != nil {
+			if r.ContentLength > n {
+				http.Error(w, fmt.Sprintf("request body too large (max. %d bytes)", n),
+					http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		h(w, r)
+	}
+}
+
+// isTooLarge reports whether the error is from http.MaxBytesReader.
+func isTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
+// tooLarge marks err as 413 Request Entity Too Large.
+func tooLarge(err error) error {
+	return &httpError{Code: http.StatusRequestEntityTooLarge, Err: err}
+}
+
+func tempFilename(ctx context.Context, prefix string) (filename string, err error) {
+	fh, e := ioutil.TempFile(converter.GetWorkdir(ctx), prefix+reqPrefix(ctx))
 	if e != nil {
 		err = e
 		return
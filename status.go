@@ -9,8 +9,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
-	"io"
+	"html/template"
 	"net/http"
 	"os"
 	"os/exec"
@@ -20,7 +21,11 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/kardianos/osext"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tgulacsi/agostle/converter"
 )
 
 type statInfo struct {
@@ -63,6 +68,26 @@ func onStart() {
 	topCmd[i] = topCmd[i] + uname
 
 	stats.startedAt = time.Now().Format(time.RFC3339)
+	for _, c := range converter.Collectors() {
+		prometheus.MustRegister(c)
+	}
+
+	defer converter.RLockConfig()() // a reload (SIGHUP) may be running already
+	if ttl := *converter.ConfWorkdirTTL; ttl > 0 && *converter.ConfWorkdir != "" {
+		Log("msg", "starting workdir reaper", "workdir", converter.Workdir, "ttl", ttl)
+		go converter.ReapWorkdir(context.Background(), ttl)
+	}
+	if ttl := *converter.ConfDebugTTL; *converter.ConfAllowDebug && ttl > 0 {
+		go converter.ReapDebugWorkdirs(context.Background(), ttl)
+	}
+	if *converter.ConfFdfCacheFiles > 0 || *converter.ConfFdfCacheTTL > 0 {
+		go converter.ReapFdfCache(context.Background(), time.Hour)
+	}
+	if *converter.ConfIMAPAddr != "" {
+		Log("msg", "starting IMAP ingestion", "addr", *converter.ConfIMAPAddr,
+			"folder", *converter.ConfIMAPFolder, "out", *converter.ConfIMAPOutDir)
+		go converter.IngestIMAP(context.Background(), *converter.ConfIMAPInterval)
+	}
 }
 
 // getTopOut returns the output of the topCmd - shall be protected with a mutex
@@ -101,31 +126,136 @@ func (st *statInfo) fill() {
 	}
 }
 
-func statusPage(w http.ResponseWriter, r *http.Request) {
-	if r.RequestURI == "/favicon.ico" {
-		http.Error(w, "", 404)
-		return
+// Version is the version of agostle, set at build time with
+// -ldflags "-X main.Version=...".
+var Version = "dev"
+
+// statusData is the data shown on the status page.
+// It must not contain secrets (such as the auth token)!
+type statusData struct {
+	Version     string            `json:"version"`
+	GoVersion   string            `json:"goVersion"`
+	Self        string            `json:"self"`
+	Pid         int               `json:"pid"`
+	StartedAt   string            `json:"startedAt"`
+	AllocMb     float64           `json:"allocMb"`
+	SysMb       float64           `json:"sysMb"`
+	Concurrency int               `json:"concurrency"`
+	InUse       int               `json:"inUse"`
+	Waiting     int               `json:"waiting"`
+	Tools       map[string]string `json:"tools"`
+	Missing     []string          `json:"missing"`
+	Converters  []string          `json:"converters"`
+	Stoppable   bool              `json:"stoppable"` // /_admin/stop is usable (auth is configured)
+	Top         string            `json:"-"`
+}
+
+// getStatusData collects the status data; it is called under withConfig.
+func getStatusData() statusData {
+	missing := converter.MissingTools()
+	if missing == nil {
+		missing = []string{}
 	}
 	stats.fill()
-	w.Header().Add("Content-Type", "text/html")
-	w.WriteHeader(200)
-	fmt.Fprintf(w, `<!DOCTYPE html>
+	stats.mtx.Lock()
+	defer stats.mtx.Unlock()
+	return statusData{
+		Version:     Version,
+		GoVersion:   stats.version,
+		Self:        self,
+		Pid:         os.Getpid(),
+		StartedAt:   stats.startedAt,
+		AllocMb:     float64(stats.mem.Alloc) / 1024 / 1024,
+		SysMb:       float64(stats.mem.Sys) / 1024 / 1024,
+		Concurrency: converter.ConcLimit.Limit(),
+		InUse:       converter.ConcLimit.InUse(),
+		Waiting:     converter.ConcLimit.Waiting(),
+		Tools:       converter.Tools(),
+		Missing:     missing,
+		Converters:  converter.ConvertibleTypes(),
+		Stoppable:   authConfig{token: *converter.ConfAuthToken, user: *converter.ConfAuthUser}.configured(),
+		Top:         string(stats.top),
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
 <html>
   <head><title>Agostle</title></head>
   <body>
-    <h1>Agostle</h1>
-    <p>%s compiled with Go version %s</p>
-    <p>%d started at %s<br/>
-    Allocated: %.03fMb (Sys: %.03fMb)</p>
+    <h1>Agostle {{.Version}}</h1>
+    <p>{{.Self}} compiled with Go version {{.GoVersion}}</p>
+    <p>{{.Pid}} started at {{.StartedAt}}<br/>
+    Allocated: {{printf "%.03f" .AllocMb}}Mb (Sys: {{printf "%.03f" .SysMb}}Mb)<br/>
+    Concurrency: {{.Concurrency}} (in use: {{.InUse}}, waiting: {{.Waiting}})</p>
+
+    {{if .Stoppable}}<p><a href="/_admin/stop">Stop</a> (hopefully supervisor runit will restart).</p>{{end}}
 
-    <p><a href="/_admin/stop">Stop</a> (hopefully supervisor runit will restart).</p>
+    <h2>Tools</h2>
+    <table>{{range $k, $v := .Tools}}
+      <tr><td>{{$k}}</td><td>{{if $v}}{{$v}}{{else}}<em>not available</em>{{end}}</td></tr>{{end}}
+    </table>
+    {{if .Missing}}<p>Missing: {{range .Missing}}{{.}} {{end}}</p>{{end}}
+
+    <h2>Converters</h2>
+    <ul>{{range .Converters}}
+      <li>{{.}}</li>{{end}}
+    </ul>
 
     <h2>Top</h2>
-    <pre>    `,
-		self, stats.version,
-		os.Getpid(), stats.startedAt,
-		float64(stats.mem.Alloc)/1024/1024, float64(stats.mem.Sys)/1024/1024)
-	//io.WriteString(w, stats.top)
-	_, _ = w.Write(stats.top)
-	_, _ = io.WriteString(w, `</pre></body></html>`)
+    <pre>    {{.Top}}</pre>
+  </body>
+</html>
+`))
+
+// statusPage renders the status page, with the ConfStatusTemplate if set,
+// or returns its data as JSON with ?format=json.
+func statusPage(w http.ResponseWriter, r *http.Request) {
+	if r.RequestURI == "/favicon.ico" {
+		http.Error(w, "", 404)
+		return
+	}
+	data := getStatusData()
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		_ = json.NewEncoder(w).Encode(data)
+		return
+	}
+	tmpl := statusTemplate
+	if fn := *converter.ConfStatusTemplate; fn != "" {
+		var err error
+		if tmpl, err = template.ParseFiles(fn); err != nil {
+			logger.Log("msg", "parse status template", "file", fn, "error", err)
+			http.Error(w, "bad status template", http.StatusInternalServerError)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		logger.Log("msg", "execute status template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
+
+// healthzPage returns 200 if all the needed external tools are available,
+// 503 with the list of the missing ones otherwise.
+func healthzPage(w http.ResponseWriter, r *http.Request) {
+	missing := converter.MissingTools()
+	if missing == nil {
+		missing = []string{}
+	}
+	code := http.StatusOK
+	if len(missing) > 0 {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		OK      bool     `json:"ok"`
+		Missing []string `json:"missing"`
+	}{OK: len(missing) == 0, Missing: missing})
 }
@@ -0,0 +1,23 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatusTemplateStop(t *testing.T) {
+	for _, stoppable := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := statusTemplate.Execute(&buf, statusData{Stoppable: stoppable}); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(buf.String(), `href="/_admin/stop"`); got != stoppable {
+			t.Errorf("stoppable=%t: got stop link %t", stoppable, got)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tgulacsi/agostle/converter"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"gopkg.in/tylerb/graceful.v1"
+)
+
+// configureHTTP2 enables HTTP/2 on the server: over TLS if ConfHTTP2 is set
+// (it is disabled otherwise), and over plain HTTP if ConfH2C is set, too.
+func configureHTTP2(s *http.Server) error {
+	if !*converter.ConfHTTP2 {
+		// a non-nil, empty TLSNextProto disables the automatic HTTP/2
+		s.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+	h2s := &http2.Server{IdleTimeout: s.IdleTimeout}
+	if *converter.ConfH2C && !useTLS() {
+		s.Handler = h2c.NewHandler(s.Handler, h2s)
+	}
+	return http2.ConfigureServer(s, h2s)
+}
+
+// useTLS reports whether both the TLS certificate and key are configured.
+func useTLS() bool {
+	return *converter.ConfTLSCert != "" && *converter.ConfTLSKey != ""
+}
+
+// listenAndServe serves HTTPS if TLS is configured (with the optional
+// HTTP redirect listener), plain HTTP otherwise.
+func listenAndServe(s *graceful.Server) error {
+	if !useTLS() {
+		return s.ListenAndServe()
+	}
+	if !*converter.ConfHTTP2 {
+		// graceful's ListenAndServeTLS always offers h2
+		ln, err := net.Listen("tcp", s.Server.Addr)
+		if err != nil {
+			return err
+		}
+		listener, err := tlsListener(ln)
+		if err != nil {
+			_ = ln.Close()
+			return err
+		}
+		return s.Serve(listener)
+	}
+	startHTTPRedirect(s.Server.Addr)
+	return s.ListenAndServeTLS(*converter.ConfTLSCert, *converter.ConfTLSKey)
+}
+
+// tlsListener wraps the listener with TLS, if it is configured.
+func tlsListener(listener net.Listener) (net.Listener, error) {
+	if !useTLS() {
+		return listener, nil
+	}
+	cert, err := tls.LoadX509KeyPair(*converter.ConfTLSCert, *converter.ConfTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	startHTTPRedirect(listener.Addr().String())
+	protos := []string{"http/1.1"}
+	if *converter.ConfHTTP2 {
+		protos = append([]string{"h2"}, protos...)
+	}
+	return tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   protos,
+	}), nil
+}
+
+// startHTTPRedirect starts a listener on ConfHTTPRedirect (if set),
+// which redirects all requests to the HTTPS server listening on tlsAddr.
+func startHTTPRedirect(tlsAddr string) {
+	addr := *converter.ConfHTTPRedirect
+	if addr == "" {
+		return
+	}
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	s := &http.Server{
+		Addr:         addr,
+		Handler:      httpsRedirect(port),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Log("msg", "redirecting HTTP to HTTPS", "listen", addr, "https", tlsAddr)
+		if err := s.ListenAndServe(); err != nil {
+			logger.Log("msg", "HTTP redirect listener", "listen", addr, "error", err)
+		}
+	}()
+}
+
+// httpsRedirect returns a handler which redirects to the same host and URI,
+// with https scheme and the given port.
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
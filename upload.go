@@ -0,0 +1,160 @@
+// Copyright 2017 The Agostle Authors. All rights reserved.
+// Use of this source code is governed by an Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/tgulacsi/agostle/converter"
+)
+
+// maxFormValueSize is the maximum size of all the non-file form values.
+const maxFormValueSize = 10 << 20
+
+// spooledFile is an uploaded file spooled to the work directory,
+// which is removed on Close (unless the temp files are kept, see converter.KeepTempFiles).
+type spooledFile struct {
+	*os.File
+	ctx context.Context
+}
+
+func (sf spooledFile) Close() error {
+	err := sf.File.Close()
+	if !converter.KeepTempFiles(sf.ctx) {
+		_ = os.Remove(sf.File.Name())
+	}
+	return err
+}
+
+// parseMultipartForm reads the multipart form of the request, keeping at most
+// ConfUploadMemory bytes of each file in memory - the bigger ones are spooled
+// into the work directory of the request (so they're removed by the reaper, too).
+//
+// The form values are set on the request (r.Form, r.PostForm and r.MultipartForm),
+// so r.FormValue works as with r.ParseMultipartForm; the files are returned
+// in upload order, and it's the caller's responsibility to Close them.
+func parseMultipartForm(ctx context.Context, r *http.Request) ([]reqFile, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, badRequest(errors.New("error parsing request as multipart-form: " + err.Error()))
+	}
+	var files []reqFile
+	closeAll := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	values := make(url.Values)
+	valuesSize := int64(maxFormValueSize)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeAll()
+			if isTooLarge(err) {
+				return nil, tooLarge(err)
+			}
+			return nil, badRequest(errors.New("error parsing request as multipart-form: " + err.Error()))
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			var buf bytes.Buffer
+			n, err := io.CopyN(&buf, part, valuesSize+1)
+			if err != nil && err != io.EOF {
+				closeAll()
+				if isTooLarge(err) {
+					return nil, tooLarge(err)
+				}
+				return nil, badRequest(errors.Wrapf(err, "read form value %q", name))
+			}
+			if valuesSize -= n; valuesSize < 0 {
+				closeAll()
+				return nil, tooLarge(errors.New("form values too large"))
+			}
+			values.Add(name, buf.String())
+			continue
+		}
+		f, err := spoolPart(ctx, part)
+		if err != nil {
+			closeAll()
+			if isTooLarge(err) {
+				return nil, tooLarge(err)
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	r.MultipartForm = &multipart.Form{Value: values}
+	r.PostForm = values
+	if r.Form == nil {
+		r.Form = make(url.Values, len(values))
+		for k, vv := range values {
+			r.Form[k] = append(r.Form[k], vv...)
+		}
+		for k, vv := range r.URL.Query() {
+			r.Form[k] = append(r.Form[k], vv...)
+		}
+	}
+	setDebug(ctx, r)
+	return files, nil
+}
+
+// spoolPart reads the file part into memory, or if it is bigger than
+// ConfUploadMemory, into a temp file in the work directory.
+func spoolPart(ctx context.Context, part *multipart.Part) (reqFile, error) {
+	f := reqFile{Field: part.FormName()}
+	f.FileHeader.Filename = part.FileName()
+	f.FileHeader.Header = part.Header
+	limit := *converter.ConfUploadMemory
+	if limit < 0 {
+		limit = 0
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, limit+1)
+	if err != nil && err != io.EOF {
+		return f, errors.Wrapf(err, "read part %q", f.Filename)
+	}
+	if n <= limit {
+		f.FileHeader.Size = n
+		f.ReadCloser = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+		return f, nil
+	}
+
+	fh, err := ioutil.TempFile(converter.GetWorkdir(ctx), "upload-"+reqPrefix(ctx))
+	if err != nil {
+		return f, errors.Wrap(err, "create spool file")
+	}
+	sf := spooledFile{File: fh, ctx: ctx}
+	if _, err = fh.Write(buf.Bytes()); err == nil {
+		var m int64
+		m, err = io.Copy(fh, part)
+		n += m
+	}
+	if err == nil {
+		_, err = fh.Seek(0, 0)
+	}
+	if err != nil {
+		_ = sf.Close()
+		return f, errors.Wrapf(err, "spool part %q", f.Filename)
+	}
+	f.FileHeader.Size = n
+	f.ReadCloser = sf
+	return f, nil
+}